@@ -5,6 +5,8 @@ import (
 )
 
 type JwtAppClaims struct {
-	UserID uint
+	UserID    uint
+	Role      string
+	TwoFactor bool
 	jwt.RegisteredClaims
 }
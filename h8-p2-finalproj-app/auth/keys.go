@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// keys used to sign and verify app tokens.
+// the active key signs new tokens, the others only verify
+// tokens issued before a rotation
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+	// HS256 secret for tokens without kid,
+	// only signs when no asymmetric key is configured
+	legacySecret []byte
+	// once an asymmetric key is active, tokens signed with the secret are
+	// only accepted until then, so they can't be forged after the migration
+	legacyUntil time.Time
+}
+
+func NewKeySet(keys []*SigningKey, activeID string, legacySecret []byte) (*KeySet, error) {
+	ks := &KeySet{
+		keys:         map[string]*SigningKey{},
+		legacySecret: legacySecret,
+	}
+	for _, k := range keys {
+		ks.keys[k.ID] = k
+	}
+	if activeID != "" {
+		active, ok := ks.keys[activeID]
+		if !ok {
+			return nil, fmt.Errorf("active key %q not found", activeID)
+		}
+		ks.active = active
+	}
+	if ks.active == nil && len(ks.legacySecret) == 0 {
+		return nil, errors.New("no signing key configured")
+	}
+	return ks, nil
+}
+
+// accepts tokens signed with the legacy secret until the time while an
+// asymmetric key is active, for tokens issued before the migration
+func (ks *KeySet) AcceptLegacyUntil(until time.Time) {
+	ks.legacyUntil = until
+}
+
+// loads <kid>.pem private keys from JWT_KEYS_DIR, signing with JWT_ACTIVE_KEY_ID.
+// falls back to HS256 with JWT_KEY when no directory is set. JWT_KEY tokens
+// are still accepted until JWT_LEGACY_UNTIL (RFC 3339) when keys are set
+func LoadKeySetFromEnv() (*KeySet, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	legacy := []byte(os.Getenv("JWT_KEY"))
+	if dir == "" {
+		return NewKeySet(nil, "", legacy)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	keys := []*SigningKey{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(p), ".pem")
+		key, err := ParseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		keys = append(keys, key)
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if activeID == "" && len(keys) > 0 {
+		// newest by name
+		activeID = keys[len(keys)-1].ID
+	}
+	ks, err := NewKeySet(keys, activeID, legacy)
+	if err != nil {
+		return nil, err
+	}
+	if until := os.Getenv("JWT_LEGACY_UNTIL"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_LEGACY_UNTIL: %w", err)
+		}
+		ks.AcceptLegacyUntil(t)
+	}
+	return ks, nil
+}
+
+// parses a PEM encoded RSA or Ed25519 private key
+func ParseSigningKey(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(kid, parsed)
+}
+
+func NewSigningKey(kid string, key any) (*SigningKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: k}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.legacySecret)
+	}
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.Private)
+}
+
+// picks the verification key by kid, for use with the jwt middleware
+func (ks *KeySet) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(ks.legacySecret) == 0 || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("missing kid")
+		}
+		if ks.active != nil && !time.Now().Before(ks.legacyUntil) {
+			return nil, errors.New("legacy tokens no longer accepted")
+		}
+		return ks.legacySecret, nil
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// public keys of all asymmetric keys, so other services can verify tokens
+func (ks *KeySet) JWKS() JWKSet {
+	ids := []string{}
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch pub := key.Private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,95 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"h8-p2-finalproj-app/auth"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeySet(t *testing.T, activeID string) *auth.KeySet {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	k1, err := auth.NewSigningKey("2024-01", rsaKey)
+	assert.NoError(t, err)
+	k2, err := auth.NewSigningKey("2024-02", edKey)
+	assert.NoError(t, err)
+
+	ks, err := auth.NewKeySet([]*auth.SigningKey{k1, k2}, activeID, nil)
+	assert.NoError(t, err)
+	return ks
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	for _, kid := range []string{"2024-01", "2024-02"} {
+		ks := newTestKeySet(t, kid)
+		signed, err := ks.Sign(&auth.JwtAppClaims{UserID: 1})
+		assert.NoError(t, err)
+
+		claims := &auth.JwtAppClaims{}
+		token, err := jwt.ParseWithClaims(signed, claims, ks.Keyfunc)
+		assert.NoError(t, err)
+		assert.True(t, token.Valid)
+		assert.Equal(t, kid, token.Header["kid"])
+		assert.Equal(t, uint(1), claims.UserID)
+	}
+}
+
+func TestKeySetRejectsHS256WithoutLegacySecret(t *testing.T) {
+	ks := newTestKeySet(t, "2024-01")
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.JwtAppClaims{UserID: 1}).
+		SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	_, err = jwt.ParseWithClaims(signed, &auth.JwtAppClaims{}, ks.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestKeySetRejectsLegacyTokensOnceKeysExist(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	key, err := auth.NewSigningKey("2024-01", rsaKey)
+	assert.NoError(t, err)
+	secret := []byte("secret")
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.JwtAppClaims{UserID: 1}).
+		SignedString(secret)
+	assert.NoError(t, err)
+
+	// without asymmetric keys the secret verifies every token
+	legacyOnly, err := auth.NewKeySet(nil, "", secret)
+	assert.NoError(t, err)
+	_, err = jwt.ParseWithClaims(signed, &auth.JwtAppClaims{}, legacyOnly.Keyfunc)
+	assert.NoError(t, err)
+
+	ks, err := auth.NewKeySet([]*auth.SigningKey{key}, "2024-01", secret)
+	assert.NoError(t, err)
+	_, err = jwt.ParseWithClaims(signed, &auth.JwtAppClaims{}, ks.Keyfunc)
+	assert.Error(t, err)
+
+	// accepted during the migration only
+	ks.AcceptLegacyUntil(time.Now().Add(time.Hour))
+	_, err = jwt.ParseWithClaims(signed, &auth.JwtAppClaims{}, ks.Keyfunc)
+	assert.NoError(t, err)
+	ks.AcceptLegacyUntil(time.Now().Add(-time.Second))
+	_, err = jwt.ParseWithClaims(signed, &auth.JwtAppClaims{}, ks.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestKeySetJWKS(t *testing.T) {
+	ks := newTestKeySet(t, "2024-01")
+	set := ks.JWKS()
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, "RSA", set.Keys[0].Kty)
+	assert.Equal(t, "RS256", set.Keys[0].Alg)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", set.Keys[1].Crv)
+}
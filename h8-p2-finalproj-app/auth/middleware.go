@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type RevocationChecker interface {
+	IsRevoked(jti string) (bool, error)
+}
+
+// rejects tokens whose jti is in the revocation list,
+// must run after the jwt middleware has set the token in context
+func RevocationMiddleware(rc RevocationChecker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := c.Get("user").(*jwt.Token)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+			}
+			claims, ok := token.Claims.(*JwtAppClaims)
+			if !ok || claims.ID == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+
+			revoked, err := rc.IsRevoked(claims.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "internal server error")
+			}
+			if revoked {
+				return echo.NewHTTPError(http.StatusUnauthorized, "token has been revoked")
+			}
+			return next(c)
+		}
+	}
+}
+
+// rejects tokens of roles that require 2fa when the login didn't use it,
+// must run after the jwt middleware has set the token in context
+func TwoFactorPolicyMiddleware(isRequired func(role string) bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := c.Get("user").(*jwt.Token)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+			}
+			claims, ok := token.Claims.(*JwtAppClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+
+			if isRequired(claims.Role) && !claims.TwoFactor {
+				return echo.NewHTTPError(http.StatusForbidden, "two factor authentication required for this account")
+			}
+			return next(c)
+		}
+	}
+}
+
+// only allows tokens with one of the roles,
+// must run after the jwt middleware has set the token in context
+func RequireRoles(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := c.Get("user").(*jwt.Token)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+			}
+			claims, ok := token.Claims.(*JwtAppClaims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+
+			for _, r := range roles {
+				if claims.Role == r {
+					return next(c)
+				}
+			}
+			return echo.NewHTTPError(http.StatusForbidden, "insufficient role")
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package auth_test
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRevocations struct {
+	revoked map[string]bool
+	err     error
+}
+
+func (f *fakeRevocations) IsRevoked(jti string) (bool, error) {
+	return f.revoked[jti], f.err
+}
+
+// runs the revocation middleware with the token set as the jwt middleware would
+func runRevocation(rc auth.RevocationChecker, token any) error {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	if token != nil {
+		c.Set("user", token)
+	}
+	h := auth.RevocationMiddleware(rc)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	return h(c)
+}
+
+func httpStatus(t *testing.T, err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return he.Code
+}
+
+func TestRevocationMiddleware(t *testing.T) {
+	rc := &fakeRevocations{revoked: map[string]bool{"revoked-jti": true}}
+	tokenWithID := func(jti string) *jwt.Token {
+		return &jwt.Token{Claims: &auth.JwtAppClaims{RegisteredClaims: jwt.RegisteredClaims{ID: jti}}}
+	}
+
+	assert.Equal(t, http.StatusOK, httpStatus(t, runRevocation(rc, tokenWithID("valid-jti"))))
+	assert.Equal(t, http.StatusUnauthorized, httpStatus(t, runRevocation(rc, tokenWithID("revoked-jti"))))
+	// tokens without a jti can't be revoked, so they are not accepted
+	assert.Equal(t, http.StatusUnauthorized, httpStatus(t, runRevocation(rc, tokenWithID(""))))
+	assert.Equal(t, http.StatusUnauthorized, httpStatus(t, runRevocation(rc, nil)))
+
+	rc.err = errors.New("db down")
+	assert.Equal(t, http.StatusInternalServerError, httpStatus(t, runRevocation(rc, tokenWithID("valid-jti"))))
+}
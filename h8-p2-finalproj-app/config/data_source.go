@@ -48,5 +48,183 @@ func CreateDBInstance() *gorm.DB {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = db.AutoMigrate(&model.RefreshToken{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RevokedToken{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.LoginAttempt{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AccountLockout{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.TwoFactorAuth{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RecoveryCode{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.LoginChallenge{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.EmailChangeRequest{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Notification{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// mail bodies were kept before, they hold tokens and payment links
+	if db.Migrator().HasColumn(&model.Notification{}, "body") {
+		err = db.Migrator().DropColumn(&model.Notification{}, "body")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = db.AutoMigrate(&model.DataExport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// replaced by download_token_hash
+	if db.Migrator().HasColumn(&model.DataExport{}, "download_token") {
+		err = db.Migrator().DropColumn(&model.DataExport{}, "download_token")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = db.AutoMigrate(&model.Branch{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.BranchOpeningHour{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OneWayFee{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.VehicleUnit{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Maintenance{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.PricingRule{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RentalPriceItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Quote{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.QuoteItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Promotion{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.PromotionRedemption{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RentalAddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.QuoteAddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.CarImage{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Review{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.ReviewPhoto{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Favorite{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AvailabilityWatch{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.WaitlistEntry{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.DriverLicense{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Organization{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationMember{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationInvitation{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationInvoice{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.SecurityDepositRate{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.SecurityDeposit{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionReport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionPhoto{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.DamageCharge{})
+	if err != nil {
+		log.Fatal(err)
+	}
 	return db
 }
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type AddOnHandler struct {
+	db  *gorm.DB
+	aos *service.AddOnService
+}
+
+func NewAddOnHandler(db *gorm.DB, aos *service.AddOnService) AddOnHandler {
+	return AddOnHandler{
+		db:  db,
+		aos: aos,
+	}
+}
+
+type PostAddOnReq struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	PricingType string  `json:"pricing_type"`
+	Price       float64 `json:"price"`
+	Inventory   *uint   `json:"inventory"`
+}
+
+type PutAddOnReq struct {
+	Description *string  `json:"description"`
+	Price       *float64 `json:"price"`
+	Inventory   *uint    `json:"inventory"`
+	// removes the inventory limit
+	Unlimited bool  `json:"unlimited"`
+	Active    *bool `json:"active"`
+}
+
+type AddOnRespItem struct {
+	AddOnID     uint    `json:"add_on_id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	PricingType string  `json:"pricing_type"`
+	Price       float64 `json:"price"`
+	Inventory   *uint   `json:"inventory,omitempty"`
+	Active      bool    `json:"active"`
+}
+
+func toAddOnRespItem(a *model.AddOn) AddOnRespItem {
+	return AddOnRespItem{
+		AddOnID:     a.ID,
+		Name:        a.Name,
+		Description: a.Description,
+		PricingType: a.PricingType,
+		Price:       a.Price,
+		Inventory:   a.Inventory,
+		Active:      a.Active,
+	}
+}
+
+// @Summary	Add ons offered for rentals
+// @Tags		add ons
+// @Produce	json
+// @Success	200	{array}		handler.AddOnRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/add-ons [get]
+func (ah *AddOnHandler) HandleGetAddOns(c echo.Context) error {
+	addOns, err := ah.aos.GetActiveAddOns()
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []AddOnRespItem{}
+	for i := range addOns {
+		resp = append(resp, toAddOnRespItem(&addOns[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Adds an add on
+// @Tags		add ons
+// @Accept		json
+// @Param		AddOnData	body	handler.PostAddOnReq	true	"Name, pricing and inventory"
+// @Produce	json
+// @Success	201	{object}	handler.AddOnRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/add-ons [post]
+func (ah *AddOnHandler) HandlePostAddOn(c echo.Context) error {
+	var reqBody PostAddOnReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if reqBody.Name == "" {
+		return util.NewAppError(http.StatusBadRequest, "name cannot be empty", "")
+	}
+	if reqBody.PricingType != model.AddOnPerDay && reqBody.PricingType != model.AddOnPerRental {
+		return util.NewAppError(http.StatusBadRequest, "invalid pricing type", "")
+	}
+	if reqBody.Price < 0 {
+		return util.NewAppError(http.StatusBadRequest, "price cannot be negative", "")
+	}
+
+	var count int64
+	err = ah.db.Model(&model.AddOn{}).Where("name=?", reqBody.Name).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		return util.NewAppError(http.StatusBadRequest, "add on already exists", "")
+	}
+
+	addOn := model.AddOn{
+		Name:        reqBody.Name,
+		Description: strings.TrimSpace(reqBody.Description),
+		PricingType: reqBody.PricingType,
+		Price:       reqBody.Price,
+		Inventory:   reqBody.Inventory,
+		Active:      true,
+	}
+	err = ah.db.Create(&addOn).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusCreated, toAddOnRespItem(&addOn))
+}
+
+// @Summary	Updates an add on, its price, inventory and whether it is offered
+// @Tags		add ons
+// @Accept		json
+// @Param		id			path	int					true	"Add on id"
+// @Param		AddOnData	body	handler.PutAddOnReq	true	"Fields to update"
+// @Produce	json
+// @Success	200	{object}	handler.AddOnRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/add-ons/{id} [put]
+func (ah *AddOnHandler) HandlePutAddOn(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var addOn model.AddOn
+	err = ah.db.Where("id=?", id).First(&addOn).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return util.NewAppError(http.StatusNotFound, "add on not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	var reqBody PutAddOnReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.Description != nil {
+		addOn.Description = strings.TrimSpace(*reqBody.Description)
+	}
+	if reqBody.Price != nil {
+		if *reqBody.Price < 0 {
+			return util.NewAppError(http.StatusBadRequest, "price cannot be negative", "")
+		}
+		addOn.Price = *reqBody.Price
+	}
+	if reqBody.Unlimited {
+		addOn.Inventory = nil
+	} else if reqBody.Inventory != nil {
+		addOn.Inventory = reqBody.Inventory
+	}
+	if reqBody.Active != nil {
+		addOn.Active = *reqBody.Active
+	}
+
+	err = ah.db.Save(&addOn).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, toAddOnRespItem(&addOn))
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type BranchHandler struct {
+	db *gorm.DB
+	bs *service.BranchService
+}
+
+func NewBranchHandler(db *gorm.DB, bs *service.BranchService) BranchHandler {
+	return BranchHandler{
+		db: db,
+		bs: bs,
+	}
+}
+
+type OpeningHourItem struct {
+	Weekday  int    `json:"weekday"`
+	OpensAt  string `json:"opens_at"`
+	ClosesAt string `json:"closes_at"`
+}
+
+type BranchReq struct {
+	Name         string            `json:"name"`
+	Address      string            `json:"address"`
+	City         string            `json:"city"`
+	Phone        string            `json:"phone"`
+	OpeningHours []OpeningHourItem `json:"opening_hours"`
+}
+
+type BranchRespItem struct {
+	BranchID     uint              `json:"branch_id"`
+	Name         string            `json:"name"`
+	Address      string            `json:"address"`
+	City         string            `json:"city"`
+	Phone        string            `json:"phone,omitempty"`
+	OpeningHours []OpeningHourItem `json:"opening_hours"`
+}
+
+type OneWayFeeReq struct {
+	FromBranchID uint    `json:"from_branch_id"`
+	ToBranchID   uint    `json:"to_branch_id"`
+	Fee          float64 `json:"fee"`
+}
+
+type OneWayFeeResp struct {
+	FromBranchID uint    `json:"from_branch_id"`
+	ToBranchID   uint    `json:"to_branch_id"`
+	Fee          float64 `json:"fee"`
+}
+
+func toBranchRespItem(b *model.Branch) BranchRespItem {
+	resp := BranchRespItem{
+		BranchID:     b.ID,
+		Name:         b.Name,
+		Address:      b.Address,
+		City:         b.City,
+		Phone:        b.Phone,
+		OpeningHours: []OpeningHourItem{},
+	}
+	for _, oh := range b.OpeningHours {
+		resp.OpeningHours = append(resp.OpeningHours, OpeningHourItem{
+			Weekday:  oh.Weekday,
+			OpensAt:  oh.OpensAt,
+			ClosesAt: oh.ClosesAt,
+		})
+	}
+	return resp
+}
+
+func (bh *BranchHandler) validateBranchReq(br *BranchReq) ([]model.BranchOpeningHour, error) {
+	br.Name = strings.TrimSpace(br.Name)
+	br.Address = strings.TrimSpace(br.Address)
+	br.City = strings.TrimSpace(br.City)
+	if br.Name == "" || br.Address == "" || br.City == "" {
+		return nil, util.NewAppError(http.StatusBadRequest, "name, address and city cannot be empty", "")
+	}
+
+	hours := []model.BranchOpeningHour{}
+	seen := map[int]bool{}
+	for _, oh := range br.OpeningHours {
+		if oh.Weekday < 0 || oh.Weekday > 6 || seen[oh.Weekday] {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid weekday in opening hours", "")
+		}
+		seen[oh.Weekday] = true
+		opens, err := time.Parse("15:04", oh.OpensAt)
+		if err != nil {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid opening time", "")
+		}
+		closes, err := time.Parse("15:04", oh.ClosesAt)
+		if err != nil {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid closing time", "")
+		}
+		if !closes.After(opens) {
+			return nil, util.NewAppError(http.StatusBadRequest, "closing time must be after opening time", "")
+		}
+		hours = append(hours, model.BranchOpeningHour{
+			Weekday:  oh.Weekday,
+			OpensAt:  oh.OpensAt,
+			ClosesAt: oh.ClosesAt,
+		})
+	}
+	return hours, nil
+}
+
+// @Summary	Branches with their addresses and opening hours
+// @Tags		branches
+// @Produce	json
+// @Success	200	{array}		handler.BranchRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/branches [get]
+func (bh *BranchHandler) HandleGetBranches(c echo.Context) error {
+	branches, err := bh.bs.GetBranches()
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []BranchRespItem{}
+	for i := range branches {
+		resp = append(resp, toBranchRespItem(&branches[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	A branch with its opening hours
+// @Tags		branches
+// @Param		id	path	int	true	"Branch id"
+// @Produce	json
+// @Success	200	{object}	handler.BranchRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/branches/{id} [get]
+func (bh *BranchHandler) HandleGetBranch(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	branch, err := bh.bs.GetBranch(id)
+	if err != nil && errors.Is(err, service.ErrBranchNotFound) {
+		return util.NewAppError(http.StatusNotFound, "branch not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, toBranchRespItem(branch))
+}
+
+// @Summary	Adds a branch
+// @Tags		branches
+// @Accept		json
+// @Param		BranchData	body	handler.BranchReq	true	"Name, address and opening hours"
+// @Produce	json
+// @Success	201	{object}	handler.BranchRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/branches [post]
+func (bh *BranchHandler) HandlePostBranch(c echo.Context) error {
+	var reqBody BranchReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	hours, err := bh.validateBranchReq(&reqBody)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = bh.db.Model(&model.Branch{}).Where("name=?", reqBody.Name).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		return util.NewAppError(http.StatusBadRequest, "branch name already used", "")
+	}
+
+	branch := model.Branch{
+		Name:         reqBody.Name,
+		Address:      reqBody.Address,
+		City:         reqBody.City,
+		Phone:        strings.TrimSpace(reqBody.Phone),
+		OpeningHours: hours,
+	}
+	err = bh.db.Create(&branch).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, toBranchRespItem(&branch))
+}
+
+// @Summary	Updates a branch, the opening hours are replaced
+// @Tags		branches
+// @Accept		json
+// @Param		id			path	int					true	"Branch id"
+// @Param		BranchData	body	handler.BranchReq	true	"Name, address and opening hours"
+// @Produce	json
+// @Success	200	{object}	handler.BranchRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/branches/{id} [put]
+func (bh *BranchHandler) HandlePutBranch(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	branch, err := bh.bs.GetBranch(id)
+	if err != nil && errors.Is(err, service.ErrBranchNotFound) {
+		return util.NewAppError(http.StatusNotFound, "branch not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	var reqBody BranchReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	hours, err := bh.validateBranchReq(&reqBody)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = bh.db.Model(&model.Branch{}).Where("name=? AND id<>?", reqBody.Name, branch.ID).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		return util.NewAppError(http.StatusBadRequest, "branch name already used", "")
+	}
+
+	err = bh.db.Model(branch).Updates(map[string]any{
+		"name":    reqBody.Name,
+		"address": reqBody.Address,
+		"city":    reqBody.City,
+		"phone":   strings.TrimSpace(reqBody.Phone),
+	}).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	err = bh.bs.SetOpeningHours(branch, hours)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, toBranchRespItem(branch))
+}
+
+// @Summary	Sets the one way fee of a route
+// @Tags		branches
+// @Accept		json
+// @Param		FeeData	body	handler.OneWayFeeReq	true	"From and to branch and fee"
+// @Produce	json
+// @Success	200	{object}	handler.OneWayFeeResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/branches/one-way-fees [put]
+func (bh *BranchHandler) HandlePutOneWayFee(c echo.Context) error {
+	var reqBody OneWayFeeReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.FromBranchID == reqBody.ToBranchID {
+		return util.NewAppError(http.StatusBadRequest, "branches must be different", "")
+	}
+	if reqBody.Fee < 0 {
+		return util.NewAppError(http.StatusBadRequest, "fee cannot be negative", "")
+	}
+	for _, id := range []uint{reqBody.FromBranchID, reqBody.ToBranchID} {
+		_, err = bh.bs.GetBranch(id)
+		if err != nil && errors.Is(err, service.ErrBranchNotFound) {
+			return util.NewAppError(http.StatusNotFound, "branch not found", "")
+		} else if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+	}
+
+	fee, err := bh.bs.SetOneWayFee(reqBody.FromBranchID, reqBody.ToBranchID, reqBody.Fee)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, OneWayFeeResp{
+		FromBranchID: fee.FromBranchID,
+		ToBranchID:   fee.ToBranchID,
+		Fee:          fee.Fee,
+	})
+}
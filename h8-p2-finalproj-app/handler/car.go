@@ -1,10 +1,14 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
 	"h8-p2-finalproj-app/service"
 	"h8-p2-finalproj-app/util"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -20,11 +24,28 @@ func NewCarHandler(cs *service.CarService) CarHandler {
 	}
 }
 
+// format of pickup and return times in responses
+const rentalTimeFormat = time.RFC3339
+
+// parses a pickup or return time, a timestamp with timezone like
+// 2024-09-02T09:30:00+07:00, or a date for rentals by the day.
+// the bool is false for dates, times are truncated to the minute
+func parseRentalTime(s string) (time.Time, bool, error) {
+	// an unescaped + in a query string is read as a space
+	s = strings.Replace(s, " ", "+", 1)
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t.Truncate(time.Minute), true, nil
+	}
+	t, err = time.Parse(time.DateOnly, s)
+	return t, false, err
+}
+
 func (ch *CarHandler) GetQueryParams(c echo.Context) (*service.GetCarsQueryParams, error) {
 	param := service.GetCarsQueryParams{}
 	if startDate := c.QueryParam("startDate"); startDate != "" {
 		// parse date
-		date, err := time.Parse(time.DateOnly, startDate)
+		date, _, err := parseRentalTime(startDate)
 		if err != nil {
 			return nil, util.NewAppError(http.StatusBadRequest, "invalid start date", "")
 		}
@@ -32,12 +53,30 @@ func (ch *CarHandler) GetQueryParams(c echo.Context) (*service.GetCarsQueryParam
 	}
 	if endDate := c.QueryParam("endDate"); endDate != "" {
 		// parse date
-		date, err := time.Parse(time.DateOnly, endDate)
+		date, _, err := parseRentalTime(endDate)
 		if err != nil {
 			return nil, util.NewAppError(http.StatusBadRequest, "invalid end date", "")
 		}
 		param.EndDate = &date
 	}
+	if branchID := c.QueryParam("pickupBranchId"); branchID != "" {
+		id, err := strconv.Atoi(branchID)
+		if err != nil || id < 1 {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid pickup branch id", "")
+		}
+		branch := uint(id)
+		param.PickupBranchID = &branch
+	}
+	if sortBy := c.QueryParam("sort"); sortBy != "" {
+		if sortBy != service.CarSortRating {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid sort", "")
+		}
+		param.SortBy = sortBy
+	}
+	// seats typed in the search are used unless given separately
+	if q := c.QueryParam("q"); q != "" {
+		param.SearchTerms, param.Seats = service.ParseCarSearch(q)
+	}
 	if seats := c.QueryParam("seats"); seats != "" {
 		// parse date
 		seats, err := strconv.Atoi(seats)
@@ -47,25 +86,124 @@ func (ch *CarHandler) GetQueryParams(c echo.Context) (*service.GetCarsQueryParam
 		param.Seats = &seats
 	}
 
-	if param.EndDate != nil && param.EndDate.Before(*param.StartDate) {
-		return nil, util.NewAppError(http.StatusBadRequest, "end date cannot be before start date", "")
+	if param.StartDate != nil && param.EndDate != nil && !param.EndDate.After(*param.StartDate) {
+		return nil, util.NewAppError(http.StatusBadRequest, "end date must be after start date", "")
 	}
 	return &param, nil
 }
 
+// extra groups of fields clients can request on GET /cars with fields=
+const (
+	CarFieldSpecs  = "specs"
+	CarFieldRating = "rating"
+	CarFieldImages = "images"
+)
+
+type CarSpecs struct {
+	Type         string  `json:"type"`
+	Transmission string  `json:"transmission"`
+	WheelDrive   uint    `json:"wheel_drive"`
+	Year         uint    `json:"year"`
+	RatePerDay   float64 `json:"rate_per_day"`
+	RatePerHour  float64 `json:"rate_per_hour,omitempty"`
+}
+
+type CarRating struct {
+	AverageRating float64 `json:"average_rating"`
+	NumOfReviews  uint    `json:"num_of_reviews"`
+}
+
+type CarImageResp struct {
+	ImageID      uint   `json:"image_id"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Position     int    `json:"position"`
+}
+
+func toCarSpecs(car *model.Car) *CarSpecs {
+	return &CarSpecs{
+		Type:         car.Type,
+		Transmission: car.Transmission,
+		WheelDrive:   car.WheelDrive,
+		Year:         car.Year,
+		RatePerDay:   car.RatePerDay,
+		RatePerHour:  car.RatePerHour,
+	}
+}
+
+func toCarRating(car *model.Car) *CarRating {
+	return &CarRating{
+		AverageRating: car.AverageRating,
+		NumOfReviews:  car.NumOfReviews,
+	}
+}
+
+func toCarImagesResp(images []model.CarImage) []CarImageResp {
+	resp := []CarImageResp{}
+	for _, img := range images {
+		resp = append(resp, CarImageResp{
+			ImageID:      img.ID,
+			URL:          img.URL,
+			ThumbnailURL: img.ThumbnailURL,
+			Position:     img.Position,
+		})
+	}
+	return resp
+}
+
+// parses the comma separated fields param
+func parseCarFields(fields string) (map[string]bool, error) {
+	result := map[string]bool{}
+	if fields == "" {
+		return result, nil
+	}
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case CarFieldSpecs, CarFieldRating, CarFieldImages:
+			result[f] = true
+		default:
+			return nil, util.NewAppError(http.StatusBadRequest, fmt.Sprintf("invalid field %q", f), "")
+		}
+	}
+	return result, nil
+}
+
+// specs and rating are only included when requested with fields
 type GetCarsRespItem struct {
 	CardID             uint   `json:"car_id"`
 	Manufacturer       string `json:"manufacturer"`
 	CarModel           string `json:"model"`
 	Seats              uint   `json:"seats"`
 	NumOfCarsAvailable uint   `json:"num_of_cars_available"`
+	*CarSpecs
+	*CarRating
+	Images []CarImageResp `json:"images,omitempty"`
 }
 
+// @Summary	Available cars, for the dates when given
+// @Tags		cars
+// @Param		startDate		query	string	false	"Start date or time"
+// @Param		endDate			query	string	false	"End date or time"
+// @Param		seats			query	int		false	"Minimum number of seats"
+// @Param		pickupBranchId	query	int		false	"Only count units at the pickup branch"
+// @Param		q				query	string	false	"Search words, like toyota suv 7 seats, ranked by relevance"
+// @Param		sort			query	string	false	"rating for the best rated first"
+// @Param		fields			query	string	false	"Comma separated specs, rating and images"
+// @Produce	json
+// @Success	200	{array}		handler.GetCarsRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars [get]
 func (ch *CarHandler) HandleGetCars(c echo.Context) error {
 	params, err := ch.GetQueryParams(c)
 	if err != nil {
 		return err
 	}
+	fields, err := parseCarFields(c.QueryParam("fields"))
+	if err != nil {
+		return err
+	}
 	availCars, err := ch.cs.GetCarsWithRentals(params)
 	if err != nil {
 		return err
@@ -73,16 +211,195 @@ func (ch *CarHandler) HandleGetCars(c echo.Context) error {
 	// convert to brief with available cars within that date range
 	resp := []GetCarsRespItem{}
 	for _, ac := range availCars {
-		if ac.Stock <= ac.NumOfRentals {
+		if ac.NumOfAvailable() == 0 {
 			// skip cars that are fully rented out
 			continue
 		}
-		resp = append(resp, GetCarsRespItem{
+		item := GetCarsRespItem{
 			CardID:             ac.ID,
 			Manufacturer:       ac.Manufacturer,
 			CarModel:           ac.CarModel,
 			Seats:              ac.Seats,
-			NumOfCarsAvailable: ac.Stock - ac.NumOfRentals,
+			NumOfCarsAvailable: ac.NumOfAvailable(),
+		}
+		if fields[CarFieldSpecs] {
+			item.CarSpecs = toCarSpecs(&ac.Car)
+		}
+		if fields[CarFieldRating] {
+			item.CarRating = toCarRating(&ac.Car)
+		}
+		resp = append(resp, item)
+	}
+
+	if fields[CarFieldImages] && len(resp) > 0 {
+		carIDs := []uint{}
+		for _, item := range resp {
+			carIDs = append(carIDs, item.CardID)
+		}
+		images, err := ch.cs.GetCarImages(carIDs)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		for i := range resp {
+			resp[i].Images = toCarImagesResp(images[resp[i].CardID])
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+type CarAvailabilityResp struct {
+	StartDate          string `json:"start_date"`
+	EndDate            string `json:"end_date"`
+	NumOfCarsAvailable uint   `json:"num_of_cars_available"`
+	Available          bool   `json:"available"`
+}
+
+type CarDetailResp struct {
+	CarID        uint   `json:"car_id"`
+	Manufacturer string `json:"manufacturer"`
+	CarModel     string `json:"model"`
+	Seats        uint   `json:"seats"`
+	*CarSpecs
+	*CarRating
+	Images []CarImageResp `json:"images"`
+	// only when startDate and endDate are given
+	Availability *CarAvailabilityResp `json:"availability,omitempty"`
+}
+
+// @Summary	Details of a car with specs, images and rating
+// @Tags		cars
+// @Param		id			path	int		true	"Car id"
+// @Param		startDate	query	string	false	"Start date or time, for the availability"
+// @Param		endDate		query	string	false	"End date or time, for the availability"
+// @Produce	json
+// @Success	200	{object}	handler.CarDetailResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id} [get]
+// full details of a car, with its availability when dates are given
+func (ch *CarHandler) HandleGetCar(c echo.Context) error {
+	carID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	params, err := ch.GetQueryParams(c)
+	if err != nil {
+		return err
+	}
+	car, err := ch.cs.GetCar(carID)
+	if err != nil && errors.Is(err, service.ErrCarNotFound) {
+		return util.NewAppError(http.StatusNotFound, "car not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	images, err := ch.cs.GetCarImages([]uint{car.ID})
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp := CarDetailResp{
+		CarID:        car.ID,
+		Manufacturer: car.Manufacturer,
+		CarModel:     car.CarModel,
+		Seats:        car.Seats,
+		CarSpecs:     toCarSpecs(car),
+		CarRating:    toCarRating(car),
+		Images:       toCarImagesResp(images[car.ID]),
+	}
+	if params.StartDate != nil && params.EndDate != nil {
+		data, err := ch.cs.GetCarWithRentals(car.ID, params)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		resp.Availability = &CarAvailabilityResp{
+			StartDate:          params.StartDate.Format(rentalTimeFormat),
+			EndDate:            params.EndDate.Format(rentalTimeFormat),
+			NumOfCarsAvailable: data.NumOfAvailable(),
+			Available:          data.NumOfAvailable() > 0,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+type DayAvailabilityItem struct {
+	Date         string `json:"date"`
+	NumAvailable uint   `json:"num_available"`
+}
+
+type AvailabilityResp struct {
+	CarID uint                  `json:"car_id"`
+	From  string                `json:"from"`
+	To    string                `json:"to"`
+	Days  []DayAvailabilityItem `json:"days"`
+}
+
+// @Summary	Available units of a car for each day
+// @Tags		cars
+// @Param		id				path	int		true	"Car id"
+// @Param		from			query	string	true	"First day, a date or timestamp, at most a year before to"
+// @Param		to				query	string	true	"Last day, a date or timestamp"
+// @Param		pickupBranchId	query	int		false	"Only count units at the pickup branch"
+// @Produce	json
+// @Success	200	{object}	handler.AvailabilityResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/availability [get]
+// available units of the car for each day from from to to, both included.
+// days start at the time of day of from, so a timestamp sets the timezone
+func (ch *CarHandler) HandleGetAvailability(c echo.Context) error {
+	carID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	from, _, err := parseRentalTime(c.QueryParam("from"))
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid from date", "")
+	}
+	to, _, err := parseRentalTime(c.QueryParam("to"))
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid to date", "")
+	}
+	if to.Before(from) {
+		return util.NewAppError(http.StatusBadRequest, "to date cannot be before from date", "")
+	}
+	end := to.AddDate(0, 0, 1)
+	if end.After(from.AddDate(0, 0, service.MaxCalendarDays)) {
+		return util.NewAppError(http.StatusBadRequest,
+			fmt.Sprintf("at most %d days can be requested", service.MaxCalendarDays), "")
+	}
+	var branchID *uint
+	if id := c.QueryParam("pickupBranchId"); id != "" {
+		parsed, err := strconv.Atoi(id)
+		if err != nil || parsed < 1 {
+			return util.NewAppError(http.StatusBadRequest, "invalid pickup branch id", "")
+		}
+		branch := uint(parsed)
+		branchID = &branch
+	}
+
+	car, err := ch.cs.GetCar(carID)
+	if err != nil && errors.Is(err, service.ErrCarNotFound) {
+		return util.NewAppError(http.StatusNotFound, "car not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	days, err := ch.cs.GetAvailabilityCalendar(car.ID, branchID, from, end)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := AvailabilityResp{
+		CarID: car.ID,
+		From:  from.Format(time.DateOnly),
+		To:    to.Format(time.DateOnly),
+		Days:  []DayAvailabilityItem{},
+	}
+	for _, d := range days {
+		resp.Days = append(resp.Days, DayAvailabilityItem{
+			Date:         d.Date.Format(time.DateOnly),
+			NumAvailable: d.NumAvailable,
 		})
 	}
 	return c.JSON(http.StatusOK, resp)
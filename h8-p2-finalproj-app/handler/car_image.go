@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/storage"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// uploaded files never change, a new upload gets a new key
+const imageCacheControl = "public, max-age=31536000, immutable"
+
+type CarImageHandler struct {
+	db  *gorm.DB
+	cis *service.CarImageService
+	// nil when images are not kept on the local disk
+	local *storage.LocalStorage
+}
+
+func NewCarImageHandler(db *gorm.DB, cis *service.CarImageService, local *storage.LocalStorage) CarImageHandler {
+	return CarImageHandler{
+		db:    db,
+		cis:   cis,
+		local: local,
+	}
+}
+
+type PutImageOrderReq struct {
+	ImageIDs []uint `json:"image_ids"`
+}
+
+func carImageAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrImageTooLarge):
+		return util.NewAppError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("image must be at most %d MB", service.MaxCarImageSize>>20), "")
+	case errors.Is(err, service.ErrImageType):
+		return util.NewAppError(http.StatusUnsupportedMediaType, "image must be a jpeg or png", "")
+	case errors.Is(err, service.ErrCarImageNotFound):
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	case errors.Is(err, service.ErrCarImageOrder):
+		return util.NewAppError(http.StatusBadRequest, "order must list every image of the car once", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// @Summary	Image gallery of a car
+// @Tags		car images
+// @Param		id	path	int	true	"Car id"
+// @Produce	json
+// @Success	200	{array}		handler.CarImageResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/images [get]
+func (cih *CarImageHandler) HandleGetImages(c echo.Context) error {
+	car, err := findCarFromParam(c, cih.db)
+	if err != nil {
+		return err
+	}
+	images, err := cih.cis.GetImages(car.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, toCarImagesResp(images))
+}
+
+// @Summary	Uploads an image of a car, a thumbnail is generated
+// @Tags		car images
+// @Accept		multipart/form-data
+// @Param		id		path		int		true	"Car id"
+// @Param		image	formData	file	true	"Jpeg or png, at most 5 MB"
+// @Produce	json
+// @Success	201	{object}	handler.CarImageResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	413	{object}	util.AppError
+// @Failure	415	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/images [post]
+// multipart upload with the file in the image field, added to the end of the gallery
+func (cih *CarImageHandler) HandlePostImage(c echo.Context) error {
+	car, err := findCarFromParam(c, cih.db)
+	if err != nil {
+		return err
+	}
+	file, err := c.FormFile("image")
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "image file is required", err.Error())
+	}
+	if file.Size > service.MaxCarImageSize {
+		return carImageAppError(service.ErrImageTooLarge)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	defer src.Close()
+
+	image, err := cih.cis.Upload(car.ID, src)
+	if err != nil {
+		return carImageAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toCarImagesResp([]model.CarImage{*image})[0])
+}
+
+// @Summary	Reorders the image gallery of a car
+// @Tags		car images
+// @Accept		json
+// @Param		id			path	int							true	"Car id"
+// @Param		OrderData	body	handler.PutImageOrderReq	true	"Every image id of the car in the new order"
+// @Produce	json
+// @Success	200	{array}		handler.CarImageResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/images/order [put]
+func (cih *CarImageHandler) HandlePutImageOrder(c echo.Context) error {
+	car, err := findCarFromParam(c, cih.db)
+	if err != nil {
+		return err
+	}
+	var reqBody PutImageOrderReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	images, err := cih.cis.Reorder(car.ID, reqBody.ImageIDs)
+	if err != nil {
+		return carImageAppError(err)
+	}
+	return c.JSON(http.StatusOK, toCarImagesResp(images))
+}
+
+// @Summary	Deletes an image of a car
+// @Tags		car images
+// @Param		id			path	int	true	"Car id"
+// @Param		image_id	path	int	true	"Image id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/images/{image_id} [delete]
+func (cih *CarImageHandler) HandleDeleteImage(c echo.Context) error {
+	car, err := findCarFromParam(c, cih.db)
+	if err != nil {
+		return err
+	}
+	imageID, err := parseIDParam(c, "image_id")
+	if err != nil {
+		return err
+	}
+	err = cih.cis.Delete(car.ID, imageID)
+	if err != nil {
+		return carImageAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "image deleted",
+	})
+}
+
+// @Summary	Image file kept on the local disk
+// @Tags		car images
+// @Param		path	path	string	true	"Image key"
+// @Produce	image/jpeg
+// @Produce	image/png
+// @Success	200	{file}		file
+// @Failure	404	{object}	util.AppError
+// @Router		/images/{path} [get]
+// serves images kept on the local disk
+func (cih *CarImageHandler) HandleServeImage(c echo.Context) error {
+	if cih.local == nil {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	path, err := cih.local.Path(c.Param("*"))
+	if err != nil {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	// missing images must not be cached
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	c.Response().Header().Set("Cache-Control", imageCacheControl)
+	return c.File(path)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCarFields(t *testing.T) {
+	fields, err := parseCarFields("")
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+
+	fields, err = parseCarFields("specs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{CarFieldSpecs: true}, fields)
+
+	// combined, in any order and with spaces, repeats are fine
+	fields, err = parseCarFields("images, rating,specs,rating")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{CarFieldSpecs: true, CarFieldRating: true, CarFieldImages: true}, fields)
+
+	for _, invalid := range []string{"price", "specs,price", "specs,", "SPECS", ","} {
+		_, err = parseCarFields(invalid)
+		var appErr *util.AppError
+		assert.True(t, errors.As(err, &appErr), invalid)
+		assert.Equal(t, http.StatusBadRequest, appErr.StatusCode, invalid)
+	}
+}
+
+func TestHandleGetCarsInvalidField(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = util.ErrorHandler
+	// the fields are checked before the cars are looked up
+	ch := NewCarHandler(nil)
+	e.GET("/cars", ch.HandleGetCars)
+
+	req := httptest.NewRequest(http.MethodGet, "/cars?fields=specs,price", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var body map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, `invalid field "price"`, body["message"])
+}
+
+func TestGetCarsRespItemFields(t *testing.T) {
+	car := &model.Car{Type: "SUV", Transmission: "Automatic", RatePerDay: 500000, AverageRating: 4.5, NumOfReviews: 2}
+
+	brief, err := json.Marshal(GetCarsRespItem{CardID: 1, Manufacturer: "Toyota"})
+	assert.NoError(t, err)
+	var keys map[string]any
+	assert.NoError(t, json.Unmarshal(brief, &keys))
+	assert.NotContains(t, keys, "type")
+	assert.NotContains(t, keys, "average_rating")
+	assert.NotContains(t, keys, "images")
+
+	// specs and rating are flattened into the item
+	full, err := json.Marshal(GetCarsRespItem{
+		CardID:    1,
+		CarSpecs:  toCarSpecs(car),
+		CarRating: toCarRating(car),
+		Images:    toCarImagesResp([]model.CarImage{{URL: "/images/1.jpg"}}),
+	})
+	assert.NoError(t, err)
+	keys = nil
+	assert.NoError(t, json.Unmarshal(full, &keys))
+	assert.Equal(t, "SUV", keys["type"])
+	assert.Equal(t, 4.5, keys["average_rating"])
+	assert.Len(t, keys["images"], 1)
+}
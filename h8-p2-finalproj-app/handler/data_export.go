@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type DataExportHandler struct {
+	db  *gorm.DB
+	des *service.DataExportService
+}
+
+func NewDataExportHandler(db *gorm.DB, des *service.DataExportService) DataExportHandler {
+	return DataExportHandler{
+		db:  db,
+		des: des,
+	}
+}
+
+type DataExportResp struct {
+	ExportID    uint       `json:"export_id"`
+	Status      string     `json:"status"`
+	DownloadUrl string     `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// completed exports get a new download link, replacing the one given before
+func (deh *DataExportHandler) toResp(export *model.DataExport) (*DataExportResp, error) {
+	resp := DataExportResp{
+		ExportID:  export.ID,
+		Status:    export.Status,
+		CreatedAt: export.CreatedAt,
+	}
+	if export.Status == model.DataExportCompleted {
+		token, err := deh.des.DownloadToken(export)
+		if err != nil {
+			return nil, err
+		}
+		resp.DownloadUrl = fmt.Sprintf("%s/users/export/%d/download?token=%s",
+			os.Getenv("APP_URL"), export.ID, token)
+		resp.ExpiresAt = export.ExpiresAt
+	}
+	return &resp, nil
+}
+
+func parseExportID(c echo.Context) (uint, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		return 0, util.NewAppError(http.StatusBadRequest, "invalid export id", "")
+	}
+	return uint(id), nil
+}
+
+// @Summary	Request an export of the user's personal data
+// @Tags		users
+// @Produce	json
+// @Success	201	{object}	handler.DataExportResp
+// @Success	202	{object}	handler.DataExportResp
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/export [post]
+func (deh *DataExportHandler) HandlePostDataExport(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, deh.db)
+	if err != nil {
+		return err
+	}
+
+	export, err := deh.des.Request(user)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	// large exports are still being generated
+	status := http.StatusCreated
+	if export.Status != model.DataExportCompleted {
+		status = http.StatusAccepted
+	}
+	resp, err := deh.toResp(export)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(status, resp)
+}
+
+// @Summary	Status of a personal data export, a completed export gets a new download link
+// @Tags		users
+// @Param		id	path	int	true	"Export id"
+// @Produce	json
+// @Success	200	{object}	handler.DataExportResp
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/export/{id} [get]
+func (deh *DataExportHandler) HandleGetDataExport(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, deh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseExportID(c)
+	if err != nil {
+		return err
+	}
+
+	export, err := deh.des.Get(user.ID, id)
+	if err != nil && errors.Is(err, service.ErrDataExportNotFound) {
+		return util.NewAppError(http.StatusNotFound, "export not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp, err := deh.toResp(export)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Download a personal data export archive
+// @Tags		users
+// @Param		id		path	int		true	"Export id"
+// @Param		token	query	string	true	"Download token"
+// @Produce	application/zip
+// @Success	200
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	410	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/export/{id}/download [get]
+func (deh *DataExportHandler) HandleDownloadDataExport(c echo.Context) error {
+	id, err := parseExportID(c)
+	if err != nil {
+		return err
+	}
+
+	path, err := deh.des.Open(id, c.QueryParam("token"))
+	if err != nil && errors.Is(err, service.ErrDataExportNotFound) {
+		return util.NewAppError(http.StatusNotFound, "export not found", "")
+	} else if err != nil && errors.Is(err, service.ErrDataExportNotReady) {
+		return util.NewAppError(http.StatusConflict, "export is not ready yet", "")
+	} else if err != nil && errors.Is(err, service.ErrDataExportExpired) {
+		return util.NewAppError(http.StatusGone, "export link has expired", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Attachment(path, fmt.Sprintf("personal-data-%d.zip", id))
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/storage"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type DriverLicenseHandler struct {
+	db  *gorm.DB
+	dls *service.DriverLicenseService
+	// private storage the license images are read from
+	documents *storage.LocalStorage
+}
+
+func NewDriverLicenseHandler(db *gorm.DB, dls *service.DriverLicenseService, documents *storage.LocalStorage) DriverLicenseHandler {
+	return DriverLicenseHandler{
+		db:        db,
+		dls:       dls,
+		documents: documents,
+	}
+}
+
+type RejectLicenseReq struct {
+	Reason string `json:"reason"`
+}
+
+type LicenseRespItem struct {
+	LicenseID    uint       `json:"license_id"`
+	Number       string     `json:"number"`
+	ExpiresOn    string     `json:"expires_on"`
+	Status       string     `json:"status"`
+	RejectReason string     `json:"reject_reason,omitempty"`
+	SubmittedAt  time.Time  `json:"submitted_at"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// with the user, for staff reviewing
+type ReviewLicenseRespItem struct {
+	LicenseRespItem
+	UserID    uint   `json:"user_id"`
+	UserName  string `json:"user_name"`
+	UserEmail string `json:"user_email"`
+}
+
+func toLicenseRespItem(l *model.DriverLicense) LicenseRespItem {
+	return LicenseRespItem{
+		LicenseID:    l.ID,
+		Number:       l.Number,
+		ExpiresOn:    l.ExpiresOn.Format(time.DateOnly),
+		Status:       l.Status,
+		RejectReason: l.RejectReason,
+		SubmittedAt:  l.CreatedAt,
+		ReviewedAt:   l.ReviewedAt,
+	}
+}
+
+func toReviewLicenseRespItem(l *model.DriverLicense) ReviewLicenseRespItem {
+	return ReviewLicenseRespItem{
+		LicenseRespItem: toLicenseRespItem(l),
+		UserID:          l.UserID,
+		UserName:        l.User.Name,
+		UserEmail:       l.User.Email,
+	}
+}
+
+func licenseAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrLicenseNotFound):
+		return util.NewAppError(http.StatusNotFound, "driver license not found", "")
+	case errors.Is(err, service.ErrLicensePending):
+		return util.NewAppError(http.StatusConflict, "driver license already waiting for review", "")
+	case errors.Is(err, service.ErrLicenseReviewed):
+		return util.NewAppError(http.StatusConflict, "driver license already reviewed", "")
+	case errors.Is(err, service.ErrLicenseNumber):
+		return util.NewAppError(http.StatusBadRequest, "license number cannot be empty", "")
+	case errors.Is(err, service.ErrLicenseExpired):
+		return util.NewAppError(http.StatusBadRequest, "driver license already expired", "")
+	case errors.Is(err, service.ErrRejectReasonNeeded):
+		return util.NewAppError(http.StatusBadRequest, "reason cannot be empty", "")
+	case errors.Is(err, service.ErrNoValidLicense):
+		return util.NewAppError(http.StatusForbidden, "an approved driver license valid until the end of the rental is required", "")
+	case errors.Is(err, service.ErrImageTooLarge), errors.Is(err, service.ErrImageType):
+		return carImageAppError(err)
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// @Summary	Uploads the user's driver license for review
+// @Tags		driver licenses
+// @Accept		multipart/form-data
+// @Param		image		formData	file	true	"Jpeg or png photo of the license"
+// @Param		number		formData	string	true	"License number"
+// @Param		expires_on	formData	string	true	"Expiry date, YYYY-MM-DD"
+// @Produce	json
+// @Success	201	{object}	handler.LicenseRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	413	{object}	util.AppError
+// @Failure	415	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/license [post]
+// multipart upload with the image in the image field, number and expires_on (YYYY-MM-DD)
+func (dlh *DriverLicenseHandler) HandlePostLicense(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, dlh.db)
+	if err != nil {
+		return err
+	}
+	expiresOn, err := time.Parse(time.DateOnly, c.FormValue("expires_on"))
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid expiry date", "")
+	}
+	file, err := c.FormFile("image")
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "image file is required", err.Error())
+	}
+	if file.Size > service.MaxCarImageSize {
+		return licenseAppError(service.ErrImageTooLarge)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	defer src.Close()
+
+	license, err := dlh.dls.Upload(user.ID, c.FormValue("number"), expiresOn, src)
+	if err != nil {
+		return licenseAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toLicenseRespItem(license))
+}
+
+// @Summary	The user's last uploaded driver license
+// @Tags		driver licenses
+// @Produce	json
+// @Success	200	{object}	handler.LicenseRespItem
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/license [get]
+// the license the user uploaded last, with its review status
+func (dlh *DriverLicenseHandler) HandleGetLicense(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, dlh.db)
+	if err != nil {
+		return err
+	}
+	license, err := dlh.dls.GetLatest(user.ID)
+	if err != nil {
+		return licenseAppError(err)
+	}
+	return c.JSON(http.StatusOK, toLicenseRespItem(license))
+}
+
+// @Summary	Driver licenses to review
+// @Tags		driver licenses
+// @Param		status	query	string	false	"pending (default), approved, rejected or all"
+// @Produce	json
+// @Success	200	{array}		handler.ReviewLicenseRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/licenses [get]
+// review queue, pending licenses by default
+func (dlh *DriverLicenseHandler) HandleGetLicenses(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "":
+		status = model.LicensePending
+	case "all":
+		status = ""
+	case model.LicensePending, model.LicenseApproved, model.LicenseRejected:
+	default:
+		return util.NewAppError(http.StatusBadRequest, "invalid status", "")
+	}
+	licenses, err := dlh.dls.GetLicenses(status)
+	if err != nil {
+		return licenseAppError(err)
+	}
+	resp := []ReviewLicenseRespItem{}
+	for i := range licenses {
+		resp = append(resp, toReviewLicenseRespItem(&licenses[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Image of a driver license
+// @Tags		driver licenses
+// @Param		id	path	int	true	"License id"
+// @Produce	image/jpeg
+// @Produce	image/png
+// @Success	200	{file}		file
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Router		/licenses/{id}/image [get]
+// the license image for staff, never cached
+func (dlh *DriverLicenseHandler) HandleGetLicenseImage(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	license, err := dlh.dls.Get(id)
+	if err != nil {
+		return licenseAppError(err)
+	}
+	path, err := dlh.documents.Path(license.ImageKey)
+	if err != nil {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.File(path)
+}
+
+func (dlh *DriverLicenseHandler) review(c echo.Context, approve bool, reason string) error {
+	staff, err := util.GetUserFromContext(c, dlh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	license, err := dlh.dls.Review(id, staff.ID, approve, reason, c.Logger())
+	if err != nil {
+		return licenseAppError(err)
+	}
+	return c.JSON(http.StatusOK, toReviewLicenseRespItem(license))
+}
+
+// @Summary	Approves a pending driver license
+// @Tags		driver licenses
+// @Param		id	path	int	true	"License id"
+// @Produce	json
+// @Success	200	{object}	handler.ReviewLicenseRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/licenses/{id}/approve [post]
+func (dlh *DriverLicenseHandler) HandleApproveLicense(c echo.Context) error {
+	return dlh.review(c, true, "")
+}
+
+// @Summary	Rejects a pending driver license with a reason
+// @Tags		driver licenses
+// @Accept		json
+// @Param		id			path	int						true	"License id"
+// @Param		ReasonData	body	handler.RejectLicenseReq	true	"Reason"
+// @Produce	json
+// @Success	200	{object}	handler.ReviewLicenseRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/licenses/{id}/reject [post]
+func (dlh *DriverLicenseHandler) HandleRejectLicense(c echo.Context) error {
+	var reqBody RejectLicenseReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	return dlh.review(c, false, reqBody.Reason)
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type FavoriteHandler struct {
+	db *gorm.DB
+	fs *service.FavoriteService
+}
+
+func NewFavoriteHandler(db *gorm.DB, fs *service.FavoriteService) FavoriteHandler {
+	return FavoriteHandler{
+		db: db,
+		fs: fs,
+	}
+}
+
+type PostFavoriteReq struct {
+	CarID uint `json:"car_id"`
+}
+
+type PostWatchReq struct {
+	StartDate      string `json:"start_date"`
+	EndDate        string `json:"end_date"`
+	PickupBranchID *uint  `json:"pickup_branch_id"`
+}
+
+type FavoriteRespItem struct {
+	CarID        uint      `json:"car_id"`
+	Manufacturer string    `json:"manufacturer"`
+	CarModel     string    `json:"car_model"`
+	RatePerDay   float64   `json:"rate_per_day"`
+	SavedAt      time.Time `json:"saved_at"`
+}
+
+type WatchRespItem struct {
+	WatchID        uint       `json:"watch_id"`
+	CarID          uint       `json:"car_id"`
+	Manufacturer   string     `json:"manufacturer"`
+	CarModel       string     `json:"car_model"`
+	StartDate      string     `json:"start_date"`
+	EndDate        string     `json:"end_date"`
+	PickupBranchID *uint      `json:"pickup_branch_id"`
+	Status         string     `json:"status"`
+	NotifiedAt     *time.Time `json:"notified_at,omitempty"`
+}
+
+func toFavoriteRespItem(f *model.Favorite) FavoriteRespItem {
+	return FavoriteRespItem{
+		CarID:        f.CarID,
+		Manufacturer: f.Car.Manufacturer,
+		CarModel:     f.Car.CarModel,
+		RatePerDay:   f.Car.RatePerDay,
+		SavedAt:      f.CreatedAt,
+	}
+}
+
+func toWatchRespItem(w *model.AvailabilityWatch, now time.Time) WatchRespItem {
+	return WatchRespItem{
+		WatchID:        w.ID,
+		CarID:          w.CarID,
+		Manufacturer:   w.Car.Manufacturer,
+		CarModel:       w.Car.CarModel,
+		StartDate:      w.StartDate.Format(rentalTimeFormat),
+		EndDate:        w.EndDate.Format(rentalTimeFormat),
+		PickupBranchID: w.PickupBranchID,
+		Status:         service.WatchStatus(w, now),
+		NotifiedAt:     w.NotifiedAt,
+	}
+}
+
+func favoriteAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrCarNotFound):
+		return util.NewAppError(http.StatusNotFound, "car not found", "")
+	case errors.Is(err, service.ErrFavoriteNotFound):
+		return util.NewAppError(http.StatusNotFound, "car not in favorites", "")
+	case errors.Is(err, service.ErrWatchNotFound):
+		return util.NewAppError(http.StatusNotFound, "watch not found", "")
+	case errors.Is(err, service.ErrInvalidWatch):
+		return util.NewAppError(http.StatusBadRequest, "end date must be after start date", "")
+	case errors.Is(err, service.ErrWatchInPast):
+		return util.NewAppError(http.StatusBadRequest, "start date must be in the future", "")
+	case errors.Is(err, service.ErrWatchTooLong):
+		return util.NewAppError(http.StatusBadRequest, "watch period is too long", "")
+	case errors.Is(err, service.ErrTooManyWatches):
+		return util.NewAppError(http.StatusBadRequest, "too many open watches", "")
+	case errors.Is(err, service.ErrCarAvailable):
+		return util.NewAppError(http.StatusConflict, "car is available for the period, book it now", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// checks the watches of the car after units were freed, failures are
+// only logged so they do not fail the staff's request
+func checkWatches(c echo.Context, fs *service.FavoriteService, carID uint) {
+	err := fs.CheckWatches(carID, c.Logger())
+	if err != nil {
+		c.Logger().Errorf("failed to check watches of car %d: %s", carID, err.Error())
+	}
+}
+
+// @Summary	Saved cars of the user, in the order saved
+// @Tags		favorites
+// @Produce	json
+// @Success	200	{array}		handler.FavoriteRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites [get]
+func (fh *FavoriteHandler) HandleGetFavorites(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	favorites, err := fh.fs.GetFavorites(user.ID)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	resp := []FavoriteRespItem{}
+	for i := range favorites {
+		resp = append(resp, toFavoriteRespItem(&favorites[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Saves a car to the favorites of the user
+// @Tags		favorites
+// @Accept		json
+// @Param		FavoriteData	body	handler.PostFavoriteReq	true	"Car to save"
+// @Produce	json
+// @Success	201	{object}	handler.FavoriteRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites [post]
+func (fh *FavoriteHandler) HandlePostFavorite(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	var reqBody PostFavoriteReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	favorite, err := fh.fs.AddFavorite(user.ID, reqBody.CarID)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toFavoriteRespItem(favorite))
+}
+
+// @Summary	Removes a car from the favorites of the user
+// @Tags		favorites
+// @Param		car_id	path	int	true	"Car id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites/{car_id} [delete]
+func (fh *FavoriteHandler) HandleDeleteFavorite(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	carID, err := parseIDParam(c, "car_id")
+	if err != nil {
+		return err
+	}
+	err = fh.fs.RemoveFavorite(user.ID, carID)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "car removed from favorites",
+	})
+}
+
+// @Summary	Availability watches of the user, earliest period first
+// @Tags		favorites
+// @Produce	json
+// @Success	200	{array}		handler.WatchRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites/watches [get]
+func (fh *FavoriteHandler) HandleGetWatches(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	watches, err := fh.fs.GetWatches(user.ID)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	now := time.Now()
+	resp := []WatchRespItem{}
+	for i := range watches {
+		resp = append(resp, toWatchRespItem(&watches[i], now))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Notifies the user once the car is available for the period
+// @Tags		favorites
+// @Accept		json
+// @Param		car_id		path	int					true	"Car id"
+// @Param		WatchData	body	handler.PostWatchReq	true	"Period and pickup branch"
+// @Produce	json
+// @Success	201	{object}	handler.WatchRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites/{car_id}/watches [post]
+func (fh *FavoriteHandler) HandlePostWatch(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	carID, err := parseIDParam(c, "car_id")
+	if err != nil {
+		return err
+	}
+	var reqBody PostWatchReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	startDate, _, err := parseRentalTime(reqBody.StartDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+	}
+	endDate, _, err := parseRentalTime(reqBody.EndDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+	}
+
+	watch, err := fh.fs.CreateWatch(user.ID, carID, reqBody.PickupBranchID, startDate, endDate)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toWatchRespItem(watch, time.Now()))
+}
+
+// @Summary	Deletes an availability watch of the user
+// @Tags		favorites
+// @Param		id	path	int	true	"Watch id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/favorites/watches/{id} [delete]
+func (fh *FavoriteHandler) HandleDeleteWatch(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, fh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	err = fh.fs.DeleteWatch(user.ID, id)
+	if err != nil {
+		return favoriteAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "watch deleted",
+	})
+}
@@ -0,0 +1,516 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/storage"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type InspectionHandler struct {
+	db  *gorm.DB
+	ins *service.InspectionService
+	// private storage the inspection photos are read from
+	documents *storage.LocalStorage
+}
+
+func NewInspectionHandler(db *gorm.DB, ins *service.InspectionService, documents *storage.LocalStorage) InspectionHandler {
+	return InspectionHandler{
+		db:        db,
+		ins:       ins,
+		documents: documents,
+	}
+}
+
+type InspectionItemReq struct {
+	Item      string `json:"item"`
+	Condition string `json:"condition"`
+	Note      string `json:"note"`
+}
+
+type PostInspectionReq struct {
+	Kind      string              `json:"kind"`
+	Odometer  uint                `json:"odometer"`
+	FuelLevel uint                `json:"fuel_level"`
+	Notes     string              `json:"notes"`
+	Items     []InspectionItemReq `json:"items"`
+}
+
+type AcknowledgeInspectionReq struct {
+	Comment string `json:"comment"`
+}
+
+type DamageChargeReq struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type InspectionItemResp struct {
+	Item      string `json:"item"`
+	Condition string `json:"condition"`
+	Note      string `json:"note,omitempty"`
+}
+
+type InspectionPhotoResp struct {
+	PhotoID uint   `json:"photo_id"`
+	Item    string `json:"item,omitempty"`
+	Caption string `json:"caption,omitempty"`
+	URL     string `json:"url"`
+}
+
+type DamageChargeResp struct {
+	ChargeID      uint      `json:"charge_id"`
+	Amount        float64   `json:"amount"`
+	Reason        string    `json:"reason"`
+	FromDeposit   float64   `json:"from_deposit"`
+	Invoiced      float64   `json:"invoiced"`
+	PaymentID     *uint     `json:"payment_id,omitempty"`
+	PaymentStatus string    `json:"payment_status,omitempty"`
+	PaymentUrl    string    `json:"payment_url,omitempty"`
+	ChargedAt     time.Time `json:"charged_at"`
+}
+
+type InspectionRespItem struct {
+	InspectionID    uint                  `json:"inspection_id"`
+	RentalID        uint                  `json:"rental_id"`
+	Kind            string                `json:"kind"`
+	Inspector       string                `json:"inspector,omitempty"`
+	Odometer        uint                  `json:"odometer"`
+	FuelLevel       uint                  `json:"fuel_level"`
+	Notes           string                `json:"notes,omitempty"`
+	Items           []InspectionItemResp  `json:"items"`
+	Photos          []InspectionPhotoResp `json:"photos"`
+	AcknowledgedAt  *time.Time            `json:"acknowledged_at,omitempty"`
+	CustomerComment string                `json:"customer_comment,omitempty"`
+	DamageCharges   []DamageChargeResp    `json:"damage_charges"`
+	InspectedAt     time.Time             `json:"inspected_at"`
+}
+
+type InspectionsResp struct {
+	Reports []InspectionRespItem `json:"reports"`
+	// items not ok at return that were fine at pickup
+	NewDamage []InspectionItemResp `json:"new_damage,omitempty"`
+}
+
+func toInspectionItemsResp(items []model.InspectionItem) []InspectionItemResp {
+	resp := []InspectionItemResp{}
+	for _, i := range items {
+		resp = append(resp, InspectionItemResp{
+			Item:      i.Item,
+			Condition: i.Condition,
+			Note:      i.Note,
+		})
+	}
+	return resp
+}
+
+func toInspectionPhotoResp(p *model.InspectionPhoto) InspectionPhotoResp {
+	return InspectionPhotoResp{
+		PhotoID: p.ID,
+		Item:    p.Item,
+		Caption: p.Caption,
+		URL:     fmt.Sprintf("/inspections/%d/photos/%d", p.InspectionReportID, p.ID),
+	}
+}
+
+func toDamageChargeResp(d *model.DamageCharge) DamageChargeResp {
+	resp := DamageChargeResp{
+		ChargeID:    d.ID,
+		Amount:      d.Amount,
+		Reason:      d.Reason,
+		FromDeposit: d.FromDeposit,
+		Invoiced:    d.Invoiced,
+		ChargedAt:   d.CreatedAt,
+	}
+	if d.Payment != nil {
+		resp.PaymentID = &d.Payment.ID
+		resp.PaymentStatus = d.Payment.Status
+		if d.Payment.Status == "Unpaid" {
+			resp.PaymentUrl = d.Payment.PaymentUrl
+		}
+	}
+	return resp
+}
+
+func toInspectionRespItem(r *model.InspectionReport) InspectionRespItem {
+	resp := InspectionRespItem{
+		InspectionID:    r.ID,
+		RentalID:        r.RentalID,
+		Kind:            r.Kind,
+		Inspector:       r.Inspector.Name,
+		Odometer:        r.Odometer,
+		FuelLevel:       r.FuelLevel,
+		Notes:           r.Notes,
+		Items:           toInspectionItemsResp(r.Items),
+		Photos:          []InspectionPhotoResp{},
+		AcknowledgedAt:  r.AcknowledgedAt,
+		CustomerComment: r.CustomerComment,
+		DamageCharges:   []DamageChargeResp{},
+		InspectedAt:     r.CreatedAt,
+	}
+	for i := range r.Photos {
+		resp.Photos = append(resp.Photos, toInspectionPhotoResp(&r.Photos[i]))
+	}
+	for i := range r.DamageCharges {
+		resp.DamageCharges = append(resp.DamageCharges, toDamageChargeResp(&r.DamageCharges[i]))
+	}
+	return resp
+}
+
+func inspectionAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInspectionNotFound):
+		return util.NewAppError(http.StatusNotFound, "inspection report not found", "")
+	case errors.Is(err, service.ErrRentalNotFound):
+		return util.NewAppError(http.StatusNotFound, "rental not found", "")
+	case errors.Is(err, service.ErrInspectionExists):
+		return util.NewAppError(http.StatusConflict, "rental already has an inspection report of this kind", "")
+	case errors.Is(err, service.ErrInvalidInspectionKind):
+		return util.NewAppError(http.StatusBadRequest, "kind must be pickup or return", "")
+	case errors.Is(err, service.ErrInvalidFuelLevel):
+		return util.NewAppError(http.StatusBadRequest, "fuel level must be from 0 to 100", "")
+	case errors.Is(err, service.ErrInvalidChecklistItem), errors.Is(err, service.ErrChecklistIncomplete):
+		return util.NewAppError(http.StatusBadRequest, err.Error(), "")
+	case errors.Is(err, service.ErrOdometerBelowPickup):
+		return util.NewAppError(http.StatusBadRequest, "odometer at return cannot be below the pickup reading", "")
+	case errors.Is(err, service.ErrRentalReturned):
+		return util.NewAppError(http.StatusBadRequest, "rental already returned", "")
+	case errors.Is(err, service.ErrRentalCancelled):
+		return util.NewAppError(http.StatusBadRequest, "rental already cancelled", "")
+	case errors.Is(err, service.ErrRentalNotPickedUp):
+		return util.NewAppError(http.StatusBadRequest, "rental not picked up yet", "")
+	case errors.Is(err, service.ErrInspectionAcknowledged):
+		return util.NewAppError(http.StatusConflict, "inspection report already acknowledged", "")
+	case errors.Is(err, service.ErrTooManyInspectionPhotos):
+		return util.NewAppError(http.StatusBadRequest, "too many photos", "")
+	case errors.Is(err, service.ErrNotReturnInspection):
+		return util.NewAppError(http.StatusBadRequest, "damage can only be charged on a return inspection", "")
+	case errors.Is(err, service.ErrDamageChargeNotFound):
+		return util.NewAppError(http.StatusNotFound, "damage charge not found", "")
+	case errors.Is(err, service.ErrDamageChargeInvoiced):
+		return util.NewAppError(http.StatusConflict, "damage charge has no invoice left to send", "")
+	case errors.Is(err, service.ErrInvalidDamageCharge):
+		return util.NewAppError(http.StatusBadRequest, "amount must be above 0 and reason cannot be empty", "")
+	case errors.Is(err, service.ErrImageTooLarge), errors.Is(err, service.ErrImageType):
+		return carImageAppError(err)
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// customers only see reports of rentals they booked or drive, staff see all
+func canViewInspection(user *model.User, rental *model.Rental) bool {
+	if user.Role != model.RoleUser {
+		return true
+	}
+	return rental.UserID == user.ID || (rental.DriverID != nil && *rental.DriverID == user.ID)
+}
+
+// @Summary	Checklist items every inspection report must cover
+// @Tags		inspections
+// @Produce	json
+// @Success	200	{array}	string
+// @Router		/inspections/checklist [get]
+// the parts every report must cover
+func (ih *InspectionHandler) HandleGetChecklist(c echo.Context) error {
+	return c.JSON(http.StatusOK, service.InspectionChecklist)
+}
+
+// @Summary	Records the pickup or return inspection of a rental
+// @Tags		inspections
+// @Accept		json
+// @Param		id				path	int							true	"Rental id"
+// @Param		InspectionData	body	handler.PostInspectionReq	true	"Kind, odometer, fuel level, notes and checklist"
+// @Produce	json
+// @Success	201	{object}	handler.InspectionRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/inspections [post]
+func (ih *InspectionHandler) HandlePostInspection(c echo.Context) error {
+	staff, err := util.GetUserFromContext(c, ih.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody PostInspectionReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+
+	req := service.InspectionReq{
+		Kind:      reqBody.Kind,
+		Odometer:  reqBody.Odometer,
+		FuelLevel: reqBody.FuelLevel,
+		Notes:     reqBody.Notes,
+	}
+	for _, i := range reqBody.Items {
+		req.Items = append(req.Items, service.InspectionItemReq{
+			Item:      i.Item,
+			Condition: i.Condition,
+			Note:      i.Note,
+		})
+	}
+	report, err := ih.ins.Create(rentalID, staff.ID, &req, c.Logger())
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	report.Inspector = *staff
+	return c.JSON(http.StatusCreated, toInspectionRespItem(report))
+}
+
+// @Summary	Inspection reports of a rental, with the damage found at return
+// @Tags		inspections
+// @Param		id	path	int	true	"Rental id"
+// @Produce	json
+// @Success	200	{object}	handler.InspectionsResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/inspections [get]
+// the pickup and return reports of a rental, with the damage found in between
+func (ih *InspectionHandler) HandleGetInspections(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, ih.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var rental model.Rental
+	err = ih.db.Where("id=?", rentalID).First(&rental).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return util.NewAppError(http.StatusNotFound, "rental not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if !canViewInspection(user, &rental) {
+		return util.NewAppError(http.StatusNotFound, "rental not found", "")
+	}
+
+	reports, err := ih.ins.GetForRental(rental.ID)
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	resp := InspectionsResp{Reports: []InspectionRespItem{}}
+	var pickup, ret *model.InspectionReport
+	for i := range reports {
+		resp.Reports = append(resp.Reports, toInspectionRespItem(&reports[i]))
+		if reports[i].Kind == model.InspectionPickup {
+			pickup = &reports[i]
+		} else {
+			ret = &reports[i]
+		}
+	}
+	if pickup != nil && ret != nil {
+		resp.NewDamage = toInspectionItemsResp(service.NewDamages(pickup.Items, ret.Items))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Adds a photo to an inspection report
+// @Tags		inspections
+// @Accept		multipart/form-data
+// @Param		id		path		int		true	"Inspection report id"
+// @Param		image	formData	file	true	"Jpeg or png photo"
+// @Param		item	formData	string	false	"Checklist item shown"
+// @Param		caption	formData	string	false	"What the photo shows"
+// @Produce	json
+// @Success	201	{object}	handler.InspectionPhotoResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	413	{object}	util.AppError
+// @Failure	415	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/inspections/{id}/photos [post]
+// multipart upload with the image in the image field, an optional checklist
+// item and a caption describing what it shows
+func (ih *InspectionHandler) HandlePostPhoto(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	file, err := c.FormFile("image")
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "image file is required", err.Error())
+	}
+	if file.Size > service.MaxCarImageSize {
+		return inspectionAppError(service.ErrImageTooLarge)
+	}
+	src, err := file.Open()
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	defer src.Close()
+
+	photo, err := ih.ins.AddPhoto(id, c.FormValue("item"), c.FormValue("caption"), src)
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toInspectionPhotoResp(photo))
+}
+
+// @Summary	Photo of an inspection report
+// @Tags		inspections
+// @Param		id			path	int	true	"Inspection report id"
+// @Param		photo_id	path	int	true	"Photo id"
+// @Produce	image/jpeg
+// @Produce	image/png
+// @Success	200	{file}		file
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Router		/inspections/{id}/photos/{photo_id} [get]
+// the photo for staff and the customer of the rental, never cached
+func (ih *InspectionHandler) HandleGetPhoto(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, ih.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	photoID, err := parseIDParam(c, "photo_id")
+	if err != nil {
+		return err
+	}
+	report, err := ih.ins.Get(id)
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	if !canViewInspection(user, &report.Rental) {
+		return inspectionAppError(service.ErrInspectionNotFound)
+	}
+	var photo *model.InspectionPhoto
+	for i := range report.Photos {
+		if report.Photos[i].ID == photoID {
+			photo = &report.Photos[i]
+		}
+	}
+	if photo == nil {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	path, err := ih.documents.Path(photo.ImageKey)
+	if err != nil {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return util.NewAppError(http.StatusNotFound, "image not found", "")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.File(path)
+}
+
+// @Summary	The customer acknowledges an inspection report, with an optional comment
+// @Tags		inspections
+// @Accept		json
+// @Param		id				path	int									true	"Inspection report id"
+// @Param		AcknowledgeData	body	handler.AcknowledgeInspectionReq	false	"Comment"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/inspections/{id}/acknowledge [post]
+func (ih *InspectionHandler) HandleAcknowledge(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, ih.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody AcknowledgeInspectionReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	report, err := ih.ins.Acknowledge(user.ID, id, reqBody.Comment)
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message":          "inspection report acknowledged",
+		"inspection_id":    report.ID,
+		"acknowledged_at":  report.AcknowledgedAt,
+		"customer_comment": report.CustomerComment,
+	})
+}
+
+// @Summary	Charges damage found at return, from the security deposit first
+// @Tags		inspections
+// @Accept		json
+// @Param		id			path	int						true	"Return inspection report id"
+// @Param		ChargeData	body	handler.DamageChargeReq	true	"Amount and reason"
+// @Produce	json
+// @Success	201	{object}	handler.DamageChargeResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Failure	502	{object}	util.AppError
+// @Router		/inspections/{id}/damage-charges [post]
+// charges damage found at return, from the security deposit first
+func (ih *InspectionHandler) HandlePostDamageCharge(c echo.Context) error {
+	staff, err := util.GetUserFromContext(c, ih.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody DamageChargeReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	charge, err := ih.ins.ChargeDamage(id, staff.ID, reqBody.Amount, reqBody.Reason, c.Logger())
+	if err != nil && charge != nil {
+		// only the invoice failed, it is sent again from the charge
+		return util.NewAppError(http.StatusBadGateway,
+			fmt.Sprintf("damage charge %d saved but its invoice failed, send it again", charge.ID), err.Error())
+	} else if err != nil {
+		return inspectionAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toDamageChargeResp(charge))
+}
+
+// @Summary	Sends the invoice of a damage charge again after it failed
+// @Tags		inspections
+// @Param		id	path	int	true	"Damage charge id"
+// @Produce	json
+// @Success	200	{object}	handler.DamageChargeResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/damage-charges/{id}/invoice [post]
+// sends the invoice of a damage charge again after it failed
+func (ih *InspectionHandler) HandlePostDamageChargeInvoice(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	charge, err := ih.ins.InvoiceDamageCharge(id, c.Logger())
+	if err != nil {
+		return inspectionAppError(err)
+	}
+	return c.JSON(http.StatusOK, toDamageChargeResp(charge))
+}
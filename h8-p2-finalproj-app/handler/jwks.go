@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"h8-p2-finalproj-app/auth"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type JWKSHandler struct {
+	keys *auth.KeySet
+}
+
+func NewJWKSHandler(keys *auth.KeySet) JWKSHandler {
+	return JWKSHandler{
+		keys: keys,
+	}
+}
+
+// @Summary	Public keys to verify access tokens
+// @Tags		auth
+// @Produce	json
+// @Success	200	{object}	auth.JWKSet
+// @Router		/.well-known/jwks.json [get]
+func (jh *JWKSHandler) HandleGetJWKS(c echo.Context) error {
+	// keys only change on rotation, short cache is enough
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, jh.keys.JWKS())
+}
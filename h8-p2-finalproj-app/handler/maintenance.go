@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type MaintenanceHandler struct {
+	db *gorm.DB
+	ms *service.MaintenanceService
+	fs *service.FavoriteService
+}
+
+func NewMaintenanceHandler(db *gorm.DB, ms *service.MaintenanceService, fs *service.FavoriteService) MaintenanceHandler {
+	return MaintenanceHandler{
+		db: db,
+		ms: ms,
+		fs: fs,
+	}
+}
+
+type PostMaintenanceReq struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Reason    string `json:"reason"`
+	UnitIDs   []uint `json:"unit_ids"`
+}
+
+type ConflictingRentalItem struct {
+	RentalID    uint   `json:"rental_id"`
+	StartDate   string `json:"start_date"`
+	EndDate     string `json:"end_date"`
+	UserEmail   string `json:"user_email"`
+	PlateNumber string `json:"plate_number,omitempty"`
+}
+
+type MaintenanceRespItem struct {
+	MaintenanceID uint   `json:"maintenance_id"`
+	CarID         uint   `json:"car_id"`
+	StartDate     string `json:"start_date"`
+	EndDate       string `json:"end_date"`
+	Reason        string `json:"reason"`
+	UnitIDs       []uint `json:"unit_ids"`
+}
+
+type PostMaintenanceResp struct {
+	MaintenanceRespItem
+	Warning            string                  `json:"warning,omitempty"`
+	ConflictingRentals []ConflictingRentalItem `json:"conflicting_rentals"`
+}
+
+func toMaintenanceRespItem(m *model.Maintenance) MaintenanceRespItem {
+	resp := MaintenanceRespItem{
+		MaintenanceID: m.ID,
+		CarID:         m.CarID,
+		StartDate:     m.StartDate.Format(time.DateOnly),
+		EndDate:       m.EndDate.Format(time.DateOnly),
+		Reason:        m.Reason,
+		UnitIDs:       []uint{},
+	}
+	for _, u := range m.Units {
+		resp.UnitIDs = append(resp.UnitIDs, u.ID)
+	}
+	return resp
+}
+
+// @Summary	Maintenance scheduled for the units of a car
+// @Tags		maintenance
+// @Param		id	path	int	true	"Car id"
+// @Produce	json
+// @Success	200	{array}		handler.MaintenanceRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/maintenance [get]
+func (mh *MaintenanceHandler) HandleGetMaintenances(c echo.Context) error {
+	car, err := findCarFromParam(c, mh.db)
+	if err != nil {
+		return err
+	}
+	maintenances, err := mh.ms.GetMaintenances(car.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []MaintenanceRespItem{}
+	for i := range maintenances {
+		resp = append(resp, toMaintenanceRespItem(&maintenances[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Schedules maintenance for units of a car, with the bookings it conflicts with
+// @Tags		maintenance
+// @Accept		json
+// @Param		id				path	int							true	"Car id"
+// @Param		MaintenanceData	body	handler.PostMaintenanceReq	true	"Dates (YYYY-MM-DD), reason and units"
+// @Produce	json
+// @Success	201	{object}	handler.PostMaintenanceResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/maintenance [post]
+func (mh *MaintenanceHandler) HandlePostMaintenance(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, mh.db)
+	if err != nil {
+		return err
+	}
+	car, err := findCarFromParam(c, mh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody PostMaintenanceReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	startDate, err := time.Parse(time.DateOnly, reqBody.StartDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+	}
+	endDate, err := time.Parse(time.DateOnly, reqBody.EndDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+	}
+	if endDate.Before(startDate) {
+		return util.NewAppError(http.StatusBadRequest, "end date cannot be before start date", "")
+	}
+	if len(reqBody.UnitIDs) == 0 {
+		return util.NewAppError(http.StatusBadRequest, "unit ids cannot be empty", "")
+	}
+
+	maintenance, conflicts, err := mh.ms.Schedule(
+		car,
+		startDate,
+		endDate,
+		strings.TrimSpace(reqBody.Reason),
+		reqBody.UnitIDs,
+		user,
+	)
+	if err != nil && errors.Is(err, service.ErrUnitNotOfCar) {
+		return util.NewAppError(http.StatusBadRequest, "units must belong to the car", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp := PostMaintenanceResp{
+		MaintenanceRespItem: toMaintenanceRespItem(maintenance),
+		ConflictingRentals:  []ConflictingRentalItem{},
+	}
+	for _, r := range conflicts {
+		ri := ConflictingRentalItem{
+			RentalID:  r.ID,
+			StartDate: r.StartDate.Format(rentalTimeFormat),
+			EndDate:   r.EndDate.Format(rentalTimeFormat),
+			UserEmail: r.User.Email,
+		}
+		if r.VehicleUnit != nil {
+			ri.PlateNumber = r.VehicleUnit.PlateNumber
+		}
+		resp.ConflictingRentals = append(resp.ConflictingRentals, ri)
+	}
+	if len(conflicts) > 0 {
+		resp.Warning = "maintenance conflicts with existing bookings, reassign or contact the customers"
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// @Summary	Cancels a maintenance
+// @Tags		maintenance
+// @Param		id				path	int	true	"Car id"
+// @Param		maintenance_id	path	int	true	"Maintenance id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/maintenance/{maintenance_id} [delete]
+func (mh *MaintenanceHandler) HandleDeleteMaintenance(c echo.Context) error {
+	car, err := findCarFromParam(c, mh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "maintenance_id")
+	if err != nil {
+		return err
+	}
+
+	err = mh.ms.Cancel(car.ID, id)
+	if err != nil && errors.Is(err, service.ErrMaintenanceNotFound) {
+		return util.NewAppError(http.StatusNotFound, "maintenance not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	checkWatches(c, mh.fs, car.ID)
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "maintenance cancelled",
+	})
+}
@@ -0,0 +1,584 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type OrganizationHandler struct {
+	db  *gorm.DB
+	ors *service.OrganizationService
+}
+
+func NewOrganizationHandler(db *gorm.DB, ors *service.OrganizationService) OrganizationHandler {
+	return OrganizationHandler{
+		db:  db,
+		ors: ors,
+	}
+}
+
+type OrganizationReq struct {
+	Name         string   `json:"name"`
+	BillingEmail string   `json:"billing_email"`
+	MonthlyLimit *float64 `json:"monthly_limit"`
+}
+
+type PostMemberReq struct {
+	Email        string   `json:"email"`
+	Role         string   `json:"role"`
+	MonthlyLimit *float64 `json:"monthly_limit"`
+}
+
+type PutMemberReq struct {
+	Role         string   `json:"role"`
+	MonthlyLimit *float64 `json:"monthly_limit"`
+}
+
+type PostOrganizationInvoicesReq struct {
+	// YYYY-MM, the previous month when empty
+	Period string `json:"period"`
+}
+
+type MemberRespItem struct {
+	UserID       uint     `json:"user_id"`
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	Role         string   `json:"role"`
+	MonthlyLimit *float64 `json:"monthly_limit"`
+}
+
+type InvitationRespItem struct {
+	InvitationID     uint     `json:"invitation_id"`
+	OrganizationID   uint     `json:"organization_id"`
+	OrganizationName string   `json:"organization_name"`
+	UserID           uint     `json:"user_id"`
+	Name             string   `json:"name"`
+	Email            string   `json:"email"`
+	Role             string   `json:"role"`
+	MonthlyLimit     *float64 `json:"monthly_limit"`
+}
+
+type OrganizationResp struct {
+	OrganizationID uint                 `json:"organization_id"`
+	Name           string               `json:"name"`
+	BillingEmail   string               `json:"billing_email"`
+	MonthlyLimit   *float64             `json:"monthly_limit"`
+	Members        []MemberRespItem     `json:"members"`
+	Invitations    []InvitationRespItem `json:"invitations"`
+}
+
+type OrganizationInvoiceRespItem struct {
+	InvoiceID      uint    `json:"invoice_id"`
+	OrganizationID uint    `json:"organization_id"`
+	Period         string  `json:"period"`
+	Total          float64 `json:"total"`
+	PaymentID      uint    `json:"payment_id"`
+	PaymentStatus  string  `json:"payment_status"`
+	PaymentUrl     string  `json:"payment_url,omitempty"`
+}
+
+func toMemberRespItem(m *model.OrganizationMember) MemberRespItem {
+	return MemberRespItem{
+		UserID:       m.UserID,
+		Name:         m.User.Name,
+		Email:        m.User.Email,
+		Role:         m.Role,
+		MonthlyLimit: m.MonthlyLimit,
+	}
+}
+
+func toInvitationRespItem(inv *model.OrganizationInvitation) InvitationRespItem {
+	return InvitationRespItem{
+		InvitationID:     inv.ID,
+		OrganizationID:   inv.OrganizationID,
+		OrganizationName: inv.Organization.Name,
+		UserID:           inv.UserID,
+		Name:             inv.User.Name,
+		Email:            inv.User.Email,
+		Role:             inv.Role,
+		MonthlyLimit:     inv.MonthlyLimit,
+	}
+}
+
+func toOrganizationResp(org *model.Organization) OrganizationResp {
+	resp := OrganizationResp{
+		OrganizationID: org.ID,
+		Name:           org.Name,
+		BillingEmail:   org.BillingEmail,
+		MonthlyLimit:   org.MonthlyLimit,
+		Members:        []MemberRespItem{},
+		Invitations:    []InvitationRespItem{},
+	}
+	for i := range org.Members {
+		resp.Members = append(resp.Members, toMemberRespItem(&org.Members[i]))
+	}
+	for i := range org.Invitations {
+		resp.Invitations = append(resp.Invitations, toInvitationRespItem(&org.Invitations[i]))
+	}
+	return resp
+}
+
+func toOrganizationInvoiceRespItem(inv *model.OrganizationInvoice) OrganizationInvoiceRespItem {
+	resp := OrganizationInvoiceRespItem{
+		InvoiceID:      inv.ID,
+		OrganizationID: inv.OrganizationID,
+		Period:         inv.Period,
+		Total:          inv.Total,
+		PaymentID:      inv.Payment.ID,
+		PaymentStatus:  inv.Payment.Status,
+	}
+	if inv.Payment.Status == "Unpaid" {
+		resp.PaymentUrl = inv.Payment.PaymentUrl
+	}
+	return resp
+}
+
+func orgAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrOrganizationNotFound):
+		return util.NewAppError(http.StatusNotFound, "organization not found", "")
+	case errors.Is(err, service.ErrOrganizationName):
+		return util.NewAppError(http.StatusConflict, "organization name already used", "")
+	case errors.Is(err, service.ErrInvalidOrganization):
+		return util.NewAppError(http.StatusBadRequest, "name and a valid billing email are required", "")
+	case errors.Is(err, service.ErrInvalidLimit):
+		return util.NewAppError(http.StatusBadRequest, "monthly limit cannot be negative", "")
+	case errors.Is(err, service.ErrInvalidOrgRole):
+		return util.NewAppError(http.StatusBadRequest, "role must be admin, booker or driver", "")
+	case errors.Is(err, service.ErrNotOrgMember):
+		return util.NewAppError(http.StatusForbidden, "not a member of the organization", "")
+	case errors.Is(err, service.ErrOrgRoleForbidden):
+		return util.NewAppError(http.StatusForbidden, "organization role not allowed", "")
+	case errors.Is(err, service.ErrAlreadyOrgMember):
+		return util.NewAppError(http.StatusConflict, "user already belongs to an organization", "")
+	case errors.Is(err, service.ErrMemberUserNotFound):
+		return util.NewAppError(http.StatusNotFound, "no user registered with the email", "")
+	case errors.Is(err, service.ErrLastOrgAdmin):
+		return util.NewAppError(http.StatusConflict, "organization needs at least one admin", "")
+	case errors.Is(err, service.ErrDriverNotMember):
+		return util.NewAppError(http.StatusBadRequest, "driver is not a member of the organization", "")
+	case errors.Is(err, service.ErrOrgLimitReached):
+		return util.NewAppError(http.StatusForbidden, "organization monthly limit reached", "")
+	case errors.Is(err, service.ErrMemberLimitReached):
+		return util.NewAppError(http.StatusForbidden, "your monthly limit on the organization account is reached", "")
+	case errors.Is(err, service.ErrInvalidPeriod):
+		return util.NewAppError(http.StatusBadRequest, "period must be a past month as YYYY-MM", "")
+	case errors.Is(err, service.ErrAlreadyInvited):
+		return util.NewAppError(http.StatusConflict, "user already invited to the organization", "")
+	case errors.Is(err, service.ErrInvitationNotFound):
+		return util.NewAppError(http.StatusNotFound, "invitation not found", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// checks the user is an admin of the organization in the id param
+func (oh *OrganizationHandler) orgAdmin(c echo.Context) (uint, error) {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return 0, err
+	}
+	orgID, err := parseIDParam(c, "id")
+	if err != nil {
+		return 0, err
+	}
+	_, err = oh.ors.RequireRole(orgID, user.ID, model.OrgRoleAdmin)
+	if err != nil {
+		return 0, orgAppError(err)
+	}
+	return orgID, nil
+}
+
+// @Summary	Creates an organization, the user becomes its admin
+// @Tags		organizations
+// @Accept		json
+// @Param		OrganizationData	body	handler.OrganizationReq	true	"Name, billing email and monthly limit"
+// @Produce	json
+// @Success	201	{object}	handler.OrganizationResp
+// @Failure	400	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations [post]
+// the user creating the organization becomes its admin
+func (oh *OrganizationHandler) HandlePostOrganization(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	var reqBody OrganizationReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	org, err := oh.ors.Create(user.ID, &service.OrganizationReq{
+		Name:         reqBody.Name,
+		BillingEmail: reqBody.BillingEmail,
+		MonthlyLimit: reqBody.MonthlyLimit,
+	})
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toOrganizationResp(org))
+}
+
+// @Summary	The organization the user belongs to, with its members
+// @Tags		organizations
+// @Produce	json
+// @Success	200	{object}	handler.OrganizationResp
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/mine [get]
+// the organization the user belongs to
+func (oh *OrganizationHandler) HandleGetMyOrganization(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	member, err := oh.ors.GetMembership(user.ID)
+	if err != nil && errors.Is(err, service.ErrNotOrgMember) {
+		return util.NewAppError(http.StatusNotFound, "not a member of any organization", "")
+	} else if err != nil {
+		return orgAppError(err)
+	}
+	org, err := oh.ors.Get(member.OrganizationID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, toOrganizationResp(org))
+}
+
+// @Summary	Updates an organization, for its admins
+// @Tags		organizations
+// @Accept		json
+// @Param		id					path	int						true	"Organization id"
+// @Param		OrganizationData	body	handler.OrganizationReq	true	"Name, billing email and monthly limit"
+// @Produce	json
+// @Success	200	{object}	handler.OrganizationResp
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id} [put]
+func (oh *OrganizationHandler) HandlePutOrganization(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	var reqBody OrganizationReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	org, err := oh.ors.Get(orgID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	org, err = oh.ors.Update(org, &service.OrganizationReq{
+		Name:         reqBody.Name,
+		BillingEmail: reqBody.BillingEmail,
+		MonthlyLimit: reqBody.MonthlyLimit,
+	})
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, toOrganizationResp(org))
+}
+
+// @Summary	Invites a registered user to the organization by email, they join once they accept
+// @Tags		organizations
+// @Accept		json
+// @Param		id			path	int					true	"Organization id"
+// @Param		MemberData	body	handler.PostMemberReq	true	"Email, role and monthly limit"
+// @Produce	json
+// @Success	201	{object}	handler.InvitationRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id}/members [post]
+// invites a registered user by email
+func (oh *OrganizationHandler) HandlePostMember(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	var reqBody PostMemberReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	invitation, err := oh.ors.InviteMember(orgID, reqBody.Email, reqBody.Role, reqBody.MonthlyLimit, c.Logger())
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toInvitationRespItem(invitation))
+}
+
+// @Summary	Withdraws an invitation to the organization
+// @Tags		organizations
+// @Param		id				path	int	true	"Organization id"
+// @Param		invitation_id	path	int	true	"Invitation id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id}/invitations/{invitation_id} [delete]
+func (oh *OrganizationHandler) HandleDeleteInvitation(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	invitationID, err := parseIDParam(c, "invitation_id")
+	if err != nil {
+		return err
+	}
+	err = oh.ors.WithdrawInvitation(orgID, invitationID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "invitation withdrawn",
+	})
+}
+
+// @Summary	The user's pending invitations to organizations
+// @Tags		organizations
+// @Produce	json
+// @Success	200	{array}		handler.InvitationRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/invitations [get]
+func (oh *OrganizationHandler) HandleGetInvitations(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	invitations, err := oh.ors.GetInvitations(user.ID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	resp := []InvitationRespItem{}
+	for i := range invitations {
+		resp = append(resp, toInvitationRespItem(&invitations[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Accepts an invitation, the user joins the organization
+// @Tags		organizations
+// @Param		id	path	int	true	"Invitation id"
+// @Produce	json
+// @Success	200	{object}	handler.MemberRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/invitations/{id}/accept [post]
+// the user joins with the role and limit of the invitation
+func (oh *OrganizationHandler) HandleAcceptInvitation(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	invitationID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	member, err := oh.ors.AcceptInvitation(user.ID, invitationID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, toMemberRespItem(member))
+}
+
+// @Summary	Declines an invitation
+// @Tags		organizations
+// @Param		id	path	int	true	"Invitation id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/invitations/{id} [delete]
+func (oh *OrganizationHandler) HandleDeclineInvitation(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	invitationID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	err = oh.ors.DeclineInvitation(user.ID, invitationID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "invitation declined",
+	})
+}
+
+// @Summary	Leaves the organization the user belongs to
+// @Tags		organizations
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/mine/leave [post]
+// the last admin has to appoint another admin first
+func (oh *OrganizationHandler) HandleLeaveOrganization(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, oh.db)
+	if err != nil {
+		return err
+	}
+	err = oh.ors.Leave(user.ID)
+	if err != nil && errors.Is(err, service.ErrNotOrgMember) {
+		return util.NewAppError(http.StatusNotFound, "not a member of any organization", "")
+	} else if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "left the organization",
+	})
+}
+
+// @Summary	Changes the role and monthly limit of a member
+// @Tags		organizations
+// @Accept		json
+// @Param		id			path	int					true	"Organization id"
+// @Param		user_id		path	int					true	"User id of the member"
+// @Param		MemberData	body	handler.PutMemberReq	true	"Role and monthly limit"
+// @Produce	json
+// @Success	200	{object}	handler.MemberRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id}/members/{user_id} [put]
+func (oh *OrganizationHandler) HandlePutMember(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	userID, err := parseIDParam(c, "user_id")
+	if err != nil {
+		return err
+	}
+	var reqBody PutMemberReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	member, err := oh.ors.UpdateMember(orgID, userID, reqBody.Role, reqBody.MonthlyLimit)
+	if err != nil && errors.Is(err, service.ErrNotOrgMember) {
+		return util.NewAppError(http.StatusNotFound, "member not found", "")
+	} else if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, toMemberRespItem(member))
+}
+
+// @Summary	Removes a member from the organization
+// @Tags		organizations
+// @Param		id		path	int	true	"Organization id"
+// @Param		user_id	path	int	true	"User id of the member"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id}/members/{user_id} [delete]
+func (oh *OrganizationHandler) HandleDeleteMember(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	userID, err := parseIDParam(c, "user_id")
+	if err != nil {
+		return err
+	}
+	err = oh.ors.RemoveMember(orgID, userID)
+	if err != nil && errors.Is(err, service.ErrNotOrgMember) {
+		return util.NewAppError(http.StatusNotFound, "member not found", "")
+	} else if err != nil {
+		return orgAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "member removed",
+	})
+}
+
+// @Summary	Monthly invoices of the organization, latest first
+// @Tags		organizations
+// @Param		id	path	int	true	"Organization id"
+// @Produce	json
+// @Success	200	{array}		handler.OrganizationInvoiceRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/{id}/invoices [get]
+func (oh *OrganizationHandler) HandleGetInvoices(c echo.Context) error {
+	orgID, err := oh.orgAdmin(c)
+	if err != nil {
+		return err
+	}
+	invoices, err := oh.ors.GetInvoices(orgID)
+	if err != nil {
+		return orgAppError(err)
+	}
+	resp := []OrganizationInvoiceRespItem{}
+	for i := range invoices {
+		resp = append(resp, toOrganizationInvoiceRespItem(&invoices[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Bills the organizations for a past month now
+// @Tags		organizations
+// @Accept		json
+// @Param		PeriodData	body	handler.PostOrganizationInvoicesReq	false	"Month as YYYY-MM, the previous month by default"
+// @Produce	json
+// @Success	201	{array}		handler.OrganizationInvoiceRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/organizations/invoices [post]
+// bills a month now instead of waiting for the monthly run, months already
+// billed are skipped and invoices without a payment link are sent again
+func (oh *OrganizationHandler) HandlePostInvoices(c echo.Context) error {
+	var reqBody PostOrganizationInvoicesReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	now := time.Now()
+	if reqBody.Period == "" {
+		thisMonth, _ := service.MonthBounds(now)
+		reqBody.Period = thisMonth.AddDate(0, -1, 0).Format("2006-01")
+	}
+	start, end, err := service.ParseInvoicePeriod(reqBody.Period, now)
+	if err != nil {
+		return orgAppError(err)
+	}
+	invoices, err := oh.ors.GenerateInvoices(start, end, c.Logger())
+	if err != nil {
+		return orgAppError(err)
+	}
+	resp := []OrganizationInvoiceRespItem{}
+	for i := range invoices {
+		resp = append(resp, toOrganizationInvoiceRespItem(&invoices[i]))
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
@@ -14,12 +14,20 @@ import (
 )
 
 type PaymentHandler struct {
-	db *gorm.DB
+	db  *gorm.DB
+	cs  *service.CarService
+	ns  *service.NotificationService
+	rs  *service.RentalService
+	sds *service.SecurityDepositService
 }
 
-func NewPaymentHandler(db *gorm.DB) *PaymentHandler {
+func NewPaymentHandler(db *gorm.DB, cs *service.CarService, ns *service.NotificationService, rs *service.RentalService, sds *service.SecurityDepositService) *PaymentHandler {
 	return &PaymentHandler{
-		db: db,
+		db:  db,
+		cs:  cs,
+		ns:  ns,
+		rs:  rs,
+		sds: sds,
 	}
 }
 
@@ -55,6 +63,34 @@ func (ph *PaymentHandler) GetUserForPayment(p *model.Payment) *model.User {
 		}
 		return &t.User
 	}
+	if p.PurchaseType == "security_deposits" {
+		var d model.SecurityDeposit
+		err := ph.db.Preload("User").Where("id=?", p.PurchaseID).First(&d).Error
+		if err != nil {
+			return nil
+		}
+		return &d.User
+	}
+	if p.PurchaseType == "damage_charges" {
+		var d model.DamageCharge
+		err := ph.db.Preload("User").Where("id=?", p.PurchaseID).First(&d).Error
+		if err != nil {
+			return nil
+		}
+		return &d.User
+	}
+	if p.PurchaseType == "organization_invoices" {
+		// the first admin of the organization, who manages its invoices
+		var m model.OrganizationMember
+		err := ph.db.Preload("User").
+			Joins("join organization_invoices on organization_invoices.organization_id = organization_members.organization_id").
+			Where("organization_invoices.id=? AND organization_members.role=?", p.PurchaseID, model.OrgRoleAdmin).
+			Order("organization_members.id").First(&m).Error
+		if err != nil {
+			return nil
+		}
+		return &m.User
+	}
 	return nil
 }
 
@@ -71,7 +107,8 @@ func (ph *PaymentHandler) HandlePaymentSuccess(c echo.Context) error {
 	if err != nil {
 		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
 	}
-	if reqBody.PaymentMethod == "" || reqBody.PaidAmount <= 0 {
+	// expired invoices come without a payment method or amount
+	if reqBody.Status == "PAID" && (reqBody.PaymentMethod == "" || reqBody.PaidAmount <= 0) {
 		return util.NewAppError(http.StatusBadRequest, "payment method and paid amount cannot be empty", "")
 	}
 
@@ -94,10 +131,10 @@ func (ph *PaymentHandler) HandlePaymentSuccess(c echo.Context) error {
 	}
 
 	// update payment
-	payment.PaymentMethod = reqBody.PaymentMethod
-	payment.TotalPayment = reqBody.PaidAmount
 	if reqBody.Status == "PAID" {
 		payment.Status = "Completed"
+		payment.PaymentMethod = reqBody.PaymentMethod
+		payment.TotalPayment = reqBody.PaidAmount
 	} else {
 		payment.Status = reqBody.Status
 	}
@@ -106,11 +143,47 @@ func (ph *PaymentHandler) HandlePaymentSuccess(c echo.Context) error {
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
 
+	// reserve a unit for confirmed rentals, staff can still pick one at pickup
+	if payment.Status == "Completed" && payment.PurchaseType == "rentals" {
+		err = ph.db.Transaction(func(tx *gorm.DB) error {
+			var rental model.Rental
+			err := tx.Where("id=?", payment.PurchaseID).First(&rental).Error
+			if err != nil {
+				return err
+			}
+			return ph.cs.AssignUnit(tx, &rental, nil)
+		})
+		if err != nil {
+			c.Logger().Errorf("failed to assign unit to rental %d: %s", payment.PurchaseID, err.Error())
+			// the rental is paid, staff have to find it a unit before pickup
+			flagErr := ph.cs.FlagUnassigned(uint(payment.PurchaseID), err)
+			if flagErr != nil {
+				c.Logger().Errorf("failed to flag rental %d: %s", payment.PurchaseID, flagErr.Error())
+			}
+		}
+	}
+
+	// an unpaid booking frees its unit, for the waitlist
+	if payment.Status == service.PaymentStatusExpired && payment.PurchaseType == "rentals" {
+		err = ph.rs.ReleaseExpired(uint(payment.PurchaseID), c.Logger())
+		if err != nil {
+			c.Logger().Errorf("failed to release expired rental %d: %s", payment.PurchaseID, err.Error())
+		}
+	}
+
+	// a paid deposit is held until the car is returned
+	if payment.PurchaseType == "security_deposits" {
+		err = ph.sds.HandlePayment(&payment)
+		if err != nil {
+			c.Logger().Errorf("failed to update security deposit %d: %s", payment.PurchaseID, err.Error())
+		}
+	}
+
 	// send email is successful
 	// get user
 	if user := ph.GetUserForPayment(&payment); user != nil {
 		if payment.Status == "Completed" {
-			service.SendMail(user.Email, "Payment received!",
+			ph.ns.SendMail(user.ID, user.Email, "Payment received!",
 				"<h1>We have received your payment, enjoy your drive!</h1>",
 				c.Logger(),
 			)
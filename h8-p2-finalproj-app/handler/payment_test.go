@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// the same test database as the service tests
+func createTestDB() *gorm.DB {
+	dsn := fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASS"),
+		os.Getenv("DB_NAME")+"_test",
+		os.Getenv("DB_PORT"),
+	)
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = db.AutoMigrate(
+		&model.User{},
+		&model.Car{},
+		&model.Rental{},
+		&model.Payment{},
+		&model.Notification{},
+		&model.Branch{},
+		&model.VehicleUnit{},
+		&model.Maintenance{},
+		&model.Favorite{},
+		&model.AvailabilityWatch{},
+		&model.WaitlistEntry{},
+		&model.SecurityDeposit{},
+		&model.LoginAttempt{},
+		&model.AccountLockout{},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+func newTestPaymentHandler(db *gorm.DB) *PaymentHandler {
+	cs := service.NewCarService(db)
+	ns := service.NewNotificationService(db)
+	is := service.NewInvoiceService()
+	sds := service.NewSecurityDepositService(db, is, ns)
+	rs := service.NewRentalService(db,
+		service.NewWaitlistService(db, cs, ns),
+		service.NewFavoriteService(db, cs, ns),
+		sds,
+		is,
+	)
+	return NewPaymentHandler(db, cs, ns, rs, sds)
+}
+
+func postPaymentCallback(ph *PaymentHandler, body string) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.HTTPErrorHandler = util.ErrorHandler
+	e.POST("/payments/callback", ph.HandlePaymentSuccess)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/callback", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("x-callback-token", os.Getenv("XENDIT_WEBHOOK_TOKEN"))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlePaymentSuccessExpired(t *testing.T) {
+	godotenv.Load("../.env")
+	db := createTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	t.Setenv("XENDIT_WEBHOOK_TOKEN", "test-callback-token")
+
+	n := time.Now().UnixNano()
+	user := model.User{
+		Name:     "Test User",
+		Email:    fmt.Sprintf("test-%d@example.com", n),
+		Role:     model.RoleUser,
+		Password: "password",
+	}
+	assert.NoError(t, db.Create(&user).Error)
+	car := model.Car{
+		Type:         "SUV",
+		Seats:        5,
+		Transmission: "Automatic",
+		Manufacturer: "Test",
+		CarModel:     fmt.Sprintf("Car %d", n),
+		Year:         2024,
+		RatePerDay:   500000,
+	}
+	assert.NoError(t, db.Create(&car).Error)
+	unit := model.VehicleUnit{
+		CarID:       car.ID,
+		PlateNumber: fmt.Sprintf("T %d", n%1000000000),
+		VIN:         fmt.Sprintf("TEST%013d", n%10000000000000),
+		Status:      model.UnitStatusAvailable,
+	}
+	assert.NoError(t, db.Create(&unit).Error)
+	startDate := time.Now().AddDate(0, 0, 7).Truncate(time.Hour)
+	endDate := startDate.AddDate(0, 0, 1)
+	rental := model.Rental{
+		UserID:     user.ID,
+		CarID:      car.ID,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		TotalPrice: car.RatePerDay,
+		Payment: model.Payment{
+			PurchaseType: "rentals",
+			Status:       "Unpaid",
+			TotalPayment: car.RatePerDay,
+		},
+	}
+	assert.NoError(t, db.Create(&rental).Error)
+	ph := newTestPaymentHandler(db)
+
+	// a paid invoice still needs its method and amount
+	rec := postPaymentCallback(ph, fmt.Sprintf(`{"id":"inv-%d","external_id":"%d","status":"PAID"}`, n, rental.Payment.ID))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// xendit sends expired invoices without them
+	rec = postPaymentCallback(ph, fmt.Sprintf(`{"id":"inv-%d","external_id":"%d","status":"EXPIRED"}`, n, rental.Payment.ID))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var payment model.Payment
+	err := db.Where("id=?", rental.Payment.ID).First(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, service.PaymentStatusExpired, payment.Status)
+	assert.Equal(t, "", payment.PaymentMethod)
+	// the amount due is kept
+	assert.Equal(t, car.RatePerDay, payment.TotalPayment)
+
+	// the rental is kept, its expired payment frees the unit
+	err = db.Where("id=?", rental.ID).First(&model.Rental{}).Error
+	assert.NoError(t, err)
+	available, err := ph.cs.IsCarAvailable(car.ID, startDate, endDate)
+	assert.NoError(t, err)
+	assert.True(t, available)
+
+	// the payment is only updated once
+	rec = postPaymentCallback(ph, fmt.Sprintf(`{"id":"inv-%d","external_id":"%d","status":"EXPIRED"}`, n, rental.Payment.ID))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
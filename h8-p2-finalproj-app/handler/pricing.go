@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type PricingHandler struct {
+	db *gorm.DB
+	ps *service.PricingService
+}
+
+func NewPricingHandler(db *gorm.DB, ps *service.PricingService) PricingHandler {
+	return PricingHandler{
+		db: db,
+		ps: ps,
+	}
+}
+
+type PriceLineResp struct {
+	Description string  `json:"description"`
+	Quantity    uint    `json:"quantity"`
+	UnitPrice   float64 `json:"unit_price"`
+	Amount      float64 `json:"amount"`
+}
+
+type PriceBreakdownResp struct {
+	CarID     uint            `json:"car_id"`
+	StartDate string          `json:"start_date"`
+	EndDate   string          `json:"end_date"`
+	Days      uint            `json:"days"`
+	Hours     uint            `json:"hours"`
+	Items     []PriceLineResp `json:"items"`
+	Total     float64         `json:"total"`
+}
+
+func toPriceLinesResp(pb *service.PriceBreakdown) []PriceLineResp {
+	lines := []PriceLineResp{}
+	for _, l := range pb.Lines {
+		lines = append(lines, PriceLineResp{
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			Amount:      l.Amount,
+		})
+	}
+	return lines
+}
+
+func toPriceItemsResp(items []model.RentalPriceItem) []PriceLineResp {
+	lines := []PriceLineResp{}
+	for _, i := range items {
+		lines = append(lines, PriceLineResp{
+			Description: i.Description,
+			Quantity:    i.Quantity,
+			UnitPrice:   i.UnitPrice,
+			Amount:      i.Amount,
+		})
+	}
+	return lines
+}
+
+type PricingRuleReq struct {
+	Type            string   `json:"type"`
+	Name            string   `json:"name"`
+	CarID           *uint    `json:"car_id"`
+	Multiplier      float64  `json:"multiplier"`
+	Rate            *float64 `json:"rate"`
+	StartDate       string   `json:"start_date"`
+	EndDate         string   `json:"end_date"`
+	MinDays         uint     `json:"min_days"`
+	DiscountPercent float64  `json:"discount_percent"`
+}
+
+type PricingRuleRespItem struct {
+	RuleID          uint     `json:"rule_id"`
+	Type            string   `json:"type"`
+	Name            string   `json:"name"`
+	CarID           *uint    `json:"car_id,omitempty"`
+	Multiplier      float64  `json:"multiplier,omitempty"`
+	Rate            *float64 `json:"rate,omitempty"`
+	StartDate       string   `json:"start_date,omitempty"`
+	EndDate         string   `json:"end_date,omitempty"`
+	MinDays         uint     `json:"min_days,omitempty"`
+	DiscountPercent float64  `json:"discount_percent,omitempty"`
+}
+
+func toPricingRuleRespItem(r *model.PricingRule) PricingRuleRespItem {
+	resp := PricingRuleRespItem{
+		RuleID:          r.ID,
+		Type:            r.Type,
+		Name:            r.Name,
+		CarID:           r.CarID,
+		Multiplier:      r.Multiplier,
+		Rate:            r.Rate,
+		MinDays:         r.MinDays,
+		DiscountPercent: r.DiscountPercent,
+	}
+	if r.StartDate != nil && r.EndDate != nil {
+		resp.StartDate = r.StartDate.Format(time.DateOnly)
+		resp.EndDate = r.EndDate.Format(time.DateOnly)
+	}
+	return resp
+}
+
+func (ph *PricingHandler) validatePricingRuleReq(prr *PricingRuleReq) (*model.PricingRule, error) {
+	rule := model.PricingRule{
+		Type:  prr.Type,
+		Name:  strings.TrimSpace(prr.Name),
+		CarID: prr.CarID,
+	}
+	if rule.Name == "" {
+		return nil, util.NewAppError(http.StatusBadRequest, "name cannot be empty", "")
+	}
+
+	switch prr.Type {
+	case model.PricingRuleWeekend:
+		if prr.Rate != nil {
+			if *prr.Rate <= 0 {
+				return nil, util.NewAppError(http.StatusBadRequest, "rate must be positive", "")
+			}
+			rule.Rate = prr.Rate
+		} else if prr.Multiplier <= 0 {
+			return nil, util.NewAppError(http.StatusBadRequest, "multiplier or rate must be positive", "")
+		}
+		rule.Multiplier = prr.Multiplier
+	case model.PricingRuleSeason:
+		if prr.Multiplier <= 0 {
+			return nil, util.NewAppError(http.StatusBadRequest, "multiplier must be positive", "")
+		}
+		startDate, err := time.Parse(time.DateOnly, prr.StartDate)
+		if err != nil {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+		}
+		endDate, err := time.Parse(time.DateOnly, prr.EndDate)
+		if err != nil {
+			return nil, util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+		}
+		if endDate.Before(startDate) {
+			return nil, util.NewAppError(http.StatusBadRequest, "end date cannot be before start date", "")
+		}
+		rule.Multiplier = prr.Multiplier
+		rule.StartDate = &startDate
+		rule.EndDate = &endDate
+	case model.PricingRuleLongStay:
+		if prr.MinDays < 1 {
+			return nil, util.NewAppError(http.StatusBadRequest, "min days must be at least 1", "")
+		}
+		if prr.DiscountPercent <= 0 || prr.DiscountPercent >= 100 {
+			return nil, util.NewAppError(http.StatusBadRequest, "discount percent must be between 0 and 100", "")
+		}
+		rule.MinDays = prr.MinDays
+		rule.DiscountPercent = prr.DiscountPercent
+	default:
+		return nil, util.NewAppError(http.StatusBadRequest, "invalid rule type", "")
+	}
+
+	if prr.CarID != nil {
+		var count int64
+		err := ph.db.Model(&model.Car{}).Where("id=?", *prr.CarID).Count(&count).Error
+		if err != nil {
+			return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		if count == 0 {
+			return nil, util.NewAppError(http.StatusNotFound, "car not found", "")
+		}
+	}
+	return &rule, nil
+}
+
+// @Summary	Pricing rules
+// @Tags		pricing
+// @Produce	json
+// @Success	200	{array}		handler.PricingRuleRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/pricing-rules [get]
+func (ph *PricingHandler) HandleGetPricingRules(c echo.Context) error {
+	var rules []model.PricingRule
+	err := ph.db.Order("id").Find(&rules).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []PricingRuleRespItem{}
+	for i := range rules {
+		resp = append(resp, toPricingRuleRespItem(&rules[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Adds a weekend, seasonal or long stay pricing rule
+// @Tags		pricing
+// @Accept		json
+// @Param		RuleData	body	handler.PricingRuleReq	true	"Rule type, name and rates"
+// @Produce	json
+// @Success	201	{object}	handler.PricingRuleRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/pricing-rules [post]
+func (ph *PricingHandler) HandlePostPricingRule(c echo.Context) error {
+	var reqBody PricingRuleReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	rule, err := ph.validatePricingRuleReq(&reqBody)
+	if err != nil {
+		return err
+	}
+
+	err = ph.db.Create(rule).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusCreated, toPricingRuleRespItem(rule))
+}
+
+// @Summary	Deletes a pricing rule
+// @Tags		pricing
+// @Param		id	path	int	true	"Pricing rule id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/pricing-rules/{id} [delete]
+func (ph *PricingHandler) HandleDeletePricingRule(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var rule model.PricingRule
+	err = ph.db.Where("id=?", id).First(&rule).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return util.NewAppError(http.StatusNotFound, "pricing rule not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	err = ph.db.Delete(&rule).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "pricing rule deleted",
+	})
+}
+
+// price of renting the car for the dates, before booking
+// @Summary	Itemized price of a car for the dates
+// @Tags		pricing
+// @Param		id			path	int		true	"Car id"
+// @Param		startDate	query	string	true	"Start date or time"
+// @Param		endDate		query	string	true	"End date or time"
+// @Produce	json
+// @Success	200	{object}	handler.PriceBreakdownResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/price [get]
+func (ph *PricingHandler) HandleGetCarPrice(c echo.Context) error {
+	car, err := findCarFromParam(c, ph.db)
+	if err != nil {
+		return err
+	}
+	startDate, _, err := parseRentalTime(c.QueryParam("startDate"))
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+	}
+	endDate, _, err := parseRentalTime(c.QueryParam("endDate"))
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+	}
+	if !endDate.After(startDate) {
+		return util.NewAppError(http.StatusBadRequest, "end date must be after start date", "")
+	}
+
+	breakdown, err := ph.ps.Calculate(car, startDate, endDate)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, PriceBreakdownResp{
+		CarID:     car.ID,
+		StartDate: startDate.Format(rentalTimeFormat),
+		EndDate:   endDate.Format(rentalTimeFormat),
+		Days:      breakdown.Days,
+		Hours:     breakdown.Hours,
+		Items:     toPriceLinesResp(breakdown),
+		Total:     breakdown.Total,
+	})
+}
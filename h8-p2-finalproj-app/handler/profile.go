@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UpdateProfileReq struct {
+	Name string `json:"name"`
+}
+
+type ChangeEmailReq struct {
+	NewEmail string `json:"new_email"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordReq struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountReq struct {
+	Password string `json:"password"`
+}
+
+func (uh *UserHandler) checkPassword(user *model.User, password string) error {
+	if password == "" {
+		return util.NewAppError(http.StatusBadRequest, "password cannot be empty", "")
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		return util.NewAppError(http.StatusUnauthorized, "incorrect password", "")
+	}
+	return nil
+}
+
+// @Summary	Update user profile
+// @Tags		users
+// @Accept		json
+// @Param		Profile	body	handler.UpdateProfileReq	true	"Profile fields to update"
+// @Produce	json
+// @Success	200	{object}	handler.UserProfile
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/profile [put]
+func (uh *UserHandler) HandleUpdateProfile(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, uh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody UpdateProfileReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if reqBody.Name == "" {
+		return util.NewAppError(http.StatusBadRequest, "full name cannot be empty", "")
+	}
+
+	user.Name = reqBody.Name
+	err = uh.db.Model(user).Update("name", user.Name).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, UserProfile{
+		UserID:  user.ID,
+		Name:    user.Name,
+		Email:   user.Email,
+		Deposit: user.Deposit,
+	})
+}
+
+// @Summary	Request an email change, a verification link is sent to the new email
+// @Tags		users
+// @Accept		json
+// @Param		Email	body	handler.ChangeEmailReq	true	"New email and current password"
+// @Produce	json
+// @Success	202	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/profile/email [post]
+func (uh *UserHandler) HandleChangeEmail(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, uh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody ChangeEmailReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	reqBody.NewEmail = strings.TrimSpace(reqBody.NewEmail)
+	if reqBody.NewEmail == "" {
+		return util.NewAppError(http.StatusBadRequest, "email cannot be empty", "")
+	}
+	err = uh.checkPassword(user, reqBody.Password)
+	if err != nil {
+		return err
+	}
+
+	token, err := uh.as.RequestEmailChange(user, reqBody.NewEmail)
+	if err != nil && errors.Is(err, service.ErrEmailAlreadyRegistered) {
+		return util.NewAppError(http.StatusBadRequest, "email already registered", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	err = uh.ns.SendMail(user.ID, reqBody.NewEmail,
+		"Confirm your new email",
+		fmt.Sprintf(`
+		<h1>Hello %s,</h1><br>
+		<p>Please confirm your new email address here:<br>%s/users/profile/email/confirm?token=%s</p>
+		<p>The link expires in 24 hours.</p>
+		`, user.Name,
+			os.Getenv("APP_URL"),
+			token),
+		c.Logger(),
+	)
+	if err != nil {
+		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+	}
+
+	return c.JSON(http.StatusAccepted, &util.ResponseData{
+		Message: "Verification email sent to the new email",
+	})
+}
+
+// @Summary	Confirm an email change
+// @Tags		users
+// @Param		token	query	string	true	"Email change token"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/profile/email/confirm [get]
+func (uh *UserHandler) HandleConfirmEmailChange(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return util.NewAppError(http.StatusBadRequest, "token cannot be empty", "")
+	}
+
+	user, oldEmail, err := uh.as.ConfirmEmailChange(token)
+	if err != nil && errors.Is(err, service.ErrInvalidEmailChangeToken) {
+		return util.NewAppError(http.StatusBadRequest, "invalid or expired token", "")
+	} else if err != nil && errors.Is(err, service.ErrEmailAlreadyRegistered) {
+		return util.NewAppError(http.StatusBadRequest, "email already registered", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	// let the old address know, in case it wasn't the owner
+	err = uh.ns.SendMail(user.ID, oldEmail,
+		"Your email has been changed",
+		fmt.Sprintf("<h1>Hello %s, the email of your account has been changed to %s</h1>",
+			user.Name, user.Email),
+		c.Logger(),
+	)
+	if err != nil {
+		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "Email successfully changed",
+	})
+}
+
+// @Summary	Change password, ends all other sessions
+// @Tags		users
+// @Accept		json
+// @Param		Passwords	body	handler.ChangePasswordReq	true	"Current and new password"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/profile/password [put]
+func (uh *UserHandler) HandleChangePassword(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, uh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody ChangePasswordReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	err = uh.checkPassword(user, reqBody.CurrentPassword)
+	if err != nil {
+		return err
+	}
+	err = validatePassword(reqBody.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	claims, err := util.GetClaimsFromContext(c)
+	if err != nil {
+		return err
+	}
+	err = uh.as.ChangePassword(user, reqBody.NewPassword, claims.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	err = uh.ns.SendMail(user.ID, user.Email,
+		"Your password has been changed",
+		fmt.Sprintf("<h1>Hello %s, your password has been changed</h1>", user.Name),
+		c.Logger(),
+	)
+	if err != nil {
+		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "Password successfully changed",
+	})
+}
+
+// @Summary	Delete account, personal data is anonymized
+// @Tags		users
+// @Accept		json
+// @Param		Password	body	handler.DeleteAccountReq	true	"Current password"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/profile [delete]
+func (uh *UserHandler) HandleDeleteAccount(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, uh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody DeleteAccountReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	err = uh.checkPassword(user, reqBody.Password)
+	if err != nil {
+		return err
+	}
+
+	email, name := user.Email, user.Name
+	err = uh.as.DeleteAccount(user)
+	if err != nil && errors.Is(err, service.ErrActiveRentals) {
+		return util.NewAppError(http.StatusConflict, "account has active rentals", "")
+	} else if err != nil && errors.Is(err, service.ErrLastOrgAdmin) {
+		return util.NewAppError(http.StatusConflict, "account is the last admin of its organization, appoint another admin first", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	err = uh.dls.DeleteForUser(user.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to delete driver licenses of user %d: %s", user.ID, err.Error())
+	}
+
+	// not recorded, the notification history is deleted with the account
+	err = service.SendMail(email,
+		"Your account has been deleted",
+		fmt.Sprintf("<h1>Goodbye %s, your account has been deleted</h1>", name),
+		c.Logger(),
+	)
+	if err != nil {
+		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "Account successfully deleted",
+	})
+}
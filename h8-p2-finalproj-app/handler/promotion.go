@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type PromotionHandler struct {
+	db  *gorm.DB
+	prs *service.PromotionService
+}
+
+func NewPromotionHandler(db *gorm.DB, prs *service.PromotionService) PromotionHandler {
+	return PromotionHandler{
+		db:  db,
+		prs: prs,
+	}
+}
+
+type PostPromotionReq struct {
+	Code          string    `json:"code"`
+	Description   string    `json:"description"`
+	DiscountType  string    `json:"discount_type"`
+	DiscountValue float64   `json:"discount_value"`
+	MaxDiscount   float64   `json:"max_discount"`
+	MinSpend      float64   `json:"min_spend"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	UsageLimit    uint      `json:"usage_limit"`
+	PerUserLimit  uint      `json:"per_user_limit"`
+	CarTypes      []string  `json:"car_types"`
+}
+
+type PromotionRespItem struct {
+	PromotionID   uint      `json:"promotion_id"`
+	Code          string    `json:"code"`
+	Description   string    `json:"description"`
+	DiscountType  string    `json:"discount_type"`
+	DiscountValue float64   `json:"discount_value"`
+	MaxDiscount   float64   `json:"max_discount,omitempty"`
+	MinSpend      float64   `json:"min_spend,omitempty"`
+	StartsAt      time.Time `json:"starts_at"`
+	EndsAt        time.Time `json:"ends_at"`
+	UsageLimit    uint      `json:"usage_limit,omitempty"`
+	PerUserLimit  uint      `json:"per_user_limit,omitempty"`
+	CarTypes      []string  `json:"car_types"`
+	Active        bool      `json:"active"`
+}
+
+type PromotionStatsResp struct {
+	PromotionID   uint    `json:"promotion_id"`
+	Code          string  `json:"code"`
+	Redemptions   int64   `json:"redemptions"`
+	UniqueUsers   int64   `json:"unique_users"`
+	TotalDiscount float64 `json:"total_discount"`
+	PaidRentals   int64   `json:"paid_rentals"`
+	TotalRevenue  float64 `json:"total_revenue"`
+}
+
+func toPromotionRespItem(p *model.Promotion) PromotionRespItem {
+	resp := PromotionRespItem{
+		PromotionID:   p.ID,
+		Code:          p.Code,
+		Description:   p.Description,
+		DiscountType:  p.DiscountType,
+		DiscountValue: p.DiscountValue,
+		MaxDiscount:   p.MaxDiscount,
+		MinSpend:      p.MinSpend,
+		StartsAt:      p.StartsAt,
+		EndsAt:        p.EndsAt,
+		UsageLimit:    p.UsageLimit,
+		PerUserLimit:  p.PerUserLimit,
+		CarTypes:      []string{},
+		Active:        p.Active,
+	}
+	if p.CarTypes != "" {
+		resp.CarTypes = strings.Split(p.CarTypes, ",")
+	}
+	return resp
+}
+
+// @Summary	Adds a promo code
+// @Tags		promotions
+// @Accept		json
+// @Param		PromotionData	body	handler.PostPromotionReq	true	"Code, discount, validity and limits"
+// @Produce	json
+// @Success	201	{object}	handler.PromotionRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/promotions [post]
+func (ph *PromotionHandler) HandlePostPromotion(c echo.Context) error {
+	var reqBody PostPromotionReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+
+	code := service.NormalizePromoCode(reqBody.Code)
+	if code == "" {
+		return util.NewAppError(http.StatusBadRequest, "code cannot be empty", "")
+	}
+	switch reqBody.DiscountType {
+	case model.DiscountPercentage:
+		if reqBody.DiscountValue <= 0 || reqBody.DiscountValue > 100 {
+			return util.NewAppError(http.StatusBadRequest, "percentage must be between 0 and 100", "")
+		}
+	case model.DiscountFixed:
+		if reqBody.DiscountValue <= 0 {
+			return util.NewAppError(http.StatusBadRequest, "discount value must be positive", "")
+		}
+	default:
+		return util.NewAppError(http.StatusBadRequest, "invalid discount type", "")
+	}
+	if reqBody.MaxDiscount < 0 || reqBody.MinSpend < 0 {
+		return util.NewAppError(http.StatusBadRequest, "max discount and min spend cannot be negative", "")
+	}
+	if reqBody.StartsAt.IsZero() || !reqBody.EndsAt.After(reqBody.StartsAt) {
+		return util.NewAppError(http.StatusBadRequest, "ends at must be after starts at", "")
+	}
+	carTypes := []string{}
+	for _, t := range reqBody.CarTypes {
+		if t = strings.TrimSpace(t); t != "" {
+			carTypes = append(carTypes, t)
+		}
+	}
+
+	var count int64
+	err = ph.db.Model(&model.Promotion{}).Where("code=?", code).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		return util.NewAppError(http.StatusBadRequest, "code already exists", "")
+	}
+
+	promo := model.Promotion{
+		Code:          code,
+		Description:   strings.TrimSpace(reqBody.Description),
+		DiscountType:  reqBody.DiscountType,
+		DiscountValue: reqBody.DiscountValue,
+		MaxDiscount:   reqBody.MaxDiscount,
+		MinSpend:      reqBody.MinSpend,
+		StartsAt:      reqBody.StartsAt,
+		EndsAt:        reqBody.EndsAt,
+		UsageLimit:    reqBody.UsageLimit,
+		PerUserLimit:  reqBody.PerUserLimit,
+		CarTypes:      strings.Join(carTypes, ","),
+		Active:        true,
+	}
+	err = ph.db.Create(&promo).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, toPromotionRespItem(&promo))
+}
+
+// @Summary	Promo codes
+// @Tags		promotions
+// @Produce	json
+// @Success	200	{array}		handler.PromotionRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/promotions [get]
+func (ph *PromotionHandler) HandleGetPromotions(c echo.Context) error {
+	var promos []model.Promotion
+	err := ph.db.Order("id DESC").Find(&promos).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []PromotionRespItem{}
+	for i := range promos {
+		resp = append(resp, toPromotionRespItem(&promos[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (ph *PromotionHandler) getPromotion(c echo.Context) (*model.Promotion, error) {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	var promo model.Promotion
+	err = ph.db.Where("id=?", id).First(&promo).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, util.NewAppError(http.StatusNotFound, "promotion not found", "")
+	} else if err != nil {
+		return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return &promo, nil
+}
+
+// stops the code from being used, past redemptions are kept
+// @Summary	Deactivates a promo code
+// @Tags		promotions
+// @Param		id	path	int	true	"Promotion id"
+// @Produce	json
+// @Success	200	{object}	handler.PromotionRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/promotions/{id}/deactivate [post]
+func (ph *PromotionHandler) HandleDeactivatePromotion(c echo.Context) error {
+	promo, err := ph.getPromotion(c)
+	if err != nil {
+		return err
+	}
+	err = ph.db.Model(promo).Update("active", false).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, toPromotionRespItem(promo))
+}
+
+// @Summary	Redemptions, discount given and revenue of paid rentals of a promo code
+// @Tags		promotions
+// @Param		id	path	int	true	"Promotion id"
+// @Produce	json
+// @Success	200	{object}	handler.PromotionStatsResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/promotions/{id}/stats [get]
+func (ph *PromotionHandler) HandleGetPromotionStats(c echo.Context) error {
+	promo, err := ph.getPromotion(c)
+	if err != nil {
+		return err
+	}
+	stats, err := ph.prs.GetStats(promo.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return c.JSON(http.StatusOK, PromotionStatsResp{
+		PromotionID:   promo.ID,
+		Code:          promo.Code,
+		Redemptions:   stats.Redemptions,
+		UniqueUsers:   stats.UniqueUsers,
+		TotalDiscount: stats.TotalDiscount,
+		PaidRentals:   stats.PaidRentals,
+		TotalRevenue:  stats.TotalRevenue,
+	})
+}
@@ -6,8 +6,8 @@ import (
 	"h8-p2-finalproj-app/model"
 	"h8-p2-finalproj-app/service"
 	"h8-p2-finalproj-app/util"
-	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -15,75 +15,495 @@ import (
 )
 
 type RentalHandler struct {
-	db *gorm.DB
-	cs *service.CarService
-	is *service.InvoiceService
+	db  *gorm.DB
+	cs  *service.CarService
+	bs  *service.BranchService
+	ps  *service.PricingService
+	qs  *service.QuoteService
+	prs *service.PromotionService
+	aos *service.AddOnService
+	is  *service.InvoiceService
+	ns  *service.NotificationService
+	rs  *service.RentalService
+	ws  *service.WaitlistService
+	dls *service.DriverLicenseService
+	ors *service.OrganizationService
+	sds *service.SecurityDepositService
 }
 
 func NewRentalHandler(
 	db *gorm.DB,
 	cs *service.CarService,
-	is *service.InvoiceService) RentalHandler {
+	bs *service.BranchService,
+	ps *service.PricingService,
+	qs *service.QuoteService,
+	prs *service.PromotionService,
+	aos *service.AddOnService,
+	is *service.InvoiceService,
+	ns *service.NotificationService,
+	rs *service.RentalService,
+	ws *service.WaitlistService,
+	dls *service.DriverLicenseService,
+	ors *service.OrganizationService,
+	sds *service.SecurityDepositService) RentalHandler {
 	return RentalHandler{
-		db: db,
-		cs: cs,
-		is: is,
+		db:  db,
+		cs:  cs,
+		bs:  bs,
+		ps:  ps,
+		qs:  qs,
+		prs: prs,
+		aos: aos,
+		is:  is,
+		ns:  ns,
+		rs:  rs,
+		ws:  ws,
+		dls: dls,
+		ors: ors,
+		sds: sds,
 	}
 }
 
 type PostRentalsReq struct {
-	CarID     uint   `json:"car_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
+	CarID           uint           `json:"car_id"`
+	StartDate       string         `json:"start_date"`
+	EndDate         string         `json:"end_date"`
+	PickupBranchID  *uint          `json:"pickup_branch_id"`
+	DropoffBranchID *uint          `json:"dropoff_branch_id"`
+	PromoCode       string         `json:"promo_code"`
+	AddOns          []AddOnItemReq `json:"add_ons"`
+	// books a quote instead, the other fields are ignored
+	QuoteID *uint `json:"quote_id"`
+	// books on the organization account, billed with its monthly invoice
+	OrganizationID *uint `json:"organization_id"`
+	// member of the organization driving, the booker by default
+	DriverID *uint `json:"driver_id"`
+	// how the security deposit is paid, wallet or invoice. the wallet
+	// when its balance covers the deposit by default
+	DepositMethod string `json:"deposit_method"`
+}
+
+type AddOnItemReq struct {
+	AddOnID  uint `json:"add_on_id"`
+	Quantity uint `json:"quantity"`
+}
+
+type RentalAddOnResp struct {
+	AddOnID  uint    `json:"add_on_id"`
+	Name     string  `json:"name"`
+	Quantity uint    `json:"quantity"`
+	Amount   float64 `json:"amount"`
+}
+
+func toRentalAddOnsResp(addOns []model.RentalAddOn) []RentalAddOnResp {
+	resp := []RentalAddOnResp{}
+	for _, a := range addOns {
+		resp = append(resp, RentalAddOnResp{
+			AddOnID:  a.AddOnID,
+			Name:     a.AddOn.Name,
+			Quantity: a.Quantity,
+			Amount:   a.Amount,
+		})
+	}
+	return resp
+}
+
+// e.g. "Child seat x2, GPS x1"
+func addOnsSummary(addOns []model.RentalAddOn) string {
+	items := []string{}
+	for _, a := range addOns {
+		items = append(items, fmt.Sprintf("%s x%d", a.AddOn.Name, a.Quantity))
+	}
+	return strings.Join(items, ", ")
+}
+
+func addOnsSummaryOrNone(addOns []model.RentalAddOn) string {
+	if len(addOns) == 0 {
+		return "-"
+	}
+	return addOnsSummary(addOns)
 }
 
 type PostRentalData struct {
-	CarID     uint      `json:"car_id"`
-	StartDate time.Time `json:"start_date"`
-	EndDate   time.Time `json:"end_date"`
+	CarID           uint      `json:"car_id"`
+	StartDate       time.Time `json:"start_date"`
+	EndDate         time.Time `json:"end_date"`
+	PickupBranchID  *uint     `json:"pickup_branch_id"`
+	DropoffBranchID *uint     `json:"dropoff_branch_id"`
+	// pickup and return times were given, not only dates
+	Timed bool `json:"-"`
 }
 
 func (rh *RentalHandler) validatePostRentalReqData(prr *PostRentalsReq) (*PostRentalData, error) {
-	startDate, err := time.Parse(time.DateOnly, prr.StartDate)
+	startDate, startTimed, err := parseRentalTime(prr.StartDate)
 	if err != nil {
-		return nil, util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+		return nil, util.NewAppError(http.StatusBadRequest, "invalid start date", "")
 	}
 
-	endDate, err := time.Parse(time.DateOnly, prr.EndDate)
+	endDate, endTimed, err := parseRentalTime(prr.EndDate)
 	if err != nil {
-		return nil, util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+		return nil, util.NewAppError(http.StatusBadRequest, "invalid end date", "")
 	}
-	if endDate.Before(startDate) {
-		return nil, util.NewAppError(http.StatusBadRequest, "end date cannot be before start date", "")
+	if !endDate.After(startDate) {
+		return nil, util.NewAppError(http.StatusBadRequest, "end date must be after start date", "")
+	}
+	for _, a := range prr.AddOns {
+		if a.Quantity < 1 {
+			return nil, util.NewAppError(http.StatusBadRequest, "add on quantity must be at least 1", "")
+		}
+	}
+	if prr.DropoffBranchID != nil && prr.PickupBranchID == nil {
+		return nil, util.NewAppError(http.StatusBadRequest, "drop off branch requires a pickup branch", "")
+	}
+	// returned to the pickup branch by default
+	dropoffBranchID := prr.DropoffBranchID
+	if dropoffBranchID == nil {
+		dropoffBranchID = prr.PickupBranchID
 	}
 	return &PostRentalData{
-		CarID:     prr.CarID,
-		StartDate: startDate,
-		EndDate:   endDate,
+		CarID:           prr.CarID,
+		StartDate:       startDate,
+		EndDate:         endDate,
+		PickupBranchID:  prr.PickupBranchID,
+		DropoffBranchID: dropoffBranchID,
+		Timed:           startTimed || endTimed,
 	}, nil
 }
 
+// checks the branches are open on the pickup and drop off dates,
+// and at the times when they were given
+func (rh *RentalHandler) validateBranches(rd *PostRentalData) error {
+	if rd.PickupBranchID == nil {
+		return nil
+	}
+	for _, b := range []struct {
+		id   uint
+		date time.Time
+	}{
+		{*rd.PickupBranchID, rd.StartDate},
+		{*rd.DropoffBranchID, rd.EndDate},
+	} {
+		var err error
+		if rd.Timed {
+			_, err = rh.bs.GetOpenBranchAt(b.id, b.date)
+		} else {
+			_, err = rh.bs.GetOpenBranch(b.id, b.date)
+		}
+		if err != nil && errors.Is(err, service.ErrBranchNotFound) {
+			return util.NewAppError(http.StatusNotFound, "branch not found", "")
+		} else if err != nil && errors.Is(err, service.ErrBranchClosed) && rd.Timed {
+			return util.NewAppError(http.StatusBadRequest,
+				fmt.Sprintf("branch is closed at %s", b.date.Format("2006-01-02 15:04")), "")
+		} else if err != nil && errors.Is(err, service.ErrBranchClosed) {
+			return util.NewAppError(http.StatusBadRequest,
+				fmt.Sprintf("branch is closed on %s", b.date.Format(time.DateOnly)), "")
+		} else if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+	}
+	return nil
+}
+
 type PostRentalResp struct {
-	RentalID      uint      `json:"rental_id"`
-	CarID         uint      `json:"car_id"`
-	StartDate     time.Time `json:"start_date"`
-	EndDate       time.Time `json:"end_date"`
-	TotalPrice    float64   `json:"total_price"`
-	PaymentID     uint      `json:"payment_id"`
-	PaymentStatus string    `json:"payment_status"`
-	PaymentUrl    string    `json:"payment_url"`
+	RentalID      uint              `json:"rental_id"`
+	CarID         uint              `json:"car_id"`
+	StartDate     time.Time         `json:"start_date"`
+	EndDate       time.Time         `json:"end_date"`
+	TotalPrice    float64           `json:"total_price"`
+	OneWayFee     float64           `json:"one_way_fee,omitempty"`
+	Discount      float64           `json:"discount,omitempty"`
+	AddOns        []RentalAddOnResp `json:"add_ons"`
+	PriceItems    []PriceLineResp   `json:"price_items"`
+	PaymentID     uint              `json:"payment_id"`
+	PaymentStatus string            `json:"payment_status"`
+	PaymentUrl    string            `json:"payment_url"`
+	// set when billed to an organization
+	OrganizationID  *uint                `json:"organization_id,omitempty"`
+	DriverID        *uint                `json:"driver_id,omitempty"`
+	SecurityDeposit *SecurityDepositResp `json:"security_deposit,omitempty"`
 }
 
 func (rh *RentalHandler) GenerateInvoiceDesc(rental *model.Rental) string {
-	return fmt.Sprintf(
+	desc := fmt.Sprintf(
 		"Renting %s %s, from: %s to %s",
 		rental.Car.Manufacturer,
 		rental.Car.CarModel,
-		rental.StartDate.Format(time.DateOnly),
-		rental.EndDate.Format(time.DateOnly),
+		rental.StartDate.Format(rentalTimeFormat),
+		rental.EndDate.Format(rentalTimeFormat),
 	)
+	if rental.OneWayFee > 0 {
+		desc += fmt.Sprintf(", one way fee: IDR %.0f", rental.OneWayFee)
+	}
+	if len(rental.AddOns) > 0 {
+		desc += ", add ons: " + addOnsSummary(rental.AddOns)
+	}
+	if rental.Discount > 0 {
+		desc += fmt.Sprintf(", discount: IDR %.0f", rental.Discount)
+	}
+	return desc
+}
+
+// validated booking with its price, shared by quotes and rentals
+type pricedRental struct {
+	data      *PostRentalData
+	car       model.Car
+	oneWayFee float64
+	breakdown *service.PriceBreakdown
+	addOns    []model.RentalAddOn
+	promotion *model.Promotion
+	discount  float64
+}
+
+func addOnAppError(err error) error {
+	if errors.Is(err, service.ErrAddOnNotFound) {
+		return util.NewAppError(http.StatusNotFound, "add on not found", "")
+	} else if errors.Is(err, service.ErrAddOnUnavailable) {
+		return util.NewAppError(http.StatusBadRequest, err.Error(), "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+func promoAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrPromoNotFound):
+		return util.NewAppError(http.StatusBadRequest, "invalid promo code", "")
+	case errors.Is(err, service.ErrPromoNotActive):
+		return util.NewAppError(http.StatusBadRequest, "promo code is not active", "")
+	case errors.Is(err, service.ErrPromoMinSpend):
+		return util.NewAppError(http.StatusBadRequest, "minimum spend for promo code not reached", "")
+	case errors.Is(err, service.ErrPromoCarType):
+		return util.NewAppError(http.StatusBadRequest, "promo code is not valid for this car", "")
+	case errors.Is(err, service.ErrPromoLimitReached):
+		return util.NewAppError(http.StatusBadRequest, "promo code is fully redeemed", "")
+	case errors.Is(err, service.ErrPromoUserLimitReached):
+		return util.NewAppError(http.StatusBadRequest, "promo code already used", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+func (rh *RentalHandler) getAvailableCar(user *model.User, rentalData *PostRentalData) (*model.Car, error) {
+	// get car details
+	var car model.Car
+	err := rh.db.Where("id=?", rentalData.CarID).First(&car).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, util.NewAppError(http.StatusNotFound, "car not found", "")
+	} else if err != nil {
+		return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	err = rh.validateBranches(rentalData)
+	if err != nil {
+		return nil, err
+	}
+
+	// check car available at the pickup branch, and not held for someone on the waitlist
+	isAvail, err := rh.cs.IsCarBookable(car.ID, rentalData.PickupBranchID, rentalData.StartDate, rentalData.EndDate, user.ID)
+	if err != nil {
+		return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if !isAvail {
+		return nil, util.NewAppError(http.StatusBadRequest, "car is not available, join the waitlist to get it when a unit is freed", "")
+	}
+	return &car, nil
+}
+
+// validates the request and calculates the price
+func (rh *RentalHandler) priceRental(user *model.User, prr *PostRentalsReq) (*pricedRental, error) {
+	rentalData, err := rh.validatePostRentalReqData(prr)
+	if err != nil {
+		return nil, err
+	}
+	car, err := rh.getAvailableCar(user, rentalData)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown, err := rh.ps.Calculate(car, rentalData.StartDate, rentalData.EndDate)
+	if err != nil {
+		return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	oneWayFee := 0.0
+	if rentalData.PickupBranchID != nil {
+		oneWayFee, err = rh.bs.GetOneWayFee(*rentalData.PickupBranchID, *rentalData.DropoffBranchID)
+		if err != nil {
+			return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+	}
+	if oneWayFee > 0 {
+		breakdown.AddLine("One way fee", 1, oneWayFee)
+	}
+
+	priced := pricedRental{
+		data:      rentalData,
+		car:       *car,
+		oneWayFee: oneWayFee,
+		breakdown: breakdown,
+	}
+	addOnReqs := []service.AddOnReq{}
+	for _, a := range prr.AddOns {
+		addOnReqs = append(addOnReqs, service.AddOnReq{AddOnID: a.AddOnID, Quantity: a.Quantity})
+	}
+	priced.addOns, err = rh.aos.Price(addOnReqs, rentalData.StartDate, rentalData.EndDate, breakdown)
+	if err != nil {
+		return nil, addOnAppError(err)
+	}
+	// discount applies to the total so far
+	if prr.PromoCode != "" {
+		priced.promotion, priced.discount, err = rh.prs.Apply(prr.PromoCode, user, car, breakdown)
+		if err != nil {
+			return nil, promoAppError(err)
+		}
+	}
+	return &priced, nil
+}
+
+// the booking of a quote, at the quoted price if the car is still available
+func (rh *RentalHandler) quotedRental(user *model.User, quoteID uint) (*model.Quote, *pricedRental, error) {
+	quote, err := rh.qs.GetUsable(user.ID, quoteID)
+	if err != nil && errors.Is(err, service.ErrQuoteNotFound) {
+		return nil, nil, util.NewAppError(http.StatusNotFound, "quote not found", "")
+	} else if err != nil && errors.Is(err, service.ErrQuoteUsed) {
+		return nil, nil, util.NewAppError(http.StatusConflict, "quote already used", "")
+	} else if err != nil && errors.Is(err, service.ErrQuoteExpired) {
+		return nil, nil, util.NewAppError(http.StatusGone, "quote has expired, request a new one", "")
+	} else if err != nil {
+		return nil, nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	rentalData := &PostRentalData{
+		CarID:           quote.CarID,
+		StartDate:       quote.StartDate,
+		EndDate:         quote.EndDate,
+		PickupBranchID:  quote.PickupBranchID,
+		DropoffBranchID: quote.DropoffBranchID,
+		Timed:           quote.Timed,
+	}
+	car, err := rh.getAvailableCar(user, rentalData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priced := pricedRental{
+		data:      rentalData,
+		car:       *car,
+		oneWayFee: quote.OneWayFee,
+		breakdown: rh.qs.Breakdown(quote),
+		discount:  quote.Discount,
+		addOns:    []model.RentalAddOn{},
+	}
+	for _, a := range quote.AddOns {
+		priced.addOns = append(priced.addOns, model.RentalAddOn{
+			AddOnID:  a.AddOnID,
+			AddOn:    a.AddOn,
+			Quantity: a.Quantity,
+			Amount:   a.Amount,
+		})
+	}
+	if quote.PromotionID != nil {
+		priced.promotion = &model.Promotion{}
+		err = rh.db.Where("id=?", *quote.PromotionID).First(priced.promotion).Error
+		if err != nil {
+			return nil, nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+	}
+	return quote, &priced, nil
 }
 
+type QuoteResp struct {
+	QuoteID         uint              `json:"quote_id"`
+	CarID           uint              `json:"car_id"`
+	StartDate       string            `json:"start_date"`
+	EndDate         string            `json:"end_date"`
+	PickupBranchID  *uint             `json:"pickup_branch_id,omitempty"`
+	DropoffBranchID *uint             `json:"dropoff_branch_id,omitempty"`
+	PriceItems      []PriceLineResp   `json:"price_items"`
+	AddOns          []RentalAddOnResp `json:"add_ons"`
+	TotalPrice      float64           `json:"total_price"`
+	ExpiresAt       time.Time         `json:"expires_at"`
+}
+
+// @Summary	Quotes the itemized price of a booking, valid for 30 minutes and bookable once
+// @Tags		rentals
+// @Accept		json
+// @Param		RentalData	body	handler.PostRentalsReq	true	"Booking to quote, quote id is ignored"
+// @Produce	json
+// @Success	201	{object}	handler.QuoteResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/quote [post]
+// prices a booking without making it, book it by sending the quote id to POST /rentals
+func (rh *RentalHandler) HandlePostQuote(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, rh.db)
+	if err != nil {
+		return err
+	}
+
+	var reqBody PostRentalsReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	priced, err := rh.priceRental(user, &reqBody)
+	if err != nil {
+		return err
+	}
+
+	quote := model.Quote{
+		UserID:          user.ID,
+		CarID:           priced.car.ID,
+		StartDate:       priced.data.StartDate,
+		EndDate:         priced.data.EndDate,
+		Timed:           priced.data.Timed,
+		PickupBranchID:  priced.data.PickupBranchID,
+		DropoffBranchID: priced.data.DropoffBranchID,
+		OneWayFee:       priced.oneWayFee,
+		Discount:        priced.discount,
+	}
+	if priced.promotion != nil {
+		quote.PromotionID = &priced.promotion.ID
+	}
+	for _, a := range priced.addOns {
+		quote.AddOns = append(quote.AddOns, model.QuoteAddOn{
+			AddOnID:  a.AddOnID,
+			AddOn:    a.AddOn,
+			Quantity: a.Quantity,
+			Amount:   a.Amount,
+		})
+	}
+	err = rh.qs.Create(&quote, priced.breakdown)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, QuoteResp{
+		QuoteID:         quote.ID,
+		CarID:           quote.CarID,
+		StartDate:       quote.StartDate.Format(rentalTimeFormat),
+		EndDate:         quote.EndDate.Format(rentalTimeFormat),
+		PickupBranchID:  quote.PickupBranchID,
+		DropoffBranchID: quote.DropoffBranchID,
+		PriceItems:      toPriceLinesResp(priced.breakdown),
+		AddOns:          toRentalAddOnsResp(priced.addOns),
+		TotalPrice:      quote.TotalPrice,
+		ExpiresAt:       quote.ExpiresAt,
+	})
+}
+
+// @Summary	Books a rental, or the quote given by quote id at its price
+// @Tags		rentals
+// @Accept		json
+// @Param		RentalData	body	handler.PostRentalsReq	true	"Car, dates, branches, add ons, promo code, or a quote id"
+// @Produce	json
+// @Success	201	{object}	handler.PostRentalResp
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	410	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals [post]
 func (rh *RentalHandler) HandlePostRentals(c echo.Context) error {
 	// get user from context
 	user, err := util.GetUserFromContext(c, rh.db)
@@ -98,38 +518,74 @@ func (rh *RentalHandler) HandlePostRentals(c echo.Context) error {
 	if err != nil {
 		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
 	}
-	rentalData, err := rh.validatePostRentalReqData(&reqBody)
+
+	// price as quoted, or calculated now
+	var priced *pricedRental
+	var quote *model.Quote
+	if reqBody.QuoteID != nil {
+		quote, priced, err = rh.quotedRental(user, *reqBody.QuoteID)
+	} else {
+		priced, err = rh.priceRental(user, &reqBody)
+	}
 	if err != nil {
 		return err
 	}
+	car := priced.car
+	rentalData := priced.data
+	breakdown := priced.breakdown
+	oneWayFee := priced.oneWayFee
 
-	// get car details
-	var car model.Car
-	err = rh.db.Where("id=?", rentalData.CarID).First(&car).Error
-	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
-		return util.NewAppError(http.StatusNotFound, "car not found", "")
-	} else if err != nil {
-		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	// only members booking on the organization account choose another driver
+	onAccount := reqBody.OrganizationID != nil
+	if reqBody.DriverID != nil && !onAccount {
+		return util.NewAppError(http.StatusBadRequest, "driver can only be set when booking for an organization", "")
+	}
+	if onAccount {
+		err = rh.ors.AuthorizeBooking(*reqBody.OrganizationID, user.ID, reqBody.DriverID)
+		if err != nil {
+			return orgAppError(err)
+		}
+	}
+	driverID := user.ID
+	if reqBody.DriverID != nil {
+		driverID = *reqBody.DriverID
+	}
+
+	if service.LicenseCheckAt() == service.LicenseCheckBooking {
+		err = rh.dls.CheckValid(rh.db, driverID, rentalData.EndDate)
+		if err != nil {
+			return licenseAppError(err)
+		}
 	}
 
-	// check car available
-	isAvail, err := rh.cs.IsCarAvailable(car.ID, rentalData.StartDate, rentalData.EndDate)
+	// refundable security deposit of the car type, reserved with the booking
+	depositAmount, err := rh.sds.AmountFor(car.Type)
 	if err != nil {
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
-	if !isAvail {
-		return util.NewAppError(http.StatusBadRequest, "car is not available", "")
+	depositMethod := ""
+	if depositAmount > 0 {
+		depositMethod, err = service.ChooseDepositMethod(reqBody.DepositMethod, user.Deposit, depositAmount)
+		if err != nil {
+			return depositAppError(err)
+		}
 	}
 
-	// calculate total price, and create rental
-	days := math.Ceil(rentalData.EndDate.Sub(rentalData.StartDate).Hours() / 24)
 	newRental := model.Rental{
-		UserID:     user.ID,
-		CarID:      car.ID,
-		StartDate:  rentalData.StartDate,
-		EndDate:    rentalData.EndDate,
-		TotalPrice: days * car.RatePerDay,
+		UserID:          user.ID,
+		CarID:           car.ID,
+		StartDate:       rentalData.StartDate,
+		EndDate:         rentalData.EndDate,
+		PickupBranchID:  rentalData.PickupBranchID,
+		DropoffBranchID: rentalData.DropoffBranchID,
+		OneWayFee:       oneWayFee,
+		Discount:        priced.discount,
 	}
+	if priced.promotion != nil {
+		newRental.PromotionID = &priced.promotion.ID
+	}
+	newRental.TotalPrice = breakdown.Total
+	newRental.PriceItems = breakdown.ToRentalPriceItems()
 
 	newPayment := model.Payment{
 		PaymentUrl:    "",
@@ -137,81 +593,264 @@ func (rh *RentalHandler) HandlePostRentals(c echo.Context) error {
 		PaymentMethod: "",
 		TotalPayment:  0,
 	}
+	if onAccount {
+		newRental.OrganizationID = reqBody.OrganizationID
+		newRental.DriverID = reqBody.DriverID
+		newPayment.Status = service.PaymentStatusOnAccount
+	}
 	newRental.Payment = newPayment
 	newRental.User = *user
 
-	err = rh.db.Create(&newRental).Error
-	if err != nil {
+	err = rh.db.Transaction(func(tx *gorm.DB) error {
+		// counted before the rental is created so it isn't counted twice
+		if onAccount {
+			err := rh.ors.CheckSpending(tx, *newRental.OrganizationID, user.ID, newRental.TotalPrice)
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Create(&newRental).Error
+		if err != nil {
+			return err
+		}
+		if quote != nil {
+			err = rh.qs.MarkUsed(tx, quote, newRental.ID)
+			if err != nil {
+				return err
+			}
+		}
+		err = rh.aos.Reserve(tx, &newRental, priced.addOns)
+		if err != nil {
+			return err
+		}
+		err = rh.ws.MarkBooked(tx, &newRental)
+		if err != nil {
+			return err
+		}
+		if depositAmount > 0 {
+			err = rh.sds.Reserve(tx, &newRental, depositAmount, depositMethod)
+			if err != nil {
+				return err
+			}
+		}
+		if priced.promotion != nil {
+			return rh.prs.Redeem(tx, priced.promotion.ID, user.ID, newRental.ID, priced.discount)
+		}
+		return nil
+	})
+	if err != nil && errors.Is(err, service.ErrQuoteUsed) {
+		return util.NewAppError(http.StatusConflict, "quote already used", "")
+	} else if err != nil && (errors.Is(err, service.ErrPromoLimitReached) || errors.Is(err, service.ErrPromoUserLimitReached)) {
+		return promoAppError(err)
+	} else if err != nil && errors.Is(err, service.ErrAddOnUnavailable) {
+		return addOnAppError(err)
+	} else if err != nil && (errors.Is(err, service.ErrOrgLimitReached) || errors.Is(err, service.ErrMemberLimitReached)) {
+		return orgAppError(err)
+	} else if err != nil && errors.Is(err, service.ErrInsufficientWallet) {
+		return depositAppError(err)
+	} else if err != nil {
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
+	newRental.AddOns = priced.addOns
 
-	// generate invoice url
-	url, err := rh.is.GenerateInvoice(
-		newRental.Payment.ID,
-		newRental.TotalPrice,
-		rh.GenerateInvoiceDesc(&newRental),
-		user.Email,
-	)
-	if err != nil {
-		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
-	}
-	// update payment url
-	// use one assigned to rental !!!!
-	newRental.Payment.PaymentUrl = url
-	err = rh.db.Save(&newRental.Payment).Error
-	if err != nil {
-		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	// the deposit has its own invoice, refunded to the wallet unlike the rental
+	deposit := newRental.SecurityDeposit
+	if deposit != nil && deposit.Method == model.DepositMethodInvoice {
+		err = rh.sds.Invoice(deposit,
+			fmt.Sprintf("Security deposit for renting %s, refundable after return", car.GetCarName()),
+			user.Email,
+		)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
 	}
 
-	// notify booking made
-	err = service.SendMail(
-		user.Email,
-		"You've made a booking!",
-		fmt.Sprintf(`
+	url := ""
+	if onAccount {
+		// confirmed now, paid with the organization's monthly invoice
+		rh.assignUnit(&newRental, c.Logger())
+		rh.sendOrgBookingMail(user, &car, &newRental, c.Logger())
+	} else {
+		// generate invoice url
+		url, err = rh.is.GenerateInvoice(
+			newRental.Payment.ID,
+			newRental.TotalPrice,
+			rh.GenerateInvoiceDesc(&newRental),
+			user.Email,
+		)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		// update payment url
+		// use one assigned to rental !!!!
+		newRental.Payment.PaymentUrl = url
+		err = rh.db.Save(&newRental.Payment).Error
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+
+		// notify booking made
+		err = rh.ns.SendMail(
+			user.ID,
+			user.Email,
+			"You've made a booking!",
+			fmt.Sprintf(`
 		<h1>Hello %s,</h1><br>
 		<p>Thank you for making a booking, here are the details:</p>
 		<p>Car: %s<br>
 		Start: %s<br>
 		End: %s<br>
+		Add ons: %s<br>
 		Total Price: IDR %.0f<br>	
 		</p>
 		<p>You can make payment here:<br>%s</p>
+		%s
 		`, user.Name,
-			car.GetCarName(),
-			newRental.StartDate.Format(time.DateOnly),
-			newRental.EndDate.Format(time.DateOnly),
-			newRental.TotalPrice,
-			newPayment.PaymentUrl),
-		c.Logger(),
-	)
-	if err != nil {
-		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+				car.GetCarName(),
+				newRental.StartDate.Format(rentalTimeFormat),
+				newRental.EndDate.Format(rentalTimeFormat),
+				addOnsSummaryOrNone(newRental.AddOns),
+				newRental.TotalPrice,
+				newPayment.PaymentUrl,
+				depositMailNote(deposit)),
+			c.Logger(),
+		)
+		if err != nil {
+			c.Logger().Errorf("failed to send email notif: %s", err.Error())
+		}
 	}
 
 	resp := PostRentalResp{
-		RentalID:      newRental.ID,
-		StartDate:     newRental.StartDate,
-		EndDate:       newRental.EndDate,
-		TotalPrice:    newRental.TotalPrice,
-		PaymentID:     newRental.Payment.ID,
-		PaymentStatus: newRental.Payment.Status,
-		PaymentUrl:    url,
+		RentalID:        newRental.ID,
+		StartDate:       newRental.StartDate,
+		EndDate:         newRental.EndDate,
+		TotalPrice:      newRental.TotalPrice,
+		OneWayFee:       newRental.OneWayFee,
+		Discount:        newRental.Discount,
+		AddOns:          toRentalAddOnsResp(newRental.AddOns),
+		PriceItems:      toPriceLinesResp(breakdown),
+		PaymentID:       newRental.Payment.ID,
+		PaymentStatus:   newRental.Payment.Status,
+		PaymentUrl:      url,
+		OrganizationID:  newRental.OrganizationID,
+		DriverID:        newRental.DriverID,
+		SecurityDeposit: toSecurityDepositResp(deposit),
 	}
 
 	return c.JSON(http.StatusCreated, resp)
 }
 
+// how the security deposit of a booking is paid, empty without a deposit
+func depositMailNote(deposit *model.SecurityDeposit) string {
+	if deposit == nil {
+		return ""
+	}
+	if deposit.Method == model.DepositMethodWallet {
+		return fmt.Sprintf("<p>A security deposit of IDR %.0f is held from your wallet until after the return.</p>", deposit.Amount)
+	}
+	return fmt.Sprintf("<p>Please pay the security deposit of IDR %.0f before pickup, it is refunded to your wallet after the return:<br>%s</p>",
+		deposit.Amount, deposit.Payment.PaymentUrl)
+}
+
+// reserves a unit for a confirmed rental, staff can still pick one at pickup
+func (rh *RentalHandler) assignUnit(rental *model.Rental, logger echo.Logger) {
+	err := rh.db.Transaction(func(tx *gorm.DB) error {
+		return rh.cs.AssignUnit(tx, rental, nil)
+	})
+	if err != nil {
+		logger.Errorf("failed to assign unit to rental %d: %s", rental.ID, err.Error())
+		flagErr := rh.cs.FlagUnassigned(rental.ID, err)
+		if flagErr != nil {
+			logger.Errorf("failed to flag rental %d: %s", rental.ID, flagErr.Error())
+		}
+	}
+}
+
+// the booking on an organization account needs no payment from the booker
+func (rh *RentalHandler) sendOrgBookingMail(user *model.User, car *model.Car, rental *model.Rental, logger echo.Logger) {
+	err := rh.ns.SendMail(
+		user.ID,
+		user.Email,
+		"You've made a booking!",
+		fmt.Sprintf(`
+		<h1>Hello %s,</h1><br>
+		<p>Thank you for making a booking on your organization account, here are the details:</p>
+		<p>Car: %s<br>
+		Start: %s<br>
+		End: %s<br>
+		Add ons: %s<br>
+		Total Price: IDR %.0f<br>
+		</p>
+		<p>The rental is confirmed and will be billed with your organization's monthly invoice.</p>
+		%s
+		`, user.Name,
+			car.GetCarName(),
+			rental.StartDate.Format(rentalTimeFormat),
+			rental.EndDate.Format(rentalTimeFormat),
+			addOnsSummaryOrNone(rental.AddOns),
+			rental.TotalPrice,
+			depositMailNote(rental.SecurityDeposit)),
+		logger,
+	)
+	if err != nil {
+		logger.Errorf("failed to send email notif: %s", err.Error())
+	}
+}
+
 type RentalRespItem struct {
-	RentalID      uint   `json:"rental_id"`
-	StartDate     string `json:"start_date"`
-	EndDate       string `json:"end_date"`
-	CarID         uint   `json:"car_id"`
-	Car           string `json:"car"`
-	PaymentID     uint   `json:"payment_id"`
-	PaymentStatus string `json:"payment_status"`
-	PaymentUrl    string `json:"payment_url,omitempty"`
+	RentalID      uint              `json:"rental_id"`
+	StartDate     string            `json:"start_date"`
+	EndDate       string            `json:"end_date"`
+	CarID         uint              `json:"car_id"`
+	Car           string            `json:"car"`
+	PaymentID     uint              `json:"payment_id"`
+	PaymentStatus string            `json:"payment_status"`
+	PaymentUrl    string            `json:"payment_url,omitempty"`
+	PlateNumber   string            `json:"plate_number,omitempty"`
+	PickupBranch  string            `json:"pickup_branch,omitempty"`
+	DropoffBranch string            `json:"dropoff_branch,omitempty"`
+	TotalPrice    float64           `json:"total_price"`
+	PriceItems    []PriceLineResp   `json:"price_items"`
+	AddOns        []RentalAddOnResp `json:"add_ons"`
+	// nil when no deposit was required
+	SecurityDeposit *SecurityDepositResp `json:"security_deposit,omitempty"`
 }
 
+func toRentalRespItem(r *model.Rental) RentalRespItem {
+	ri := RentalRespItem{
+		RentalID:        r.ID,
+		StartDate:       r.StartDate.Format(rentalTimeFormat),
+		EndDate:         r.EndDate.Format(rentalTimeFormat),
+		CarID:           r.Car.ID,
+		Car:             r.Car.GetCarName(),
+		PaymentID:       r.Payment.ID,
+		PaymentStatus:   r.Payment.Status,
+		TotalPrice:      r.TotalPrice,
+		PriceItems:      toPriceItemsResp(r.PriceItems),
+		AddOns:          toRentalAddOnsResp(r.AddOns),
+		SecurityDeposit: toSecurityDepositResp(r.SecurityDeposit),
+	}
+	if r.Payment.Status == "Unpaid" {
+		ri.PaymentUrl = r.Payment.PaymentUrl
+	}
+	if r.VehicleUnit != nil {
+		ri.PlateNumber = r.VehicleUnit.PlateNumber
+	}
+	if r.PickupBranch != nil {
+		ri.PickupBranch = r.PickupBranch.Name
+	}
+	if r.DropoffBranch != nil {
+		ri.DropoffBranch = r.DropoffBranch.Name
+	}
+	return ri
+}
+
+// @Summary	Rentals of the user, cancelled ones included
+// @Tags		rentals
+// @Produce	json
+// @Success	200	{array}		handler.RentalRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals [get]
 func (rh *RentalHandler) HandleGetRentals(c echo.Context) error {
 	// get user from context
 	user, err := util.GetUserFromContext(c, rh.db)
@@ -222,27 +861,268 @@ func (rh *RentalHandler) HandleGetRentals(c echo.Context) error {
 
 	// get rentals for user
 	var rentals []model.Rental
-	err = rh.db.Preload("Car").Preload("Payment").Where("user_id=?", user.ID).Find(&rentals).Error
+	err = rh.db.Preload("Car").Preload("Payment").Preload("VehicleUnit").
+		Preload("PickupBranch").Preload("DropoffBranch").Preload("PriceItems").Preload("AddOns.AddOn").
+		Preload("SecurityDeposit.Payment").
+		Where("user_id=?", user.ID).Find(&rentals).Error
 	if err != nil {
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
 
 	resp := []RentalRespItem{}
 	for _, r := range rentals {
-		ri := RentalRespItem{
-			RentalID:      r.ID,
-			StartDate:     r.StartDate.Format(time.DateOnly),
-			EndDate:       r.EndDate.Format(time.DateOnly),
-			CarID:         r.Car.ID,
-			Car:           r.Car.GetCarName(),
-			PaymentID:     r.Payment.ID,
-			PaymentStatus: r.Payment.Status,
+		resp = append(resp, toRentalRespItem(&r))
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+type UnassignedRentalRespItem struct {
+	RentalRespItem
+	UserID uint `json:"user_id"`
+	// why no unit could be assigned when the rental was confirmed
+	UnitAssignmentError string `json:"unit_assignment_error"`
+}
+
+// @Summary	Confirmed rentals no unit could be assigned to, for staff to sort out before pickup
+// @Tags		rentals
+// @Produce	json
+// @Success	200	{array}		handler.UnassignedRentalRespItem
+// @Failure	401	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/unassigned [get]
+func (rh *RentalHandler) HandleGetUnassignedRentals(c echo.Context) error {
+	rentals, err := rh.cs.GetUnassignedRentals()
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp := []UnassignedRentalRespItem{}
+	for _, r := range rentals {
+		resp = append(resp, UnassignedRentalRespItem{
+			RentalRespItem:      toRentalRespItem(&r),
+			UserID:              r.UserID,
+			UnitAssignmentError: r.UnitAssignmentError,
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+type PickupRentalReq struct {
+	VehicleUnitID *uint `json:"vehicle_unit_id"`
+}
+
+type PickupRentalResp struct {
+	RentalID      uint      `json:"rental_id"`
+	VehicleUnitID uint      `json:"vehicle_unit_id"`
+	PlateNumber   string    `json:"plate_number"`
+	PickedUpAt    time.Time `json:"picked_up_at"`
+}
+
+// @Summary	Hands over a paid rental's car to the customer
+// @Tags		rentals
+// @Accept		json
+// @Param		id			path	int						true	"Rental id"
+// @Param		PickupData	body	handler.PickupRentalReq	false	"Unit to hand over instead of the assigned one"
+// @Produce	json
+// @Success	200	{object}	handler.PickupRentalResp
+// @Failure	400	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/pickup [post]
+// staff hands over a car, optionally choosing a different unit than the one assigned
+func (rh *RentalHandler) HandlePickupRental(c echo.Context) error {
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var reqBody PickupRentalReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+
+	var rental model.Rental
+	err = rh.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("Payment").Where("id=?", rentalID).First(&rental).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return util.NewAppError(http.StatusNotFound, "rental not found", "")
+		} else if err != nil {
+			return err
 		}
-		if r.Payment.Status == "Unpaid" {
-			ri.PaymentUrl = r.Payment.PaymentUrl
+		if !service.IsRentalConfirmed(rental.Payment.Status) {
+			return util.NewAppError(http.StatusBadRequest, "rental is not paid", "")
 		}
-		resp = append(resp, ri)
+		if rental.PickedUpAt != nil {
+			return util.NewAppError(http.StatusBadRequest, "rental already picked up", "")
+		}
+		// keys are only handed to drivers with a valid license on file
+		driverID := rental.UserID
+		if rental.DriverID != nil {
+			driverID = *rental.DriverID
+		}
+		err = rh.dls.CheckValid(tx, driverID, rental.EndDate)
+		if err != nil && errors.Is(err, service.ErrNoValidLicense) {
+			return util.NewAppError(http.StatusForbidden, "customer has no approved driver license valid until the end of the rental", "")
+		} else if err != nil {
+			return err
+		}
+		err = rh.sds.CheckHeld(tx, rental.ID)
+		if err != nil && errors.Is(err, service.ErrDepositNotHeld) {
+			return util.NewAppError(http.StatusBadRequest, "security deposit is not paid", "")
+		} else if err != nil {
+			return err
+		}
+
+		err = rh.cs.AssignUnit(tx, &rental, reqBody.VehicleUnitID)
+		if err != nil && errors.Is(err, service.ErrNoUnitAvailable) {
+			return util.NewAppError(http.StatusConflict, "no unit available for rental", "")
+		} else if err != nil && errors.Is(err, service.ErrUnitNotFree) {
+			return util.NewAppError(http.StatusConflict, "unit is not free for rental", "")
+		} else if err != nil {
+			return err
+		}
+		if rental.VehicleUnit == nil {
+			rental.VehicleUnit = &model.VehicleUnit{}
+			err = tx.Where("id=?", *rental.VehicleUnitID).First(rental.VehicleUnit).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		rental.PickedUpAt = &now
+		return tx.Model(&rental).Update("picked_up_at", now).Error
+	})
+	var appErr *util.AppError
+	if err != nil && errors.As(err, &appErr) {
+		return err
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
 
+	return c.JSON(http.StatusOK, PickupRentalResp{
+		RentalID:      rental.ID,
+		VehicleUnitID: rental.VehicleUnit.ID,
+		PlateNumber:   rental.VehicleUnit.PlateNumber,
+		PickedUpAt:    *rental.PickedUpAt,
+	})
+}
+
+type ReturnRentalResp struct {
+	RentalID      uint      `json:"rental_id"`
+	VehicleUnitID uint      `json:"vehicle_unit_id"`
+	ReturnedAt    time.Time `json:"returned_at"`
+}
+
+// @Summary	Takes back the car of a picked up rental
+// @Tags		rentals
+// @Param		id	path	int	true	"Rental id"
+// @Produce	json
+// @Success	200	{object}	handler.ReturnRentalResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/return [post]
+// staff takes back a car, the unit is now at the drop off branch
+func (rh *RentalHandler) HandleReturnRental(c echo.Context) error {
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var rental model.Rental
+	err = rh.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id=?", rentalID).First(&rental).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return util.NewAppError(http.StatusNotFound, "rental not found", "")
+		} else if err != nil {
+			return err
+		}
+		if rental.PickedUpAt == nil || rental.VehicleUnitID == nil {
+			return util.NewAppError(http.StatusBadRequest, "rental not picked up yet", "")
+		}
+		if rental.ReturnedAt != nil {
+			return util.NewAppError(http.StatusBadRequest, "rental already returned", "")
+		}
+
+		now := time.Now()
+		rental.ReturnedAt = &now
+		err = tx.Model(&rental).Update("returned_at", now).Error
+		if err != nil {
+			return err
+		}
+		if rental.DropoffBranchID != nil {
+			err = tx.Model(&model.VehicleUnit{}).
+				Where("id=?", *rental.VehicleUnitID).
+				Update("branch_id", *rental.DropoffBranchID).Error
+		}
+		return err
+	})
+	var appErr *util.AppError
+	if err != nil && errors.As(err, &appErr) {
+		return err
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, ReturnRentalResp{
+		RentalID:      rental.ID,
+		VehicleUnitID: *rental.VehicleUnitID,
+		ReturnedAt:    *rental.ReturnedAt,
+	})
+}
+
+type CancelRentalResp struct {
+	RentalID      uint    `json:"rental_id"`
+	PaymentStatus string  `json:"payment_status"`
+	Refunded      float64 `json:"refunded"`
+}
+
+// @Summary	Cancels a rental before it starts, a paid rental is refunded to the wallet
+// @Tags		rentals
+// @Param		id	path	int	true	"Rental id"
+// @Produce	json
+// @Success	200	{object}	handler.CancelRentalResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/cancel [post]
+// the user cancels a rental before pickup, the unit goes to the waitlist
+func (rh *RentalHandler) HandleCancelRental(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, rh.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	rental, err := rh.rs.Cancel(user.ID, rentalID, c.Logger())
+	if err != nil && errors.Is(err, service.ErrRentalNotFound) {
+		return util.NewAppError(http.StatusNotFound, "rental not found", "")
+	} else if err != nil && errors.Is(err, service.ErrRentalStarted) {
+		return util.NewAppError(http.StatusBadRequest, "rental already started", "")
+	} else if err != nil && errors.Is(err, service.ErrRentalCancelled) {
+		return util.NewAppError(http.StatusBadRequest, "rental already cancelled", "")
+	} else if err != nil && errors.Is(err, service.ErrRentalPaymentPending) {
+		return util.NewAppError(http.StatusConflict, "rental was paid, cancel again once the payment is confirmed to be refunded", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp := CancelRentalResp{
+		RentalID:      rental.ID,
+		PaymentStatus: rental.Payment.Status,
+	}
+	if rental.Payment.Status == service.PaymentStatusRefunded {
+		resp.Refunded = rental.Payment.TotalPayment
+	}
 	return c.JSON(http.StatusOK, resp)
 }
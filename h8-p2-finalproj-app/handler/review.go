@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type ReviewHandler struct {
+	db *gorm.DB
+	rs *service.ReviewService
+}
+
+func NewReviewHandler(db *gorm.DB, rs *service.ReviewService) ReviewHandler {
+	return ReviewHandler{
+		db: db,
+		rs: rs,
+	}
+}
+
+// photos can only be sent as multipart form data, in the photos field
+type PostReviewReq struct {
+	Rating int    `json:"rating" form:"rating"`
+	Text   string `json:"text" form:"text"`
+}
+
+type HideReviewReq struct {
+	Reason string `json:"reason"`
+}
+
+type ReviewPhotoResp struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+}
+
+type ReviewRespItem struct {
+	ReviewID  uint              `json:"review_id"`
+	RentalID  uint              `json:"rental_id"`
+	CarID     uint              `json:"car_id"`
+	User      string            `json:"user"`
+	Rating    int               `json:"rating"`
+	Text      string            `json:"text"`
+	Photos    []ReviewPhotoResp `json:"photos"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// with the moderation status, for staff
+type ModeratedReviewRespItem struct {
+	ReviewRespItem
+	Hidden       bool       `json:"hidden"`
+	HiddenReason string     `json:"hidden_reason,omitempty"`
+	HiddenAt     *time.Time `json:"hidden_at,omitempty"`
+}
+
+func toReviewRespItem(r *model.Review) ReviewRespItem {
+	resp := ReviewRespItem{
+		ReviewID:  r.ID,
+		RentalID:  r.RentalID,
+		CarID:     r.CarID,
+		User:      r.User.Name,
+		Rating:    r.Rating,
+		Text:      r.Text,
+		Photos:    []ReviewPhotoResp{},
+		CreatedAt: r.CreatedAt,
+	}
+	for _, p := range r.Photos {
+		resp.Photos = append(resp.Photos, ReviewPhotoResp{
+			URL:          p.URL,
+			ThumbnailURL: p.ThumbnailURL,
+		})
+	}
+	return resp
+}
+
+func toModeratedReviewRespItem(r *model.Review) ModeratedReviewRespItem {
+	return ModeratedReviewRespItem{
+		ReviewRespItem: toReviewRespItem(r),
+		Hidden:         r.Hidden,
+		HiddenReason:   r.HiddenReason,
+		HiddenAt:       r.HiddenAt,
+	}
+}
+
+func reviewAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrRentalNotFound):
+		return util.NewAppError(http.StatusNotFound, "rental not found", "")
+	case errors.Is(err, service.ErrReviewNotFound):
+		return util.NewAppError(http.StatusNotFound, "review not found", "")
+	case errors.Is(err, service.ErrRentalNotCompleted):
+		return util.NewAppError(http.StatusBadRequest, "only completed rentals can be reviewed", "")
+	case errors.Is(err, service.ErrReviewExists):
+		return util.NewAppError(http.StatusConflict, "rental already reviewed", "")
+	case errors.Is(err, service.ErrInvalidRating):
+		return util.NewAppError(http.StatusBadRequest, "rating must be from 1 to 5", "")
+	case errors.Is(err, service.ErrReviewTooLong):
+		return util.NewAppError(http.StatusBadRequest, "review text is too long", "")
+	case errors.Is(err, service.ErrTooManyPhotos):
+		return util.NewAppError(http.StatusBadRequest, "too many photos", "")
+	case errors.Is(err, service.ErrImageTooLarge), errors.Is(err, service.ErrImageType):
+		return carImageAppError(err)
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// @Summary	Reviews a paid and returned rental, as json or multipart form data with photos
+// @Tags		reviews
+// @Accept		json
+// @Accept		multipart/form-data
+// @Param		id		path		int		true	"Rental id"
+// @Param		rating	formData	int		true	"Rating from 1 to 5"
+// @Param		text	formData	string	false	"Review text"
+// @Param		photos	formData	file	false	"Up to 5 jpeg or png photos"
+// @Produce	json
+// @Success	201	{object}	handler.ReviewRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/review [post]
+// reviews a completed rental of the user, as json or multipart form data with photos
+func (rvh *ReviewHandler) HandlePostReview(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, rvh.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var reqBody PostReviewReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	req := service.ReviewReq{
+		Rating: reqBody.Rating,
+		Text:   reqBody.Text,
+	}
+
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+		}
+		files := form.File["photos"]
+		if len(files) > service.MaxReviewPhotos {
+			return reviewAppError(service.ErrTooManyPhotos)
+		}
+		for _, f := range files {
+			if f.Size > service.MaxCarImageSize {
+				return reviewAppError(service.ErrImageTooLarge)
+			}
+			src, err := f.Open()
+			if err != nil {
+				return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+			}
+			defer src.Close()
+			req.Photos = append(req.Photos, io.Reader(src))
+		}
+	}
+
+	review, err := rvh.rs.Create(user, rentalID, &req)
+	if err != nil {
+		return reviewAppError(err)
+	}
+	return c.JSON(http.StatusCreated, toReviewRespItem(review))
+}
+
+// @Summary	Visible reviews of a car, newest first
+// @Tags		reviews
+// @Param		id	path	int	true	"Car id"
+// @Produce	json
+// @Success	200	{array}		handler.ReviewRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/reviews [get]
+func (rvh *ReviewHandler) HandleGetCarReviews(c echo.Context) error {
+	car, err := findCarFromParam(c, rvh.db)
+	if err != nil {
+		return err
+	}
+	reviews, err := rvh.rs.GetCarReviews(car.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []ReviewRespItem{}
+	for i := range reviews {
+		resp = append(resp, toReviewRespItem(&reviews[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Reviews for moderation
+// @Tags		reviews
+// @Param		hidden	query	bool	false	"Only hidden or only visible reviews"
+// @Produce	json
+// @Success	200	{array}		handler.ModeratedReviewRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/reviews [get]
+// reviews for moderation, filtered with hidden=true or hidden=false
+func (rvh *ReviewHandler) HandleGetReviews(c echo.Context) error {
+	var hidden *bool
+	if h := c.QueryParam("hidden"); h != "" {
+		parsed, err := strconv.ParseBool(h)
+		if err != nil {
+			return util.NewAppError(http.StatusBadRequest, "invalid hidden filter", "")
+		}
+		hidden = &parsed
+	}
+	reviews, err := rvh.rs.GetReviews(hidden)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	resp := []ModeratedReviewRespItem{}
+	for i := range reviews {
+		resp = append(resp, toModeratedReviewRespItem(&reviews[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (rvh *ReviewHandler) setHidden(c echo.Context, hidden bool) error {
+	staff, err := util.GetUserFromContext(c, rvh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody HideReviewReq
+	if hidden {
+		err = c.Bind(&reqBody)
+		if err != nil {
+			return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+		}
+		if strings.TrimSpace(reqBody.Reason) == "" {
+			return util.NewAppError(http.StatusBadRequest, "reason cannot be empty", "")
+		}
+	}
+	review, err := rvh.rs.SetHidden(id, hidden, reqBody.Reason, staff)
+	if err != nil {
+		return reviewAppError(err)
+	}
+	return c.JSON(http.StatusOK, toModeratedReviewRespItem(review))
+}
+
+// @Summary	Hides an abusive review with a reason
+// @Tags		reviews
+// @Accept		json
+// @Param		id			path	int						true	"Review id"
+// @Param		ReasonData	body	handler.HideReviewReq	true	"Reason"
+// @Produce	json
+// @Success	200	{object}	handler.ModeratedReviewRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/reviews/{id}/hide [post]
+// hides an abusive review, it no longer counts towards the car's rating
+func (rvh *ReviewHandler) HandleHideReview(c echo.Context) error {
+	return rvh.setHidden(c, true)
+}
+
+// @Summary	Shows a hidden review again
+// @Tags		reviews
+// @Param		id	path	int	true	"Review id"
+// @Produce	json
+// @Success	200	{object}	handler.ModeratedReviewRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/reviews/{id}/unhide [post]
+func (rvh *ReviewHandler) HandleUnhideReview(c echo.Context) error {
+	return rvh.setHidden(c, false)
+}
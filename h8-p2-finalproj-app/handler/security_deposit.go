@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type SecurityDepositHandler struct {
+	db  *gorm.DB
+	sds *service.SecurityDepositService
+}
+
+func NewSecurityDepositHandler(db *gorm.DB, sds *service.SecurityDepositService) SecurityDepositHandler {
+	return SecurityDepositHandler{
+		db:  db,
+		sds: sds,
+	}
+}
+
+type DepositRateReq struct {
+	CarType string  `json:"car_type"`
+	Amount  float64 `json:"amount"`
+}
+
+type DepositRateRespItem struct {
+	RateID  uint    `json:"rate_id"`
+	CarType string  `json:"car_type"`
+	Amount  float64 `json:"amount"`
+}
+
+type CaptureDepositReq struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type SecurityDepositResp struct {
+	RentalID       uint       `json:"rental_id"`
+	Amount         float64    `json:"amount"`
+	Method         string     `json:"method"`
+	Status         string     `json:"status"`
+	PaymentID      *uint      `json:"payment_id,omitempty"`
+	PaymentUrl     string     `json:"payment_url,omitempty"`
+	CapturedAmount float64    `json:"captured_amount,omitempty"`
+	CaptureReason  string     `json:"capture_reason,omitempty"`
+	SettledAt      *time.Time `json:"settled_at,omitempty"`
+}
+
+func toSecurityDepositResp(d *model.SecurityDeposit) *SecurityDepositResp {
+	if d == nil {
+		return nil
+	}
+	resp := SecurityDepositResp{
+		RentalID:       d.RentalID,
+		Amount:         d.Amount,
+		Method:         d.Method,
+		Status:         d.Status,
+		CapturedAmount: d.CapturedAmount,
+		CaptureReason:  d.CaptureReason,
+		SettledAt:      d.SettledAt,
+	}
+	if d.Payment != nil {
+		resp.PaymentID = &d.Payment.ID
+		if d.Status == model.DepositPending {
+			resp.PaymentUrl = d.Payment.PaymentUrl
+		}
+	}
+	return &resp
+}
+
+func depositAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrDepositNotFound):
+		return util.NewAppError(http.StatusNotFound, "security deposit not found", "")
+	case errors.Is(err, service.ErrDepositRateNotFound):
+		return util.NewAppError(http.StatusNotFound, "deposit rate not found", "")
+	case errors.Is(err, service.ErrInvalidDepositRate):
+		return util.NewAppError(http.StatusBadRequest, "car type is required and amount cannot be negative", "")
+	case errors.Is(err, service.ErrInvalidDepositMethod):
+		return util.NewAppError(http.StatusBadRequest, "deposit method must be wallet or invoice", "")
+	case errors.Is(err, service.ErrInsufficientWallet):
+		return util.NewAppError(http.StatusBadRequest, "wallet balance too low for the security deposit, top up or pay it by invoice", "")
+	case errors.Is(err, service.ErrDepositNotHeld):
+		return util.NewAppError(http.StatusConflict, "security deposit is not held", "")
+	case errors.Is(err, service.ErrDepositNotPayable):
+		return util.NewAppError(http.StatusConflict, "security deposit is already paid or settled", "")
+	case errors.Is(err, service.ErrInvalidCapture):
+		return util.NewAppError(http.StatusBadRequest, "captured amount must be above 0 and at most the deposit", "")
+	case errors.Is(err, service.ErrCaptureReasonNeeded):
+		return util.NewAppError(http.StatusBadRequest, "reason cannot be empty", "")
+	case errors.Is(err, service.ErrInvoicePaid):
+		return util.NewAppError(http.StatusConflict, "security deposit invoice is already paid", "")
+	case errors.Is(err, service.ErrRentalNotPickedUp):
+		return util.NewAppError(http.StatusBadRequest, "rental not picked up yet", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// @Summary	Security deposit per car type
+// @Tags		security deposits
+// @Produce	json
+// @Success	200	{array}		handler.DepositRateRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/deposit-rates [get]
+func (sdh *SecurityDepositHandler) HandleGetRates(c echo.Context) error {
+	rates, err := sdh.sds.GetRates()
+	if err != nil {
+		return depositAppError(err)
+	}
+	resp := []DepositRateRespItem{}
+	for _, r := range rates {
+		resp = append(resp, DepositRateRespItem{
+			RateID:  r.ID,
+			CarType: r.CarType,
+			Amount:  r.Amount,
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Sets the security deposit of a car type
+// @Tags		security deposits
+// @Accept		json
+// @Param		RateData	body	handler.DepositRateReq	true	"Car type and amount, 0 for no deposit"
+// @Produce	json
+// @Success	200	{object}	handler.DepositRateRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/deposit-rates [put]
+// sets the deposit of a car type, 0 for no deposit
+func (sdh *SecurityDepositHandler) HandlePutRate(c echo.Context) error {
+	var reqBody DepositRateReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	rate, err := sdh.sds.SetRate(reqBody.CarType, reqBody.Amount)
+	if err != nil {
+		return depositAppError(err)
+	}
+	return c.JSON(http.StatusOK, DepositRateRespItem{
+		RateID:  rate.ID,
+		CarType: rate.CarType,
+		Amount:  rate.Amount,
+	})
+}
+
+// @Summary	Deletes the security deposit of a car type
+// @Tags		security deposits
+// @Param		id	path	int	true	"Deposit rate id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/deposit-rates/{id} [delete]
+func (sdh *SecurityDepositHandler) HandleDeleteRate(c echo.Context) error {
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	err = sdh.sds.DeleteRate(id)
+	if err != nil {
+		return depositAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "deposit rate deleted",
+	})
+}
+
+// @Summary	Pays the security deposit of a rental from the wallet, its invoice is expired
+// @Tags		security deposits
+// @Param		id	path	int	true	"Rental id"
+// @Produce	json
+// @Success	200	{object}	handler.SecurityDepositResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/deposit/pay [post]
+// pays the deposit from the wallet instead of its invoice
+func (sdh *SecurityDepositHandler) HandlePayDeposit(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, sdh.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	deposit, err := sdh.sds.PayFromWallet(user.ID, rentalID)
+	if err != nil {
+		return depositAppError(err)
+	}
+	return c.JSON(http.StatusOK, toSecurityDepositResp(deposit))
+}
+
+// @Summary	Keeps part or all of a held security deposit, the rest goes back to the wallet
+// @Tags		security deposits
+// @Accept		json
+// @Param		id			path	int							true	"Rental id"
+// @Param		CaptureData	body	handler.CaptureDepositReq	true	"Amount kept and reason"
+// @Produce	json
+// @Success	200	{object}	handler.SecurityDepositResp
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/rentals/{id}/deposit/capture [post]
+// staff keep part or all of the deposit for damages, the rest is released
+func (sdh *SecurityDepositHandler) HandleCaptureDeposit(c echo.Context) error {
+	staff, err := util.GetUserFromContext(c, sdh.db)
+	if err != nil {
+		return err
+	}
+	rentalID, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	var reqBody CaptureDepositReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	deposit, err := sdh.sds.Capture(rentalID, staff.ID, reqBody.Amount, reqBody.Reason, c.Logger())
+	if err != nil {
+		return depositAppError(err)
+	}
+	return c.JSON(http.StatusOK, toSecurityDepositResp(deposit))
+}
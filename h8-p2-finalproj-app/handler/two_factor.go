@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type TwoFactorHandler struct {
+	db  *gorm.DB
+	tfs *service.TwoFactorService
+	ts  *service.TokenService
+}
+
+func NewTwoFactorHandler(
+	db *gorm.DB,
+	tfs *service.TwoFactorService,
+	ts *service.TokenService) TwoFactorHandler {
+	return TwoFactorHandler{
+		db:  db,
+		tfs: tfs,
+		ts:  ts,
+	}
+}
+
+type TwoFactorCodeReq struct {
+	Code string `json:"code"`
+}
+
+type LoginTwoFactorReq struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorEnrollResp struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type RecoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// maps two factor service errors to app errors
+func twoFactorAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidTwoFactorCode):
+		return util.NewAppError(http.StatusBadRequest, "invalid two factor code", "")
+	case errors.Is(err, service.ErrTwoFactorAlreadyEnabled):
+		return util.NewAppError(http.StatusBadRequest, "two factor authentication already enabled", "")
+	case errors.Is(err, service.ErrTwoFactorNotEnabled):
+		return util.NewAppError(http.StatusBadRequest, "two factor authentication not enabled", "")
+	case errors.Is(err, service.ErrTwoFactorNotEnrolled):
+		return util.NewAppError(http.StatusBadRequest, "two factor enrollment not started", "")
+	case errors.Is(err, service.ErrTwoFactorRequired):
+		return util.NewAppError(http.StatusForbidden, "two factor authentication is required for this account", "")
+	case errors.Is(err, service.ErrInvalidLoginChallenge):
+		return util.NewAppError(http.StatusUnauthorized, "invalid or expired login challenge", "")
+	default:
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+}
+
+func (th *TwoFactorHandler) bindCode(c echo.Context) (string, error) {
+	var reqBody TwoFactorCodeReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return "", util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.Code == "" {
+		return "", util.NewAppError(http.StatusBadRequest, "code cannot be empty", "")
+	}
+	return reqBody.Code, nil
+}
+
+// @Summary	Second login step, completes login with a totp or recovery code
+// @Tags		users
+// @Accept		json
+// @Param		Challenge	body	handler.LoginTwoFactorReq	true	"Challenge token from login and code"
+// @Produce	json
+// @Success	200	{object}	handler.LoginRespData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/login/2fa [post]
+func (th *TwoFactorHandler) HandleLoginTwoFactor(c echo.Context) error {
+	var reqBody LoginTwoFactorReq
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.ChallengeToken == "" || reqBody.Code == "" {
+		return util.NewAppError(http.StatusBadRequest, "challenge token and code cannot be empty", "")
+	}
+
+	user, err := th.tfs.VerifyLoginChallenge(reqBody.ChallengeToken, reqBody.Code)
+	if err != nil {
+		return twoFactorAppError(err)
+	}
+
+	tokens, err := th.ts.IssueTokens(user, true)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, LoginRespData{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	})
+}
+
+// @Summary	Start 2fa enrollment, returns the secret and the uri for the QR code
+// @Tags		users
+// @Produce	json
+// @Success	200	{object}	handler.TwoFactorEnrollResp
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/2fa/enroll [post]
+func (th *TwoFactorHandler) HandleEnrollTwoFactor(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, th.db)
+	if err != nil {
+		return err
+	}
+
+	secret, uri, err := th.tfs.BeginEnrollment(user)
+	if err != nil {
+		return twoFactorAppError(err)
+	}
+
+	return c.JSON(http.StatusOK, TwoFactorEnrollResp{
+		Secret:          secret,
+		ProvisioningURI: uri,
+	})
+}
+
+// @Summary	Verify 2fa enrollment with a code from the authenticator, returns recovery codes
+// @Tags		users
+// @Accept		json
+// @Param		Code	body	handler.TwoFactorCodeReq	true	"TOTP code"
+// @Produce	json
+// @Success	200	{object}	handler.RecoveryCodesResp
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/2fa/verify [post]
+func (th *TwoFactorHandler) HandleVerifyTwoFactor(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, th.db)
+	if err != nil {
+		return err
+	}
+	code, err := th.bindCode(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := th.tfs.ConfirmEnrollment(user, code)
+	if err != nil {
+		return twoFactorAppError(err)
+	}
+
+	return c.JSON(http.StatusOK, RecoveryCodesResp{
+		RecoveryCodes: codes,
+	})
+}
+
+// @Summary	Disable 2fa
+// @Tags		users
+// @Accept		json
+// @Param		Code	body	handler.TwoFactorCodeReq	true	"TOTP or recovery code"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	403	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/2fa/disable [post]
+func (th *TwoFactorHandler) HandleDisableTwoFactor(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, th.db)
+	if err != nil {
+		return err
+	}
+	code, err := th.bindCode(c)
+	if err != nil {
+		return err
+	}
+
+	err = th.tfs.Disable(user, code)
+	if err != nil {
+		return twoFactorAppError(err)
+	}
+
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "Two factor authentication disabled",
+	})
+}
+
+// @Summary	Regenerate 2fa recovery codes, the old ones stop working
+// @Tags		users
+// @Accept		json
+// @Param		Code	body	handler.TwoFactorCodeReq	true	"TOTP or recovery code"
+// @Produce	json
+// @Success	200	{object}	handler.RecoveryCodesResp
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/2fa/recovery-codes [post]
+func (th *TwoFactorHandler) HandleRegenerateRecoveryCodes(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, th.db)
+	if err != nil {
+		return err
+	}
+	code, err := th.bindCode(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := th.tfs.RegenerateRecoveryCodes(user, code)
+	if err != nil {
+		return twoFactorAppError(err)
+	}
+
+	return c.JSON(http.StatusOK, RecoveryCodesResp{
+		RecoveryCodes: codes,
+	})
+}
@@ -3,33 +3,58 @@ package handler
 import (
 	"errors"
 	"fmt"
-	"h8-p2-finalproj-app/auth"
 	"h8-p2-finalproj-app/model"
 	"h8-p2-finalproj-app/service"
 	"h8-p2-finalproj-app/util"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	db *gorm.DB
-	is *service.InvoiceService
+	db  *gorm.DB
+	is  *service.InvoiceService
+	ts  *service.TokenService
+	lg  *service.LoginGuardService
+	tfs *service.TwoFactorService
+	as  *service.AccountService
+	ns  *service.NotificationService
+	dls *service.DriverLicenseService
 }
 
-func NewUserHandler(db *gorm.DB, is *service.InvoiceService) UserHandler {
+func NewUserHandler(
+	db *gorm.DB,
+	is *service.InvoiceService,
+	ts *service.TokenService,
+	lg *service.LoginGuardService,
+	tfs *service.TwoFactorService,
+	as *service.AccountService,
+	ns *service.NotificationService,
+	dls *service.DriverLicenseService) UserHandler {
 	return UserHandler{
-		db: db,
-		is: is,
+		db:  db,
+		is:  is,
+		ts:  ts,
+		lg:  lg,
+		tfs: tfs,
+		as:  as,
+		ns:  ns,
+		dls: dls,
 	}
 }
 
+// hash compared against when the email is not registered
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), 12)
+	return hash
+})
+
 type RegisterReqData struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
@@ -48,7 +73,42 @@ type LoginReqData struct {
 }
 
 type LoginRespData struct {
-	Token string `json:"token"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type LoginChallengeRespData struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+}
+
+type RefreshReqData struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (uh *UserHandler) validateEmailAvailable(email string) error {
+	var count int64
+	err := uh.db.Model(&model.User{}).Where("email=?", email).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		// email already registered
+		return util.NewAppError(http.StatusBadRequest, "email already registered", "")
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	// check pass cannot be empty and less than 4 chars
+	if password == "" {
+		return util.NewAppError(http.StatusBadRequest, "password cannot be empty", "")
+	}
+	if len(password) < 4 {
+		return util.NewAppError(http.StatusBadRequest, "password must be at least 4 characters", "")
+	}
+	return nil
 }
 
 func (uh *UserHandler) validateRegisterUserData(ud *RegisterReqData) error {
@@ -63,22 +123,14 @@ func (uh *UserHandler) validateRegisterUserData(ud *RegisterReqData) error {
 		return util.NewAppError(http.StatusBadRequest, "email cannot be empty", "")
 	}
 	// check dupe
-	var count int64
-	err := uh.db.Model(&model.User{}).Where("email=?", ud.Email).Count(&count).Error
+	err := uh.validateEmailAvailable(ud.Email)
 	if err != nil {
-		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
-	}
-	if count != 0 {
-		// email already registered
-		return util.NewAppError(http.StatusBadRequest, "email already registered", "")
+		return err
 	}
 
-	// check pass cannot be empty and less than 4 chars
-	if ud.Password == "" {
-		return util.NewAppError(http.StatusBadRequest, "password cannot be empty", "")
-	}
-	if len(ud.Password) < 4 {
-		return util.NewAppError(http.StatusBadRequest, "password must be at least 4 characters", "")
+	err = validatePassword(ud.Password)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -116,6 +168,7 @@ func (uh *UserHandler) HandleRegisterUser(c echo.Context) error {
 	newUser := model.User{
 		Name:     userData.Name,
 		Email:    userData.Email,
+		Role:     model.RoleUser,
 		Password: string(passHash),
 	}
 	err = uh.db.Create(&newUser).Error
@@ -130,7 +183,7 @@ func (uh *UserHandler) HandleRegisterUser(c echo.Context) error {
 		Email: newUser.Email,
 	}
 
-	err = service.SendMail(userData.Email,
+	err = uh.ns.SendMail(newUser.ID, userData.Email,
 		"Welcome to car rental app!",
 		fmt.Sprintf("<h1>Hello %s, thank you for registering with us!</h1>",
 			newUser.Name),
@@ -162,8 +215,9 @@ func (uh *UserHandler) validateLoginData(ud *RegisterReqData) error {
 // @Param		EmailPassword	body	handler.LoginReqData	true	"Email and password"
 // @Produce	json
 // @Success	200	{object}	handler.LoginRespData
-// @Failure	404	{object}	util.AppError
-// @Failure	400	{object}	util.AppError
+// @Success	202	{object}	handler.LoginChallengeRespData
+// @Failure	401	{object}	util.AppError
+// @Failure	429	{object}	util.AppError
 // @Failure	500	{object}	util.AppError
 // @Router		/users/login [post]
 func (uh *UserHandler) HandleLoginUser(c echo.Context) error {
@@ -180,44 +234,218 @@ func (uh *UserHandler) HandleLoginUser(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	// same response whether the email exists or not
+	invalidLogin := util.NewAppError(http.StatusUnauthorized, "invalid email or password", "")
+	ip := c.RealIP()
+
+	// check attempts from this ip
+	err = uh.lg.CheckIP(ip)
+	if err != nil && errors.Is(err, service.ErrTooManyLoginAttempts) {
+		return util.NewAppError(http.StatusTooManyRequests, "too many login attempts, try again later", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
 	// get existing user
 	var existingUser model.User
 	err = uh.db.Where("email =?", loginData.Email).First(&existingUser).Error
 	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
-		// email not registed
-		return util.NewAppError(http.StatusNotFound, "email not registered", "")
+		// email not registed, still compare to keep the timing the same
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(loginData.Password))
+		_, _, err = uh.lg.RecordFailure(loginData.Email, ip, nil)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		return invalidLogin
 	} else if err != nil {
 		// other error
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
-	fmt.Println(existingUser)
+
+	// locked accounts get the same response, the owner is notified by email
+	locked, err := uh.lg.IsLocked(existingUser.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if locked {
+		// compare anyway, a quicker response would tell the account is locked
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(loginData.Password))
+		_, _, err = uh.lg.RecordFailure(loginData.Email, ip, nil)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		return invalidLogin
+	}
+
 	// compare password
 	err = bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(loginData.Password))
 	if err != nil {
-		fmt.Println(err)
-		return util.NewAppError(http.StatusBadRequest, "incorrect password", "")
+		lockout, unlockToken, err := uh.lg.RecordFailure(loginData.Email, ip, &existingUser)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		if lockout != nil {
+			uh.sendLockoutMail(c, &existingUser, lockout, unlockToken)
+		}
+		return invalidLogin
+	}
+	err = uh.lg.RecordSuccess(loginData.Email, ip)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
 
-	// Set custom claims
-	claims := &auth.JwtAppClaims{
-		UserID: existingUser.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Subject:   existingUser.Email,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 5)),
-		},
+	// with 2fa enabled the client has to complete the challenge at /users/login/2fa
+	twoFactorEnabled, err := uh.tfs.IsEnabled(existingUser.ID)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if twoFactorEnabled {
+		challenge, err := uh.tfs.CreateLoginChallenge(&existingUser)
+		if err != nil {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+		return c.JSON(http.StatusAccepted, LoginChallengeRespData{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
 	}
 
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// issue access and refresh tokens
+	tokens, err := uh.ts.IssueTokens(&existingUser, false)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
 
-	// Generate encoded token and send it as response.
-	t, err := token.SignedString([]byte(os.Getenv("JWT_KEY")))
+	return c.JSON(http.StatusOK, LoginRespData{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	})
+}
+
+// @Summary	Refresh access token
+// @Tags		users
+// @Accept		json
+// @Param		RefreshToken	body	handler.RefreshReqData	true	"Refresh token"
+// @Produce	json
+// @Success	200	{object}	handler.LoginRespData
+// @Failure	400	{object}	util.AppError
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/refresh [post]
+func (uh *UserHandler) HandleRefreshToken(c echo.Context) error {
+	// parse body
+	var reqBody RefreshReqData
+	err := c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.RefreshToken == "" {
+		return util.NewAppError(http.StatusBadRequest, "refresh token cannot be empty", "")
+	}
+
+	// rotate refresh token
+	tokens, err := uh.ts.Refresh(reqBody.RefreshToken)
+	if err != nil && (errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused)) {
+		return util.NewAppError(http.StatusUnauthorized, "invalid or expired refresh token", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	return c.JSON(http.StatusOK, LoginRespData{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt,
+	})
+}
+
+// @Summary	Logout, revokes the access token and the refresh token family
+// @Tags		users
+// @Accept		json
+// @Param		RefreshToken	body	handler.RefreshReqData	false	"Refresh token"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	401	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/logout [post]
+func (uh *UserHandler) HandleLogoutUser(c echo.Context) error {
+	claims, err := util.GetClaimsFromContext(c)
 	if err != nil {
+		return err
+	}
+
+	// body is optional
+	var reqBody RefreshReqData
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+
+	// revoke current access token
+	expiresAt := time.Now().Add(service.AccessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	err = uh.ts.RevokeAccessToken(claims.ID, expiresAt)
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	// revoke refresh token family
+	if reqBody.RefreshToken != "" {
+		err = uh.ts.RevokeRefreshToken(claims.UserID, reqBody.RefreshToken)
+		if err != nil && !errors.Is(err, service.ErrInvalidRefreshToken) {
+			return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "User successfully logged out",
+	})
+}
+
+func (uh *UserHandler) sendLockoutMail(c echo.Context, user *model.User, lockout *model.AccountLockout, unlockToken string) {
+	err := uh.ns.SendMail(user.ID, user.Email,
+		"Your account has been locked",
+		fmt.Sprintf(`
+		<h1>Hello %s,</h1><br>
+		<p>We locked your account until %s after too many failed login attempts.</p>
+		<p>If this was you, you can unlock your account here:<br>%s/users/unlock?token=%s</p>
+		<p>If this wasn't you, consider changing your password once unlocked.</p>
+		`, user.Name,
+			lockout.LockedUntil.Format(time.DateTime),
+			os.Getenv("APP_URL"),
+			unlockToken),
+		c.Logger(),
+	)
+	if err != nil {
+		c.Logger().Errorf("failed to send email notif: %s", err.Error())
+	}
+}
+
+// @Summary	Unlock a locked account using the emailed token
+// @Tags		users
+// @Param		token	query	string	true	"Unlock token"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/users/unlock [get]
+func (uh *UserHandler) HandleUnlockUser(c echo.Context) error {
+	token := c.QueryParam("token")
+	if token == "" {
+		return util.NewAppError(http.StatusBadRequest, "token cannot be empty", "")
+	}
+
+	err := uh.lg.Unlock(token)
+	if err != nil && errors.Is(err, service.ErrInvalidUnlockToken) {
+		return util.NewAppError(http.StatusBadRequest, "invalid or expired unlock token", "")
+	} else if err != nil {
 		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"token": t,
+	return c.JSON(http.StatusOK, &util.ResponseData{
+		Message: "Account successfully unlocked",
 	})
 }
 
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleLoginUserSpoofedForwardedFor(t *testing.T) {
+	godotenv.Load("../.env")
+	db := createTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	t.Setenv("TRUSTED_PROXIES", "")
+	ipExtractor, err := util.IPExtractorFromEnv()
+	assert.NoError(t, err)
+
+	e := echo.New()
+	e.HTTPErrorHandler = util.ErrorHandler
+	e.IPExtractor = ipExtractor
+	uh := NewUserHandler(db, nil, nil, service.NewLoginGuardService(db), nil, nil, nil, nil)
+	e.POST("/users/login", uh.HandleLoginUser)
+
+	// a fresh client address, so failures of earlier runs don't count
+	n := time.Now().UnixNano()
+	remoteAddr := fmt.Sprintf("10.%d.%d.%d:4321", n%250+1, (n/250)%250+1, (n/62500)%250+1)
+	login := func(forwardedFor string) int {
+		body := fmt.Sprintf(`{"email":"nobody-%d@example.com","password":"wrong-password"}`, n)
+		req := httptest.NewRequest(http.MethodPost, "/users/login", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < service.MaxFailedLoginsPerIP; i++ {
+		assert.Equal(t, http.StatusUnauthorized, login(fmt.Sprintf("203.0.113.%d", i)))
+	}
+	// a different forwarded ip on every request is still the same client
+	assert.Equal(t, http.StatusTooManyRequests, login("198.51.100.1"))
+}
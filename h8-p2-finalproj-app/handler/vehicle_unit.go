@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type VehicleUnitHandler struct {
+	db *gorm.DB
+	fs *service.FavoriteService
+}
+
+func NewVehicleUnitHandler(db *gorm.DB, fs *service.FavoriteService) VehicleUnitHandler {
+	return VehicleUnitHandler{
+		db: db,
+		fs: fs,
+	}
+}
+
+type PostUnitReq struct {
+	PlateNumber string `json:"plate_number"`
+	VIN         string `json:"vin"`
+	Color       string `json:"color"`
+	Odometer    uint   `json:"odometer"`
+	BranchID    *uint  `json:"branch_id"`
+}
+
+// moving a unit to another branch is how staff rebalance the fleet
+type PutUnitReq struct {
+	Color    *string `json:"color"`
+	Odometer *uint   `json:"odometer"`
+	Status   *string `json:"status"`
+	BranchID *uint   `json:"branch_id"`
+}
+
+type UnitRespItem struct {
+	UnitID      uint   `json:"unit_id"`
+	CarID       uint   `json:"car_id"`
+	PlateNumber string `json:"plate_number"`
+	VIN         string `json:"vin"`
+	Color       string `json:"color"`
+	Odometer    uint   `json:"odometer"`
+	Status      string `json:"status"`
+	BranchID    *uint  `json:"branch_id"`
+}
+
+func toUnitRespItem(u *model.VehicleUnit) UnitRespItem {
+	return UnitRespItem{
+		UnitID:      u.ID,
+		CarID:       u.CarID,
+		PlateNumber: u.PlateNumber,
+		VIN:         u.VIN,
+		Color:       u.Color,
+		Odometer:    u.Odometer,
+		Status:      u.Status,
+		BranchID:    u.BranchID,
+	}
+}
+
+func parseIDParam(c echo.Context, name string) (uint, error) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id < 1 {
+		return 0, util.NewAppError(http.StatusBadRequest, "invalid "+strings.ReplaceAll(name, "_", " "), "")
+	}
+	return uint(id), nil
+}
+
+func (vh *VehicleUnitHandler) getCar(c echo.Context) (*model.Car, error) {
+	return findCarFromParam(c, vh.db)
+}
+
+// gets the car of the id path param
+func findCarFromParam(c echo.Context, db *gorm.DB) (*model.Car, error) {
+	carID, err := parseIDParam(c, "id")
+	if err != nil {
+		return nil, err
+	}
+	var car model.Car
+	err = db.Where("id=?", carID).First(&car).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, util.NewAppError(http.StatusNotFound, "car not found", "")
+	} else if err != nil {
+		return nil, util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	return &car, nil
+}
+
+func (vh *VehicleUnitHandler) validateBranch(branchID *uint) error {
+	if branchID == nil {
+		return nil
+	}
+	var count int64
+	err := vh.db.Model(&model.Branch{}).Where("id=?", *branchID).Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count == 0 {
+		return util.NewAppError(http.StatusNotFound, "branch not found", "")
+	}
+	return nil
+}
+
+// @Summary	Vehicle units of a car
+// @Tags		vehicle units
+// @Param		id	path	int	true	"Car id"
+// @Produce	json
+// @Success	200	{array}		handler.UnitRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/units [get]
+func (vh *VehicleUnitHandler) HandleGetUnits(c echo.Context) error {
+	car, err := vh.getCar(c)
+	if err != nil {
+		return err
+	}
+
+	var units []model.VehicleUnit
+	err = vh.db.Where("car_id=?", car.ID).Order("id").Find(&units).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	resp := []UnitRespItem{}
+	for i := range units {
+		resp = append(resp, toUnitRespItem(&units[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Adds a vehicle unit to a car
+// @Tags		vehicle units
+// @Accept		json
+// @Param		id			path	int						true	"Car id"
+// @Param		UnitData	body	handler.PostUnitReq		true	"Plate number, vin, color, odometer and branch"
+// @Produce	json
+// @Success	201	{object}	handler.UnitRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/units [post]
+func (vh *VehicleUnitHandler) HandlePostUnit(c echo.Context) error {
+	car, err := vh.getCar(c)
+	if err != nil {
+		return err
+	}
+
+	var reqBody PostUnitReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	reqBody.PlateNumber = strings.ToUpper(strings.TrimSpace(reqBody.PlateNumber))
+	reqBody.VIN = strings.ToUpper(strings.TrimSpace(reqBody.VIN))
+	if reqBody.PlateNumber == "" || reqBody.VIN == "" {
+		return util.NewAppError(http.StatusBadRequest, "plate number and vin cannot be empty", "")
+	}
+	err = vh.validateBranch(reqBody.BranchID)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	err = vh.db.Model(&model.VehicleUnit{}).
+		Where("plate_number=? OR vin=?", reqBody.PlateNumber, reqBody.VIN).
+		Count(&count).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	if count != 0 {
+		return util.NewAppError(http.StatusBadRequest, "plate number or vin already registered", "")
+	}
+
+	unit := model.VehicleUnit{
+		CarID:       car.ID,
+		PlateNumber: reqBody.PlateNumber,
+		VIN:         reqBody.VIN,
+		Color:       strings.TrimSpace(reqBody.Color),
+		Odometer:    reqBody.Odometer,
+		Status:      model.UnitStatusAvailable,
+		BranchID:    reqBody.BranchID,
+	}
+	err = vh.db.Create(&unit).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	checkWatches(c, vh.fs, car.ID)
+
+	return c.JSON(http.StatusCreated, toUnitRespItem(&unit))
+}
+
+// @Summary	Updates a vehicle unit, moving it to another branch rebalances the fleet
+// @Tags		vehicle units
+// @Accept		json
+// @Param		id			path	int					true	"Car id"
+// @Param		unit_id		path	int					true	"Unit id"
+// @Param		UnitData	body	handler.PutUnitReq	true	"Fields to update"
+// @Produce	json
+// @Success	200	{object}	handler.UnitRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/cars/{id}/units/{unit_id} [put]
+func (vh *VehicleUnitHandler) HandlePutUnit(c echo.Context) error {
+	car, err := vh.getCar(c)
+	if err != nil {
+		return err
+	}
+	unitID, err := parseIDParam(c, "unit_id")
+	if err != nil {
+		return err
+	}
+
+	var unit model.VehicleUnit
+	err = vh.db.Where("id=? AND car_id=?", unitID, car.ID).First(&unit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return util.NewAppError(http.StatusNotFound, "unit not found", "")
+	} else if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+
+	var reqBody PutUnitReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	if reqBody.Color != nil {
+		unit.Color = strings.TrimSpace(*reqBody.Color)
+	}
+	if reqBody.Odometer != nil {
+		if *reqBody.Odometer < unit.Odometer {
+			return util.NewAppError(http.StatusBadRequest, "odometer cannot go backwards", "")
+		}
+		unit.Odometer = *reqBody.Odometer
+	}
+	if reqBody.Status != nil {
+		switch *reqBody.Status {
+		case model.UnitStatusAvailable, model.UnitStatusMaintenance, model.UnitStatusRetired:
+			unit.Status = *reqBody.Status
+		default:
+			return util.NewAppError(http.StatusBadRequest, "invalid unit status", "")
+		}
+	}
+
+	if reqBody.BranchID != nil {
+		err = vh.validateBranch(reqBody.BranchID)
+		if err != nil {
+			return err
+		}
+		unit.BranchID = reqBody.BranchID
+	}
+
+	err = vh.db.Save(&unit).Error
+	if err != nil {
+		return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+	}
+	// back in service or moved to another branch
+	if unit.Status == model.UnitStatusAvailable {
+		checkWatches(c, vh.fs, car.ID)
+	}
+
+	return c.JSON(http.StatusOK, toUnitRespItem(&unit))
+}
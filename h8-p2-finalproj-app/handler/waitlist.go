@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/util"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type WaitlistHandler struct {
+	db *gorm.DB
+	ws *service.WaitlistService
+}
+
+func NewWaitlistHandler(db *gorm.DB, ws *service.WaitlistService) WaitlistHandler {
+	return WaitlistHandler{
+		db: db,
+		ws: ws,
+	}
+}
+
+type PostWaitlistReq struct {
+	CarID          uint   `json:"car_id"`
+	StartDate      string `json:"start_date"`
+	EndDate        string `json:"end_date"`
+	PickupBranchID *uint  `json:"pickup_branch_id"`
+}
+
+type WaitlistRespItem struct {
+	WaitlistID     uint   `json:"waitlist_id"`
+	CarID          uint   `json:"car_id"`
+	Car            string `json:"car"`
+	StartDate      string `json:"start_date"`
+	EndDate        string `json:"end_date"`
+	PickupBranchID *uint  `json:"pickup_branch_id"`
+	Status         string `json:"status"`
+	// place in line while waiting
+	Position      uint       `json:"position,omitempty"`
+	HoldExpiresAt *time.Time `json:"hold_expires_at,omitempty"`
+	RentalID      *uint      `json:"rental_id,omitempty"`
+}
+
+func (wh *WaitlistHandler) toWaitlistRespItem(e *model.WaitlistEntry) (WaitlistRespItem, error) {
+	position, err := wh.ws.Position(e)
+	if err != nil {
+		return WaitlistRespItem{}, err
+	}
+	resp := WaitlistRespItem{
+		WaitlistID:     e.ID,
+		CarID:          e.CarID,
+		Car:            e.Car.GetCarName(),
+		StartDate:      e.StartDate.Format(rentalTimeFormat),
+		EndDate:        e.EndDate.Format(rentalTimeFormat),
+		PickupBranchID: e.PickupBranchID,
+		Status:         e.Status,
+		Position:       position,
+		RentalID:       e.RentalID,
+	}
+	if e.Status == model.WaitlistHeld {
+		resp.HoldExpiresAt = e.HoldExpiresAt
+	}
+	return resp, nil
+}
+
+func waitlistAppError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrCarNotFound):
+		return util.NewAppError(http.StatusNotFound, "car not found", "")
+	case errors.Is(err, service.ErrWaitlistEntryNotFound):
+		return util.NewAppError(http.StatusNotFound, "waitlist entry not found", "")
+	case errors.Is(err, service.ErrWaitlistEntryClosed):
+		return util.NewAppError(http.StatusBadRequest, "waitlist entry is no longer open", "")
+	case errors.Is(err, service.ErrAlreadyWaiting):
+		return util.NewAppError(http.StatusConflict, "already on the waitlist for these dates", "")
+	case errors.Is(err, service.ErrInvalidWatch):
+		return util.NewAppError(http.StatusBadRequest, "end date must be after start date", "")
+	case errors.Is(err, service.ErrWatchInPast):
+		return util.NewAppError(http.StatusBadRequest, "start date must be in the future", "")
+	case errors.Is(err, service.ErrWatchTooLong):
+		return util.NewAppError(http.StatusBadRequest, "waitlist period is too long", "")
+	case errors.Is(err, service.ErrCarAvailable):
+		return util.NewAppError(http.StatusConflict, "car is available for the period, book it now", "")
+	}
+	return util.NewAppError(http.StatusInternalServerError, "internal server error", err.Error())
+}
+
+// @Summary	Joins the waitlist of a car that is fully booked for the dates
+// @Tags		waitlist
+// @Accept		json
+// @Param		WaitlistData	body	handler.PostWaitlistReq	true	"Car, dates and pickup branch"
+// @Produce	json
+// @Success	201	{object}	handler.WaitlistRespItem
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	409	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/waitlist [post]
+func (wh *WaitlistHandler) HandlePostWaitlist(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, wh.db)
+	if err != nil {
+		return err
+	}
+	var reqBody PostWaitlistReq
+	err = c.Bind(&reqBody)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "bad request", err.Error())
+	}
+	startDate, _, err := parseRentalTime(reqBody.StartDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid start date", "")
+	}
+	endDate, _, err := parseRentalTime(reqBody.EndDate)
+	if err != nil {
+		return util.NewAppError(http.StatusBadRequest, "invalid end date", "")
+	}
+
+	entry, err := wh.ws.Join(user.ID, reqBody.CarID, reqBody.PickupBranchID, startDate, endDate)
+	if err != nil {
+		return waitlistAppError(err)
+	}
+	resp, err := wh.toWaitlistRespItem(entry)
+	if err != nil {
+		return waitlistAppError(err)
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// @Summary	Waitlist entries of the user, latest first
+// @Tags		waitlist
+// @Produce	json
+// @Success	200	{array}		handler.WaitlistRespItem
+// @Failure	500	{object}	util.AppError
+// @Router		/waitlist [get]
+func (wh *WaitlistHandler) HandleGetWaitlist(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, wh.db)
+	if err != nil {
+		return err
+	}
+	entries, err := wh.ws.GetEntries(user.ID)
+	if err != nil {
+		return waitlistAppError(err)
+	}
+	resp := []WaitlistRespItem{}
+	for i := range entries {
+		item, err := wh.toWaitlistRespItem(&entries[i])
+		if err != nil {
+			return waitlistAppError(err)
+		}
+		resp = append(resp, item)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Summary	Leaves the waitlist, a held unit goes to the next in line
+// @Tags		waitlist
+// @Param		id	path	int	true	"Waitlist entry id"
+// @Produce	json
+// @Success	200	{object}	util.ResponseData
+// @Failure	400	{object}	util.AppError
+// @Failure	404	{object}	util.AppError
+// @Failure	500	{object}	util.AppError
+// @Router		/waitlist/{id} [delete]
+func (wh *WaitlistHandler) HandleDeleteWaitlist(c echo.Context) error {
+	user, err := util.GetUserFromContext(c, wh.db)
+	if err != nil {
+		return err
+	}
+	id, err := parseIDParam(c, "id")
+	if err != nil {
+		return err
+	}
+	err = wh.ws.Leave(user.ID, id, c.Logger())
+	if err != nil {
+		return waitlistAppError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": "left the waitlist",
+	})
+}
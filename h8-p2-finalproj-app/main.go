@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"h8-p2-finalproj-app/auth"
 	"h8-p2-finalproj-app/config"
 	"h8-p2-finalproj-app/service"
+	"h8-p2-finalproj-app/storage"
 
 	_ "h8-p2-finalproj-app/docs"
 	"h8-p2-finalproj-app/handler"
+	"h8-p2-finalproj-app/model"
 	"h8-p2-finalproj-app/util"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -43,40 +46,279 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.Logger())
 	e.HTTPErrorHandler = util.ErrorHandler
+	// client ips for the login limits
+	ipExtractor, err := util.IPExtractorFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	e.IPExtractor = ipExtractor
+
+	// jwt signing keys
+	keySet, err := auth.LoadKeySetFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// jwt middleware
 	config := echojwt.Config{
 		NewClaimsFunc: func(c echo.Context) jwt.Claims {
 			return new(auth.JwtAppClaims)
 		},
-		SigningKey: []byte(os.Getenv("JWT_KEY")),
+		KeyFunc: keySet.Keyfunc,
+	}
+	jwtMiddleware := echojwt.WithConfig(config)
+	tokenService := service.NewTokenService(db, keySet)
+	notificationService := service.NewNotificationService(db)
+	twoFactorService := service.NewTwoFactorService(db)
+	revocationCheck := auth.RevocationMiddleware(tokenService)
+	twoFactorPolicy := auth.TwoFactorPolicyMiddleware(twoFactorService.IsRequiredForRole)
+	jwtAuth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwtMiddleware(revocationCheck(twoFactorPolicy(next)))
 	}
-	jwtAuth := echojwt.WithConfig(config)
+	// skips the 2fa policy, so users required to use 2fa can still enroll
+	jwtAuthEnrollment := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return jwtMiddleware(revocationCheck(next))
+	}
+	staffOnly := auth.RequireRoles(model.RoleStaff, model.RoleAdmin)
+	adminOnly := auth.RequireRoles(model.RoleAdmin)
+
+	// driver licenses, kept in a private storage
+	documentStorage := storage.NewDocumentStorageFromEnv()
+	driverLicenseService := service.NewDriverLicenseService(db, documentStorage, notificationService)
 
 	// users
-	user := handler.NewUserHandler(db, service.NewInvoiceService())
+	user := handler.NewUserHandler(
+		db,
+		service.NewInvoiceService(),
+		tokenService,
+		service.NewLoginGuardService(db),
+		twoFactorService,
+		service.NewAccountService(db, tokenService),
+		notificationService,
+		driverLicenseService,
+	)
 	e.POST("/users/register", user.HandleRegisterUser)
 	e.POST("/users/login", user.HandleLoginUser)
+	e.GET("/users/unlock", user.HandleUnlockUser)
+	e.POST("/users/refresh", user.HandleRefreshToken)
+	e.POST("/users/logout", jwtAuthEnrollment(user.HandleLogoutUser))
+
+	// personal data export
+	dataExportService := service.NewDataExportService(db)
+	dataExport := handler.NewDataExportHandler(db, dataExportService)
+	e.POST("/users/export", jwtAuth(dataExport.HandlePostDataExport))
+	e.GET("/users/export/:id", jwtAuth(dataExport.HandleGetDataExport))
+	e.GET("/users/export/:id/download", dataExport.HandleDownloadDataExport)
+	go dataExportService.ResumeUnfinished()
+
+	// two factor auth
+	twoFactor := handler.NewTwoFactorHandler(db, twoFactorService, tokenService)
+	e.POST("/users/login/2fa", twoFactor.HandleLoginTwoFactor)
+	e.POST("/users/2fa/enroll", jwtAuthEnrollment(twoFactor.HandleEnrollTwoFactor))
+	e.POST("/users/2fa/verify", jwtAuthEnrollment(twoFactor.HandleVerifyTwoFactor))
+	e.POST("/users/2fa/disable", jwtAuth(twoFactor.HandleDisableTwoFactor))
+	e.POST("/users/2fa/recovery-codes", jwtAuth(twoFactor.HandleRegenerateRecoveryCodes))
 	e.GET("/users/profile", jwtAuth(user.HandleUserProfile))
+	e.PUT("/users/profile", jwtAuth(user.HandleUpdateProfile))
+	e.DELETE("/users/profile", jwtAuth(user.HandleDeleteAccount))
+	e.POST("/users/profile/email", jwtAuth(user.HandleChangeEmail))
+	e.GET("/users/profile/email/confirm", user.HandleConfirmEmailChange)
+	e.PUT("/users/profile/password", jwtAuth(user.HandleChangePassword))
 	e.GET("/users/topup", jwtAuth(user.HandlePostTopUp))
 
+	// driver license upload and the staff review queue
+	driverLicense := handler.NewDriverLicenseHandler(db, driverLicenseService, documentStorage)
+	e.POST("/users/license", driverLicense.HandlePostLicense, jwtAuth, middleware.BodyLimit("6M"))
+	e.GET("/users/license", driverLicense.HandleGetLicense, jwtAuth)
+	e.GET("/licenses", driverLicense.HandleGetLicenses, jwtAuth, staffOnly)
+	e.GET("/licenses/:id/image", driverLicense.HandleGetLicenseImage, jwtAuth, staffOnly)
+	e.POST("/licenses/:id/approve", driverLicense.HandleApproveLicense, jwtAuth, staffOnly)
+	e.POST("/licenses/:id/reject", driverLicense.HandleRejectLicense, jwtAuth, staffOnly)
+
 	// cars
-	car := handler.NewCarHandler(service.NewCarService(db))
+	carService := service.NewCarService(db)
+	car := handler.NewCarHandler(carService)
 	cars := e.Group("/cars")
 	cars.GET("", car.HandleGetCars)
+	cars.GET("/:id", car.HandleGetCar)
+	cars.GET("/:id/availability", car.HandleGetAvailability)
+
+	// saved cars, watches notify when a saved car is free again for the dates
+	favoriteService := service.NewFavoriteService(db, carService, notificationService)
+	favorite := handler.NewFavoriteHandler(db, favoriteService)
+	favorites := e.Group("/favorites")
+	favorites.Use(jwtAuth)
+	favorites.GET("", favorite.HandleGetFavorites)
+	favorites.POST("", favorite.HandlePostFavorite)
+	favorites.DELETE("/:car_id", favorite.HandleDeleteFavorite)
+	favorites.POST("/:car_id/watches", favorite.HandlePostWatch)
+	favorites.GET("/watches", favorite.HandleGetWatches)
+	favorites.DELETE("/watches/:id", favorite.HandleDeleteWatch)
+
+	// vehicle units, staff only
+	unit := handler.NewVehicleUnitHandler(db, favoriteService)
+	cars.GET("/:id/units", unit.HandleGetUnits, jwtAuth, staffOnly)
+	cars.POST("/:id/units", unit.HandlePostUnit, jwtAuth, staffOnly)
+	cars.PUT("/:id/units/:unit_id", unit.HandlePutUnit, jwtAuth, staffOnly)
+
+	// car images, files are served by the app when kept on the local disk
+	imageStorage, err := storage.NewStorageFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	localStorage, _ := imageStorage.(*storage.LocalStorage)
+	carImage := handler.NewCarImageHandler(db, service.NewCarImageService(db, imageStorage), localStorage)
+	cars.GET("/:id/images", carImage.HandleGetImages)
+	cars.POST("/:id/images", carImage.HandlePostImage, jwtAuth, adminOnly, middleware.BodyLimit("6M"))
+	cars.PUT("/:id/images/order", carImage.HandlePutImageOrder, jwtAuth, adminOnly)
+	cars.DELETE("/:id/images/:image_id", carImage.HandleDeleteImage, jwtAuth, adminOnly)
+	e.GET("/images/*", carImage.HandleServeImage)
+
+	// pricing
+	pricingService := service.NewPricingService(db)
+	pricing := handler.NewPricingHandler(db, pricingService)
+	cars.GET("/:id/price", pricing.HandleGetCarPrice)
+	e.GET("/pricing-rules", pricing.HandleGetPricingRules, jwtAuth, staffOnly)
+	e.POST("/pricing-rules", pricing.HandlePostPricingRule, jwtAuth, adminOnly)
+	e.DELETE("/pricing-rules/:id", pricing.HandleDeletePricingRule, jwtAuth, adminOnly)
+
+	// maintenance windows, staff only
+	maintenance := handler.NewMaintenanceHandler(db, service.NewMaintenanceService(db, carService), favoriteService)
+	cars.GET("/:id/maintenance", maintenance.HandleGetMaintenances, jwtAuth, staffOnly)
+	cars.POST("/:id/maintenance", maintenance.HandlePostMaintenance, jwtAuth, staffOnly)
+	cars.DELETE("/:id/maintenance/:maintenance_id", maintenance.HandleDeleteMaintenance, jwtAuth, staffOnly)
+
+	// branches
+	_, err = service.DefaultOneWayFee()
+	if err != nil {
+		log.Fatal(err)
+	}
+	branchService := service.NewBranchService(db)
+	branch := handler.NewBranchHandler(db, branchService)
+	branches := e.Group("/branches")
+	branches.GET("", branch.HandleGetBranches)
+	branches.GET("/:id", branch.HandleGetBranch)
+	branches.POST("", branch.HandlePostBranch, jwtAuth, adminOnly)
+	branches.PUT("/:id", branch.HandlePutBranch, jwtAuth, adminOnly)
+	branches.PUT("/one-way-fees", branch.HandlePutOneWayFee, jwtAuth, adminOnly)
+
+	// promotions
+	promotionService := service.NewPromotionService(db)
+	promotion := handler.NewPromotionHandler(db, promotionService)
+	promotions := e.Group("/promotions")
+	promotions.Use(jwtAuth, adminOnly)
+	promotions.POST("", promotion.HandlePostPromotion)
+	promotions.GET("", promotion.HandleGetPromotions)
+	promotions.POST("/:id/deactivate", promotion.HandleDeactivatePromotion)
+	promotions.GET("/:id/stats", promotion.HandleGetPromotionStats)
+
+	// add ons
+	addOnService := service.NewAddOnService(db)
+	addOn := handler.NewAddOnHandler(db, addOnService)
+	e.GET("/add-ons", addOn.HandleGetAddOns)
+	e.POST("/add-ons", addOn.HandlePostAddOn, jwtAuth, adminOnly)
+	e.PUT("/add-ons/:id", addOn.HandlePutAddOn, jwtAuth, adminOnly)
+
+	// waitlist for fully booked cars, freed units are held for the first in line
+	waitlistService := service.NewWaitlistService(db, carService, notificationService)
+	waitlist := handler.NewWaitlistHandler(db, waitlistService)
+	e.GET("/waitlist", waitlist.HandleGetWaitlist, jwtAuth)
+	e.POST("/waitlist", waitlist.HandlePostWaitlist, jwtAuth)
+	e.DELETE("/waitlist/:id", waitlist.HandleDeleteWaitlist, jwtAuth)
+	go waitlistService.RunHoldExpiry(time.Minute, e.Logger)
+
+	// organizations, rentals on their account are billed once a month
+	invoiceService := service.NewInvoiceService()
+	organizationService := service.NewOrganizationService(db, invoiceService, notificationService)
+	organization := handler.NewOrganizationHandler(db, organizationService)
+	organizations := e.Group("/organizations")
+	organizations.Use(jwtAuth)
+	organizations.POST("", organization.HandlePostOrganization)
+	organizations.GET("/mine", organization.HandleGetMyOrganization)
+	organizations.POST("/mine/leave", organization.HandleLeaveOrganization)
+	organizations.GET("/invitations", organization.HandleGetInvitations)
+	organizations.POST("/invitations/:id/accept", organization.HandleAcceptInvitation)
+	organizations.DELETE("/invitations/:id", organization.HandleDeclineInvitation)
+	organizations.PUT("/:id", organization.HandlePutOrganization)
+	organizations.POST("/:id/members", organization.HandlePostMember)
+	organizations.PUT("/:id/members/:user_id", organization.HandlePutMember)
+	organizations.DELETE("/:id/members/:user_id", organization.HandleDeleteMember)
+	organizations.DELETE("/:id/invitations/:invitation_id", organization.HandleDeleteInvitation)
+	organizations.GET("/:id/invoices", organization.HandleGetInvoices)
+	organizations.POST("/invoices", organization.HandlePostInvoices, adminOnly)
+	go organizationService.RunMonthlyInvoicing(time.Hour, e.Logger)
+
+	// security deposits per car type, held from booking until after the return
+	securityDepositService := service.NewSecurityDepositService(db, invoiceService, notificationService)
+	securityDeposit := handler.NewSecurityDepositHandler(db, securityDepositService)
+	e.GET("/deposit-rates", securityDeposit.HandleGetRates)
+	e.PUT("/deposit-rates", securityDeposit.HandlePutRate, jwtAuth, adminOnly)
+	e.DELETE("/deposit-rates/:id", securityDeposit.HandleDeleteRate, jwtAuth, adminOnly)
+	go securityDepositService.RunRelease(10*time.Minute, e.Logger)
 
 	// rentals
-	rental := handler.NewRentalHandler(db, service.NewCarService(db), service.NewInvoiceService())
+	rentalService := service.NewRentalService(db, waitlistService, favoriteService, securityDepositService, invoiceService)
+	rental := handler.NewRentalHandler(
+		db,
+		carService,
+		branchService,
+		pricingService,
+		service.NewQuoteService(db),
+		promotionService,
+		addOnService,
+		invoiceService,
+		notificationService,
+		rentalService,
+		waitlistService,
+		driverLicenseService,
+		organizationService,
+		securityDepositService,
+	)
 	rentals := e.Group("/rentals")
 	rentals.Use(jwtAuth)
 	rentals.POST("", rental.HandlePostRentals)
+	rentals.POST("/quote", rental.HandlePostQuote)
 	rentals.GET("", rental.HandleGetRentals)
+	rentals.GET("/unassigned", rental.HandleGetUnassignedRentals, staffOnly)
+	rentals.POST("/:id/cancel", rental.HandleCancelRental)
+	rentals.POST("/:id/pickup", rental.HandlePickupRental, staffOnly)
+	rentals.POST("/:id/return", rental.HandleReturnRental, staffOnly)
+	rentals.POST("/:id/deposit/pay", securityDeposit.HandlePayDeposit)
+	rentals.POST("/:id/deposit/capture", securityDeposit.HandleCaptureDeposit, staffOnly)
+
+	// inspection reports at pickup and return, damage is charged from the deposit first
+	inspection := handler.NewInspectionHandler(db,
+		service.NewInspectionService(db, documentStorage, securityDepositService, invoiceService, notificationService),
+		documentStorage,
+	)
+	rentals.GET("/:id/inspections", inspection.HandleGetInspections)
+	rentals.POST("/:id/inspections", inspection.HandlePostInspection, staffOnly)
+	inspections := e.Group("/inspections")
+	inspections.Use(jwtAuth)
+	inspections.GET("/checklist", inspection.HandleGetChecklist, staffOnly)
+	inspections.POST("/:id/photos", inspection.HandlePostPhoto, staffOnly, middleware.BodyLimit("6M"))
+	inspections.GET("/:id/photos/:photo_id", inspection.HandleGetPhoto)
+	inspections.POST("/:id/acknowledge", inspection.HandleAcknowledge)
+	inspections.POST("/:id/damage-charges", inspection.HandlePostDamageCharge, staffOnly)
+	e.POST("/damage-charges/:id/invoice", inspection.HandlePostDamageChargeInvoice, jwtAuth, staffOnly)
+
+	// reviews, hidden by staff when abusive
+	review := handler.NewReviewHandler(db, service.NewReviewService(db, imageStorage))
+	rentals.POST("/:id/review", review.HandlePostReview)
+	cars.GET("/:id/reviews", review.HandleGetCarReviews)
+	e.GET("/reviews", review.HandleGetReviews, jwtAuth, staffOnly)
+	e.POST("/reviews/:id/hide", review.HandleHideReview, jwtAuth, staffOnly)
+	e.POST("/reviews/:id/unhide", review.HandleUnhideReview, jwtAuth, staffOnly)
 
 	// payments, for call backs by xendit
-	payment := handler.NewPaymentHandler(db)
+	payment := handler.NewPaymentHandler(db, carService, notificationService, rentalService, securityDepositService)
 	payments := e.Group("/payments")
 	payments.POST("/callback", payment.HandlePaymentSuccess)
 
+	// public keys for partner services to verify tokens
+	jwks := handler.NewJWKSHandler(keySet)
+	e.GET("/.well-known/jwks.json", jwks.HandleGetJWKS)
+
 	// swagger docs
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
@@ -0,0 +1,38 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	AddOnPerDay    = "PerDay"
+	AddOnPerRental = "PerRental"
+)
+
+// an extra that can be attached to a rental, like a child seat or insurance
+type AddOn struct {
+	gorm.Model
+	Name        string `gorm:"not null;unique"`
+	Description string
+	PricingType string  `gorm:"not null"`
+	Price       float64 `gorm:"not null"`
+	Inventory   *uint   // units in stock, nil for services without a limit
+	Active      bool    `gorm:"not null"`
+}
+
+type RentalAddOn struct {
+	gorm.Model
+	RentalID uint `gorm:"not null;index"`
+	AddOnID  uint `gorm:"not null;index"`
+	AddOn    AddOn
+	Quantity uint    `gorm:"not null"`
+	Amount   float64 `gorm:"not null"`
+}
+
+// add ons of a quote, reserved when the quote is booked
+type QuoteAddOn struct {
+	gorm.Model
+	QuoteID  uint `gorm:"not null;index"`
+	AddOnID  uint `gorm:"not null"`
+	AddOn    AddOn
+	Quantity uint    `gorm:"not null"`
+	Amount   float64 `gorm:"not null"`
+}
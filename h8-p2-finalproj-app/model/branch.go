@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a rental location where cars are picked up and dropped off
+type Branch struct {
+	gorm.Model
+	Name         string `gorm:"not null;unique"`
+	Address      string `gorm:"not null"`
+	City         string `gorm:"not null"`
+	Phone        string
+	OpeningHours []BranchOpeningHour
+}
+
+// opening hours of a branch for a day of the week,
+// days without a row are closed
+type BranchOpeningHour struct {
+	gorm.Model
+	BranchID uint   `gorm:"not null;uniqueIndex:idx_branch_weekday"`
+	Weekday  int    `gorm:"not null;uniqueIndex:idx_branch_weekday"` // time.Weekday, 0 is sunday
+	OpensAt  string `gorm:"not null"`                                // 15:04
+	ClosesAt string `gorm:"not null"`                                // 15:04
+}
+
+// fee charged for dropping off at a different branch than the pickup
+type OneWayFee struct {
+	gorm.Model
+	FromBranchID uint `gorm:"not null;uniqueIndex:idx_one_way_route"`
+	FromBranch   Branch
+	ToBranchID   uint `gorm:"not null;uniqueIndex:idx_one_way_route"`
+	ToBranch     Branch
+	Fee          float64 `gorm:"not null"`
+}
+
+func (b *Branch) IsOpenOn(t time.Time) bool {
+	for _, oh := range b.OpeningHours {
+		if oh.Weekday == int(t.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// open at the time of day, in the timezone of the time
+func (b *Branch) IsOpenAt(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	for _, oh := range b.OpeningHours {
+		if oh.Weekday != int(t.Weekday()) {
+			continue
+		}
+		opens, err := time.Parse("15:04", oh.OpensAt)
+		if err != nil {
+			continue
+		}
+		closes, err := time.Parse("15:04", oh.ClosesAt)
+		if err != nil {
+			continue
+		}
+		if minute >= opens.Hour()*60+opens.Minute() && minute <= closes.Hour()*60+closes.Minute() {
+			return true
+		}
+	}
+	return false
+}
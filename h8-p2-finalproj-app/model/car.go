@@ -15,8 +15,15 @@ type Car struct {
 	Manufacturer string
 	CarModel     string
 	Year         uint
-	Stock        uint
+	Stock        uint // no longer used for availability, see VehicleUnit
 	RatePerDay   float64
+	// charged for the hours left over after whole days, up to the day
+	// rate, rentals without an hourly rate are charged by the day
+	RatePerHour float64
+	Images      []CarImage
+	// aggregated from the reviews of the car
+	AverageRating float64
+	NumOfReviews  uint
 }
 
 func (c *Car) GetCarName() string {
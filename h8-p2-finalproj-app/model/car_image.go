@@ -0,0 +1,17 @@
+package model
+
+import "gorm.io/gorm"
+
+// a photo in the gallery of the car, shown by position
+type CarImage struct {
+	gorm.Model
+	CarID        uint   `gorm:"not null;index"`
+	URL          string `gorm:"not null"`
+	Position     int    `gorm:"not null"`
+	ThumbnailURL string
+	// storage keys of uploaded images
+	Key          string
+	ThumbnailKey string
+	ContentType  string
+	Size         int64
+}
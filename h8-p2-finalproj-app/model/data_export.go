@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DataExportPending    = "Pending"
+	DataExportProcessing = "Processing"
+	DataExportCompleted  = "Completed"
+	DataExportFailed     = "Failed"
+	DataExportExpired    = "Expired"
+)
+
+// archive of the personal data of a user, for data subject requests
+type DataExport struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	Status   string `gorm:"not null"`
+	FilePath string
+	// hash of the token in the download link, a new link replaces it
+	DownloadTokenHash string `gorm:"index"`
+	Error             string
+	CompletedAt       *time.Time
+	ExpiresAt         *time.Time
+}
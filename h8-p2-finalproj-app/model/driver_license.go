@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	LicensePending  = "Pending"
+	LicenseApproved = "Approved"
+	LicenseRejected = "Rejected"
+)
+
+// a driver license (SIM) uploaded by a user, reviewed by staff before rentals
+// can be picked up. the image is kept in the private document storage
+type DriverLicense struct {
+	gorm.Model
+	UserID       uint `gorm:"not null;index"`
+	User         User
+	Number       string    `gorm:"not null"`
+	ExpiresOn    time.Time `gorm:"not null"` // the date only
+	ImageKey     string    `gorm:"not null"`
+	ContentType  string
+	Status       string `gorm:"not null"`
+	ReviewedByID *uint
+	ReviewedAt   *time.Time
+	RejectReason string
+}
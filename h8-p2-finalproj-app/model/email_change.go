@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// pending email change, applied once the new address is verified
+type EmailChangeRequest struct {
+	gorm.Model
+	UserID      uint `gorm:"not null;index"`
+	User        User
+	NewEmail    string    `gorm:"not null"`
+	TokenHash   string    `gorm:"not null;unique"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	ConfirmedAt *time.Time
+}
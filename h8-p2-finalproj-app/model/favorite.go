@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a car saved by a user, removed for good so it can be saved again
+type Favorite struct {
+	gorm.Model
+	UserID uint `gorm:"not null;uniqueIndex:idx_favorite_user_car"`
+	CarID  uint `gorm:"not null;uniqueIndex:idx_favorite_user_car"`
+	Car    Car
+}
+
+// notifies the user once when a saved car becomes available for the period
+type AvailabilityWatch struct {
+	gorm.Model
+	UserID         uint `gorm:"not null;index"`
+	User           User
+	CarID          uint `gorm:"not null;index"`
+	Car            Car
+	StartDate      time.Time `gorm:"not null"`
+	EndDate        time.Time `gorm:"not null"`
+	PickupBranchID *uint
+	NotifiedAt     *time.Time
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InspectionPickup = "pickup"
+	InspectionReturn = "return"
+)
+
+const (
+	ConditionOK      = "ok"
+	ConditionDamaged = "damaged"
+	ConditionMissing = "missing"
+)
+
+// the state of the car when it is handed over or taken back, one of each
+// per rental
+type InspectionReport struct {
+	gorm.Model
+	RentalID    uint `gorm:"not null;uniqueIndex:idx_inspection_rental_kind"`
+	Rental      Rental
+	Kind        string `gorm:"not null;uniqueIndex:idx_inspection_rental_kind"`
+	InspectorID uint   `gorm:"not null"`
+	Inspector   User
+	Odometer    uint `gorm:"not null"`
+	FuelLevel   uint `gorm:"not null"` // percent of a full tank
+	Notes       string
+	Items       []InspectionItem
+	Photos      []InspectionPhoto
+	// the customer agreed with the report, optionally commenting on it
+	AcknowledgedAt  *time.Time
+	CustomerComment string
+	DamageCharges   []DamageCharge
+}
+
+// a checked part of the car
+type InspectionItem struct {
+	gorm.Model
+	InspectionReportID uint   `gorm:"not null;index"`
+	Item               string `gorm:"not null"`
+	Condition          string `gorm:"not null"`
+	Note               string
+}
+
+// a photo with a caption pointing out what it shows, kept in private storage
+type InspectionPhoto struct {
+	gorm.Model
+	InspectionReportID uint `gorm:"not null;index"`
+	Item               string
+	Caption            string
+	ImageKey           string `gorm:"not null"`
+	ContentType        string
+}
+
+// damage found at return charged to the customer, taken from the security
+// deposit first and invoiced for the rest
+type DamageCharge struct {
+	gorm.Model
+	InspectionReportID uint `gorm:"not null;index"`
+	RentalID           uint `gorm:"not null;index"`
+	UserID             uint `gorm:"not null;index"`
+	User               User
+	Amount             float64 `gorm:"not null"`
+	Reason             string  `gorm:"not null"`
+	FromDeposit        float64
+	Invoiced           float64
+	ChargedByID        uint `gorm:"not null"`
+	// only when part of the charge is invoiced
+	Payment *Payment `gorm:"polymorphicType:PurchaseType;polymorphicId:PurchaseID"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type LoginAttempt struct {
+	gorm.Model
+	Email   string `gorm:"not null;index"`
+	IP      string `gorm:"column:ip;not null;index"`
+	Success bool   `gorm:"not null"`
+}
+
+// lockout events, kept for auditing
+type AccountLockout struct {
+	gorm.Model
+	UserID          uint `gorm:"not null;index"`
+	User            User
+	IP              string `gorm:"column:ip"`
+	Reason          string
+	LockedUntil     time.Time `gorm:"not null"`
+	UnlockTokenHash string    `gorm:"index"`
+	UnlockedAt      *time.Time
+}
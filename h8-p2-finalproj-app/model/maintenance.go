@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a period when units of a car are in the workshop and cannot be rented
+type Maintenance struct {
+	gorm.Model
+	CarID       uint `gorm:"not null;index"`
+	Car         Car
+	StartDate   time.Time `gorm:"not null"`
+	EndDate     time.Time `gorm:"not null"`
+	Reason      string
+	CreatedByID uint
+	CreatedBy   User
+	Units       []VehicleUnit `gorm:"many2many:maintenance_units"`
+}
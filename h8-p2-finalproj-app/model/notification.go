@@ -0,0 +1,13 @@
+package model
+
+import "gorm.io/gorm"
+
+// history of notifications sent to a user. the body is not kept, mails
+// carry tokens and payment links
+type Notification struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Channel   string `gorm:"not null"`
+	Recipient string `gorm:"not null"`
+	Subject   string
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// manages members, limits and invoices of the organization
+	OrgRoleAdmin = "admin"
+	// books rentals on the organization account
+	OrgRoleBooker = "booker"
+	// can be the driver of rentals booked on the organization account
+	OrgRoleDriver = "driver"
+)
+
+// a business renting for its employees, rentals booked on its account are
+// billed with one invoice a month
+type Organization struct {
+	gorm.Model
+	Name         string `gorm:"not null;unique"`
+	BillingEmail string `gorm:"not null"`
+	// spending per calendar month on the account, nil for no limit
+	MonthlyLimit *float64
+	Members      []OrganizationMember
+	Invitations  []OrganizationInvitation
+}
+
+// a user belongs to at most one organization
+type OrganizationMember struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;index"`
+	UserID         uint `gorm:"not null;unique"`
+	User           User
+	Role           string `gorm:"not null"`
+	// spending per calendar month of the rentals the member books, nil for no limit
+	MonthlyLimit *float64
+}
+
+// a user invited by an admin, they become a member once they accept it
+type OrganizationInvitation struct {
+	gorm.Model
+	OrganizationID uint `gorm:"not null;uniqueIndex:idx_org_invitation_user"`
+	Organization   Organization
+	UserID         uint `gorm:"not null;uniqueIndex:idx_org_invitation_user"`
+	User           User
+	Role           string `gorm:"not null"`
+	// the monthly limit of the member once accepted
+	MonthlyLimit *float64
+}
+
+// the consolidated invoice of a month, for rentals ending in that month
+type OrganizationInvoice struct {
+	gorm.Model
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_org_invoice_period"`
+	Period         string    `gorm:"not null;uniqueIndex:idx_org_invoice_period"` // YYYY-MM
+	PeriodStart    time.Time `gorm:"not null"`
+	PeriodEnd      time.Time `gorm:"not null"`
+	Total          float64   `gorm:"not null"`
+	Rentals        []Rental
+	Payment        Payment `gorm:"polymorphicType:PurchaseType;polymorphicId:PurchaseID"`
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PricingRuleWeekend  = "Weekend"
+	PricingRuleSeason   = "Season"
+	PricingRuleLongStay = "LongStay"
+)
+
+// adjusts the price of rentals, car specific rules
+// replace the general rules of the same type for that car
+type PricingRule struct {
+	gorm.Model
+	Type  string `gorm:"not null;index"`
+	Name  string `gorm:"not null"`
+	CarID *uint  `gorm:"index"`
+	Car   *Car
+	// weekend and season
+	Multiplier float64
+	// weekend, a fixed rate per day instead of the multiplier
+	Rate *float64
+	// season, inclusive
+	StartDate *time.Time
+	EndDate   *time.Time
+	// long stay
+	MinDays         uint
+	DiscountPercent float64
+}
+
+// an itemized line of the rental price
+type RentalPriceItem struct {
+	gorm.Model
+	RentalID    uint   `gorm:"not null;index"`
+	Description string `gorm:"not null"`
+	Quantity    uint
+	UnitPrice   float64
+	Amount      float64 `gorm:"not null"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DiscountPercentage = "Percentage"
+	DiscountFixed      = "Fixed"
+)
+
+// a promo code for a marketing campaign
+type Promotion struct {
+	gorm.Model
+	Code          string `gorm:"not null;unique"`
+	Description   string
+	DiscountType  string  `gorm:"not null"`
+	DiscountValue float64 `gorm:"not null"`
+	MaxDiscount   float64 // caps percentage discounts, 0 for no cap
+	MinSpend      float64
+	StartsAt      time.Time `gorm:"not null"`
+	EndsAt        time.Time `gorm:"not null"`
+	UsageLimit    uint      // 0 for unlimited
+	PerUserLimit  uint      // 0 for unlimited
+	CarTypes      string    // comma separated, empty for all types
+	Active        bool      `gorm:"not null"`
+}
+
+type PromotionRedemption struct {
+	gorm.Model
+	PromotionID uint `gorm:"not null;index"`
+	Promotion   Promotion
+	UserID      uint `gorm:"not null;index"`
+	RentalID    uint `gorm:"not null"`
+	Discount    float64
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a price offered to a user before booking, booking with it charges exactly this price
+type Quote struct {
+	gorm.Model
+	UserID          uint `gorm:"not null;index"`
+	User            User
+	CarID           uint `gorm:"not null"`
+	Car             Car
+	StartDate       time.Time `gorm:"not null"`
+	EndDate         time.Time `gorm:"not null"`
+	PickupBranchID  *uint
+	DropoffBranchID *uint
+	OneWayFee       float64
+	TotalPrice      float64   `gorm:"not null"`
+	ExpiresAt       time.Time `gorm:"not null"`
+	UsedAt          *time.Time
+	RentalID        *uint
+	PromotionID     *uint
+	Discount        float64
+	Items           []QuoteItem
+	AddOns          []QuoteAddOn
+	// pickup and return times were given, branches are checked at the times
+	Timed bool `gorm:"not null;default:false"`
+}
+
+type QuoteItem struct {
+	gorm.Model
+	QuoteID     uint   `gorm:"not null;index"`
+	Description string `gorm:"not null"`
+	Quantity    uint
+	UnitPrice   float64
+	Amount      float64 `gorm:"not null"`
+}
@@ -16,4 +16,29 @@ type Rental struct {
 	EndDate    time.Time
 	TotalPrice float64
 	Payment    Payment `gorm:"polymorphicType:PurchaseType;polymorphicId:PurchaseID"`
+	// assigned at confirmation or pickup
+	VehicleUnitID *uint
+	VehicleUnit   *VehicleUnit
+	// why no unit could be assigned at confirmation, cleared once one is
+	UnitAssignmentError string
+	PickedUpAt          *time.Time
+	// pickup and drop off branches differ for one way rentals
+	PickupBranchID  *uint
+	PickupBranch    *Branch
+	DropoffBranchID *uint
+	DropoffBranch   *Branch
+	OneWayFee       float64
+	ReturnedAt      *time.Time
+	PriceItems      []RentalPriceItem
+	PromotionID     *uint
+	Discount        float64
+	AddOns          []RentalAddOn
+	// booked on an organization account, billed with its monthly invoice
+	OrganizationID        *uint
+	OrganizationInvoiceID *uint
+	// the member driving, when booked for someone else
+	DriverID *uint
+	Driver   *User
+	// nil when no deposit is required for the car type
+	SecurityDeposit *SecurityDeposit
 }
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a customer's review of a car after a completed rental, one per rental
+type Review struct {
+	gorm.Model
+	UserID   uint `gorm:"not null;index"`
+	User     User
+	RentalID uint `gorm:"not null;unique"`
+	CarID    uint `gorm:"not null;index"`
+	Rating   int  `gorm:"not null"` // 1 to 5
+	Text     string
+	Photos   []ReviewPhoto
+	// hidden reviews are kept for the record but not shown or counted
+	Hidden       bool `gorm:"not null;default:false"`
+	HiddenReason string
+	HiddenByID   *uint
+	HiddenAt     *time.Time
+}
+
+type ReviewPhoto struct {
+	gorm.Model
+	ReviewID     uint   `gorm:"not null;index"`
+	URL          string `gorm:"not null"`
+	ThumbnailURL string
+	Key          string
+	ThumbnailKey string
+}
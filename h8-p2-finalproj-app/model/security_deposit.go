@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// reserved from the user's wallet balance
+	DepositMethodWallet = "wallet"
+	// paid with its own invoice at the payment gateway
+	DepositMethodInvoice = "invoice"
+)
+
+const (
+	// invoiced and not paid yet
+	DepositPending = "Pending"
+	DepositHeld    = "Held"
+	// returned to the user's wallet in full
+	DepositReleased = "Released"
+	// partly or fully kept for damages, the rest returned to the wallet
+	DepositCaptured = "Captured"
+	// the rental was cancelled or the invoice expired before it was paid
+	DepositCancelled = "Cancelled"
+)
+
+// security deposit required for cars of a type, matched case insensitively
+type SecurityDepositRate struct {
+	gorm.Model
+	CarType string  `gorm:"not null;unique"`
+	Amount  float64 `gorm:"not null"`
+}
+
+// the refundable deposit of a rental, held until after the car is returned
+type SecurityDeposit struct {
+	gorm.Model
+	RentalID uint `gorm:"not null;unique"`
+	UserID   uint `gorm:"not null;index"`
+	User     User
+	Amount   float64 `gorm:"not null"`
+	Method   string  `gorm:"not null"`
+	Status   string  `gorm:"not null"`
+	// kept for damages, with the reason given by staff
+	CapturedAmount float64
+	CaptureReason  string
+	CapturedByID   *uint
+	// when it was released or captured
+	SettledAt *time.Time
+	// only for deposits paid by invoice
+	Payment *Payment `gorm:"polymorphicType:PurchaseType;polymorphicId:PurchaseID"`
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// refresh tokens are stored hashed, each rotation creates a new
+// row within the same family
+type RefreshToken struct {
+	gorm.Model
+	UserID          uint `gorm:"not null"`
+	User            User
+	FamilyID        string `gorm:"not null;index"`
+	TokenHash       string `gorm:"not null;unique"`
+	AccessTokenID   string
+	AccessExpiresAt time.Time
+	TwoFactor       bool      `gorm:"not null;default:false"`
+	ExpiresAt       time.Time `gorm:"not null"`
+	UsedAt          *time.Time
+	RevokedAt       *time.Time
+}
+
+// access tokens revoked before they expire, looked up by jti
+type RevokedToken struct {
+	gorm.Model
+	JTI       string    `gorm:"column:jti;not null;unique"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
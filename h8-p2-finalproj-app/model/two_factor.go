@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TwoFactorAuth struct {
+	gorm.Model
+	UserID       uint `gorm:"not null;unique"`
+	User         User
+	Secret       string `gorm:"not null"`
+	Enabled      bool   `gorm:"not null"`
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// second step of a login when 2fa is enabled
+type LoginChallenge struct {
+	gorm.Model
+	UserID    uint `gorm:"not null"`
+	User      User
+	TokenHash string    `gorm:"not null;unique"`
+	ExpiresAt time.Time `gorm:"not null"`
+	Attempts  uint      `gorm:"not null"`
+	UsedAt    *time.Time
+}
@@ -2,6 +2,12 @@ package model
 
 import "gorm.io/gorm"
 
+const (
+	RoleUser  = "user"
+	RoleStaff = "staff"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	gorm.Model
 	Name     string  `gorm:"not null"`
@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	UnitStatusAvailable   = "Available"
+	UnitStatusMaintenance = "Maintenance"
+	UnitStatusRetired     = "Retired"
+)
+
+// a physical car of a car model
+type VehicleUnit struct {
+	gorm.Model
+	CarID       uint `gorm:"not null;index"`
+	Car         Car
+	BranchID    *uint `gorm:"index"` // where the unit currently is
+	Branch      *Branch
+	PlateNumber string `gorm:"not null;unique"`
+	VIN         string `gorm:"column:vin;not null;unique"`
+	Color       string
+	Odometer    uint   `gorm:"not null"`
+	Status      string `gorm:"not null"`
+}
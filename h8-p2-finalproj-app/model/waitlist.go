@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WaitlistWaiting   = "Waiting"
+	WaitlistHeld      = "Held"
+	WaitlistBooked    = "Booked"
+	WaitlistLapsed    = "Lapsed" // the hold ran out before booking
+	WaitlistExpired   = "Expired"
+	WaitlistCancelled = "Cancelled"
+)
+
+// a user queued for a fully booked car, the first in line gets a unit held
+// for them when one is freed
+type WaitlistEntry struct {
+	gorm.Model
+	UserID         uint `gorm:"not null;index"`
+	User           User
+	CarID          uint `gorm:"not null;index"`
+	Car            Car
+	StartDate      time.Time `gorm:"not null"`
+	EndDate        time.Time `gorm:"not null"`
+	PickupBranchID *uint
+	Status         string `gorm:"not null"`
+	HoldExpiresAt  *time.Time
+	RentalID       *uint
+}
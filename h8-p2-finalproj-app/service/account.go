@@ -0,0 +1,247 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const EmailChangeTTL = 24 * time.Hour
+
+var (
+	ErrEmailAlreadyRegistered  = errors.New("email already registered")
+	ErrInvalidEmailChangeToken = errors.New("invalid email change token")
+	ErrActiveRentals           = errors.New("user has active rentals")
+)
+
+type AccountService struct {
+	db *gorm.DB
+	ts *TokenService
+}
+
+func NewAccountService(db *gorm.DB, ts *TokenService) *AccountService {
+	return &AccountService{
+		db: db,
+		ts: ts,
+	}
+}
+
+func (as *AccountService) isEmailRegistered(tx *gorm.DB, email string) (bool, error) {
+	var count int64
+	err := tx.Model(&model.User{}).Where("email=?", email).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// creates a pending email change, returns the token to send to the new address
+func (as *AccountService) RequestEmailChange(user *model.User, newEmail string) (string, error) {
+	registered, err := as.isEmailRegistered(as.db, newEmail)
+	if err != nil {
+		return "", err
+	}
+	if registered {
+		return "", ErrEmailAlreadyRegistered
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = as.db.Create(&model.EmailChangeRequest{
+		UserID:    user.ID,
+		NewEmail:  newEmail,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(EmailChangeTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// applies the email change for the token, returns the updated user and the old email
+func (as *AccountService) ConfirmEmailChange(token string) (*model.User, string, error) {
+	var user model.User
+	var oldEmail string
+	err := as.db.Transaction(func(tx *gorm.DB) error {
+		var req model.EmailChangeRequest
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("User").
+			Where("token_hash=? AND confirmed_at IS NULL", hashToken(token)).
+			First(&req).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidEmailChangeToken
+		} else if err != nil {
+			return err
+		}
+		if time.Now().After(req.ExpiresAt) || req.User.ID == 0 {
+			return ErrInvalidEmailChangeToken
+		}
+
+		// may have been taken since the request
+		registered, err := as.isEmailRegistered(tx, req.NewEmail)
+		if err != nil {
+			return err
+		}
+		if registered {
+			return ErrEmailAlreadyRegistered
+		}
+
+		now := time.Now()
+		req.ConfirmedAt = &now
+		err = tx.Save(&req).Error
+		if err != nil {
+			return err
+		}
+
+		user = req.User
+		oldEmail = user.Email
+		user.Email = req.NewEmail
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, oldEmail, nil
+}
+
+// changes the password and ends all other sessions, the session of the
+// access token with the jti is kept
+func (as *AccountService) ChangePassword(user *model.User, newPassword string, keepJTI string) error {
+	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	if err != nil {
+		return err
+	}
+	err = as.db.Model(user).Update("password", string(passHash)).Error
+	if err != nil {
+		return err
+	}
+	return as.ts.RevokeOtherSessions(user.ID, keepJTI)
+}
+
+// rentals booked or driven by the user that still hold a car: not returned
+// yet, and either picked up, overdue included, or not ended yet
+func countActiveRentals(tx *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&model.Rental{}).
+		Joins("left join payments on payments.purchase_id = rentals.id AND payments.purchase_type = ?", "rentals").
+		Where("rentals.user_id=? OR rentals.driver_id=?", userID, userID).
+		Where("rentals.returned_at IS NULL").
+		Where("rentals.picked_up_at IS NOT NULL OR rentals.end_date >= ?", time.Now().Truncate(24*time.Hour)).
+		Where("payments.status IN ?", activeRentalPaymentStatuses).
+		Count(&count).Error
+	return count, err
+}
+
+// the organization of a deleted admin still needs someone to manage it
+func checkNotLastOrgAdmin(tx *gorm.DB, userID uint) error {
+	var member model.OrganizationMember
+	err := tx.Where("user_id=? AND role=?", userID, model.OrgRoleAdmin).First(&member).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var admins int64
+	err = tx.Model(&model.OrganizationMember{}).
+		Where("organization_id=? AND role=? AND user_id<>?", member.OrganizationID, model.OrgRoleAdmin, userID).
+		Count(&admins).Error
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastOrgAdmin
+	}
+	return nil
+}
+
+// anonymizes personal data and soft deletes the user.
+// rentals, top ups and payments are kept for financial records
+func (as *AccountService) DeleteAccount(user *model.User) error {
+	var exportPaths []string
+	err := as.db.Model(&model.DataExport{}).
+		Where("user_id=? AND file_path <> ''", user.ID).
+		Pluck("file_path", &exportPaths).Error
+	if err != nil {
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		// checked within the transaction, with the user locked
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", user.ID).First(&model.User{}).Error
+		if err != nil {
+			return err
+		}
+		active, err := countActiveRentals(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrActiveRentals
+		}
+		err = checkNotLastOrgAdmin(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		anonEmail := fmt.Sprintf("deleted-user-%d@deleted.invalid", user.ID)
+		err = tx.Model(&model.LoginAttempt{}).
+			Where("email=?", user.Email).
+			Update("email", anonEmail).Error
+		if err != nil {
+			return err
+		}
+
+		for _, m := range []any{
+			&model.TwoFactorAuth{},
+			&model.RecoveryCode{},
+			&model.EmailChangeRequest{},
+			&model.Notification{},
+			&model.DataExport{},
+			&model.Favorite{},
+			&model.AvailabilityWatch{},
+			&model.WaitlistEntry{},
+			&model.OrganizationMember{},
+			&model.OrganizationInvitation{},
+		} {
+			err = tx.Unscoped().Where("user_id=?", user.ID).Delete(m).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		// unusable password
+		password, err := randomToken(32)
+		if err != nil {
+			return err
+		}
+		passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(user).Updates(map[string]any{
+			"name":     "Deleted User",
+			"email":    anonEmail,
+			"password": string(passHash),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range exportPaths {
+		os.Remove(p)
+	}
+	return as.ts.RevokeAllForUser(user.ID)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAddOnNotFound    = errors.New("add on not found")
+	ErrAddOnUnavailable = errors.New("add on out of stock for dates")
+)
+
+type AddOnService struct {
+	db *gorm.DB
+}
+
+func NewAddOnService(db *gorm.DB) *AddOnService {
+	return &AddOnService{
+		db: db,
+	}
+}
+
+type AddOnReq struct {
+	AddOnID  uint
+	Quantity uint
+}
+
+// price of the add on for the rental days
+func AddOnAmount(addOn *model.AddOn, quantity uint, days uint) float64 {
+	if addOn.PricingType == model.AddOnPerDay {
+		return roundPrice(addOn.Price * float64(quantity*days))
+	}
+	return roundPrice(addOn.Price * float64(quantity))
+}
+
+// quantity of the add on attached to active rentals overlapping the dates
+func (aos *AddOnService) reservedQuantity(tx *gorm.DB, addOnID uint, startDate time.Time, endDate time.Time) (uint, error) {
+	var reserved uint
+	q := tx.Model(&model.RentalAddOn{}).
+		Select("COALESCE(SUM(rental_add_ons.quantity), 0)").
+		Joins("join rentals on rentals.id = rental_add_ons.rental_id").
+		Joins("left join payments on payments.purchase_id = rentals.id AND payments.purchase_type = ?", "rentals").
+		Where("rental_add_ons.add_on_id=? AND rentals.returned_at IS NULL", addOnID).
+		Where("payments.status IN ?", activeRentalPaymentStatuses)
+	err := whereRentalOverlaps(q, "rentals", startDate, endDate).Scan(&reserved).Error
+	return reserved, err
+}
+
+func (aos *AddOnService) checkInventory(tx *gorm.DB, addOn *model.AddOn, quantity uint, startDate time.Time, endDate time.Time) error {
+	if addOn.Inventory == nil {
+		return nil
+	}
+	reserved, err := aos.reservedQuantity(tx, addOn.ID, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	if reserved+quantity > *addOn.Inventory {
+		return fmt.Errorf("%w: %s", ErrAddOnUnavailable, addOn.Name)
+	}
+	return nil
+}
+
+func (aos *AddOnService) GetActiveAddOns() ([]model.AddOn, error) {
+	var addOns []model.AddOn
+	err := aos.db.Where("active = ?", true).Order("id").Find(&addOns).Error
+	return addOns, err
+}
+
+// checks the add ons are in stock and adds them to the breakdown
+func (aos *AddOnService) Price(reqs []AddOnReq, startDate time.Time, endDate time.Time, breakdown *PriceBreakdown) ([]model.RentalAddOn, error) {
+	// merge repeated add ons
+	quantities := map[uint]uint{}
+	order := []uint{}
+	for _, r := range reqs {
+		if _, ok := quantities[r.AddOnID]; !ok {
+			order = append(order, r.AddOnID)
+		}
+		quantities[r.AddOnID] += r.Quantity
+	}
+
+	rentalAddOns := []model.RentalAddOn{}
+	for _, id := range order {
+		var addOn model.AddOn
+		err := aos.db.Where("id=? AND active = ?", id, true).First(&addOn).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAddOnNotFound
+		} else if err != nil {
+			return nil, err
+		}
+
+		quantity := quantities[id]
+		err = aos.checkInventory(aos.db, &addOn, quantity, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+
+		amount := AddOnAmount(&addOn, quantity, breakdown.Days)
+		if addOn.PricingType == model.AddOnPerDay {
+			breakdown.AddLine(fmt.Sprintf("%s x%d, per day", addOn.Name, quantity), quantity*breakdown.Days, addOn.Price)
+		} else {
+			breakdown.AddLine(addOn.Name, quantity, addOn.Price)
+		}
+		rentalAddOns = append(rentalAddOns, model.RentalAddOn{
+			AddOnID:  addOn.ID,
+			AddOn:    addOn,
+			Quantity: quantity,
+			Amount:   amount,
+		})
+	}
+	return rentalAddOns, nil
+}
+
+// attaches the add ons to the rental, stock is checked again with
+// the add on locked so concurrent bookings can't exceed it
+func (aos *AddOnService) Reserve(tx *gorm.DB, rental *model.Rental, addOns []model.RentalAddOn) error {
+	for i := range addOns {
+		var addOn model.AddOn
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", addOns[i].AddOnID).First(&addOn).Error
+		if err != nil {
+			return err
+		}
+		err = aos.checkInventory(tx, &addOn, addOns[i].Quantity, rental.StartDate, rental.EndDate)
+		if err != nil {
+			return err
+		}
+		addOns[i].RentalID = rental.ID
+		err = tx.Omit("AddOn").Create(&addOns[i]).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
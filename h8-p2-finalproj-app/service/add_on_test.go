@@ -0,0 +1,17 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddOnAmount(t *testing.T) {
+	seat := &model.AddOn{PricingType: model.AddOnPerDay, Price: 25000}
+	assert.Equal(t, 150000.0, service.AddOnAmount(seat, 2, 3))
+
+	insurance := &model.AddOn{PricingType: model.AddOnPerRental, Price: 100000}
+	assert.Equal(t, 100000.0, service.AddOnAmount(insurance, 1, 3))
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"h8-p2-finalproj-app/model"
+	"time"
+)
+
+// longest window of the availability calendar
+const MaxCalendarDays = 366
+
+type DayAvailability struct {
+	Date         time.Time
+	NumAvailable uint
+	IsOverbooked bool
+}
+
+// a unit blocked by maintenance
+type MaintenanceUnit struct {
+	VehicleUnitID uint
+	StartDate     time.Time
+	EndDate       time.Time
+}
+
+// what the calendar of a car is counted from, loaded once for the whole window
+type CalendarData struct {
+	NumOfUnits   uint
+	Rentals      []model.Rental
+	Maintenances []MaintenanceUnit
+	// unreturned one way rentals from or to the pickup branch
+	Transfers []model.Rental
+}
+
+// available units for each day from the start of from until to, a day is
+// 24 hours from the time of day of from. uses the same overlap rules as
+// CountRentalsPerCarQuery and CountMaintenancePerCarQuery
+func AvailabilityCalendar(data *CalendarData, branchID *uint, from time.Time, to time.Time, buffer time.Duration) []DayAvailability {
+	days := []DayAvailability{}
+	for dayStart := from; dayStart.Before(to); dayStart = dayStart.AddDate(0, 0, 1) {
+		dayEnd := dayStart.AddDate(0, 0, 1)
+
+		acd := AvailableCarData{NumOfUnits: data.NumOfUnits}
+		for _, r := range data.Rentals {
+			if r.StartDate.Before(dayEnd.Add(buffer)) && r.EndDate.After(dayStart.Add(-buffer)) {
+				acd.NumOfRentals++
+			}
+		}
+		inMaintenance := map[uint]bool{}
+		for _, m := range data.Maintenances {
+			if m.StartDate.Before(dayEnd) && m.EndDate.After(dayStart.AddDate(0, 0, -1)) {
+				inMaintenance[m.VehicleUnitID] = true
+			}
+		}
+		acd.NumInMaintenance = uint(len(inMaintenance))
+		if branchID != nil {
+			for _, t := range data.Transfers {
+				if t.EndDate.After(dayStart.Add(-buffer)) {
+					continue
+				}
+				if t.DropoffBranchID != nil && *t.DropoffBranchID == *branchID {
+					acd.NetTransfers++
+				} else {
+					acd.NetTransfers--
+				}
+			}
+		}
+
+		days = append(days, DayAvailability{
+			Date:         dayStart,
+			NumAvailable: acd.NumOfAvailable(),
+			IsOverbooked: acd.IsOverbooked(),
+		})
+	}
+	return days
+}
+
+// loads the active rentals, maintenances and transfers of the car touching the window
+func (cs *CarService) getCalendarData(carID uint, branchID *uint, from time.Time, to time.Time) (*CalendarData, error) {
+	params := &GetCarsQueryParams{PickupBranchID: branchID}
+	data := CalendarData{}
+
+	var units struct {
+		NumOfUnits uint
+	}
+	err := cs.CountUnitsPerCarQuery(params).Where("car_id=?", carID).Scan(&units).Error
+	if err != nil {
+		return nil, err
+	}
+	data.NumOfUnits = units.NumOfUnits
+
+	q := cs.db.Model(&model.Rental{}).Select("rentals.start_date, rentals.end_date").Where("rentals.car_id=?", carID)
+	if branchID != nil {
+		q = q.Where("rentals.pickup_branch_id = ?", *branchID)
+	}
+	q = whereRentalActive(q, "rentals")
+	err = whereRentalOverlaps(q, "rentals", from, to).Find(&data.Rentals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	q = cs.db.Model(&model.Maintenance{}).
+		Select("vehicle_units.id AS vehicle_unit_id, maintenances.start_date, maintenances.end_date").
+		Joins("join maintenance_units on maintenance_units.maintenance_id = maintenances.id").
+		Joins("join vehicle_units on vehicle_units.id = maintenance_units.vehicle_unit_id").
+		Where("vehicle_units.car_id = ?", carID).
+		Where("vehicle_units.status = ? AND vehicle_units.deleted_at IS NULL", model.UnitStatusAvailable)
+	if branchID != nil {
+		q = q.Where("vehicle_units.branch_id = ?", *branchID)
+	}
+	err = whereMaintenanceOverlaps(q, from, to).Scan(&data.Maintenances).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if branchID != nil {
+		q = cs.db.Model(&model.Rental{}).Select("rentals.end_date, rentals.dropoff_branch_id").
+			Where("rentals.car_id=? AND rentals.returned_at IS NULL AND rentals.pickup_branch_id <> rentals.dropoff_branch_id", carID).
+			Where("rentals.pickup_branch_id = ? OR rentals.dropoff_branch_id = ?", *branchID, *branchID).
+			Where("rentals.end_date <= ?", to.Add(-RentalBuffer()))
+		err = whereRentalActive(q, "rentals").Find(&data.Transfers).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &data, nil
+}
+
+// per day availability of the car, at the pickup branch or across all branches when nil.
+// a fixed number of queries whatever the length of the window
+func (cs *CarService) GetAvailabilityCalendar(carID uint, branchID *uint, from time.Time, to time.Time) ([]DayAvailability, error) {
+	data, err := cs.getCalendarData(carID, branchID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return AvailabilityCalendar(data, branchID, from, to, RentalBuffer()), nil
+}
@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAvailabilityCalendar(t *testing.T) {
+	data := &service.CalendarData{
+		NumOfUnits: 2,
+		Rentals: []model.Rental{
+			{StartDate: mustDate("2024-09-02"), EndDate: mustDate("2024-09-04")},
+			{StartDate: mustTime("2024-09-03T10:00:00Z"), EndDate: mustTime("2024-09-03T14:00:00Z")},
+		},
+		Maintenances: []service.MaintenanceUnit{
+			{VehicleUnitID: 1, StartDate: mustDate("2024-09-05"), EndDate: mustDate("2024-09-05")},
+		},
+	}
+	days := service.AvailabilityCalendar(data, nil, mustDate("2024-09-01"), mustDate("2024-09-07"), 0)
+	assert.Len(t, days, 6)
+	expected := []uint{2, 1, 0, 2, 1, 2}
+	for i, d := range days {
+		assert.Equal(t, expected[i], d.NumAvailable, d.Date.Format(time.DateOnly))
+	}
+	assert.True(t, days[2].Date.Equal(mustDate("2024-09-03")))
+}
+
+func TestAvailabilityCalendarBuffer(t *testing.T) {
+	data := &service.CalendarData{
+		NumOfUnits: 1,
+		Rentals: []model.Rental{
+			{StartDate: mustTime("2024-09-01T20:00:00Z"), EndDate: mustTime("2024-09-01T23:30:00Z")},
+		},
+	}
+	days := service.AvailabilityCalendar(data, nil, mustDate("2024-09-01"), mustDate("2024-09-03"), time.Hour)
+	assert.Equal(t, uint(0), days[0].NumAvailable)
+	// still being cleaned at the start of the day
+	assert.Equal(t, uint(0), days[1].NumAvailable)
+}
+
+func TestAvailabilityCalendarTransfers(t *testing.T) {
+	branchID := uint(1)
+	otherID := uint(2)
+	data := &service.CalendarData{
+		NumOfUnits: 1,
+		Transfers: []model.Rental{
+			// arrives at the branch on the 2nd
+			{EndDate: mustDate("2024-09-02"), DropoffBranchID: &branchID},
+			// leaves the branch on the 3rd
+			{EndDate: mustDate("2024-09-03"), DropoffBranchID: &otherID},
+		},
+	}
+	days := service.AvailabilityCalendar(data, &branchID, mustDate("2024-09-01"), mustDate("2024-09-04"), 0)
+	assert.Equal(t, uint(1), days[0].NumAvailable)
+	assert.Equal(t, uint(2), days[1].NumAvailable)
+	assert.Equal(t, uint(1), days[2].NumAvailable)
+}
+
+func TestGetAvailabilityCalendarOnlyActiveRentals(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	t.Setenv("RENTAL_BUFFER_MINUTES", "")
+	cs := service.NewCarService(db)
+	car, _ := CreateTestCar(t, db, 2, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	CreateTestRental(t, db, user, car, mustDate("2030-03-02"), mustDate("2030-03-04"), "Completed")
+	CreateTestRental(t, db, user, car, mustDate("2030-03-02"), mustDate("2030-03-04"), service.PaymentStatusRefunded)
+	CreateTestRental(t, db, user, car, mustDate("2030-03-03"), mustDate("2030-03-04"), service.PaymentStatusExpired)
+
+	days, err := cs.GetAvailabilityCalendar(car.ID, nil, mustDate("2030-03-01"), mustDate("2030-03-05"))
+	assert.NoError(t, err)
+	assert.Len(t, days, 4)
+	expected := []uint{2, 1, 1, 2}
+	for i, d := range days {
+		assert.Equal(t, expected[i], d.NumAvailable, d.Date.Format(time.DateOnly))
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrBranchNotFound = errors.New("branch not found")
+	ErrBranchClosed   = errors.New("branch closed on date")
+)
+
+type BranchService struct {
+	db *gorm.DB
+}
+
+func NewBranchService(db *gorm.DB) *BranchService {
+	return &BranchService{
+		db: db,
+	}
+}
+
+func (bs *BranchService) GetBranches() ([]model.Branch, error) {
+	var branches []model.Branch
+	err := bs.db.Preload("OpeningHours", func(db *gorm.DB) *gorm.DB {
+		return db.Order("weekday")
+	}).Order("id").Find(&branches).Error
+	return branches, err
+}
+
+func (bs *BranchService) GetBranch(id uint) (*model.Branch, error) {
+	var branch model.Branch
+	err := bs.db.Preload("OpeningHours", func(db *gorm.DB) *gorm.DB {
+		return db.Order("weekday")
+	}).Where("id=?", id).First(&branch).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBranchNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+// gets the branch and checks it is open on the date
+func (bs *BranchService) GetOpenBranch(id uint, date time.Time) (*model.Branch, error) {
+	branch, err := bs.GetBranch(id)
+	if err != nil {
+		return nil, err
+	}
+	if !branch.IsOpenOn(date) {
+		return nil, ErrBranchClosed
+	}
+	return branch, nil
+}
+
+// gets the branch and checks it is open at the time of day
+func (bs *BranchService) GetOpenBranchAt(id uint, t time.Time) (*model.Branch, error) {
+	branch, err := bs.GetBranch(id)
+	if err != nil {
+		return nil, err
+	}
+	if !branch.IsOpenAt(t) {
+		return nil, ErrBranchClosed
+	}
+	return branch, nil
+}
+
+// replaces the opening hours of the branch
+func (bs *BranchService) SetOpeningHours(branch *model.Branch, hours []model.BranchOpeningHour) error {
+	return bs.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("branch_id=?", branch.ID).Delete(&model.BranchOpeningHour{}).Error
+		if err != nil {
+			return err
+		}
+		for i := range hours {
+			hours[i].BranchID = branch.ID
+		}
+		if len(hours) > 0 {
+			err = tx.Create(&hours).Error
+			if err != nil {
+				return err
+			}
+		}
+		branch.OpeningHours = hours
+		return nil
+	})
+}
+
+// fee of routes without their own fee, ONE_WAY_FEE, 0 when not set.
+// checked at startup so a typo doesn't make one way rentals free
+func DefaultOneWayFee() (float64, error) {
+	value := os.Getenv("ONE_WAY_FEE")
+	if value == "" {
+		return 0, nil
+	}
+	fee, err := strconv.ParseFloat(value, 64)
+	if err != nil || fee < 0 {
+		return 0, fmt.Errorf("invalid ONE_WAY_FEE %q", value)
+	}
+	return fee, nil
+}
+
+// fee for dropping off at another branch, the route's own fee
+// or ONE_WAY_FEE if the route has none
+func (bs *BranchService) GetOneWayFee(fromID uint, toID uint) (float64, error) {
+	if fromID == toID {
+		return 0, nil
+	}
+	var fee model.OneWayFee
+	err := bs.db.Where("from_branch_id=? AND to_branch_id=?", fromID, toID).First(&fee).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return DefaultOneWayFee()
+	} else if err != nil {
+		return 0, err
+	}
+	return fee.Fee, nil
+}
+
+// creates or updates the fee of a route
+func (bs *BranchService) SetOneWayFee(fromID uint, toID uint, amount float64) (*model.OneWayFee, error) {
+	fee := model.OneWayFee{
+		FromBranchID: fromID,
+		ToBranchID:   toID,
+		Fee:          amount,
+	}
+	err := bs.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "from_branch_id"}, {Name: "to_branch_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"fee", "updated_at"}),
+	}).Create(&fee).Error
+	if err != nil {
+		return nil, err
+	}
+	return &fee, nil
+}
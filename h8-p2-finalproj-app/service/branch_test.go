@@ -0,0 +1,179 @@
+package service_test
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// open on mondays from 08:00 to 17:00 and on saturdays from 09:00 to 12:00
+func testOpeningHours() []model.BranchOpeningHour {
+	return []model.BranchOpeningHour{
+		{Weekday: int(time.Monday), OpensAt: "08:00", ClosesAt: "17:00"},
+		{Weekday: int(time.Saturday), OpensAt: "09:00", ClosesAt: "12:00"},
+	}
+}
+
+// a new branch with the test opening hours
+func createTestBranch(t *testing.T, db *gorm.DB, bs *service.BranchService) *model.Branch {
+	branch := model.Branch{
+		Name:    fmt.Sprintf("Test Branch %d", time.Now().UnixNano()),
+		Address: "Jl. Test 1",
+		City:    "Jakarta",
+	}
+	err := db.Create(&branch).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.SetOpeningHours(&branch, testOpeningHours())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &branch
+}
+
+func TestIsOpenAt(t *testing.T) {
+	branch := model.Branch{OpeningHours: testOpeningHours()}
+
+	cases := []struct {
+		at   string
+		open bool
+	}{
+		// 2030-03-04 is a monday
+		{"2030-03-04T07:59:00+07:00", false},
+		{"2030-03-04T08:00:00+07:00", true},
+		{"2030-03-04T12:30:00+07:00", true},
+		{"2030-03-04T17:00:00+07:00", true},
+		{"2030-03-04T17:01:00+07:00", false},
+		{"2030-03-05T10:00:00+07:00", false},
+		{"2030-03-09T11:00:00+07:00", true},
+		{"2030-03-09T13:00:00+07:00", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.open, branch.IsOpenAt(mustTime(c.at)), c.at)
+	}
+
+	// hours are in the timezone of the time, 01:00 UTC is 08:00 in Jakarta
+	assert.False(t, branch.IsOpenAt(mustTime("2030-03-04T01:00:00Z")))
+	assert.True(t, branch.IsOpenOn(mustDate("2030-03-04")))
+	assert.False(t, branch.IsOpenOn(mustDate("2030-03-05")))
+}
+
+func TestDefaultOneWayFee(t *testing.T) {
+	t.Setenv("ONE_WAY_FEE", "")
+	fee, err := service.DefaultOneWayFee()
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), fee)
+
+	t.Setenv("ONE_WAY_FEE", "150000")
+	fee, err = service.DefaultOneWayFee()
+	assert.NoError(t, err)
+	assert.Equal(t, float64(150000), fee)
+
+	for _, value := range []string{"150,000", "IDR 150000", "-1"} {
+		t.Setenv("ONE_WAY_FEE", value)
+		_, err = service.DefaultOneWayFee()
+		assert.Error(t, err, value)
+	}
+}
+
+func TestGetOpenBranchAt(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	bs := service.NewBranchService(db)
+	branch := createTestBranch(t, db, bs)
+
+	open, err := bs.GetOpenBranchAt(branch.ID, mustTime("2030-03-04T10:00:00+07:00"))
+	assert.NoError(t, err)
+	assert.Equal(t, branch.ID, open.ID)
+	assert.Len(t, open.OpeningHours, 2)
+
+	_, err = bs.GetOpenBranchAt(branch.ID, mustTime("2030-03-04T18:00:00+07:00"))
+	assert.ErrorIs(t, err, service.ErrBranchClosed)
+	_, err = bs.GetOpenBranchAt(branch.ID, mustTime("2030-03-05T10:00:00+07:00"))
+	assert.ErrorIs(t, err, service.ErrBranchClosed)
+	// open that day, the time is not checked
+	_, err = bs.GetOpenBranch(branch.ID, mustTime("2030-03-04T18:00:00+07:00"))
+	assert.NoError(t, err)
+
+	_, err = bs.GetOpenBranchAt(0, mustTime("2030-03-04T10:00:00+07:00"))
+	assert.ErrorIs(t, err, service.ErrBranchNotFound)
+}
+
+func TestNetTransfersPerCar(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	t.Setenv("RENTAL_BUFFER_MINUTES", "60")
+	bs := service.NewBranchService(db)
+	cs := service.NewCarService(db)
+	from := createTestBranch(t, db, bs)
+	to := createTestBranch(t, db, bs)
+	car, _ := CreateTestCar(t, db, 2, &from.ID)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	// one way rentals ending on 2030-03-02 10:00, only the paid one moves a unit
+	for _, status := range []string{"Completed", service.PaymentStatusCancelled} {
+		rental := CreateTestRental(t, db, user, car,
+			mustTime("2030-03-01T10:00:00Z"), mustTime("2030-03-02T10:00:00Z"), status)
+		err := db.Model(rental).Updates(map[string]any{
+			"pickup_branch_id":  from.ID,
+			"dropoff_branch_id": to.ID,
+		}).Error
+		assert.NoError(t, err)
+	}
+
+	availability := func(branchID uint, start string) *service.AvailableCarData {
+		startDate := mustTime(start)
+		endDate := startDate.AddDate(0, 0, 1)
+		res, err := cs.GetCarWithRentals(car.ID, &service.GetCarsQueryParams{
+			StartDate:      &startDate,
+			EndDate:        &endDate,
+			PickupBranchID: &branchID,
+		})
+		assert.NoError(t, err)
+		return res
+	}
+
+	res := availability(to.ID, "2030-03-02T11:00:00Z")
+	assert.Equal(t, uint(0), res.NumOfUnits)
+	assert.Equal(t, 1, res.NetTransfers)
+	assert.Equal(t, uint(1), res.NumOfAvailable())
+
+	res = availability(from.ID, "2030-03-02T11:00:00Z")
+	assert.Equal(t, uint(2), res.NumOfUnits)
+	assert.Equal(t, -1, res.NetTransfers)
+	assert.Equal(t, uint(1), res.NumOfAvailable())
+
+	// within the cleaning buffer the unit has not arrived yet
+	res = availability(to.ID, "2030-03-02T10:30:00Z")
+	assert.Equal(t, 0, res.NetTransfers)
+	assert.Equal(t, uint(0), res.NumOfAvailable())
+
+	// no branch, no transfers
+	startDate := mustTime("2030-03-02T11:00:00Z")
+	endDate := startDate.AddDate(0, 0, 1)
+	res, err := cs.GetCarWithRentals(car.ID, &service.GetCarsQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.NetTransfers)
+
+	// once returned the unit is counted at the drop off branch itself
+	err = db.Model(&model.Rental{}).Where("car_id=?", car.ID).Update("returned_at", time.Now()).Error
+	assert.NoError(t, err)
+	res = availability(to.ID, "2030-03-02T11:00:00Z")
+	assert.Equal(t, 0, res.NetTransfers)
+}
@@ -5,9 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"h8-p2-finalproj-app/model"
+	"os"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCarNotFound = errors.New("car not found")
 )
 
 type CarService struct {
@@ -20,6 +27,31 @@ func NewCarService(db *gorm.DB) *CarService {
 	}
 }
 
+func (cs *CarService) GetCar(id uint) (*model.Car, error) {
+	var car model.Car
+	err := cs.db.Where("id=?", id).First(&car).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCarNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &car, nil
+}
+
+// images of the cars by car id, in gallery order
+func (cs *CarService) GetCarImages(carIDs []uint) (map[uint][]model.CarImage, error) {
+	var images []model.CarImage
+	err := cs.db.Where("car_id IN ?", carIDs).Order("car_id, position, id").Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	result := map[uint][]model.CarImage{}
+	for _, img := range images {
+		result[img.CarID] = append(result[img.CarID], img)
+	}
+	return result, nil
+}
+
 type GetCarsQueryParams struct {
 	StartDate    *time.Time
 	EndDate      *time.Time
@@ -30,29 +62,146 @@ type GetCarsQueryParams struct {
 	Manufacturer []string
 	CarModel     []string
 	Year         *uint
+	// only counts units and rentals of the pickup branch
+	PickupBranchID *uint
+	SortBy         string
+	// full text search words, matching cars are ranked by relevance
+	SearchTerms []string
+}
+
+const (
+	// best rated first, then most reviewed
+	CarSortRating = "rating"
+)
+
+// time kept free between two rentals of a unit for cleaning, RENTAL_BUFFER_MINUTES
+func RentalBuffer() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("RENTAL_BUFFER_MINUTES"))
+	if err != nil || minutes < 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// rentals of the table overlapping the period, to the minute, a rental
+// ending less than the cleaning buffer before the start also overlaps
+func whereRentalOverlaps(q *gorm.DB, table string, startDate time.Time, endDate time.Time) *gorm.DB {
+	buffer := RentalBuffer()
+	return q.Where(
+		fmt.Sprintf("%[1]s.start_date < ? AND %[1]s.end_date > ?", table),
+		endDate.Add(buffer),
+		startDate.Add(-buffer),
+	)
+}
+
+// maintenances overlapping the period, maintenance dates are whole days
+// with the end date included
+func whereMaintenanceOverlaps(q *gorm.DB, startDate time.Time, endDate time.Time) *gorm.DB {
+	return q.Where("maintenances.start_date < ? AND maintenances.end_date > ?", endDate, startDate.AddDate(0, 0, -1))
+}
+
+// rentals of the table that still hold a unit, cancelled, refunded and
+// expired bookings don't
+func whereRentalActive(q *gorm.DB, table string) *gorm.DB {
+	return q.Joins(fmt.Sprintf("join payments on payments.purchase_id = %s.id AND payments.purchase_type = ?", table), "rentals").
+		Where("payments.status IN ?", activeRentalPaymentStatuses)
 }
 
 func (cs *CarService) CountRentalsPerCarQuery(params *GetCarsQueryParams) *gorm.DB {
-	q := cs.db.Model(&model.Rental{}).Select("car_id, COUNT(rentals.id) AS num_of_rentals")
+	q := cs.db.Model(&model.Rental{}).Select("rentals.car_id, COUNT(rentals.id) AS num_of_rentals")
+	q = whereRentalActive(q, "rentals")
 	if params.StartDate != nil && params.EndDate != nil {
-		q = q.
-			Where("start_date >= ? AND start_date <= ?", *params.StartDate, *params.EndDate).
-			Or("end_date >= ? AND end_date <= ?", *params.StartDate, *params.EndDate).
-			Or("start_date <= ? AND end_date >= ?", *params.StartDate, *params.EndDate)
-
+		q = whereRentalOverlaps(q, "rentals", *params.StartDate, *params.EndDate)
 	}
-	q = q.Group("car_id")
+	if params.PickupBranchID != nil {
+		q = q.Where("rentals.pickup_branch_id = ?", *params.PickupBranchID)
+	}
+	q = q.Group("rentals.car_id")
 	return q
 }
 
+// units in service per car, these are what can be rented out
+func (cs *CarService) CountUnitsPerCarQuery(params *GetCarsQueryParams) *gorm.DB {
+	q := cs.db.Model(&model.VehicleUnit{}).
+		Select("car_id AS unit_car_id, COUNT(vehicle_units.id) AS num_of_units").
+		Where("status = ?", model.UnitStatusAvailable)
+	if params.PickupBranchID != nil {
+		q = q.Where("branch_id = ?", *params.PickupBranchID)
+	}
+	return q.Group("car_id")
+}
+
+// units moving in (+1) or out (-1) of the pickup branch by active one way
+// rentals that end before the start date, with the cleaning buffer, but have
+// not been returned yet
+func (cs *CarService) NetTransfersPerCarQuery(params *GetCarsQueryParams) *gorm.DB {
+	branchID := uint(0)
+	if params.PickupBranchID != nil {
+		branchID = *params.PickupBranchID
+	}
+	q := cs.db.Model(&model.Rental{}).
+		Select("rentals.car_id AS transfer_car_id, SUM(CASE WHEN rentals.dropoff_branch_id = ? THEN 1 ELSE -1 END) AS net_transfers", branchID).
+		Where("rentals.returned_at IS NULL AND rentals.pickup_branch_id <> rentals.dropoff_branch_id").
+		Where("rentals.pickup_branch_id = ? OR rentals.dropoff_branch_id = ?", branchID, branchID)
+	q = whereRentalActive(q, "rentals")
+	if params.PickupBranchID == nil || params.StartDate == nil {
+		// without a branch and date, transfers don't change the count
+		q = q.Where("1 = 0")
+	} else {
+		q = q.Where("rentals.end_date <= ?", params.StartDate.Add(-RentalBuffer()))
+	}
+	return q.Group("rentals.car_id")
+}
+
+// units in service per car that are scheduled for maintenance during the dates,
+// or today when no dates are given
+func (cs *CarService) CountMaintenancePerCarQuery(params *GetCarsQueryParams) *gorm.DB {
+	startDate := time.Now().Truncate(24 * time.Hour)
+	endDate := startDate.AddDate(0, 0, 1)
+	if params.StartDate != nil && params.EndDate != nil {
+		startDate = *params.StartDate
+		endDate = *params.EndDate
+	}
+	q := cs.db.Model(&model.Maintenance{}).
+		Select("vehicle_units.car_id AS maintenance_car_id, COUNT(DISTINCT vehicle_units.id) AS num_in_maintenance").
+		Joins("join maintenance_units on maintenance_units.maintenance_id = maintenances.id").
+		Joins("join vehicle_units on vehicle_units.id = maintenance_units.vehicle_unit_id").
+		Where("vehicle_units.status = ? AND vehicle_units.deleted_at IS NULL", model.UnitStatusAvailable)
+	q = whereMaintenanceOverlaps(q, startDate, endDate)
+	if params.PickupBranchID != nil {
+		q = q.Where("vehicle_units.branch_id = ?", *params.PickupBranchID)
+	}
+	return q.Group("vehicle_units.car_id")
+}
+
 func (cs *CarService) CarsWithRentalQuery(params *GetCarsQueryParams) *gorm.DB {
 	countRentalQ := cs.CountRentalsPerCarQuery(params)
+	countUnitQ := cs.CountUnitsPerCarQuery(params)
+	transferQ := cs.NetTransfersPerCarQuery(params)
+	maintenanceQ := cs.CountMaintenancePerCarQuery(params)
 	q := cs.db.
 		Model(&model.Car{}).
-		Joins("left join (?) q on cars.id = q.car_id", countRentalQ)
+		Joins("left join (?) q on cars.id = q.car_id", countRentalQ).
+		Joins("left join (?) u on cars.id = u.unit_car_id", countUnitQ).
+		Joins("left join (?) t on cars.id = t.transfer_car_id", transferQ).
+		Joins("left join (?) m on cars.id = m.maintenance_car_id", maintenanceQ)
 	if params.Seats != nil {
 		q = q.Where("cars.seats >= ?", *params.Seats)
 	}
+	if len(params.SearchTerms) > 0 {
+		tsQuery := carSearchQuery(params.SearchTerms)
+		q = q.Where(carSearchVector+" @@ to_tsquery('simple', ?)", tsQuery)
+		if params.SortBy == "" {
+			q = q.Order(clause.OrderBy{Expression: clause.Expr{
+				SQL:                "ts_rank(" + carSearchVector + ", to_tsquery('simple', ?)) DESC, cars.id",
+				Vars:               []any{tsQuery},
+				WithoutParentheses: true,
+			}})
+		}
+	}
+	if params.SortBy == CarSortRating {
+		q = q.Order("cars.average_rating DESC, cars.num_of_reviews DESC, cars.id")
+	}
 	q = q.Select("*")
 	return q
 }
@@ -62,6 +211,27 @@ type AvailableCarData struct {
 	model.Car
 	CarID        uint
 	NumOfRentals uint
+	NumOfUnits   uint
+	NetTransfers int
+	// units blocked by maintenance
+	NumInMaintenance uint
+}
+
+// can be negative when bookings exceed the units left
+func (acd *AvailableCarData) netAvailable() int {
+	return int(acd.NumOfUnits) + acd.NetTransfers - int(acd.NumInMaintenance) - int(acd.NumOfRentals)
+}
+
+func (acd *AvailableCarData) NumOfAvailable() uint {
+	avail := acd.netAvailable()
+	if avail <= 0 {
+		return 0
+	}
+	return uint(avail)
+}
+
+func (acd *AvailableCarData) IsOverbooked() bool {
+	return acd.netAvailable() < 0
 }
 
 func (cs *CarService) GetCarsWithRentals(params *GetCarsQueryParams) ([]AvailableCarData, error) {
@@ -81,10 +251,28 @@ func (cs *CarService) GetCarsWithRentals(params *GetCarsQueryParams) ([]Availabl
 }
 
 func (cs *CarService) IsCarAvailable(carId uint, startDate time.Time, endDate time.Time) (bool, error) {
+	return cs.IsCarAvailableAtBranch(carId, nil, startDate, endDate)
+}
+
+// availability data of one car for the params
+func (cs *CarService) GetCarWithRentals(carId uint, params *GetCarsQueryParams) (*AvailableCarData, error) {
+	var result AvailableCarData
+	err := cs.CarsWithRentalQuery(params).Where("cars.id=?", carId).First(&result).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCarNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// availability at the pickup branch, or across all branches when nil
+func (cs *CarService) IsCarAvailableAtBranch(carId uint, branchID *uint, startDate time.Time, endDate time.Time) (bool, error) {
 
 	q := cs.CarsWithRentalQuery(&GetCarsQueryParams{
-		StartDate: &startDate,
-		EndDate:   &endDate,
+		StartDate:      &startDate,
+		EndDate:        &endDate,
+		PickupBranchID: branchID,
 	})
 	q.Where("cars.id=?", carId)
 
@@ -98,10 +286,6 @@ func (cs *CarService) IsCarAvailable(carId uint, startDate time.Time, endDate ti
 		return false, err
 	}
 
-	if result.Stock > result.NumOfRentals {
-		return true, nil
-	} else {
-		return false, nil
-	}
+	return result.NumOfAvailable() > 0, nil
 
 }
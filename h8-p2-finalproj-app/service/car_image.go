@@ -0,0 +1,283 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/storage"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxCarImageSize = 5 << 20
+	// larger images are rejected before decoding
+	MaxCarImagePixels = 40_000_000
+	ThumbnailWidth    = 400
+	ThumbnailHeight   = 300
+)
+
+var (
+	ErrImageTooLarge      = errors.New("image too large")
+	ErrImageType          = errors.New("image must be a jpeg or png")
+	ErrCarImageNotFound   = errors.New("car image not found")
+	ErrCarImageOrder      = errors.New("order must list every image of the car once")
+	imageExtByContentType = map[string]string{
+		"image/jpeg": "jpg",
+		"image/png":  "png",
+	}
+)
+
+type CarImageService struct {
+	db    *gorm.DB
+	store storage.Storage
+}
+
+func NewCarImageService(db *gorm.DB, store storage.Storage) *CarImageService {
+	return &CarImageService{
+		db:    db,
+		store: store,
+	}
+}
+
+// content type of the image from its bytes, the client's header isn't trusted
+func DetectImageType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := imageExtByContentType[contentType]; !ok {
+		return "", ErrImageType
+	}
+	return contentType, nil
+}
+
+// scales the image down to fit in the box, keeping the aspect ratio.
+// each pixel is the average of the pixels it covers
+func Thumbnail(img image.Image, maxWidth int, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		maxWidth, maxHeight = w, h
+	} else if w*maxHeight > h*maxWidth {
+		maxHeight = max(1, h*maxWidth/w)
+	} else {
+		maxWidth = max(1, w*maxHeight/h)
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, maxWidth, maxHeight))
+	for y := 0; y < maxHeight; y++ {
+		y0 := b.Min.Y + y*h/maxHeight
+		y1 := max(y0+1, b.Min.Y+(y+1)*h/maxHeight)
+		for x := 0; x < maxWidth; x++ {
+			x0 := b.Min.X + x*w/maxWidth
+			x1 := max(x0+1, b.Min.X+(x+1)*w/maxWidth)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			thumb.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return thumb
+}
+
+// checks the upload is a jpeg or png within the limits and decodes it
+func decodeCarImage(data []byte) (string, image.Image, error) {
+	if len(data) > MaxCarImageSize {
+		return "", nil, ErrImageTooLarge
+	}
+	contentType, err := DetectImageType(data)
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, ErrImageType
+	}
+	if cfg.Width*cfg.Height > MaxCarImagePixels {
+		return "", nil, ErrImageTooLarge
+	}
+	var img image.Image
+	if contentType == "image/png" {
+		img, err = png.Decode(bytes.NewReader(data))
+	} else {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return "", nil, ErrImageType
+	}
+	return contentType, img, nil
+}
+
+func (cis *CarImageService) GetImages(carID uint) ([]model.CarImage, error) {
+	var images []model.CarImage
+	err := cis.db.Where("car_id=?", carID).Order("position, id").Find(&images).Error
+	return images, err
+}
+
+// an uploaded image and its thumbnail in the storage
+type StoredImage struct {
+	URL          string
+	ThumbnailURL string
+	Key          string
+	ThumbnailKey string
+	ContentType  string
+	Size         int64
+}
+
+// validates the upload and stores it with a thumbnail under the prefix
+func storeImage(store storage.Storage, prefix string, r io.Reader) (*StoredImage, error) {
+	// one byte more than allowed to tell when the limit is passed
+	data, err := io.ReadAll(io.LimitReader(r, MaxCarImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	contentType, img, err := decodeCarImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumb bytes.Buffer
+	err = jpeg.Encode(&thumb, Thumbnail(img, ThumbnailWidth, ThumbnailHeight), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s.%s", prefix, name, imageExtByContentType[contentType])
+	thumbKey := fmt.Sprintf("%s/%s_thumb.jpg", prefix, name)
+	err = store.Put(key, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	err = store.Put(thumbKey, "image/jpeg", &thumb)
+	if err != nil {
+		removeFiles(store, key)
+		return nil, err
+	}
+	return &StoredImage{
+		URL:          store.URL(key),
+		ThumbnailURL: store.URL(thumbKey),
+		Key:          key,
+		ThumbnailKey: thumbKey,
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+	}, nil
+}
+
+// stores the image and its thumbnail and adds it to the end of the gallery
+func (cis *CarImageService) Upload(carID uint, r io.Reader) (*model.CarImage, error) {
+	stored, err := storeImage(cis.store, fmt.Sprintf("cars/%d", carID), r)
+	if err != nil {
+		return nil, err
+	}
+	key, thumbKey := stored.Key, stored.ThumbnailKey
+
+	carImage := model.CarImage{
+		CarID:        carID,
+		URL:          stored.URL,
+		ThumbnailURL: stored.ThumbnailURL,
+		Key:          key,
+		ThumbnailKey: thumbKey,
+		ContentType:  stored.ContentType,
+		Size:         stored.Size,
+	}
+	err = cis.db.Transaction(func(tx *gorm.DB) error {
+		// lock the car so concurrent uploads get different positions
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", carID).First(&model.Car{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&model.CarImage{}).Where("car_id=?", carID).
+			Select("COALESCE(MAX(position), 0) + 1").Scan(&carImage.Position).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&carImage).Error
+	})
+	if err != nil {
+		removeFiles(cis.store, key, thumbKey)
+		return nil, err
+	}
+	return &carImage, nil
+}
+
+// sets the gallery order, ids must be all the images of the car
+func (cis *CarImageService) Reorder(carID uint, imageIDs []uint) ([]model.CarImage, error) {
+	images, err := cis.GetImages(carID)
+	if err != nil {
+		return nil, err
+	}
+	if len(imageIDs) != len(images) {
+		return nil, ErrCarImageOrder
+	}
+	byID := map[uint]bool{}
+	for _, img := range images {
+		byID[img.ID] = true
+	}
+	for _, id := range imageIDs {
+		if !byID[id] {
+			return nil, ErrCarImageOrder
+		}
+		delete(byID, id)
+	}
+
+	err = cis.db.Transaction(func(tx *gorm.DB) error {
+		for i, id := range imageIDs {
+			err := tx.Model(&model.CarImage{}).Where("id=?", id).Update("position", i+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cis.GetImages(carID)
+}
+
+func (cis *CarImageService) Delete(carID uint, imageID uint) error {
+	var carImage model.CarImage
+	err := cis.db.Where("id=? AND car_id=?", imageID, carID).First(&carImage).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCarImageNotFound
+	} else if err != nil {
+		return err
+	}
+	err = cis.db.Delete(&carImage).Error
+	if err != nil {
+		return err
+	}
+	removeFiles(cis.store, carImage.Key, carImage.ThumbnailKey)
+	return nil
+}
+
+// files left behind are only logged, the image is already gone for clients
+func removeFiles(store storage.Storage, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		err := store.Delete(key)
+		if err != nil {
+			log.Printf("failed to delete image %s: %s", key, err.Error())
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"bytes"
+	"h8-p2-finalproj-app/service"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage(w int, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	return img
+}
+
+func TestThumbnailKeepsAspectRatio(t *testing.T) {
+	thumb := service.Thumbnail(testImage(1600, 900), 400, 300)
+	assert.Equal(t, 400, thumb.Bounds().Dx())
+	assert.Equal(t, 225, thumb.Bounds().Dy())
+
+	thumb = service.Thumbnail(testImage(600, 1200), 400, 300)
+	assert.Equal(t, 150, thumb.Bounds().Dx())
+	assert.Equal(t, 300, thumb.Bounds().Dy())
+
+	r, g, b, _ := thumb.At(10, 10).RGBA()
+	assert.Equal(t, []uint32{200, 100, 50}, []uint32{r >> 8, g >> 8, b >> 8})
+}
+
+func TestThumbnailSmallImageNotEnlarged(t *testing.T) {
+	thumb := service.Thumbnail(testImage(100, 80), 400, 300)
+	assert.Equal(t, 100, thumb.Bounds().Dx())
+	assert.Equal(t, 80, thumb.Bounds().Dy())
+}
+
+func TestDetectImageType(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, testImage(4, 4))
+	contentType, err := service.DetectImageType(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+
+	_, err = service.DetectImageType([]byte("<html><body>not an image</body></html>"))
+	assert.ErrorIs(t, err, service.ErrImageType)
+}
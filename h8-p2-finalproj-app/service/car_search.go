@@ -0,0 +1,48 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// weighted document of a car for full text search, the same expression
+// is indexed in the ddl so it must not change without the index
+const carSearchVector = "setweight(to_tsvector('simple', coalesce(cars.manufacturer, '')), 'A') || " +
+	"setweight(to_tsvector('simple', coalesce(cars.car_model, '')), 'A') || " +
+	"setweight(to_tsvector('simple', coalesce(cars.type, '')), 'B') || " +
+	"setweight(to_tsvector('simple', coalesce(cars.transmission, '')), 'C')"
+
+// "7 seat", "7 seats", "7 seater", "7-seater"
+var seatsPattern = regexp.MustCompile(`(?i)\b(\d+)[\s-]*seat(s|er|ers)?\b`)
+
+// splits a search like "7 seat automatic SUV" into a minimum number of
+// seats and the words to match, words are lower case letters and digits
+func ParseCarSearch(q string) ([]string, *int) {
+	var seats *int
+	if m := seatsPattern.FindStringSubmatch(q); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err == nil && n > 0 {
+			seats = &n
+		}
+		q = seatsPattern.ReplaceAllString(q, " ")
+	}
+
+	terms := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		terms = append(terms, word)
+	}
+	return terms, seats
+}
+
+// tsquery matching cars with every term, as prefixes so "toyo" finds Toyota
+func carSearchQuery(terms []string) string {
+	parts := []string{}
+	for _, t := range terms {
+		parts = append(parts, t+":*")
+	}
+	return strings.Join(parts, " & ")
+}
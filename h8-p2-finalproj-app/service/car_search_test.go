@@ -0,0 +1,27 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCarSearch(t *testing.T) {
+	terms, seats := service.ParseCarSearch("7 seat automatic SUV")
+	assert.Equal(t, []string{"automatic", "suv"}, terms)
+	assert.Equal(t, 7, *seats)
+
+	terms, seats = service.ParseCarSearch("Toyota 5-seater")
+	assert.Equal(t, []string{"toyota"}, terms)
+	assert.Equal(t, 5, *seats)
+
+	terms, seats = service.ParseCarSearch("  BMW M4 ")
+	assert.Equal(t, []string{"bmw", "m4"}, terms)
+	assert.Nil(t, seats)
+}
+
+func TestParseCarSearchDropsOperators(t *testing.T) {
+	terms, _ := service.ParseCarSearch("suv & !(sedan):* | 'x'")
+	assert.Equal(t, []string{"suv", "sedan", "x"}, terms)
+}
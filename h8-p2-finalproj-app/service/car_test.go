@@ -51,6 +51,170 @@ func CreateTestDB() *gorm.DB {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = db.AutoMigrate(&model.RefreshToken{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RevokedToken{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.LoginAttempt{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AccountLockout{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.TwoFactorAuth{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RecoveryCode{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.LoginChallenge{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.EmailChangeRequest{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Notification{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.DataExport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Branch{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.BranchOpeningHour{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OneWayFee{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.VehicleUnit{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Maintenance{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.PricingRule{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RentalPriceItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Quote{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.QuoteItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Promotion{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.PromotionRedemption{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.RentalAddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.QuoteAddOn{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.CarImage{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Review{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.ReviewPhoto{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Favorite{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.AvailabilityWatch{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.WaitlistEntry{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.DriverLicense{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.Organization{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationMember{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationInvitation{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.OrganizationInvoice{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.SecurityDepositRate{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.SecurityDeposit{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionReport{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionItem{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.InspectionPhoto{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = db.AutoMigrate(&model.DamageCharge{})
+	if err != nil {
+		log.Fatal(err)
+	}
 	return db
 }
 
@@ -73,6 +237,83 @@ func PrintAsJSON(m any) {
 	}
 }
 
+// a new user with a unique email, tests running against the same db don't clash
+func CreateTestUser(t *testing.T, db *gorm.DB, role string) *model.User {
+	user := model.User{
+		Name:     "Test User",
+		Email:    fmt.Sprintf("test-%d@example.com", time.Now().UnixNano()),
+		Role:     role,
+		Password: "password",
+	}
+	err := db.Create(&user).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &user
+}
+
+// a new car with its own units in service, so counts are not affected by
+// the seed data or other tests
+func CreateTestCar(t *testing.T, db *gorm.DB, numOfUnits int, branchID *uint) (*model.Car, []model.VehicleUnit) {
+	n := time.Now().UnixNano()
+	car := model.Car{
+		Type:         "SUV",
+		Seats:        5,
+		Transmission: "Automatic",
+		Manufacturer: "Test",
+		CarModel:     fmt.Sprintf("Car %d", n),
+		Year:         2024,
+		RatePerDay:   500000,
+	}
+	err := db.Create(&car).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	units := []model.VehicleUnit{}
+	for i := 0; i < numOfUnits; i++ {
+		unit := model.VehicleUnit{
+			CarID:       car.ID,
+			BranchID:    branchID,
+			PlateNumber: fmt.Sprintf("T %d %d", n%1000000000, i),
+			VIN:         fmt.Sprintf("TEST%011d%02d", n%100000000000, i),
+			Status:      model.UnitStatusAvailable,
+		}
+		err = db.Create(&unit).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, unit)
+	}
+	return &car, units
+}
+
+// a rental of the car with its payment in the status
+func CreateTestRental(t *testing.T, db *gorm.DB, user *model.User, car *model.Car, startDate time.Time, endDate time.Time, status string) *model.Rental {
+	rental := model.Rental{
+		UserID:     user.ID,
+		CarID:      car.ID,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		TotalPrice: car.RatePerDay,
+		Payment: model.Payment{
+			PurchaseType: "rentals",
+			Status:       status,
+			TotalPayment: car.RatePerDay,
+		},
+	}
+	err := db.Create(&rental).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rental
+}
+
+func assignTestUnit(db *gorm.DB, cs *service.CarService, rental *model.Rental, unitID *uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return cs.AssignUnit(tx, rental, unitID)
+	})
+}
+
 func TestCountRentalsPerCar(t *testing.T) {
 	godotenv.Load("../.env")
 	db := CreateTestDB()
@@ -192,6 +433,196 @@ func TestCountRentalsPerCarNotFound(t *testing.T) {
 	assert.Len(t, res, 0)
 }
 
+func TestCountRentalsPerCarOnlyActiveRentals(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	carService := service.NewCarService(db)
+	car, _ := CreateTestCar(t, db, 3, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	startDate := mustTime("2030-03-01T10:00:00Z")
+	endDate := mustTime("2030-03-03T10:00:00Z")
+	statuses := []string{
+		"Unpaid",
+		"Completed",
+		service.PaymentStatusOnAccount,
+		service.PaymentStatusCancelled,
+		service.PaymentStatusRefunded,
+		service.PaymentStatusExpired,
+	}
+	for _, status := range statuses {
+		CreateTestRental(t, db, user, car, startDate, endDate, status)
+	}
+
+	res, err := carService.GetCarWithRentals(car.ID, &service.GetCarsQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), res.NumOfUnits)
+	// cancelled, refunded and expired rentals don't hold a unit
+	assert.Equal(t, uint(3), res.NumOfRentals)
+	assert.Equal(t, uint(0), res.NumOfAvailable())
+	assert.False(t, res.IsOverbooked())
+}
+
+func TestCountRentalsPerCarToTheMinute(t *testing.T) {
+	// r is rental item, b is the cleaning buffer
+	// q is query
+	//        r.StartDate   r.EndDate
+	// 	----|---|--------------|---|----
+	//      b                      b
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	t.Setenv("RENTAL_BUFFER_MINUTES", "30")
+	carService := service.NewCarService(db)
+	car, _ := CreateTestCar(t, db, 1, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+	CreateTestRental(t, db, user, car,
+		mustTime("2030-03-01T10:00:00Z"), mustTime("2030-03-01T14:00:00Z"), "Completed")
+
+	cases := []struct {
+		start     string
+		end       string
+		available bool
+	}{
+		{"2030-03-01T07:00:00Z", "2030-03-01T09:30:00Z", true},
+		{"2030-03-01T07:00:00Z", "2030-03-01T09:45:00Z", false},
+		{"2030-03-01T11:00:00Z", "2030-03-01T12:00:00Z", false},
+		{"2030-03-01T14:00:00Z", "2030-03-01T16:00:00Z", false},
+		{"2030-03-01T14:15:00Z", "2030-03-01T16:00:00Z", false},
+		{"2030-03-01T14:30:00Z", "2030-03-01T16:00:00Z", true},
+	}
+	for _, c := range cases {
+		available, err := carService.IsCarAvailable(car.ID, mustTime(c.start), mustTime(c.end))
+		assert.NoError(t, err)
+		assert.Equal(t, c.available, available, "%s to %s", c.start, c.end)
+	}
+
+	// without a buffer the unit can be rented again right at the end
+	t.Setenv("RENTAL_BUFFER_MINUTES", "")
+	available, err := carService.IsCarAvailable(car.ID,
+		mustTime("2030-03-01T14:00:00Z"), mustTime("2030-03-01T16:00:00Z"))
+	assert.NoError(t, err)
+	assert.True(t, available)
+}
+
+func TestAssignUnit(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	carService := service.NewCarService(db)
+	car, units := CreateTestCar(t, db, 2, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+	startDate := mustTime("2030-03-01T10:00:00Z")
+	endDate := mustTime("2030-03-03T10:00:00Z")
+
+	first := CreateTestRental(t, db, user, car, startDate, endDate, "Completed")
+	assert.NoError(t, assignTestUnit(db, carService, first, nil))
+	assert.Equal(t, units[0].ID, *first.VehicleUnitID)
+
+	// a cancelled rental keeps its unit id but does not hold the unit
+	cancelled := CreateTestRental(t, db, user, car, startDate, endDate, service.PaymentStatusCancelled)
+	err := db.Model(cancelled).Update("vehicle_unit_id", units[1].ID).Error
+	assert.NoError(t, err)
+
+	second := CreateTestRental(t, db, user, car, startDate.Add(time.Hour), endDate, "Completed")
+	assert.NoError(t, assignTestUnit(db, carService, second, nil))
+	assert.Equal(t, units[1].ID, *second.VehicleUnitID)
+
+	third := CreateTestRental(t, db, user, car, startDate, endDate, "Completed")
+	assert.ErrorIs(t, assignTestUnit(db, carService, third, nil), service.ErrNoUnitAvailable)
+	assert.ErrorIs(t, assignTestUnit(db, carService, third, &units[0].ID), service.ErrUnitNotFree)
+
+	// a rental after the others gets the first unit back
+	later := CreateTestRental(t, db, user, car, endDate.AddDate(0, 0, 1), endDate.AddDate(0, 0, 2), "Completed")
+	assert.NoError(t, assignTestUnit(db, carService, later, nil))
+	assert.Equal(t, units[0].ID, *later.VehicleUnitID)
+
+	// staff see the rental that got no unit until one is assigned
+	assert.NoError(t, carService.FlagUnassigned(third.ID, service.ErrNoUnitAvailable))
+	unassigned, err := carService.GetUnassignedRentals()
+	assert.NoError(t, err)
+	assert.Contains(t, rentalIDs(unassigned), third.ID)
+
+	err = db.Model(&model.Payment{}).Where("id=?", first.Payment.ID).
+		Update("status", service.PaymentStatusCancelled).Error
+	assert.NoError(t, err)
+	free, err := RunFindOnQuery(carService.FreeUnitsQuery(db, third))
+	assert.NoError(t, err)
+	assert.Len(t, free, 1)
+	assert.NoError(t, assignTestUnit(db, carService, third, nil))
+	assert.Equal(t, units[0].ID, *third.VehicleUnitID)
+
+	unassigned, err = carService.GetUnassignedRentals()
+	assert.NoError(t, err)
+	assert.NotContains(t, rentalIDs(unassigned), third.ID)
+}
+
+func rentalIDs(rentals []model.Rental) []uint {
+	ids := []uint{}
+	for _, r := range rentals {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestAssignUnitSkipsUnitsOutOfService(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	carService := service.NewCarService(db)
+	car, units := CreateTestCar(t, db, 3, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	err := db.Model(&units[0]).Update("status", model.UnitStatusRetired).Error
+	assert.NoError(t, err)
+	maintenance := model.Maintenance{
+		CarID:       car.ID,
+		StartDate:   mustDate("2030-03-01"),
+		EndDate:     mustDate("2030-03-02"),
+		CreatedByID: user.ID,
+		Units:       []model.VehicleUnit{units[1]},
+	}
+	err = db.Create(&maintenance).Error
+	assert.NoError(t, err)
+
+	startDate := mustTime("2030-03-02T10:00:00Z")
+	endDate := mustTime("2030-03-04T10:00:00Z")
+	res, err := carService.GetCarWithRentals(car.ID, &service.GetCarsQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), res.NumOfUnits)
+	assert.Equal(t, uint(1), res.NumInMaintenance)
+	assert.Equal(t, uint(1), res.NumOfAvailable())
+
+	rental := CreateTestRental(t, db, user, car, startDate, endDate, "Completed")
+	assert.NoError(t, assignTestUnit(db, carService, rental, nil))
+	assert.Equal(t, units[2].ID, *rental.VehicleUnitID)
+
+	// the maintenance ends with its last day
+	startDate = mustTime("2030-03-03T10:00:00Z")
+	res, err = carService.GetCarWithRentals(car.ID, &service.GetCarsQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), res.NumInMaintenance)
+	assert.Equal(t, uint(1), res.NumOfAvailable())
+}
+
 func TestCarsWithRentals(t *testing.T) {
 	// r is rental item
 	// q is query
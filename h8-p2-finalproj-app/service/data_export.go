@@ -0,0 +1,489 @@
+package service
+
+import (
+	"archive/zip"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DataExportTTL = 24 * time.Hour
+	// exports with more records than this are generated in the background
+	DataExportSyncLimit = 200
+)
+
+var (
+	ErrDataExportNotFound = errors.New("data export not found")
+	ErrDataExportNotReady = errors.New("data export not ready")
+	ErrDataExportExpired  = errors.New("data export expired")
+)
+
+type DataExportService struct {
+	db  *gorm.DB
+	dir string
+}
+
+// archives are written to DATA_EXPORT_DIR, defaults to the temp dir
+func NewDataExportService(db *gorm.DB) *DataExportService {
+	dir := os.Getenv("DATA_EXPORT_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "data-exports")
+	}
+	return &DataExportService{
+		db:  db,
+		dir: dir,
+	}
+}
+
+type ExportUser struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Deposit   float64   `json:"deposit"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportRental struct {
+	ID         uint      `json:"id"`
+	CarID      uint      `json:"car_id"`
+	Car        string    `json:"car"`
+	StartDate  time.Time `json:"start_date"`
+	EndDate    time.Time `json:"end_date"`
+	TotalPrice float64   `json:"total_price"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type ExportTopUp struct {
+	ID        uint      `json:"id"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportPayment struct {
+	ID            uint      `json:"id"`
+	PurchaseID    int       `json:"purchase_id"`
+	PurchaseType  string    `json:"purchase_type"`
+	Status        string    `json:"status"`
+	PaymentMethod string    `json:"payment_method"`
+	TotalPayment  float64   `json:"total_payment"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type ExportNotification struct {
+	ID        uint      `json:"id"`
+	Channel   string    `json:"channel"`
+	Recipient string    `json:"recipient"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportData struct {
+	GeneratedAt   time.Time            `json:"generated_at"`
+	User          ExportUser           `json:"user"`
+	Rentals       []ExportRental       `json:"rentals"`
+	TopUps        []ExportTopUp        `json:"top_ups"`
+	Payments      []ExportPayment      `json:"payments"`
+	Notifications []ExportNotification `json:"notifications"`
+}
+
+// number of records that would be exported for the user
+func (des *DataExportService) countRecords(userID uint) (int64, error) {
+	total := int64(0)
+	for _, m := range []any{&model.Rental{}, &model.TopUp{}, &model.Notification{}} {
+		var count int64
+		err := des.db.Model(m).Where("user_id=?", userID).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// starts an export, small ones are completed before returning
+func (des *DataExportService) Request(user *model.User) (*model.DataExport, error) {
+	des.purgeExpired(user.ID)
+
+	export := model.DataExport{
+		UserID: user.ID,
+		Status: model.DataExportPending,
+	}
+	err := des.db.Create(&export).Error
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := des.countRecords(user.ID)
+	if err != nil {
+		des.fail(&export, err)
+		return nil, err
+	}
+	if count <= DataExportSyncLimit {
+		des.generate(&export)
+		return &export, nil
+	}
+
+	background := export
+	go des.generate(&background)
+	return &export, nil
+}
+
+// returns the export of the user, marking it expired when the link lapsed
+func (des *DataExportService) Get(userID uint, id uint) (*model.DataExport, error) {
+	var export model.DataExport
+	err := des.db.Where("id=? AND user_id=?", id, userID).First(&export).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataExportNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if export.Status == model.DataExportCompleted && time.Now().After(*export.ExpiresAt) {
+		err = des.expire(&export)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &export, nil
+}
+
+// returns the archive path of an export for a download token
+func (des *DataExportService) Open(id uint, token string) (string, error) {
+	var export model.DataExport
+	err := des.db.Where("id=?", id).First(&export).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrDataExportNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if export.DownloadTokenHash == "" || subtle.ConstantTimeCompare([]byte(export.DownloadTokenHash), []byte(hashToken(token))) != 1 {
+		return "", ErrDataExportNotFound
+	}
+	if export.Status == model.DataExportExpired {
+		return "", ErrDataExportExpired
+	}
+	if export.Status != model.DataExportCompleted {
+		return "", ErrDataExportNotReady
+	}
+	if time.Now().After(*export.ExpiresAt) {
+		err = des.expire(&export)
+		if err != nil {
+			return "", err
+		}
+		return "", ErrDataExportExpired
+	}
+	return export.FilePath, nil
+}
+
+// a new download token for a completed export, only its hash is kept so
+// the link of an earlier token stops working
+func (des *DataExportService) DownloadToken(export *model.DataExport) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	export.DownloadTokenHash = hashToken(token)
+	err = des.db.Model(export).Update("download_token_hash", export.DownloadTokenHash).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (des *DataExportService) expire(export *model.DataExport) error {
+	if export.FilePath != "" {
+		os.Remove(export.FilePath)
+	}
+	export.Status = model.DataExportExpired
+	export.FilePath = ""
+	export.DownloadTokenHash = ""
+	return des.db.Save(export).Error
+}
+
+// removes lapsed archives of the user
+func (des *DataExportService) purgeExpired(userID uint) {
+	var exports []model.DataExport
+	err := des.db.Where("user_id=? AND status=? AND expires_at < ?", userID, model.DataExportCompleted, time.Now()).
+		Find(&exports).Error
+	if err != nil {
+		log.Printf("failed to find expired data exports: %s", err.Error())
+		return
+	}
+	for i := range exports {
+		if err := des.expire(&exports[i]); err != nil {
+			log.Printf("failed to expire data export %d: %s", exports[i].ID, err.Error())
+		}
+	}
+}
+
+// regenerates the exports left pending or processing when the app stopped,
+// ones older than the download ttl are failed instead, the user has likely
+// requested a new one by then
+func (des *DataExportService) ResumeUnfinished() {
+	var exports []model.DataExport
+	err := des.db.Where("status IN ?", []string{model.DataExportPending, model.DataExportProcessing}).
+		Order("id").Find(&exports).Error
+	if err != nil {
+		log.Printf("failed to find unfinished data exports: %s", err.Error())
+		return
+	}
+	for i := range exports {
+		if time.Since(exports[i].CreatedAt) > DataExportTTL {
+			des.fail(&exports[i], errors.New("not finished before the app stopped"))
+			continue
+		}
+		des.generate(&exports[i])
+	}
+}
+
+// marks the export failed so it doesn't stay pending
+func (des *DataExportService) fail(export *model.DataExport, err error) {
+	log.Printf("failed to generate data export %d: %s", export.ID, err.Error())
+	export.Status = model.DataExportFailed
+	export.Error = err.Error()
+	err = des.db.Save(export).Error
+	if err != nil {
+		log.Printf("failed to save data export %d: %s", export.ID, err.Error())
+	}
+}
+
+func (des *DataExportService) generate(export *model.DataExport) {
+	// a panic in the background fails the export instead of the app
+	defer func() {
+		if r := recover(); r != nil {
+			des.fail(export, fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	export.Status = model.DataExportProcessing
+	des.db.Save(export)
+
+	path, err := des.writeArchive(export)
+	if err != nil {
+		des.fail(export, err)
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(DataExportTTL)
+	export.Status = model.DataExportCompleted
+	export.FilePath = path
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	err = des.db.Save(export).Error
+	if err != nil {
+		log.Printf("failed to save data export %d: %s", export.ID, err.Error())
+	}
+}
+
+func (des *DataExportService) collect(userID uint) (*ExportData, error) {
+	var user model.User
+	err := des.db.Where("id=?", userID).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	data := ExportData{
+		GeneratedAt: time.Now(),
+		User: ExportUser{
+			ID:        user.ID,
+			Name:      user.Name,
+			Email:     user.Email,
+			Role:      user.Role,
+			Deposit:   user.Deposit,
+			CreatedAt: user.CreatedAt,
+		},
+		Rentals:       []ExportRental{},
+		TopUps:        []ExportTopUp{},
+		Payments:      []ExportPayment{},
+		Notifications: []ExportNotification{},
+	}
+
+	var rentals []model.Rental
+	err = des.db.Preload("Car").Where("user_id=?", userID).Order("id").Find(&rentals).Error
+	if err != nil {
+		return nil, err
+	}
+	rentalIDs := []uint{}
+	for _, r := range rentals {
+		rentalIDs = append(rentalIDs, r.ID)
+		data.Rentals = append(data.Rentals, ExportRental{
+			ID:         r.ID,
+			CarID:      r.CarID,
+			Car:        r.Car.GetCarName(),
+			StartDate:  r.StartDate,
+			EndDate:    r.EndDate,
+			TotalPrice: r.TotalPrice,
+			CreatedAt:  r.CreatedAt,
+		})
+	}
+
+	var topUps []model.TopUp
+	err = des.db.Where("user_id=?", userID).Order("id").Find(&topUps).Error
+	if err != nil {
+		return nil, err
+	}
+	topUpIDs := []uint{}
+	for _, t := range topUps {
+		topUpIDs = append(topUpIDs, t.ID)
+		data.TopUps = append(data.TopUps, ExportTopUp{
+			ID:        t.ID,
+			Amount:    t.Amount,
+			CreatedAt: t.CreatedAt,
+		})
+	}
+
+	var payments []model.Payment
+	err = des.db.
+		Where("purchase_type = ? AND purchase_id IN ?", "rentals", append(rentalIDs, 0)).
+		Or("purchase_type = ? AND purchase_id IN ?", "top_ups", append(topUpIDs, 0)).
+		Order("id").
+		Find(&payments).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		data.Payments = append(data.Payments, ExportPayment{
+			ID:            p.ID,
+			PurchaseID:    p.PurchaseID,
+			PurchaseType:  p.PurchaseType,
+			Status:        p.Status,
+			PaymentMethod: p.PaymentMethod,
+			TotalPayment:  p.TotalPayment,
+			CreatedAt:     p.CreatedAt,
+		})
+	}
+
+	var notifications []model.Notification
+	err = des.db.Where("user_id=?", userID).Order("id").Find(&notifications).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range notifications {
+		data.Notifications = append(data.Notifications, ExportNotification{
+			ID:        n.ID,
+			Channel:   n.Channel,
+			Recipient: n.Recipient,
+			Subject:   n.Subject,
+			CreatedAt: n.CreatedAt,
+		})
+	}
+
+	return &data, nil
+}
+
+func (des *DataExportService) writeArchive(export *model.DataExport) (string, error) {
+	data, err := des.collect(export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(des.dir, 0o700)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(des.dir, fmt.Sprintf("export-%d-%d.zip", export.UserID, export.ID))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	err = WriteExportArchive(f, data)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatUint(u uint) string {
+	return strconv.FormatUint(uint64(u), 10)
+}
+
+// writes data.json and one csv per record type into a zip archive
+func WriteExportArchive(w io.Writer, data *ExportData) error {
+	zw := zip.NewWriter(w)
+
+	jw, err := zw.Create("data.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(jw)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	csvFiles := map[string][][]string{
+		"user.csv": {
+			{"id", "name", "email", "role", "deposit", "created_at"},
+			{formatUint(data.User.ID), data.User.Name, data.User.Email, data.User.Role, formatFloat(data.User.Deposit), formatTime(data.User.CreatedAt)},
+		},
+		"rentals.csv": {
+			{"id", "car_id", "car", "start_date", "end_date", "total_price", "created_at"},
+		},
+		"top_ups.csv": {
+			{"id", "amount", "created_at"},
+		},
+		"payments.csv": {
+			{"id", "purchase_id", "purchase_type", "status", "payment_method", "total_payment", "created_at"},
+		},
+		"notifications.csv": {
+			{"id", "channel", "recipient", "subject", "created_at"},
+		},
+	}
+	for _, r := range data.Rentals {
+		csvFiles["rentals.csv"] = append(csvFiles["rentals.csv"], []string{
+			formatUint(r.ID), formatUint(r.CarID), r.Car, formatTime(r.StartDate), formatTime(r.EndDate), formatFloat(r.TotalPrice), formatTime(r.CreatedAt),
+		})
+	}
+	for _, t := range data.TopUps {
+		csvFiles["top_ups.csv"] = append(csvFiles["top_ups.csv"], []string{
+			formatUint(t.ID), formatFloat(t.Amount), formatTime(t.CreatedAt),
+		})
+	}
+	for _, p := range data.Payments {
+		csvFiles["payments.csv"] = append(csvFiles["payments.csv"], []string{
+			formatUint(p.ID), strconv.Itoa(p.PurchaseID), p.PurchaseType, p.Status, p.PaymentMethod, formatFloat(p.TotalPayment), formatTime(p.CreatedAt),
+		})
+	}
+	for _, n := range data.Notifications {
+		csvFiles["notifications.csv"] = append(csvFiles["notifications.csv"], []string{
+			formatUint(n.ID), n.Channel, n.Recipient, n.Subject, formatTime(n.CreatedAt),
+		})
+	}
+
+	for _, name := range []string{"user.csv", "rentals.csv", "top_ups.csv", "payments.csv", "notifications.csv"} {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(fw)
+		err = cw.WriteAll(csvFiles[name])
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
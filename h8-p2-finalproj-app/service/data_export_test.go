@@ -0,0 +1,48 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteExportArchive(t *testing.T) {
+	data := &service.ExportData{
+		GeneratedAt: time.Now(),
+		User:        service.ExportUser{ID: 1, Name: "John Doe", Email: "john@example.com"},
+		Rentals: []service.ExportRental{
+			{ID: 1, CarID: 1, Car: "Toyota RAV4", TotalPrice: 2000000},
+			{ID: 2, CarID: 2, Car: "Honda Civic", TotalPrice: 800000},
+		},
+		TopUps:        []service.ExportTopUp{},
+		Payments:      []service.ExportPayment{},
+		Notifications: []service.ExportNotification{},
+	}
+
+	var buf bytes.Buffer
+	err := service.WriteExportArchive(&buf, data)
+	assert.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	names := []string{}
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		"data.json", "user.csv", "rentals.csv", "top_ups.csv", "payments.csv", "notifications.csv",
+	}, names)
+
+	f, err := zr.Open("rentals.csv")
+	assert.NoError(t, err)
+	rows, err := csv.NewReader(f).ReadAll()
+	assert.NoError(t, err)
+	// header and 2 rentals
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "Honda Civic", rows[2][2])
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/storage"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// licenses are checked when staff hand over the car
+	LicenseCheckPickup = "pickup"
+	// licenses are checked when the rental is booked, and again at pickup
+	LicenseCheckBooking = "booking"
+)
+
+var (
+	ErrLicenseNotFound    = errors.New("driver license not found")
+	ErrLicensePending     = errors.New("driver license already waiting for review")
+	ErrLicenseReviewed    = errors.New("driver license already reviewed")
+	ErrLicenseNumber      = errors.New("driver license number cannot be empty")
+	ErrLicenseExpired     = errors.New("driver license already expired")
+	ErrNoValidLicense     = errors.New("no approved driver license valid for the rental")
+	ErrRejectReasonNeeded = errors.New("reject reason cannot be empty")
+)
+
+type DriverLicenseService struct {
+	db    *gorm.DB
+	store storage.Storage
+	ns    *NotificationService
+}
+
+func NewDriverLicenseService(db *gorm.DB, store storage.Storage, ns *NotificationService) *DriverLicenseService {
+	return &DriverLicenseService{
+		db:    db,
+		store: store,
+		ns:    ns,
+	}
+}
+
+// when licenses are checked, LICENSE_CHECK is pickup (default) or booking
+func LicenseCheckAt() string {
+	if os.Getenv("LICENSE_CHECK") == LicenseCheckBooking {
+		return LicenseCheckBooking
+	}
+	return LicenseCheckPickup
+}
+
+// an approved license is valid for a rental if it does not expire
+// before the end date of the rental
+func LicenseCovers(license *model.DriverLicense, endDate time.Time) bool {
+	if license.Status != model.LicenseApproved {
+		return false
+	}
+	endDay := time.Date(endDate.Year(), endDate.Month(), endDate.Day(), 0, 0, 0, 0, time.UTC)
+	return !license.ExpiresOn.UTC().Before(endDay)
+}
+
+// the license the user uploaded last
+func (dls *DriverLicenseService) GetLatest(userID uint) (*model.DriverLicense, error) {
+	var license model.DriverLicense
+	err := dls.db.Where("user_id=?", userID).Order("id DESC").First(&license).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLicenseNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &license, nil
+}
+
+func (dls *DriverLicenseService) Get(id uint) (*model.DriverLicense, error) {
+	var license model.DriverLicense
+	err := dls.db.Preload("User").Where("id=?", id).First(&license).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLicenseNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &license, nil
+}
+
+// review queue for staff, oldest first. all statuses when status is empty
+func (dls *DriverLicenseService) GetLicenses(status string) ([]model.DriverLicense, error) {
+	q := dls.db.Preload("User").Order("id")
+	if status != "" {
+		q = q.Where("status=?", status)
+	}
+	var licenses []model.DriverLicense
+	err := q.Find(&licenses).Error
+	return licenses, err
+}
+
+// stores the image and submits the license for review
+func (dls *DriverLicenseService) Upload(userID uint, number string, expiresOn time.Time, r io.Reader) (*model.DriverLicense, error) {
+	number = strings.ToUpper(strings.TrimSpace(number))
+	if number == "" {
+		return nil, ErrLicenseNumber
+	}
+	if !expiresOn.After(time.Now()) {
+		return nil, ErrLicenseExpired
+	}
+
+	var pending int64
+	err := dls.db.Model(&model.DriverLicense{}).
+		Where("user_id=? AND status=?", userID, model.LicensePending).
+		Count(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, ErrLicensePending
+	}
+
+	// one byte more than allowed to tell when the limit is passed
+	data, err := io.ReadAll(io.LimitReader(r, MaxCarImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	contentType, _, err := decodeCarImage(data)
+	if err != nil {
+		return nil, err
+	}
+	name, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("licenses/%d/%s.%s", userID, name, imageExtByContentType[contentType])
+	err = dls.store.Put(key, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	license := model.DriverLicense{
+		UserID:      userID,
+		Number:      number,
+		ExpiresOn:   expiresOn,
+		ImageKey:    key,
+		ContentType: contentType,
+		Status:      model.LicensePending,
+	}
+	err = dls.db.Create(&license).Error
+	if err != nil {
+		removeFiles(dls.store, key)
+		return nil, err
+	}
+	return &license, nil
+}
+
+// approves or rejects a pending license and tells the user
+func (dls *DriverLicenseService) Review(id uint, staffID uint, approve bool, reason string, logger echo.Logger) (*model.DriverLicense, error) {
+	reason = strings.TrimSpace(reason)
+	if !approve && reason == "" {
+		return nil, ErrRejectReasonNeeded
+	}
+
+	var license model.DriverLicense
+	err := dls.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", id).First(&license).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrLicenseNotFound
+		} else if err != nil {
+			return err
+		}
+		if license.Status != model.LicensePending {
+			return ErrLicenseReviewed
+		}
+
+		now := time.Now()
+		license.Status = model.LicenseRejected
+		if approve {
+			license.Status = model.LicenseApproved
+			reason = ""
+		}
+		license.RejectReason = reason
+		license.ReviewedByID = &staffID
+		license.ReviewedAt = &now
+		return tx.Model(&license).Updates(map[string]any{
+			"status":         license.Status,
+			"reject_reason":  license.RejectReason,
+			"reviewed_by_id": staffID,
+			"reviewed_at":    now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var user model.User
+	err = dls.db.Where("id=?", license.UserID).First(&user).Error
+	if err != nil {
+		logger.Errorf("failed to load user of driver license %d: %s", license.ID, err.Error())
+		return &license, nil
+	}
+	license.User = user
+	if approve {
+		dls.ns.SendMail(user.ID, user.Email, "Your driver license is approved",
+			fmt.Sprintf("<h1>Hello %s, your driver license is approved, you are ready to drive!</h1>", user.Name),
+			logger,
+		)
+	} else {
+		dls.ns.SendMail(user.ID, user.Email, "Your driver license was rejected",
+			fmt.Sprintf("<h1>Hello %s, your driver license was rejected</h1><p>Reason: %s</p><p>Please upload it again.</p>",
+				user.Name, reason),
+			logger,
+		)
+	}
+	return &license, nil
+}
+
+// checks the user has an approved license valid until the end of the rental
+func (dls *DriverLicenseService) CheckValid(tx *gorm.DB, userID uint, endDate time.Time) error {
+	var licenses []model.DriverLicense
+	err := tx.Where("user_id=? AND status=?", userID, model.LicenseApproved).Find(&licenses).Error
+	if err != nil {
+		return err
+	}
+	for i := range licenses {
+		if LicenseCovers(&licenses[i], endDate) {
+			return nil
+		}
+	}
+	return ErrNoValidLicense
+}
+
+// removes the licenses of a deleted account and their images
+func (dls *DriverLicenseService) DeleteForUser(userID uint) error {
+	var licenses []model.DriverLicense
+	err := dls.db.Unscoped().Where("user_id=?", userID).Find(&licenses).Error
+	if err != nil {
+		return err
+	}
+	err = dls.db.Unscoped().Where("user_id=?", userID).Delete(&model.DriverLicense{}).Error
+	if err != nil {
+		return err
+	}
+	for _, l := range licenses {
+		removeFiles(dls.store, l.ImageKey)
+	}
+	return nil
+}
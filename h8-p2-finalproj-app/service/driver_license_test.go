@@ -0,0 +1,35 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLicenseCovers(t *testing.T) {
+	expiresOn, _ := time.Parse(time.DateOnly, "2024-09-10")
+	license := model.DriverLicense{Status: model.LicenseApproved, ExpiresOn: expiresOn}
+
+	assert.True(t, service.LicenseCovers(&license, mustTime("2024-09-05T10:00:00+07:00")))
+	// still valid on the day it expires, whatever the time zone of the rental
+	assert.True(t, service.LicenseCovers(&license, mustTime("2024-09-10T21:00:00+07:00")))
+	assert.True(t, service.LicenseCovers(&license, mustTime("2024-09-10T01:00:00+07:00")))
+	assert.False(t, service.LicenseCovers(&license, mustTime("2024-09-11T09:00:00+07:00")))
+
+	for _, status := range []string{model.LicensePending, model.LicenseRejected} {
+		license.Status = status
+		assert.False(t, service.LicenseCovers(&license, mustTime("2024-09-05T10:00:00+07:00")), status)
+	}
+}
+
+func TestLicenseCheckAt(t *testing.T) {
+	t.Setenv("LICENSE_CHECK", "")
+	assert.Equal(t, service.LicenseCheckPickup, service.LicenseCheckAt())
+	t.Setenv("LICENSE_CHECK", "booking")
+	assert.Equal(t, service.LicenseCheckBooking, service.LicenseCheckAt())
+	t.Setenv("LICENSE_CHECK", "never")
+	assert.Equal(t, service.LicenseCheckPickup, service.LicenseCheckAt())
+}
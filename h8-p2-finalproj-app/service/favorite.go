@@ -0,0 +1,218 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// longest period a watch can be for, like the availability calendar
+	MaxWatchDays = MaxCalendarDays
+	// open watches a user can have at once
+	MaxWatchesPerUser = 20
+)
+
+const (
+	WatchStatusWatching = "Watching"
+	WatchStatusNotified = "Notified"
+	// the period started before the car became available
+	WatchStatusExpired = "Expired"
+)
+
+var (
+	ErrFavoriteNotFound = errors.New("favorite not found")
+	ErrWatchNotFound    = errors.New("watch not found")
+	ErrWatchInPast      = errors.New("watch must start in the future")
+	ErrWatchTooLong     = errors.New("watch period too long")
+	ErrInvalidWatch     = errors.New("end date must be after start date")
+	ErrTooManyWatches   = errors.New("too many open watches")
+	ErrCarAvailable     = errors.New("car already available for the period")
+)
+
+type FavoriteService struct {
+	db *gorm.DB
+	cs *CarService
+	ns *NotificationService
+}
+
+func NewFavoriteService(db *gorm.DB, cs *CarService, ns *NotificationService) *FavoriteService {
+	return &FavoriteService{
+		db: db,
+		cs: cs,
+		ns: ns,
+	}
+}
+
+func ValidateWatchPeriod(startDate time.Time, endDate time.Time, now time.Time) error {
+	if !endDate.After(startDate) {
+		return ErrInvalidWatch
+	}
+	if !startDate.After(now) {
+		return ErrWatchInPast
+	}
+	if endDate.Sub(startDate) > MaxWatchDays*24*time.Hour {
+		return ErrWatchTooLong
+	}
+	return nil
+}
+
+func WatchStatus(w *model.AvailabilityWatch, now time.Time) string {
+	if w.NotifiedAt != nil {
+		return WatchStatusNotified
+	}
+	if !w.StartDate.After(now) {
+		return WatchStatusExpired
+	}
+	return WatchStatusWatching
+}
+
+func (fs *FavoriteService) GetFavorites(userID uint) ([]model.Favorite, error) {
+	var favorites []model.Favorite
+	err := fs.db.Preload("Car").Where("user_id=?", userID).Order("id").Find(&favorites).Error
+	return favorites, err
+}
+
+// saves the car, saving it again keeps the first one
+func (fs *FavoriteService) AddFavorite(userID uint, carID uint) (*model.Favorite, error) {
+	car, err := fs.cs.GetCar(carID)
+	if err != nil {
+		return nil, err
+	}
+	favorite := model.Favorite{UserID: userID, CarID: carID}
+	err = fs.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&favorite).Error
+	if err != nil {
+		return nil, err
+	}
+	err = fs.db.Where("user_id=? AND car_id=?", userID, carID).First(&favorite).Error
+	if err != nil {
+		return nil, err
+	}
+	favorite.Car = *car
+	return &favorite, nil
+}
+
+// removes the car and the watches on it
+func (fs *FavoriteService) RemoveFavorite(userID uint, carID uint) error {
+	return fs.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Unscoped().Where("user_id=? AND car_id=?", userID, carID).Delete(&model.Favorite{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrFavoriteNotFound
+		}
+		return tx.Where("user_id=? AND car_id=?", userID, carID).Delete(&model.AvailabilityWatch{}).Error
+	})
+}
+
+func (fs *FavoriteService) GetWatches(userID uint) ([]model.AvailabilityWatch, error) {
+	var watches []model.AvailabilityWatch
+	err := fs.db.Preload("Car").Where("user_id=?", userID).Order("start_date, id").Find(&watches).Error
+	return watches, err
+}
+
+// watches a saved car that is fully booked for the period
+func (fs *FavoriteService) CreateWatch(userID uint, carID uint, branchID *uint, startDate time.Time, endDate time.Time) (*model.AvailabilityWatch, error) {
+	err := ValidateWatchPeriod(startDate, endDate, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	var favorite model.Favorite
+	err = fs.db.Preload("Car").Where("user_id=? AND car_id=?", userID, carID).First(&favorite).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFavoriteNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var open int64
+	err = fs.db.Model(&model.AvailabilityWatch{}).
+		Where("user_id=? AND notified_at IS NULL AND start_date > ?", userID, time.Now()).
+		Count(&open).Error
+	if err != nil {
+		return nil, err
+	}
+	if open >= MaxWatchesPerUser {
+		return nil, ErrTooManyWatches
+	}
+
+	isAvail, err := fs.cs.IsCarBookable(carID, branchID, startDate, endDate, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isAvail {
+		return nil, ErrCarAvailable
+	}
+
+	watch := model.AvailabilityWatch{
+		UserID:         userID,
+		CarID:          carID,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		PickupBranchID: branchID,
+	}
+	err = fs.db.Create(&watch).Error
+	if err != nil {
+		return nil, err
+	}
+	watch.Car = favorite.Car
+	return &watch, nil
+}
+
+func (fs *FavoriteService) DeleteWatch(userID uint, id uint) error {
+	res := fs.db.Where("id=? AND user_id=?", id, userID).Delete(&model.AvailabilityWatch{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrWatchNotFound
+	}
+	return nil
+}
+
+// notifies the open watches on the car that it is available again, called
+// when units of the car are freed. each watch is notified once
+func (fs *FavoriteService) CheckWatches(carID uint, logger echo.Logger) error {
+	var watches []model.AvailabilityWatch
+	err := fs.db.Preload("User").Preload("Car").
+		Where("car_id=? AND notified_at IS NULL AND start_date > ?", carID, time.Now()).
+		Order("id").Find(&watches).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range watches {
+		w := &watches[i]
+		isAvail, err := fs.cs.IsCarBookable(w.CarID, w.PickupBranchID, w.StartDate, w.EndDate, w.UserID)
+		if err != nil {
+			return err
+		}
+		if !isAvail {
+			continue
+		}
+		// another check may have notified it already
+		res := fs.db.Model(&model.AvailabilityWatch{}).
+			Where("id=? AND notified_at IS NULL", w.ID).
+			Update("notified_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		fs.ns.SendMail(w.UserID, w.User.Email, "A car you saved is available!",
+			fmt.Sprintf("<h1>%s %s is available from %s to %s, book it before it is gone!</h1>",
+				w.Car.Manufacturer, w.Car.CarModel,
+				w.StartDate.Format(mailTimeFormat), w.EndDate.Format(mailTimeFormat)),
+			logger,
+		)
+	}
+	return nil
+}
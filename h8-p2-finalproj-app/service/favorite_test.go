@@ -0,0 +1,34 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWatchPeriod(t *testing.T) {
+	now := mustTime("2024-09-01T10:00:00+07:00")
+	start := mustTime("2024-09-10T09:00:00+07:00")
+
+	assert.NoError(t, service.ValidateWatchPeriod(start, start.AddDate(0, 0, 3), now))
+	assert.ErrorIs(t, service.ValidateWatchPeriod(start, start, now), service.ErrInvalidWatch)
+	assert.ErrorIs(t, service.ValidateWatchPeriod(now, now.AddDate(0, 0, 1), now), service.ErrWatchInPast)
+	assert.ErrorIs(t, service.ValidateWatchPeriod(start, start.AddDate(0, 0, service.MaxWatchDays+1), now), service.ErrWatchTooLong)
+}
+
+func TestWatchStatus(t *testing.T) {
+	now := mustTime("2024-09-01T10:00:00+07:00")
+	notified := mustTime("2024-08-30T08:00:00+07:00")
+
+	watch := model.AvailabilityWatch{StartDate: mustTime("2024-09-10T09:00:00+07:00")}
+	assert.Equal(t, service.WatchStatusWatching, service.WatchStatus(&watch, now))
+
+	watch.NotifiedAt = &notified
+	assert.Equal(t, service.WatchStatusNotified, service.WatchStatus(&watch, now))
+
+	// never notified before the rental would have started
+	expired := model.AvailabilityWatch{StartDate: mustTime("2024-08-31T09:00:00+07:00")}
+	assert.Equal(t, service.WatchStatusExpired, service.WatchStatus(&expired, now))
+}
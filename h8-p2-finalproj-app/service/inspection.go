@@ -0,0 +1,448 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/storage"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MaxInspectionPhotos = 30
+
+// parts every report must cover, staff can add more
+var InspectionChecklist = []string{
+	"Front bumper",
+	"Rear bumper",
+	"Left side",
+	"Right side",
+	"Roof",
+	"Windshield and windows",
+	"Lights",
+	"Tires and wheels",
+	"Interior",
+	"Spare tire and tools",
+	"Vehicle documents",
+}
+
+var (
+	ErrInspectionNotFound      = errors.New("inspection report not found")
+	ErrInspectionExists        = errors.New("rental already has an inspection report of this kind")
+	ErrInvalidInspectionKind   = errors.New("kind must be pickup or return")
+	ErrInvalidFuelLevel        = errors.New("fuel level must be from 0 to 100")
+	ErrInvalidChecklistItem    = errors.New("checklist items need a unique name and a condition of ok, damaged or missing")
+	ErrChecklistIncomplete     = errors.New("checklist incomplete")
+	ErrOdometerBelowPickup     = errors.New("odometer is below the pickup reading")
+	ErrRentalReturned          = errors.New("rental already returned")
+	ErrInspectionAcknowledged  = errors.New("inspection report already acknowledged")
+	ErrTooManyInspectionPhotos = errors.New("too many inspection photos")
+	ErrNotReturnInspection     = errors.New("damage can only be charged on a return inspection")
+	ErrInvalidDamageCharge     = errors.New("amount must be above 0 and reason cannot be empty")
+	ErrDamageChargeNotFound    = errors.New("damage charge not found")
+	ErrDamageChargeInvoiced    = errors.New("damage charge has no invoice left to send")
+)
+
+type InspectionService struct {
+	db    *gorm.DB
+	store storage.Storage
+	sds   *SecurityDepositService
+	is    *InvoiceService
+	ns    *NotificationService
+}
+
+func NewInspectionService(db *gorm.DB, store storage.Storage, sds *SecurityDepositService, is *InvoiceService, ns *NotificationService) *InspectionService {
+	return &InspectionService{
+		db:    db,
+		store: store,
+		sds:   sds,
+		is:    is,
+		ns:    ns,
+	}
+}
+
+type InspectionItemReq struct {
+	Item      string
+	Condition string
+	Note      string
+}
+
+type InspectionReq struct {
+	Kind      string
+	Odometer  uint
+	FuelLevel uint
+	Notes     string
+	Items     []InspectionItemReq
+}
+
+func ValidateInspection(req *InspectionReq) error {
+	if req.Kind != model.InspectionPickup && req.Kind != model.InspectionReturn {
+		return ErrInvalidInspectionKind
+	}
+	if req.FuelLevel > 100 {
+		return ErrInvalidFuelLevel
+	}
+	seen := map[string]bool{}
+	for i := range req.Items {
+		item := &req.Items[i]
+		item.Item = strings.TrimSpace(item.Item)
+		item.Note = strings.TrimSpace(item.Note)
+		key := strings.ToLower(item.Item)
+		if item.Item == "" || seen[key] {
+			return ErrInvalidChecklistItem
+		}
+		switch item.Condition {
+		case model.ConditionOK, model.ConditionDamaged, model.ConditionMissing:
+		default:
+			return ErrInvalidChecklistItem
+		}
+		seen[key] = true
+	}
+	missing := []string{}
+	for _, name := range InspectionChecklist {
+		if !seen[strings.ToLower(name)] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w, missing: %s", ErrChecklistIncomplete, strings.Join(missing, ", "))
+	}
+	req.Notes = strings.TrimSpace(req.Notes)
+	return nil
+}
+
+// items found not ok at return that were ok, or not checked, at pickup
+func NewDamages(pickup []model.InspectionItem, ret []model.InspectionItem) []model.InspectionItem {
+	before := map[string]string{}
+	for _, i := range pickup {
+		before[strings.ToLower(i.Item)] = i.Condition
+	}
+	damages := []model.InspectionItem{}
+	for _, i := range ret {
+		if i.Condition == model.ConditionOK {
+			continue
+		}
+		if condition, ok := before[strings.ToLower(i.Item)]; ok && condition == i.Condition {
+			continue
+		}
+		damages = append(damages, i)
+	}
+	return damages
+}
+
+// the part of a damage charge taken from the held deposit, and the rest to invoice
+func SplitDamageCharge(amount float64, held float64) (float64, float64) {
+	fromDeposit := held
+	if amount < held {
+		fromDeposit = amount
+	}
+	return roundPrice(fromDeposit), roundPrice(amount - fromDeposit)
+}
+
+// the reports of a rental, pickup first
+func (ins *InspectionService) GetForRental(rentalID uint) ([]model.InspectionReport, error) {
+	var reports []model.InspectionReport
+	err := ins.db.Preload("Inspector").Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Photos", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("DamageCharges.Payment").
+		Where("rental_id=?", rentalID).Order("id").Find(&reports).Error
+	return reports, err
+}
+
+// the report with its rental, to check who may see it
+func (ins *InspectionService) Get(id uint) (*model.InspectionReport, error) {
+	var report model.InspectionReport
+	err := ins.db.Preload("Rental").Preload("Photos").Where("id=?", id).First(&report).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInspectionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// records the inspection by staff and asks the customer to acknowledge it
+func (ins *InspectionService) Create(rentalID uint, staffID uint, req *InspectionReq, logger echo.Logger) (*model.InspectionReport, error) {
+	err := ValidateInspection(req)
+	if err != nil {
+		return nil, err
+	}
+	report := model.InspectionReport{
+		RentalID:    rentalID,
+		Kind:        req.Kind,
+		InspectorID: staffID,
+		Odometer:    req.Odometer,
+		FuelLevel:   req.FuelLevel,
+		Notes:       req.Notes,
+	}
+	for _, i := range req.Items {
+		report.Items = append(report.Items, model.InspectionItem{
+			Item:      i.Item,
+			Condition: i.Condition,
+			Note:      i.Note,
+		})
+	}
+
+	err = ins.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("User").Preload("Payment").
+			Where("id=?", rentalID).First(&report.Rental).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRentalNotFound
+		} else if err != nil {
+			return err
+		}
+		rental := &report.Rental
+		if !IsRentalActive(rental.Payment.Status) {
+			return ErrRentalCancelled
+		}
+		if req.Kind == model.InspectionPickup && rental.ReturnedAt != nil {
+			return ErrRentalReturned
+		}
+		if req.Kind == model.InspectionReturn && rental.PickedUpAt == nil {
+			return ErrRentalNotPickedUp
+		}
+
+		var reports []model.InspectionReport
+		err = tx.Where("rental_id=?", rentalID).Find(&reports).Error
+		if err != nil {
+			return err
+		}
+		for _, r := range reports {
+			if r.Kind == req.Kind {
+				return ErrInspectionExists
+			}
+			if (r.Kind == model.InspectionPickup && req.Odometer < r.Odometer) ||
+				(r.Kind == model.InspectionReturn && req.Odometer > r.Odometer) {
+				return ErrOdometerBelowPickup
+			}
+		}
+
+		err = tx.Omit("Rental").Create(&report).Error
+		if err != nil {
+			return err
+		}
+		// the unit's odometer only goes up
+		if rental.VehicleUnitID != nil {
+			err = tx.Model(&model.VehicleUnit{}).
+				Where("id=? AND odometer < ?", *rental.VehicleUnitID, req.Odometer).
+				Update("odometer", req.Odometer).Error
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user := report.Rental.User
+	ins.ns.SendMail(user.ID, user.Email,
+		fmt.Sprintf("Please check the %s inspection of your rental", report.Kind),
+		fmt.Sprintf(`<h1>Hello %s,</h1>
+		<p>We inspected the car at %s for rental %d.</p>
+		<p>Odometer: %d km<br>Fuel: %d%%</p>
+		<p>Please review the report and acknowledge it in the app.</p>`,
+			user.Name, report.Kind, report.RentalID, report.Odometer, report.FuelLevel),
+		logger,
+	)
+	return &report, nil
+}
+
+// attaches a photo with its caption, until the customer acknowledged the report
+func (ins *InspectionService) AddPhoto(reportID uint, item string, caption string, r io.Reader) (*model.InspectionPhoto, error) {
+	report, err := ins.Get(reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.AcknowledgedAt != nil {
+		return nil, ErrInspectionAcknowledged
+	}
+	if len(report.Photos) >= MaxInspectionPhotos {
+		return nil, ErrTooManyInspectionPhotos
+	}
+
+	// one byte more than allowed to tell when the limit is passed
+	data, err := io.ReadAll(io.LimitReader(r, MaxCarImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	contentType, _, err := decodeCarImage(data)
+	if err != nil {
+		return nil, err
+	}
+	name, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("inspections/%d/%s.%s", report.RentalID, name, imageExtByContentType[contentType])
+	err = ins.store.Put(key, contentType, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	photo := model.InspectionPhoto{
+		InspectionReportID: report.ID,
+		Item:               strings.TrimSpace(item),
+		Caption:            strings.TrimSpace(caption),
+		ImageKey:           key,
+		ContentType:        contentType,
+	}
+	err = ins.db.Create(&photo).Error
+	if err != nil {
+		removeFiles(ins.store, key)
+		return nil, err
+	}
+	return &photo, nil
+}
+
+// the customer, or the driver they booked for, agrees with the report,
+// optionally with a comment
+func (ins *InspectionService) Acknowledge(userID uint, reportID uint, comment string) (*model.InspectionReport, error) {
+	var report model.InspectionReport
+	err := ins.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Joins("join rentals on rentals.id = inspection_reports.rental_id").
+			Where("inspection_reports.id=? AND (rentals.user_id=? OR rentals.driver_id=?)", reportID, userID, userID).
+			First(&report).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInspectionNotFound
+		} else if err != nil {
+			return err
+		}
+		if report.AcknowledgedAt != nil {
+			return ErrInspectionAcknowledged
+		}
+		now := time.Now()
+		report.AcknowledgedAt = &now
+		report.CustomerComment = strings.TrimSpace(comment)
+		return tx.Model(&report).Updates(map[string]any{
+			"acknowledged_at":  now,
+			"customer_comment": report.CustomerComment,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// charges the customer for damage found at return, from the held security
+// deposit first and with an invoice for what it does not cover
+func (ins *InspectionService) ChargeDamage(reportID uint, staffID uint, amount float64, reason string, logger echo.Logger) (*model.DamageCharge, error) {
+	reason = strings.TrimSpace(reason)
+	if amount <= 0 || reason == "" {
+		return nil, ErrInvalidDamageCharge
+	}
+	var charge model.DamageCharge
+	err := ins.db.Transaction(func(tx *gorm.DB) error {
+		var report model.InspectionReport
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Rental.User").
+			Where("id=?", reportID).First(&report).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInspectionNotFound
+		} else if err != nil {
+			return err
+		}
+		if report.Kind != model.InspectionReturn {
+			return ErrNotReturnInspection
+		}
+
+		charge = model.DamageCharge{
+			InspectionReportID: report.ID,
+			RentalID:           report.RentalID,
+			UserID:             report.Rental.UserID,
+			User:               report.Rental.User,
+			Amount:             roundPrice(amount),
+			Reason:             reason,
+			ChargedByID:        staffID,
+		}
+		// deposits already released or never required leave it all to the invoice
+		held := 0.0
+		deposit, err := ins.sds.lockHeld(tx, report.RentalID)
+		if err == nil {
+			held = deposit.Amount
+		} else if !errors.Is(err, ErrDepositNotFound) && !errors.Is(err, ErrDepositNotHeld) {
+			return err
+		}
+		charge.FromDeposit, charge.Invoiced = SplitDamageCharge(charge.Amount, held)
+		if charge.FromDeposit > 0 {
+			err = ins.sds.capture(tx, &deposit, staffID, charge.FromDeposit, reason)
+			if err != nil {
+				return err
+			}
+		}
+		if charge.Invoiced > 0 {
+			charge.Payment = &model.Payment{
+				Status: "Unpaid",
+			}
+		}
+		return tx.Omit("User").Create(&charge).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the charge is saved, an invoice that fails can be sent again
+	err = ins.sendDamageCharge(ins.db, &charge, logger)
+	if err != nil {
+		return &charge, err
+	}
+	return &charge, nil
+}
+
+// sends the invoice of a charge whose invoice failed, with the email
+func (ins *InspectionService) InvoiceDamageCharge(chargeID uint, logger echo.Logger) (*model.DamageCharge, error) {
+	var charge model.DamageCharge
+	err := ins.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("User").Preload("Payment").
+			Where("id=?", chargeID).First(&charge).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrDamageChargeNotFound
+		} else if err != nil {
+			return err
+		}
+		if charge.Payment == nil || charge.Payment.Status != "Unpaid" || charge.Payment.PaymentUrl != "" {
+			return ErrDamageChargeInvoiced
+		}
+		return ins.sendDamageCharge(tx, &charge, logger)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &charge, nil
+}
+
+// generates the invoice of the invoiced part, if any, and emails the charge
+func (ins *InspectionService) sendDamageCharge(tx *gorm.DB, charge *model.DamageCharge, logger echo.Logger) error {
+	paymentNote := ""
+	if charge.Payment != nil {
+		url, err := ins.is.GenerateInvoice(charge.Payment.ID, charge.Invoiced,
+			fmt.Sprintf("Damage charge for rental %d: %s", charge.RentalID, charge.Reason),
+			charge.User.Email,
+		)
+		if err != nil {
+			return err
+		}
+		charge.Payment.PaymentUrl = url
+		err = tx.Save(charge.Payment).Error
+		if err != nil {
+			return err
+		}
+		paymentNote = fmt.Sprintf("<p>Please pay the remaining IDR %.0f here:<br>%s</p>", charge.Invoiced, url)
+	}
+
+	ins.ns.SendMail(charge.User.ID, charge.User.Email, "Damage charge for your rental",
+		fmt.Sprintf(`<h1>Hello %s,</h1>
+		<p>Damage was found when the car of rental %d was returned.</p>
+		<p>Reason: %s<br>Charge: IDR %.0f<br>Taken from your security deposit: IDR %.0f</p>
+		%s`,
+			charge.User.Name, charge.RentalID, charge.Reason, charge.Amount, charge.FromDeposit, paymentNote),
+		logger,
+	)
+	return nil
+}
@@ -0,0 +1,87 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fullChecklist() []service.InspectionItemReq {
+	items := []service.InspectionItemReq{}
+	for _, name := range service.InspectionChecklist {
+		items = append(items, service.InspectionItemReq{Item: name, Condition: model.ConditionOK})
+	}
+	return items
+}
+
+func TestValidateInspection(t *testing.T) {
+	req := service.InspectionReq{
+		Kind:      model.InspectionPickup,
+		Odometer:  15000,
+		FuelLevel: 100,
+		Items:     append(fullChecklist(), service.InspectionItemReq{Item: " Roof rack ", Condition: model.ConditionDamaged}),
+	}
+	assert.NoError(t, service.ValidateInspection(&req))
+	assert.Equal(t, "Roof rack", req.Items[len(req.Items)-1].Item)
+
+	req.Kind = "handover"
+	assert.ErrorIs(t, service.ValidateInspection(&req), service.ErrInvalidInspectionKind)
+
+	req = service.InspectionReq{Kind: model.InspectionReturn, FuelLevel: 101, Items: fullChecklist()}
+	assert.ErrorIs(t, service.ValidateInspection(&req), service.ErrInvalidFuelLevel)
+
+	req = service.InspectionReq{Kind: model.InspectionReturn, Items: fullChecklist()}
+	req.Items[0].Condition = "scratched"
+	assert.ErrorIs(t, service.ValidateInspection(&req), service.ErrInvalidChecklistItem)
+
+	// the same part twice
+	req = service.InspectionReq{Kind: model.InspectionReturn, Items: fullChecklist()}
+	req.Items = append(req.Items, service.InspectionItemReq{Item: "front BUMPER", Condition: model.ConditionOK})
+	assert.ErrorIs(t, service.ValidateInspection(&req), service.ErrInvalidChecklistItem)
+
+	req = service.InspectionReq{Kind: model.InspectionReturn, Items: fullChecklist()[1:]}
+	err := service.ValidateInspection(&req)
+	assert.ErrorIs(t, err, service.ErrChecklistIncomplete)
+	assert.Contains(t, err.Error(), service.InspectionChecklist[0])
+}
+
+func TestNewDamages(t *testing.T) {
+	pickup := []model.InspectionItem{
+		{Item: "Front bumper", Condition: model.ConditionDamaged},
+		{Item: "Left side", Condition: model.ConditionOK},
+		{Item: "Spare tire and tools", Condition: model.ConditionOK},
+	}
+	ret := []model.InspectionItem{
+		// already damaged at pickup
+		{Item: "front bumper", Condition: model.ConditionDamaged},
+		{Item: "Left side", Condition: model.ConditionDamaged},
+		{Item: "Spare tire and tools", Condition: model.ConditionMissing},
+		// not checked at pickup
+		{Item: "Roof rack", Condition: model.ConditionDamaged},
+		{Item: "Lights", Condition: model.ConditionOK},
+	}
+	damages := service.NewDamages(pickup, ret)
+	names := []string{}
+	for _, d := range damages {
+		names = append(names, d.Item)
+	}
+	assert.Equal(t, []string{"Left side", "Spare tire and tools", "Roof rack"}, names)
+	assert.Empty(t, service.NewDamages(pickup, nil))
+}
+
+func TestSplitDamageCharge(t *testing.T) {
+	fromDeposit, invoiced := service.SplitDamageCharge(300000, 500000)
+	assert.Equal(t, 300000.0, fromDeposit)
+	assert.Equal(t, 0.0, invoiced)
+
+	fromDeposit, invoiced = service.SplitDamageCharge(750000, 500000)
+	assert.Equal(t, 500000.0, fromDeposit)
+	assert.Equal(t, 250000.0, invoiced)
+
+	// no deposit held
+	fromDeposit, invoiced = service.SplitDamageCharge(750000, 0)
+	assert.Equal(t, 0.0, fromDeposit)
+	assert.Equal(t, 750000.0, invoiced)
+}
@@ -10,15 +10,22 @@ import (
 	"os"
 )
 
+var (
+	ErrInvoicePaid = errors.New("invoice already paid")
+)
+
 type InvoiceService struct {
 	client   *http.Client
 	hostname string
+	// v1 api, invoices are expired there
+	expireHostname string
 }
 
 func NewInvoiceService() *InvoiceService {
 	return &InvoiceService{
-		client:   &http.Client{},
-		hostname: "https://api.xendit.co/v2/invoices",
+		client:         &http.Client{},
+		hostname:       "https://api.xendit.co/v2/invoices",
+		expireHostname: "https://api.xendit.co/invoices",
 	}
 }
 
@@ -86,3 +93,48 @@ func (is *InvoiceService) GenerateInvoice(id uint,
 		return "", errors.New("failed to get invoice url from resp")
 	}
 }
+
+// sends a request to xendit and decodes the json response into respBody
+func (is *InvoiceService) do(method string, url string, respBody any) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(os.Getenv("XENDIT_API_KEY"), "")
+
+	resp, err := is.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("xendit status code %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(respBody)
+}
+
+// expires the pending invoices of the payment so they can no longer be paid,
+// ErrInvoicePaid when one was paid already
+func (is *InvoiceService) ExpireInvoice(id uint) error {
+	var invoices []struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err := is.do("GET", fmt.Sprintf("%s?external_id=%d", is.hostname, id), &invoices)
+	if err != nil {
+		return err
+	}
+	for _, invoice := range invoices {
+		switch invoice.Status {
+		case "PAID", "SETTLED":
+			return ErrInvoicePaid
+		case "PENDING":
+			var expired map[string]any
+			err = is.do("POST", fmt.Sprintf("%s/%s/expire!", is.expireHostname, invoice.ID), &expired)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
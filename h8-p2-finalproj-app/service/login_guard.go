@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	MaxFailedLoginsPerAccount = 5
+	MaxFailedLoginsPerIP      = 20
+	LoginAttemptWindow        = 15 * time.Minute
+	LockoutDuration           = 30 * time.Minute
+)
+
+var (
+	ErrTooManyLoginAttempts = errors.New("too many login attempts")
+	ErrInvalidUnlockToken   = errors.New("invalid unlock token")
+)
+
+type LoginGuardService struct {
+	db *gorm.DB
+}
+
+func NewLoginGuardService(db *gorm.DB) *LoginGuardService {
+	return &LoginGuardService{
+		db: db,
+	}
+}
+
+// returns ErrTooManyLoginAttempts if the ip has too many recent failures
+func (lg *LoginGuardService) CheckIP(ip string) error {
+	var count int64
+	err := lg.db.Model(&model.LoginAttempt{}).
+		Where("ip=? AND NOT success AND created_at > ?", ip, time.Now().Add(-LoginAttemptWindow)).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count >= MaxFailedLoginsPerIP {
+		return ErrTooManyLoginAttempts
+	}
+	return nil
+}
+
+// counts failed attempts for the account within the window,
+// since the last successful login or the end of the last lockout
+func (lg *LoginGuardService) countAccountFailures(user *model.User) (int64, error) {
+	since := time.Now().Add(-LoginAttemptWindow)
+
+	var lastSuccess model.LoginAttempt
+	err := lg.db.Where("email=? AND success", user.Email).Order("created_at desc").First(&lastSuccess).Error
+	if err == nil && lastSuccess.CreatedAt.After(since) {
+		since = lastSuccess.CreatedAt
+	} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var lastLockout model.AccountLockout
+	err = lg.db.Where("user_id=?", user.ID).Order("created_at desc").First(&lastLockout).Error
+	if err == nil {
+		lockoutEnd := lastLockout.LockedUntil
+		if lastLockout.UnlockedAt != nil {
+			lockoutEnd = *lastLockout.UnlockedAt
+		}
+		if lockoutEnd.After(since) {
+			since = lockoutEnd
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var count int64
+	err = lg.db.Model(&model.LoginAttempt{}).
+		Where("email=? AND NOT success AND created_at > ?", user.Email, since).
+		Count(&count).Error
+	return count, err
+}
+
+func (lg *LoginGuardService) IsLocked(userID uint) (bool, error) {
+	var count int64
+	err := lg.db.Model(&model.AccountLockout{}).
+		Where("user_id=? AND unlocked_at IS NULL AND locked_until > ?", userID, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (lg *LoginGuardService) RecordSuccess(email string, ip string) error {
+	return lg.db.Create(&model.LoginAttempt{Email: email, IP: ip, Success: true}).Error
+}
+
+// records a failed attempt, locks the account when it reaches the limit.
+// returns the lockout and the plain unlock token if the account got locked
+func (lg *LoginGuardService) RecordFailure(email string, ip string, user *model.User) (*model.AccountLockout, string, error) {
+	err := lg.db.Create(&model.LoginAttempt{Email: email, IP: ip, Success: false}).Error
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", nil
+	}
+
+	count, err := lg.countAccountFailures(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if count < MaxFailedLoginsPerAccount {
+		return nil, "", nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	lockout := model.AccountLockout{
+		UserID:          user.ID,
+		IP:              ip,
+		Reason:          fmt.Sprintf("%d failed login attempts", count),
+		LockedUntil:     time.Now().Add(LockoutDuration),
+		UnlockTokenHash: hashToken(token),
+	}
+	err = lg.db.Create(&lockout).Error
+	if err != nil {
+		return nil, "", err
+	}
+	return &lockout, token, nil
+}
+
+// unlocks the account of an active lockout using the emailed token
+func (lg *LoginGuardService) Unlock(token string) error {
+	var lockout model.AccountLockout
+	err := lg.db.Where("unlock_token_hash=? AND unlocked_at IS NULL", hashToken(token)).First(&lockout).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidUnlockToken
+	} else if err != nil {
+		return err
+	}
+	if time.Now().After(lockout.LockedUntil) {
+		return ErrInvalidUnlockToken
+	}
+
+	now := time.Now()
+	lockout.UnlockedAt = &now
+	return lg.db.Save(&lockout).Error
+}
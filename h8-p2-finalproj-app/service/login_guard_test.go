@@ -0,0 +1,169 @@
+package service_test
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func newTestLoginGuard(t *testing.T) (*service.LoginGuardService, *gorm.DB) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	return service.NewLoginGuardService(db), db
+}
+
+// an ip no other test uses
+func testIP() string {
+	n := time.Now().UnixNano()
+	return fmt.Sprintf("10.%d.%d.%d", n/65536%256, n/256%256, n%256)
+}
+
+// records failures until just below the account limit
+func failBelowLimit(t *testing.T, lg *service.LoginGuardService, user *model.User, ip string) {
+	for i := 0; i < service.MaxFailedLoginsPerAccount-1; i++ {
+		lockout, _, err := lg.RecordFailure(user.Email, ip, user)
+		assert.NoError(t, err)
+		assert.Nil(t, lockout)
+	}
+}
+
+func TestCheckIPLimit(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	ip := testIP()
+
+	for i := 0; i < service.MaxFailedLoginsPerIP-1; i++ {
+		_, _, err := lg.RecordFailure(fmt.Sprintf("unknown-%d@example.com", i), ip, nil)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, lg.CheckIP(ip))
+	// successful logins don't count
+	assert.NoError(t, lg.RecordSuccess("known@example.com", ip))
+	assert.NoError(t, lg.CheckIP(ip))
+
+	_, _, err := lg.RecordFailure("unknown@example.com", ip, nil)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, lg.CheckIP(ip), service.ErrTooManyLoginAttempts)
+	assert.NoError(t, lg.CheckIP(testIP()))
+
+	// the failures leave the window
+	err = db.Model(&model.LoginAttempt{}).Where("ip=?", ip).
+		Update("created_at", time.Now().Add(-service.LoginAttemptWindow-time.Minute)).Error
+	assert.NoError(t, err)
+	assert.NoError(t, lg.CheckIP(ip))
+}
+
+func TestRecordFailureLocksAccount(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	ip := testIP()
+
+	failBelowLimit(t, lg, user, ip)
+	locked, err := lg.IsLocked(user.ID)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+
+	lockout, token, err := lg.RecordFailure(user.Email, ip, user)
+	assert.NoError(t, err)
+	assert.NotNil(t, lockout)
+	assert.NotEmpty(t, token)
+	// only the hash is stored
+	assert.NotEqual(t, token, lockout.UnlockTokenHash)
+	assert.WithinDuration(t, time.Now().Add(service.LockoutDuration), lockout.LockedUntil, time.Minute)
+
+	locked, err = lg.IsLocked(user.ID)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+}
+
+func TestAccountFailuresCountSinceLastSuccess(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	ip := testIP()
+
+	failBelowLimit(t, lg, user, ip)
+	assert.NoError(t, lg.RecordSuccess(user.Email, ip))
+	failBelowLimit(t, lg, user, ip)
+
+	locked, err := lg.IsLocked(user.ID)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+}
+
+func TestAccountFailuresOutsideWindow(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	ip := testIP()
+
+	failBelowLimit(t, lg, user, ip)
+	err := db.Model(&model.LoginAttempt{}).Where("email=?", user.Email).
+		Update("created_at", time.Now().Add(-service.LoginAttemptWindow-time.Minute)).Error
+	assert.NoError(t, err)
+
+	lockout, _, err := lg.RecordFailure(user.Email, ip, user)
+	assert.NoError(t, err)
+	assert.Nil(t, lockout)
+}
+
+func TestUnlock(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	ip := testIP()
+
+	failBelowLimit(t, lg, user, ip)
+	lockout, token, err := lg.RecordFailure(user.Email, ip, user)
+	assert.NoError(t, err)
+	assert.NotNil(t, lockout)
+
+	assert.ErrorIs(t, lg.Unlock("not-the-token"), service.ErrInvalidUnlockToken)
+	assert.NoError(t, lg.Unlock(token))
+	locked, err := lg.IsLocked(user.ID)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	// the token works once
+	assert.ErrorIs(t, lg.Unlock(token), service.ErrInvalidUnlockToken)
+
+	// failures before the unlock are not counted again
+	lockout, _, err = lg.RecordFailure(user.Email, ip, user)
+	assert.NoError(t, err)
+	assert.Nil(t, lockout)
+}
+
+func TestUnlockAfterLockoutEnded(t *testing.T) {
+	lg, db := newTestLoginGuard(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	ip := testIP()
+
+	failBelowLimit(t, lg, user, ip)
+	lockout, token, err := lg.RecordFailure(user.Email, ip, user)
+	assert.NoError(t, err)
+	assert.NotNil(t, lockout)
+
+	err = db.Model(lockout).Update("locked_until", time.Now().Add(-time.Minute)).Error
+	assert.NoError(t, err)
+	locked, err := lg.IsLocked(user.ID)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.ErrorIs(t, lg.Unlock(token), service.ErrInvalidUnlockToken)
+}
+
+func TestUnknownEmailNeverLocks(t *testing.T) {
+	lg, _ := newTestLoginGuard(t)
+	ip := testIP()
+	email := fmt.Sprintf("unknown-%d@example.com", time.Now().UnixNano())
+
+	for i := 0; i < service.MaxFailedLoginsPerAccount*2; i++ {
+		lockout, token, err := lg.RecordFailure(email, ip, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, lockout)
+		assert.Empty(t, token)
+	}
+}
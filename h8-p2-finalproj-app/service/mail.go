@@ -9,6 +9,9 @@ import (
 	"gopkg.in/gomail.v2"
 )
 
+// dates and times in mails, with the timezone they were booked in
+const mailTimeFormat = "2006-01-02 15:04 -07:00"
+
 func SendMail(to string, subject string, body string, logger echo.Logger) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", "mrdrummerman123@gmail.com")
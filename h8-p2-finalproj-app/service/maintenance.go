@@ -0,0 +1,125 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMaintenanceNotFound = errors.New("maintenance not found")
+	ErrUnitNotOfCar        = errors.New("unit does not belong to car")
+)
+
+type MaintenanceService struct {
+	db *gorm.DB
+	cs *CarService
+}
+
+func NewMaintenanceService(db *gorm.DB, cs *CarService) *MaintenanceService {
+	return &MaintenanceService{
+		db: db,
+		cs: cs,
+	}
+}
+
+func (ms *MaintenanceService) GetMaintenances(carID uint) ([]model.Maintenance, error) {
+	var maintenances []model.Maintenance
+	err := ms.db.Preload("Units").
+		Where("car_id=?", carID).
+		Order("start_date").
+		Find(&maintenances).Error
+	return maintenances, err
+}
+
+// schedules the maintenance and returns the bookings that conflict with it
+func (ms *MaintenanceService) Schedule(
+	car *model.Car,
+	startDate time.Time,
+	endDate time.Time,
+	reason string,
+	unitIDs []uint,
+	createdBy *model.User) (*model.Maintenance, []model.Rental, error) {
+
+	var units []model.VehicleUnit
+	err := ms.db.Where("id IN ? AND car_id=?", unitIDs, car.ID).Find(&units).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(units) != len(unitIDs) {
+		return nil, nil, ErrUnitNotOfCar
+	}
+
+	maintenance := model.Maintenance{
+		CarID:       car.ID,
+		StartDate:   startDate,
+		EndDate:     endDate,
+		Reason:      reason,
+		CreatedByID: createdBy.ID,
+		Units:       units,
+	}
+	err = ms.db.Omit("Units.*").Create(&maintenance).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conflicts, err := ms.Conflicts(&maintenance)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &maintenance, conflicts, nil
+}
+
+// active bookings overlapping the maintenance on its units, and bookings
+// without a unit yet if the car no longer has enough units left for them
+func (ms *MaintenanceService) Conflicts(m *model.Maintenance) ([]model.Rental, error) {
+	unitIDs := []uint{}
+	for _, u := range m.Units {
+		unitIDs = append(unitIDs, u.ID)
+	}
+
+	// the end date is included, so the maintenance lasts until the day after
+	endDate := m.EndDate.AddDate(0, 0, 1)
+	var data AvailableCarData
+	err := ms.cs.CarsWithRentalQuery(&GetCarsQueryParams{
+		StartDate: &m.StartDate,
+		EndDate:   &endDate,
+	}).Where("cars.id=?", m.CarID).First(&data).Error
+	if err != nil {
+		return nil, err
+	}
+
+	q := ms.db.Preload("User").Preload("VehicleUnit").
+		Joins("left join payments on payments.purchase_id = rentals.id AND payments.purchase_type = ?", "rentals").
+		Where("rentals.car_id=? AND rentals.returned_at IS NULL", m.CarID).
+		Where("payments.status IN ?", activeRentalPaymentStatuses)
+	q = whereRentalOverlaps(q, "rentals", m.StartDate, endDate)
+	if data.IsOverbooked() {
+		q = q.Where("rentals.vehicle_unit_id IN ? OR rentals.vehicle_unit_id IS NULL", unitIDs)
+	} else {
+		q = q.Where("rentals.vehicle_unit_id IN ?", unitIDs)
+	}
+
+	var rentals []model.Rental
+	err = q.Order("rentals.start_date").Find(&rentals).Error
+	return rentals, err
+}
+
+func (ms *MaintenanceService) Cancel(carID uint, id uint) error {
+	var maintenance model.Maintenance
+	err := ms.db.Where("id=? AND car_id=?", id, carID).First(&maintenance).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrMaintenanceNotFound
+	} else if err != nil {
+		return err
+	}
+	return ms.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&maintenance).Association("Units").Clear()
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&maintenance).Error
+	})
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"h8-p2-finalproj-app/model"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const NotificationChannelEmail = "email"
+
+type NotificationService struct {
+	db *gorm.DB
+}
+
+func NewNotificationService(db *gorm.DB) *NotificationService {
+	return &NotificationService{
+		db: db,
+	}
+}
+
+// sends the mail and records its subject in the notification history of the user
+func (ns *NotificationService) SendMail(userID uint, to string, subject string, body string, logger echo.Logger) error {
+	err := ns.db.Create(&model.Notification{
+		UserID:    userID,
+		Channel:   NotificationChannelEmail,
+		Recipient: to,
+		Subject:   subject,
+	}).Error
+	if err != nil {
+		logger.Errorf("failed to record notification: %s", err.Error())
+	}
+	return SendMail(to, subject, body, logger)
+}
@@ -0,0 +1,668 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rentals on an organization account are confirmed at booking and paid with
+// the monthly invoice
+const PaymentStatusOnAccount = "OnAccount"
+
+// payment statuses of rentals that are booked, others were cancelled or failed
+var activeRentalPaymentStatuses = []string{"Unpaid", "Completed", PaymentStatusOnAccount}
+
+// paid, or on an organization account, so the car can be handed over
+func IsRentalConfirmed(paymentStatus string) bool {
+	return paymentStatus == "Completed" || paymentStatus == PaymentStatusOnAccount
+}
+
+// not cancelled, refunded or expired, the rental still holds a unit
+func IsRentalActive(paymentStatus string) bool {
+	return slices.Contains(activeRentalPaymentStatuses, paymentStatus)
+}
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrOrganizationName     = errors.New("organization name already used")
+	ErrInvalidOrganization  = errors.New("name and a valid billing email are required")
+	ErrInvalidLimit         = errors.New("limit cannot be negative")
+	ErrInvalidOrgRole       = errors.New("invalid organization role")
+	ErrNotOrgMember         = errors.New("not a member of the organization")
+	ErrOrgRoleForbidden     = errors.New("organization role not allowed")
+	ErrAlreadyOrgMember     = errors.New("user already belongs to an organization")
+	ErrMemberUserNotFound   = errors.New("user not found")
+	ErrLastOrgAdmin         = errors.New("organization needs at least one admin")
+	ErrDriverNotMember      = errors.New("driver is not a member of the organization")
+	ErrOrgLimitReached      = errors.New("organization monthly limit reached")
+	ErrMemberLimitReached   = errors.New("member monthly limit reached")
+	ErrInvalidPeriod        = errors.New("period must be a past month as YYYY-MM")
+	ErrAlreadyInvited       = errors.New("user already invited to the organization")
+	ErrInvitationNotFound   = errors.New("invitation not found")
+)
+
+type OrganizationService struct {
+	db *gorm.DB
+	is *InvoiceService
+	ns *NotificationService
+}
+
+func NewOrganizationService(db *gorm.DB, is *InvoiceService, ns *NotificationService) *OrganizationService {
+	return &OrganizationService{
+		db: db,
+		is: is,
+		ns: ns,
+	}
+}
+
+type OrganizationReq struct {
+	Name         string
+	BillingEmail string
+	MonthlyLimit *float64
+}
+
+func ValidateOrganization(req *OrganizationReq) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.BillingEmail = strings.TrimSpace(req.BillingEmail)
+	if req.Name == "" {
+		return ErrInvalidOrganization
+	}
+	if _, err := mail.ParseAddress(req.BillingEmail); err != nil {
+		return ErrInvalidOrganization
+	}
+	if req.MonthlyLimit != nil && *req.MonthlyLimit < 0 {
+		return ErrInvalidLimit
+	}
+	return nil
+}
+
+func ValidateOrgRole(role string) error {
+	switch role {
+	case model.OrgRoleAdmin, model.OrgRoleBooker, model.OrgRoleDriver:
+		return nil
+	}
+	return ErrInvalidOrgRole
+}
+
+// first day of the month of t and of the next month
+func MonthBounds(t time.Time) (time.Time, time.Time) {
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 1, 0)
+}
+
+// the month of a YYYY-MM period, which must have ended before now
+func ParseInvoicePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	month, err := time.ParseInLocation("2006-01", period, now.Location())
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	start, end := MonthBounds(month)
+	if end.After(now) {
+		return time.Time{}, time.Time{}, ErrInvalidPeriod
+	}
+	return start, end, nil
+}
+
+// whether booking the amount stays within the limit, nil limits are unlimited
+func WithinLimit(limit *float64, spent float64, amount float64) bool {
+	return limit == nil || spent+amount <= *limit
+}
+
+func (ors *OrganizationService) Get(id uint) (*model.Organization, error) {
+	var org model.Organization
+	err := ors.db.Preload("Members", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Members.User").Preload("Invitations", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("Invitations.Organization").Preload("Invitations.User").Where("id=?", id).First(&org).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrganizationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// membership of the user in the organization
+func (ors *OrganizationService) GetMember(orgID uint, userID uint) (*model.OrganizationMember, error) {
+	var member model.OrganizationMember
+	err := ors.db.Where("organization_id=? AND user_id=?", orgID, userID).First(&member).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotOrgMember
+	} else if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// membership of the user in any organization
+func (ors *OrganizationService) GetMembership(userID uint) (*model.OrganizationMember, error) {
+	var member model.OrganizationMember
+	err := ors.db.Where("user_id=?", userID).First(&member).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotOrgMember
+	} else if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// the membership of the user if their role is one of the roles
+func (ors *OrganizationService) RequireRole(orgID uint, userID uint, roles ...string) (*model.OrganizationMember, error) {
+	member, err := ors.GetMember(orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if member.Role == role {
+			return member, nil
+		}
+	}
+	return nil, ErrOrgRoleForbidden
+}
+
+// creates the organization with the user as its admin
+func (ors *OrganizationService) Create(userID uint, req *OrganizationReq) (*model.Organization, error) {
+	err := ValidateOrganization(req)
+	if err != nil {
+		return nil, err
+	}
+	org := model.Organization{
+		Name:         req.Name,
+		BillingEmail: req.BillingEmail,
+		MonthlyLimit: req.MonthlyLimit,
+	}
+	err = ors.db.Transaction(func(tx *gorm.DB) error {
+		err := ors.checkNotMember(tx, userID)
+		if err != nil {
+			return err
+		}
+		err = ors.checkNameFree(tx, req.Name, 0)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&org).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&model.OrganizationMember{
+			OrganizationID: org.ID,
+			UserID:         userID,
+			Role:           model.OrgRoleAdmin,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ors.Get(org.ID)
+}
+
+func (ors *OrganizationService) Update(org *model.Organization, req *OrganizationReq) (*model.Organization, error) {
+	err := ValidateOrganization(req)
+	if err != nil {
+		return nil, err
+	}
+	err = ors.checkNameFree(ors.db, req.Name, org.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = ors.db.Model(org).Updates(map[string]any{
+		"name":          req.Name,
+		"billing_email": req.BillingEmail,
+		"monthly_limit": req.MonthlyLimit,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return ors.Get(org.ID)
+}
+
+func (ors *OrganizationService) checkNameFree(tx *gorm.DB, name string, exceptID uint) error {
+	var count int64
+	err := tx.Model(&model.Organization{}).Where("name=? AND id<>?", name, exceptID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return ErrOrganizationName
+	}
+	return nil
+}
+
+func (ors *OrganizationService) checkNotMember(tx *gorm.DB, userID uint) error {
+	var count int64
+	err := tx.Model(&model.OrganizationMember{}).Where("user_id=?", userID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return ErrAlreadyOrgMember
+	}
+	return nil
+}
+
+// invites the registered user with the email to the organization, they
+// only become a member once they accept
+func (ors *OrganizationService) InviteMember(orgID uint, email string, role string, limit *float64, logger echo.Logger) (*model.OrganizationInvitation, error) {
+	err := ValidateOrgRole(role)
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil && *limit < 0 {
+		return nil, ErrInvalidLimit
+	}
+	var user model.User
+	err = ors.db.Where("email=?", strings.TrimSpace(email)).First(&user).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMemberUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	invitation := model.OrganizationInvitation{
+		OrganizationID: orgID,
+		UserID:         user.ID,
+		User:           user,
+		Role:           role,
+		MonthlyLimit:   limit,
+	}
+	err = ors.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id=?", orgID).First(&invitation.Organization).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrOrganizationNotFound
+		} else if err != nil {
+			return err
+		}
+		err = ors.checkNotMember(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		var count int64
+		err = tx.Model(&model.OrganizationInvitation{}).
+			Where("organization_id=? AND user_id=?", orgID, user.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return ErrAlreadyInvited
+		}
+		return tx.Omit("Organization", "User").Create(&invitation).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ors.ns.SendMail(user.ID, user.Email,
+		fmt.Sprintf("You are invited to %s", invitation.Organization.Name),
+		fmt.Sprintf("<h1>Hello %s, you are invited to join %s as %s.</h1><p>Accept the invitation in the app to book on the organization account.</p>",
+			user.Name, invitation.Organization.Name, role),
+		logger,
+	)
+	return &invitation, nil
+}
+
+// the pending invitations of the user
+func (ors *OrganizationService) GetInvitations(userID uint) ([]model.OrganizationInvitation, error) {
+	var invitations []model.OrganizationInvitation
+	err := ors.db.Preload("Organization").Preload("User").
+		Where("user_id=?", userID).Order("id").Find(&invitations).Error
+	return invitations, err
+}
+
+// makes the user a member, their other invitations are dropped since a user
+// belongs to one organization
+func (ors *OrganizationService) AcceptInvitation(userID uint, invitationID uint) (*model.OrganizationMember, error) {
+	var member model.OrganizationMember
+	err := ors.db.Transaction(func(tx *gorm.DB) error {
+		var invitation model.OrganizationInvitation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id=? AND user_id=?", invitationID, userID).First(&invitation).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvitationNotFound
+		} else if err != nil {
+			return err
+		}
+		err = ors.checkNotMember(tx, userID)
+		if err != nil {
+			return err
+		}
+		member = model.OrganizationMember{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         userID,
+			Role:           invitation.Role,
+			MonthlyLimit:   invitation.MonthlyLimit,
+		}
+		err = tx.Create(&member).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id=?", userID).Delete(&model.OrganizationInvitation{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = ors.db.Where("id=?", userID).First(&member.User).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// the user declines an invitation
+func (ors *OrganizationService) DeclineInvitation(userID uint, invitationID uint) error {
+	return ors.deleteInvitation(ors.db.Where("id=? AND user_id=?", invitationID, userID))
+}
+
+// an admin withdraws an invitation of the organization
+func (ors *OrganizationService) WithdrawInvitation(orgID uint, invitationID uint) error {
+	return ors.deleteInvitation(ors.db.Where("id=? AND organization_id=?", invitationID, orgID))
+}
+
+func (ors *OrganizationService) deleteInvitation(q *gorm.DB) error {
+	// removed for good so the user can be invited again
+	result := q.Unscoped().Delete(&model.OrganizationInvitation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// the user leaves their organization, the last admin can't leave
+func (ors *OrganizationService) Leave(userID uint) error {
+	member, err := ors.GetMembership(userID)
+	if err != nil {
+		return err
+	}
+	return ors.RemoveMember(member.OrganizationID, userID)
+}
+
+// changes the role and limit of a member, the last admin can't be demoted
+func (ors *OrganizationService) UpdateMember(orgID uint, userID uint, role string, limit *float64) (*model.OrganizationMember, error) {
+	err := ValidateOrgRole(role)
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil && *limit < 0 {
+		return nil, ErrInvalidLimit
+	}
+	var member model.OrganizationMember
+	err = ors.db.Transaction(func(tx *gorm.DB) error {
+		err := ors.lockMember(tx, orgID, userID, &member)
+		if err != nil {
+			return err
+		}
+		if member.Role == model.OrgRoleAdmin && role != model.OrgRoleAdmin {
+			err = ors.checkOtherAdmin(tx, orgID, userID)
+			if err != nil {
+				return err
+			}
+		}
+		member.Role = role
+		member.MonthlyLimit = limit
+		return tx.Model(&member).Updates(map[string]any{
+			"role":          role,
+			"monthly_limit": limit,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = ors.db.Where("id=?", member.UserID).First(&member.User).Error
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (ors *OrganizationService) RemoveMember(orgID uint, userID uint) error {
+	return ors.db.Transaction(func(tx *gorm.DB) error {
+		var member model.OrganizationMember
+		err := ors.lockMember(tx, orgID, userID, &member)
+		if err != nil {
+			return err
+		}
+		if member.Role == model.OrgRoleAdmin {
+			err = ors.checkOtherAdmin(tx, orgID, userID)
+			if err != nil {
+				return err
+			}
+		}
+		// removed for good so the user can join another organization
+		return tx.Unscoped().Delete(&member).Error
+	})
+}
+
+// locks the organization's members so admins can't all be removed at once
+func (ors *OrganizationService) lockMember(tx *gorm.DB, orgID uint, userID uint, member *model.OrganizationMember) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", orgID).First(&model.Organization{}).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrOrganizationNotFound
+	} else if err != nil {
+		return err
+	}
+	err = tx.Where("organization_id=? AND user_id=?", orgID, userID).First(member).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotOrgMember
+	}
+	return err
+}
+
+func (ors *OrganizationService) checkOtherAdmin(tx *gorm.DB, orgID uint, userID uint) error {
+	var admins int64
+	err := tx.Model(&model.OrganizationMember{}).
+		Where("organization_id=? AND role=? AND user_id<>?", orgID, model.OrgRoleAdmin, userID).
+		Count(&admins).Error
+	if err != nil {
+		return err
+	}
+	if admins == 0 {
+		return ErrLastOrgAdmin
+	}
+	return nil
+}
+
+// checks the booker may book on the organization account for the driver,
+// the driver is the booker when nil
+func (ors *OrganizationService) AuthorizeBooking(orgID uint, bookerID uint, driverID *uint) error {
+	_, err := ors.RequireRole(orgID, bookerID, model.OrgRoleAdmin, model.OrgRoleBooker)
+	if err != nil {
+		return err
+	}
+	if driverID != nil && *driverID != bookerID {
+		_, err = ors.GetMember(orgID, *driverID)
+		if err != nil && errors.Is(err, ErrNotOrgMember) {
+			return ErrDriverNotMember
+		}
+		return err
+	}
+	return nil
+}
+
+// checks the rental stays within the monthly limits of the organization and
+// the booker, counted from the rentals booked this month. locks the
+// organization so concurrent bookings are counted one after the other
+func (ors *OrganizationService) CheckSpending(tx *gorm.DB, orgID uint, bookerID uint, amount float64) error {
+	var org model.Organization
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", orgID).First(&org).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrOrganizationNotFound
+	} else if err != nil {
+		return err
+	}
+	member, err := ors.GetMember(orgID, bookerID)
+	if err != nil {
+		return err
+	}
+
+	monthStart, monthEnd := MonthBounds(time.Now())
+	spent := func(q *gorm.DB) (float64, error) {
+		var total float64
+		q = q.Model(&model.Rental{}).
+			Select("COALESCE(SUM(rentals.total_price), 0)").
+			Where("rentals.organization_id=? AND rentals.created_at >= ? AND rentals.created_at < ?", orgID, monthStart, monthEnd)
+		err := whereRentalActive(q, "rentals").Scan(&total).Error
+		return total, err
+	}
+	orgSpent, err := spent(tx)
+	if err != nil {
+		return err
+	}
+	if !WithinLimit(org.MonthlyLimit, orgSpent, amount) {
+		return ErrOrgLimitReached
+	}
+	memberSpent, err := spent(tx.Where("rentals.user_id=?", bookerID))
+	if err != nil {
+		return err
+	}
+	if !WithinLimit(member.MonthlyLimit, memberSpent, amount) {
+		return ErrMemberLimitReached
+	}
+	return nil
+}
+
+func (ors *OrganizationService) GetInvoices(orgID uint) ([]model.OrganizationInvoice, error) {
+	var invoices []model.OrganizationInvoice
+	err := ors.db.Preload("Payment").Where("organization_id=?", orgID).Order("period DESC").Find(&invoices).Error
+	return invoices, err
+}
+
+// bills the rentals of every organization ending in the month with one
+// invoice each. organizations already billed for the month are skipped,
+// unless their invoice has no payment link yet. an organization that fails
+// is logged and billed again on the next run
+func (ors *OrganizationService) GenerateInvoices(periodStart time.Time, periodEnd time.Time, logger echo.Logger) ([]model.OrganizationInvoice, error) {
+	var orgs []model.Organization
+	err := ors.db.Order("id").Find(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+	invoices := []model.OrganizationInvoice{}
+	for i := range orgs {
+		invoice, err := ors.generateInvoice(&orgs[i], periodStart, periodEnd)
+		if err != nil {
+			logger.Errorf("failed to generate invoice of organization %d: %s", orgs[i].ID, err.Error())
+			continue
+		}
+		if invoice == nil {
+			continue
+		}
+		ors.sendInvoice(&orgs[i], invoice, logger)
+		invoices = append(invoices, *invoice)
+	}
+	return invoices, nil
+}
+
+// nil when there is nothing to bill or the invoice was already sent. an
+// invoice without a payment link was not sent, its link is generated again
+func (ors *OrganizationService) generateInvoice(org *model.Organization, periodStart time.Time, periodEnd time.Time) (*model.OrganizationInvoice, error) {
+	invoice := model.OrganizationInvoice{
+		OrganizationID: org.ID,
+		Period:         periodStart.Format("2006-01"),
+		PeriodStart:    periodStart,
+		PeriodEnd:      periodEnd,
+		Payment: model.Payment{
+			Status: "Unpaid",
+		},
+	}
+	unsent := false
+	err := ors.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", org.ID).First(&model.Organization{}).Error
+		if err != nil {
+			return err
+		}
+		var billed model.OrganizationInvoice
+		err = tx.Preload("Payment").Where("organization_id=? AND period=?", org.ID, invoice.Period).First(&billed).Error
+		if err == nil {
+			if billed.Payment.PaymentUrl == "" {
+				invoice = billed
+				unsent = true
+			}
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var rentals []model.Rental
+		q := tx.Where("rentals.organization_id=? AND rentals.organization_invoice_id IS NULL", org.ID).
+			Where("rentals.end_date >= ? AND rentals.end_date < ?", periodStart, periodEnd)
+		err = whereRentalActive(q, "rentals").Find(&rentals).Error
+		if err != nil || len(rentals) == 0 {
+			return err
+		}
+		rentalIDs := []uint{}
+		for _, r := range rentals {
+			invoice.Total += r.TotalPrice
+			rentalIDs = append(rentalIDs, r.ID)
+		}
+		invoice.Total = roundPrice(invoice.Total)
+		err = tx.Create(&invoice).Error
+		if err != nil {
+			return err
+		}
+		unsent = true
+		return tx.Model(&model.Rental{}).Where("id IN ?", rentalIDs).
+			Update("organization_invoice_id", invoice.ID).Error
+	})
+	if err != nil || !unsent {
+		return nil, err
+	}
+
+	url, err := ors.is.GenerateInvoice(
+		invoice.Payment.ID,
+		invoice.Total,
+		fmt.Sprintf("Rentals of %s for %s", org.Name, invoice.Period),
+		org.BillingEmail,
+	)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Payment.PaymentUrl = url
+	err = ors.db.Save(&invoice.Payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+func (ors *OrganizationService) sendInvoice(org *model.Organization, invoice *model.OrganizationInvoice, logger echo.Logger) {
+	// not in a user's notification history, the billing email may be anyone's
+	err := SendMail(org.BillingEmail,
+		fmt.Sprintf("Your rentals invoice for %s", invoice.Period),
+		fmt.Sprintf(`
+		<h1>Hello %s,</h1><br>
+		<p>Here is the invoice of the rentals ending in %s.</p>
+		<p>Total: IDR %.0f</p>
+		<p>You can make payment here:<br>%s</p>
+		`, org.Name, invoice.Period, invoice.Total, invoice.Payment.PaymentUrl),
+		logger,
+	)
+	if err != nil {
+		logger.Errorf("failed to send invoice of organization %d: %s", org.ID, err.Error())
+	}
+}
+
+// bills the previous month once it is over, checked every interval until
+// the app stops. months already billed are skipped
+func (ors *OrganizationService) RunMonthlyInvoicing(interval time.Duration, logger echo.Logger) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		periodEnd, _ := MonthBounds(time.Now())
+		periodStart := periodEnd.AddDate(0, -1, 0)
+		_, err := ors.GenerateInvoices(periodStart, periodEnd, logger)
+		if err != nil {
+			logger.Errorf("failed to generate organization invoices: %s", err.Error())
+		}
+	}
+}
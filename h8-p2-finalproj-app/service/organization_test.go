@@ -0,0 +1,125 @@
+package service_test
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithinLimit(t *testing.T) {
+	limit := 1000000.0
+	assert.True(t, service.WithinLimit(nil, 5000000, 5000000))
+	assert.True(t, service.WithinLimit(&limit, 600000, 400000))
+	assert.False(t, service.WithinLimit(&limit, 600000, 400001))
+
+	zero := 0.0
+	assert.False(t, service.WithinLimit(&zero, 0, 1))
+}
+
+func TestMonthBounds(t *testing.T) {
+	start, end := service.MonthBounds(mustTime("2024-12-31T23:00:00+07:00"))
+	assert.Equal(t, mustTime("2024-12-01T00:00:00+07:00"), start)
+	assert.Equal(t, mustTime("2025-01-01T00:00:00+07:00"), end)
+}
+
+func TestParseInvoicePeriod(t *testing.T) {
+	now := mustTime("2024-09-01T08:00:00+07:00")
+
+	start, end, err := service.ParseInvoicePeriod("2024-08", now)
+	assert.NoError(t, err)
+	assert.True(t, start.Equal(mustTime("2024-08-01T00:00:00+07:00")))
+	assert.True(t, end.Equal(mustTime("2024-09-01T00:00:00+07:00")))
+
+	// the current month is not over yet
+	_, _, err = service.ParseInvoicePeriod("2024-09", now)
+	assert.ErrorIs(t, err, service.ErrInvalidPeriod)
+	_, _, err = service.ParseInvoicePeriod("2024-8", now)
+	assert.ErrorIs(t, err, service.ErrInvalidPeriod)
+	_, _, err = service.ParseInvoicePeriod("", now)
+	assert.ErrorIs(t, err, service.ErrInvalidPeriod)
+}
+
+func TestValidateOrganization(t *testing.T) {
+	req := service.OrganizationReq{Name: " PT Maju ", BillingEmail: " finance@maju.co.id "}
+	assert.NoError(t, service.ValidateOrganization(&req))
+	assert.Equal(t, "PT Maju", req.Name)
+	assert.Equal(t, "finance@maju.co.id", req.BillingEmail)
+
+	assert.ErrorIs(t, service.ValidateOrganization(&service.OrganizationReq{Name: " ", BillingEmail: "a@b.c"}), service.ErrInvalidOrganization)
+	assert.ErrorIs(t, service.ValidateOrganization(&service.OrganizationReq{Name: "PT Maju", BillingEmail: "finance"}), service.ErrInvalidOrganization)
+
+	limit := -1.0
+	req = service.OrganizationReq{Name: "PT Maju", BillingEmail: "a@b.c", MonthlyLimit: &limit}
+	assert.ErrorIs(t, service.ValidateOrganization(&req), service.ErrInvalidLimit)
+}
+
+func TestValidateOrgRole(t *testing.T) {
+	for _, role := range []string{model.OrgRoleAdmin, model.OrgRoleBooker, model.OrgRoleDriver} {
+		assert.NoError(t, service.ValidateOrgRole(role))
+	}
+	assert.ErrorIs(t, service.ValidateOrgRole("owner"), service.ErrInvalidOrgRole)
+}
+
+func TestIsRentalConfirmed(t *testing.T) {
+	assert.True(t, service.IsRentalConfirmed("Completed"))
+	assert.True(t, service.IsRentalConfirmed(service.PaymentStatusOnAccount))
+	for _, status := range []string{"Unpaid", service.PaymentStatusCancelled, service.PaymentStatusExpired} {
+		assert.False(t, service.IsRentalConfirmed(status), status)
+	}
+}
+
+func TestInvitationFlow(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	ors := service.NewOrganizationService(db, service.NewInvoiceService(), service.NewNotificationService(db))
+	logger := echo.New().Logger
+
+	admin := CreateTestUser(t, db, model.RoleUser)
+	org, err := ors.Create(admin.ID, &service.OrganizationReq{
+		Name:         fmt.Sprintf("PT Test %d", time.Now().UnixNano()),
+		BillingEmail: "finance@example.com",
+	})
+	assert.NoError(t, err)
+
+	// the invited user is not a member until they accept
+	user := CreateTestUser(t, db, model.RoleUser)
+	invitation, err := ors.InviteMember(org.ID, user.Email, model.OrgRoleBooker, nil, logger)
+	assert.NoError(t, err)
+	_, err = ors.GetMembership(user.ID)
+	assert.ErrorIs(t, err, service.ErrNotOrgMember)
+	_, err = ors.InviteMember(org.ID, user.Email, model.OrgRoleDriver, nil, logger)
+	assert.ErrorIs(t, err, service.ErrAlreadyInvited)
+
+	// only the invited user can accept
+	_, err = ors.AcceptInvitation(admin.ID, invitation.ID)
+	assert.ErrorIs(t, err, service.ErrInvitationNotFound)
+	member, err := ors.AcceptInvitation(user.ID, invitation.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, org.ID, member.OrganizationID)
+	assert.Equal(t, model.OrgRoleBooker, member.Role)
+	invitations, err := ors.GetInvitations(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, invitations)
+
+	// members can leave, the last admin can't
+	assert.NoError(t, ors.Leave(user.ID))
+	_, err = ors.GetMembership(user.ID)
+	assert.ErrorIs(t, err, service.ErrNotOrgMember)
+	assert.ErrorIs(t, ors.Leave(admin.ID), service.ErrLastOrgAdmin)
+
+	// declined invitations are gone
+	invitation, err = ors.InviteMember(org.ID, user.Email, model.OrgRoleDriver, nil, logger)
+	assert.NoError(t, err)
+	assert.NoError(t, ors.DeclineInvitation(user.ID, invitation.ID))
+	_, err = ors.AcceptInvitation(user.ID, invitation.ID)
+	assert.ErrorIs(t, err, service.ErrInvitationNotFound)
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PricingService struct {
+	db *gorm.DB
+}
+
+func NewPricingService(db *gorm.DB) *PricingService {
+	return &PricingService{
+		db: db,
+	}
+}
+
+type PriceLine struct {
+	Description string
+	Quantity    uint
+	UnitPrice   float64
+	Amount      float64
+}
+
+type PriceBreakdown struct {
+	Days  uint
+	Hours uint
+	Lines []PriceLine
+	Total float64
+}
+
+func roundPrice(p float64) float64 {
+	return math.Round(p*100) / 100
+}
+
+func (pb *PriceBreakdown) AddLine(description string, quantity uint, unitPrice float64) {
+	amount := roundPrice(float64(quantity) * unitPrice)
+	pb.Lines = append(pb.Lines, PriceLine{
+		Description: description,
+		Quantity:    quantity,
+		UnitPrice:   roundPrice(unitPrice),
+		Amount:      amount,
+	})
+	pb.Total = roundPrice(pb.Total + amount)
+}
+
+func (pb *PriceBreakdown) ToRentalPriceItems() []model.RentalPriceItem {
+	items := []model.RentalPriceItem{}
+	for _, l := range pb.Lines {
+		items = append(items, model.RentalPriceItem{
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			Amount:      l.Amount,
+		})
+	}
+	return items
+}
+
+// number of days started, same as before pricing rules
+func RentalDays(startDate time.Time, endDate time.Time) uint {
+	return uint(math.Ceil(endDate.Sub(startDate).Hours() / 24))
+}
+
+// whole days of the rental and the hours started after them
+func RentalDuration(startDate time.Time, endDate time.Time) (uint, uint) {
+	hours := uint(math.Ceil(endDate.Sub(startDate).Hours()))
+	return hours / 24, hours % 24
+}
+
+// days and hours charged, the hours left over are charged by the hour
+// unless that costs more than a day or the car has no hourly rate
+func chargedDuration(car *model.Car, startDate time.Time, endDate time.Time) (uint, uint) {
+	days, hours := RentalDuration(startDate, endDate)
+	if hours > 0 && (car.RatePerHour <= 0 || float64(hours)*car.RatePerHour >= car.RatePerDay) {
+		return days + 1, 0
+	}
+	return days, hours
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// keeps the car's own rules for the types it has, and the general rules otherwise
+func effectiveRules(carID uint, rules []model.PricingRule) map[string][]model.PricingRule {
+	general := map[string][]model.PricingRule{}
+	specific := map[string][]model.PricingRule{}
+	for _, r := range rules {
+		if r.CarID == nil {
+			general[r.Type] = append(general[r.Type], r)
+		} else if *r.CarID == carID {
+			specific[r.Type] = append(specific[r.Type], r)
+		}
+	}
+	for t, rs := range specific {
+		general[t] = rs
+	}
+	return general
+}
+
+// prices each day of the rental with the rules, days with the
+// same price and description are grouped in one line
+func CalculatePrice(car *model.Car, startDate time.Time, endDate time.Time, rules []model.PricingRule) *PriceBreakdown {
+	byType := effectiveRules(car.ID, rules)
+	var weekend *model.PricingRule
+	if rs := byType[model.PricingRuleWeekend]; len(rs) > 0 {
+		weekend = &rs[0]
+	}
+
+	type dayPrice struct {
+		description string
+		price       float64
+	}
+	order := []dayPrice{}
+	counts := map[dayPrice]uint{}
+
+	days, hours := chargedDuration(car, startDate, endDate)
+	for i := 0; i < int(days); i++ {
+		day := startDate.AddDate(0, 0, i)
+		// season dates have no time, compare the calendar date of the day
+		date := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		dp := dayPrice{description: "Daily rate", price: car.RatePerDay}
+		if weekend != nil && isWeekend(day) {
+			dp.description = weekend.Name
+			if weekend.Rate != nil {
+				dp.price = *weekend.Rate
+			} else {
+				dp.price = car.RatePerDay * weekend.Multiplier
+			}
+		}
+
+		// the highest season applies when seasons overlap
+		var season *model.PricingRule
+		for j, s := range byType[model.PricingRuleSeason] {
+			if s.StartDate == nil || s.EndDate == nil || date.Before(*s.StartDate) || date.After(*s.EndDate) {
+				continue
+			}
+			if season == nil || s.Multiplier > season.Multiplier {
+				season = &byType[model.PricingRuleSeason][j]
+			}
+		}
+		if season != nil {
+			dp.description = fmt.Sprintf("%s, %s", dp.description, season.Name)
+			dp.price = dp.price * season.Multiplier
+		}
+
+		dp.price = roundPrice(dp.price)
+		if _, ok := counts[dp]; !ok {
+			order = append(order, dp)
+		}
+		counts[dp]++
+	}
+
+	breakdown := PriceBreakdown{Days: days, Hours: hours, Lines: []PriceLine{}}
+	for _, dp := range order {
+		breakdown.AddLine(dp.description, counts[dp], dp.price)
+	}
+	if hours > 0 {
+		breakdown.AddLine("Hourly rate", hours, car.RatePerHour)
+	}
+
+	// the biggest long stay discount the rental qualifies for
+	var longStay *model.PricingRule
+	for j, r := range byType[model.PricingRuleLongStay] {
+		if days < r.MinDays {
+			continue
+		}
+		if longStay == nil || r.DiscountPercent > longStay.DiscountPercent {
+			longStay = &byType[model.PricingRuleLongStay][j]
+		}
+	}
+	if longStay != nil && breakdown.Total > 0 {
+		breakdown.AddLine(
+			fmt.Sprintf("%s (%.0f%% off)", longStay.Name, longStay.DiscountPercent),
+			1,
+			-breakdown.Total*longStay.DiscountPercent/100,
+		)
+	}
+
+	return &breakdown
+}
+
+// general rules and the car's own rules
+func (ps *PricingService) GetRulesForCar(carID uint) ([]model.PricingRule, error) {
+	var rules []model.PricingRule
+	err := ps.db.Where("car_id IS NULL OR car_id = ?", carID).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+func (ps *PricingService) Calculate(car *model.Car, startDate time.Time, endDate time.Time) (*PriceBreakdown, error) {
+	rules, err := ps.GetRulesForCar(car.ID)
+	if err != nil {
+		return nil, err
+	}
+	return CalculatePrice(car, startDate, endDate, rules), nil
+}
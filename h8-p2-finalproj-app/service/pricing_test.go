@@ -0,0 +1,119 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func mustDate(s string) time.Time {
+	d, _ := time.Parse(time.DateOnly, s)
+	return d
+}
+
+func testCar() *model.Car {
+	return &model.Car{Model: gorm.Model{ID: 1}, RatePerDay: 100000}
+}
+
+func TestCalculatePriceFlatRate(t *testing.T) {
+	// monday to thursday
+	pb := service.CalculatePrice(testCar(), mustDate("2024-09-02"), mustDate("2024-09-05"), nil)
+	assert.Equal(t, uint(3), pb.Days)
+	assert.Len(t, pb.Lines, 1)
+	assert.Equal(t, 300000.0, pb.Total)
+}
+
+func TestCalculatePriceWeekend(t *testing.T) {
+	rules := []model.PricingRule{
+		{Type: model.PricingRuleWeekend, Name: "Weekend rate", Multiplier: 1.5},
+	}
+	// friday to monday, saturday and sunday are weekend days
+	pb := service.CalculatePrice(testCar(), mustDate("2024-09-06"), mustDate("2024-09-09"), rules)
+	assert.Len(t, pb.Lines, 2)
+	assert.Equal(t, "Daily rate", pb.Lines[0].Description)
+	assert.Equal(t, uint(2), pb.Lines[1].Quantity)
+	assert.Equal(t, 150000.0, pb.Lines[1].UnitPrice)
+	assert.Equal(t, 400000.0, pb.Total)
+}
+
+func TestCalculatePriceCarOverride(t *testing.T) {
+	carID := uint(1)
+	otherCarID := uint(2)
+	rate := 120000.0
+	rules := []model.PricingRule{
+		{Type: model.PricingRuleWeekend, Name: "Weekend rate", Multiplier: 2},
+		{Type: model.PricingRuleWeekend, Name: "Weekend special", CarID: &carID, Rate: &rate},
+		{Type: model.PricingRuleWeekend, Name: "Other car", CarID: &otherCarID, Multiplier: 3},
+	}
+	// saturday only
+	pb := service.CalculatePrice(testCar(), mustDate("2024-09-07"), mustDate("2024-09-08"), rules)
+	assert.Equal(t, "Weekend special", pb.Lines[0].Description)
+	assert.Equal(t, 120000.0, pb.Total)
+}
+
+func TestCalculatePriceSeason(t *testing.T) {
+	start := mustDate("2024-12-24")
+	end := mustDate("2024-12-26")
+	rules := []model.PricingRule{
+		{Type: model.PricingRuleSeason, Name: "Christmas", Multiplier: 2, StartDate: &start, EndDate: &end},
+		{Type: model.PricingRuleSeason, Name: "Holidays", Multiplier: 1.2, StartDate: &start, EndDate: &end},
+	}
+	// monday 23rd to friday 27th, 24th to 26th in season
+	pb := service.CalculatePrice(testCar(), mustDate("2024-12-23"), mustDate("2024-12-27"), rules)
+	assert.Len(t, pb.Lines, 2)
+	assert.Equal(t, "Daily rate, Christmas", pb.Lines[1].Description)
+	assert.Equal(t, uint(3), pb.Lines[1].Quantity)
+	assert.Equal(t, 700000.0, pb.Total)
+}
+
+func TestCalculatePriceLongStay(t *testing.T) {
+	rules := []model.PricingRule{
+		{Type: model.PricingRuleLongStay, Name: "Weekly discount", MinDays: 7, DiscountPercent: 10},
+		{Type: model.PricingRuleLongStay, Name: "Monthly discount", MinDays: 30, DiscountPercent: 25},
+	}
+	pb := service.CalculatePrice(testCar(), mustDate("2024-09-02"), mustDate("2024-09-09"), rules)
+	assert.Len(t, pb.Lines, 2)
+	assert.Equal(t, -70000.0, pb.Lines[1].Amount)
+	assert.Equal(t, 630000.0, pb.Total)
+
+	// too short for a discount
+	pb = service.CalculatePrice(testCar(), mustDate("2024-09-02"), mustDate("2024-09-04"), rules)
+	assert.Len(t, pb.Lines, 1)
+}
+
+func mustTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+func TestCalculatePriceHourly(t *testing.T) {
+	car := testCar()
+	car.RatePerHour = 15000
+	// one day and 3 hours, started hours are charged in full
+	pb := service.CalculatePrice(car, mustTime("2024-09-02T09:00:00+07:00"), mustTime("2024-09-03T11:30:00+07:00"), nil)
+	assert.Equal(t, uint(1), pb.Days)
+	assert.Equal(t, uint(3), pb.Hours)
+	assert.Len(t, pb.Lines, 2)
+	assert.Equal(t, "Hourly rate", pb.Lines[1].Description)
+	assert.Equal(t, 145000.0, pb.Total)
+}
+
+func TestCalculatePriceHourlyCappedAtDay(t *testing.T) {
+	car := testCar()
+	car.RatePerHour = 15000
+	// 7 hours cost more than a day
+	pb := service.CalculatePrice(car, mustTime("2024-09-02T09:00:00+07:00"), mustTime("2024-09-02T16:00:00+07:00"), nil)
+	assert.Equal(t, uint(1), pb.Days)
+	assert.Equal(t, uint(0), pb.Hours)
+	assert.Equal(t, 100000.0, pb.Total)
+}
+
+func TestCalculatePriceWithoutHourlyRate(t *testing.T) {
+	pb := service.CalculatePrice(testCar(), mustTime("2024-09-02T09:00:00+07:00"), mustTime("2024-09-03T11:00:00+07:00"), nil)
+	assert.Equal(t, uint(2), pb.Days)
+	assert.Equal(t, 200000.0, pb.Total)
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPromoNotFound         = errors.New("promo code not found")
+	ErrPromoNotActive        = errors.New("promo code not active")
+	ErrPromoMinSpend         = errors.New("minimum spend not reached")
+	ErrPromoCarType          = errors.New("promo code not valid for car type")
+	ErrPromoLimitReached     = errors.New("promo code usage limit reached")
+	ErrPromoUserLimitReached = errors.New("promo code already used by user")
+)
+
+type PromotionService struct {
+	db *gorm.DB
+}
+
+func NewPromotionService(db *gorm.DB) *PromotionService {
+	return &PromotionService{
+		db: db,
+	}
+}
+
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// discount of the promotion on the subtotal, never more than the subtotal
+func CalculateDiscount(promo *model.Promotion, subtotal float64) float64 {
+	discount := promo.DiscountValue
+	if promo.DiscountType == model.DiscountPercentage {
+		discount = subtotal * promo.DiscountValue / 100
+		if promo.MaxDiscount > 0 {
+			discount = math.Min(discount, promo.MaxDiscount)
+		}
+	}
+	return roundPrice(math.Min(discount, subtotal))
+}
+
+// checks the promotion can be used for the car and subtotal at the time,
+// usage limits are checked separately
+func CheckPromotion(promo *model.Promotion, car *model.Car, subtotal float64, now time.Time) error {
+	if !promo.Active || now.Before(promo.StartsAt) || now.After(promo.EndsAt) {
+		return ErrPromoNotActive
+	}
+	if subtotal < promo.MinSpend {
+		return ErrPromoMinSpend
+	}
+	if promo.CarTypes != "" {
+		for _, t := range strings.Split(promo.CarTypes, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), car.Type) {
+				return nil
+			}
+		}
+		return ErrPromoCarType
+	}
+	return nil
+}
+
+// redemptions of rentals that still stand, a cancelled, refunded or expired
+// rental gives its use of the code back
+func activeRedemptionsQuery(tx *gorm.DB) *gorm.DB {
+	q := tx.Model(&model.PromotionRedemption{}).
+		Joins("join rentals on rentals.id = promotion_redemptions.rental_id")
+	return whereRentalActive(q, "rentals")
+}
+
+func (prs *PromotionService) checkLimits(tx *gorm.DB, promo *model.Promotion, userID uint) error {
+	if promo.UsageLimit > 0 {
+		var count int64
+		err := activeRedemptionsQuery(tx).Where("promotion_redemptions.promotion_id=?", promo.ID).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(promo.UsageLimit) {
+			return ErrPromoLimitReached
+		}
+	}
+	if promo.PerUserLimit > 0 {
+		var count int64
+		err := activeRedemptionsQuery(tx).
+			Where("promotion_redemptions.promotion_id=? AND promotion_redemptions.user_id=?", promo.ID, userID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(promo.PerUserLimit) {
+			return ErrPromoUserLimitReached
+		}
+	}
+	return nil
+}
+
+func (prs *PromotionService) GetByCode(code string) (*model.Promotion, error) {
+	var promo model.Promotion
+	err := prs.db.Where("code=?", NormalizePromoCode(code)).First(&promo).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPromoNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// validates the code for the user and adds the discount to the breakdown
+func (prs *PromotionService) Apply(code string, user *model.User, car *model.Car, breakdown *PriceBreakdown) (*model.Promotion, float64, error) {
+	promo, err := prs.GetByCode(code)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = CheckPromotion(promo, car, breakdown.Total, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	err = prs.checkLimits(prs.db, promo, user.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	discount := CalculateDiscount(promo, breakdown.Total)
+	breakdown.AddLine("Promo "+promo.Code, 1, -discount)
+	return promo, discount, nil
+}
+
+// records the use of the promotion by the rental, the limits are checked
+// again with the promotion locked so concurrent bookings can't exceed them
+func (prs *PromotionService) Redeem(tx *gorm.DB, promoID uint, userID uint, rentalID uint, discount float64) error {
+	var promo model.Promotion
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", promoID).First(&promo).Error
+	if err != nil {
+		return err
+	}
+	err = prs.checkLimits(tx, &promo, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&model.PromotionRedemption{
+		PromotionID: promo.ID,
+		UserID:      userID,
+		RentalID:    rentalID,
+		Discount:    discount,
+	}).Error
+}
+
+type PromotionStats struct {
+	Redemptions   int64
+	UniqueUsers   int64
+	TotalDiscount float64
+	TotalRevenue  float64
+	PaidRentals   int64
+}
+
+func (prs *PromotionService) GetStats(promoID uint) (*PromotionStats, error) {
+	var stats PromotionStats
+	err := prs.db.Model(&model.PromotionRedemption{}).
+		Select("COUNT(promotion_redemptions.id) AS redemptions, "+
+			"COUNT(DISTINCT promotion_redemptions.user_id) AS unique_users, "+
+			"COALESCE(SUM(promotion_redemptions.discount), 0) AS total_discount").
+		Where("promotion_id=?", promoID).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// revenue of the rentals that were paid
+	err = prs.db.Model(&model.PromotionRedemption{}).
+		Select("COUNT(rentals.id) AS paid_rentals, COALESCE(SUM(rentals.total_price), 0) AS total_revenue").
+		Joins("join rentals on rentals.id = promotion_redemptions.rental_id").
+		Joins("join payments on payments.purchase_id = rentals.id AND payments.purchase_type = ?", "rentals").
+		Where("promotion_redemptions.promotion_id=? AND payments.status = ?", promoID, "Completed").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
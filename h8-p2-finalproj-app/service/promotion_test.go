@@ -0,0 +1,105 @@
+package service_test
+
+import (
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPromotion() *model.Promotion {
+	return &model.Promotion{
+		Code:          "HOLIDAY",
+		DiscountType:  model.DiscountPercentage,
+		DiscountValue: 10,
+		StartsAt:      mustDate("2024-12-01"),
+		EndsAt:        mustDate("2024-12-31"),
+		Active:        true,
+	}
+}
+
+func TestCalculateDiscountPercentage(t *testing.T) {
+	promo := testPromotion()
+	assert.Equal(t, 50000.0, service.CalculateDiscount(promo, 500000))
+
+	promo.MaxDiscount = 30000
+	assert.Equal(t, 30000.0, service.CalculateDiscount(promo, 500000))
+}
+
+func TestCalculateDiscountFixed(t *testing.T) {
+	promo := testPromotion()
+	promo.DiscountType = model.DiscountFixed
+	promo.DiscountValue = 100000
+	assert.Equal(t, 100000.0, service.CalculateDiscount(promo, 500000))
+	// never more than the price
+	assert.Equal(t, 80000.0, service.CalculateDiscount(promo, 80000))
+}
+
+func TestCheckPromotion(t *testing.T) {
+	car := &model.Car{Type: "SUV"}
+	now := mustDate("2024-12-10")
+
+	promo := testPromotion()
+	assert.NoError(t, service.CheckPromotion(promo, car, 100000, now))
+
+	assert.ErrorIs(t, service.CheckPromotion(promo, car, 100000, now.AddDate(0, 1, 0)), service.ErrPromoNotActive)
+
+	promo.MinSpend = 200000
+	assert.ErrorIs(t, service.CheckPromotion(promo, car, 100000, now), service.ErrPromoMinSpend)
+
+	promo.MinSpend = 0
+	promo.CarTypes = "Sedan, Coupe"
+	assert.ErrorIs(t, service.CheckPromotion(promo, car, 100000, now), service.ErrPromoCarType)
+	promo.CarTypes = "Sedan,suv"
+	assert.NoError(t, service.CheckPromotion(promo, car, 100000, now))
+
+	promo.Active = false
+	assert.ErrorIs(t, service.CheckPromotion(promo, car, 100000, now), service.ErrPromoNotActive)
+}
+
+func TestRedeemLimitsCountOnlyActiveRentals(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	prs := service.NewPromotionService(db)
+	car, _ := CreateTestCar(t, db, 3, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+	other := CreateTestUser(t, db, model.RoleUser)
+
+	promo := testPromotion()
+	promo.Code = fmt.Sprintf("TEST%d", time.Now().UnixNano())
+	promo.UsageLimit = 2
+	promo.PerUserLimit = 1
+	err := db.Create(promo).Error
+	assert.NoError(t, err)
+
+	redeem := func(user *model.User, status string) (*model.Rental, error) {
+		rental := CreateTestRental(t, db, user, car,
+			mustTime("2030-03-01T10:00:00Z"), mustTime("2030-03-03T10:00:00Z"), status)
+		return rental, prs.Redeem(db, promo.ID, user.ID, rental.ID, 10000)
+	}
+
+	first, err := redeem(user, "Unpaid")
+	assert.NoError(t, err)
+	_, err = redeem(user, "Unpaid")
+	assert.ErrorIs(t, err, service.ErrPromoUserLimitReached)
+
+	// the cancelled booking gives the use back
+	err = db.Model(&model.Payment{}).Where("id=?", first.Payment.ID).
+		Update("status", service.PaymentStatusCancelled).Error
+	assert.NoError(t, err)
+	_, err = redeem(user, "Completed")
+	assert.NoError(t, err)
+
+	_, err = redeem(other, service.PaymentStatusOnAccount)
+	assert.NoError(t, err)
+	third := CreateTestUser(t, db, model.RoleUser)
+	_, err = redeem(third, "Unpaid")
+	assert.ErrorIs(t, err, service.ErrPromoLimitReached)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const QuoteTTL = 30 * time.Minute
+
+var (
+	ErrQuoteNotFound = errors.New("quote not found")
+	ErrQuoteExpired  = errors.New("quote expired")
+	ErrQuoteUsed     = errors.New("quote already used")
+)
+
+type QuoteService struct {
+	db *gorm.DB
+}
+
+func NewQuoteService(db *gorm.DB) *QuoteService {
+	return &QuoteService{
+		db: db,
+	}
+}
+
+// saves the quote with its price items, valid for QuoteTTL
+func (qs *QuoteService) Create(quote *model.Quote, breakdown *PriceBreakdown) error {
+	quote.TotalPrice = breakdown.Total
+	quote.ExpiresAt = time.Now().Add(QuoteTTL)
+	quote.Items = []model.QuoteItem{}
+	for _, l := range breakdown.Lines {
+		quote.Items = append(quote.Items, model.QuoteItem{
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			Amount:      l.Amount,
+		})
+	}
+	return qs.db.Omit("AddOns.AddOn").Create(quote).Error
+}
+
+// gets a quote of the user that can still be booked
+func (qs *QuoteService) GetUsable(userID uint, id uint) (*model.Quote, error) {
+	var quote model.Quote
+	err := qs.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Preload("AddOns.AddOn").Preload("Car").Where("id=? AND user_id=?", id, userID).First(&quote).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQuoteNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if quote.UsedAt != nil {
+		return nil, ErrQuoteUsed
+	}
+	if time.Now().After(quote.ExpiresAt) {
+		return nil, ErrQuoteExpired
+	}
+	return &quote, nil
+}
+
+// the price breakdown as it was quoted
+func (qs *QuoteService) Breakdown(quote *model.Quote) *PriceBreakdown {
+	days, hours := chargedDuration(&quote.Car, quote.StartDate, quote.EndDate)
+	breakdown := PriceBreakdown{
+		Days:  days,
+		Hours: hours,
+		Lines: []PriceLine{},
+		Total: quote.TotalPrice,
+	}
+	for _, i := range quote.Items {
+		breakdown.Lines = append(breakdown.Lines, PriceLine{
+			Description: i.Description,
+			Quantity:    i.Quantity,
+			UnitPrice:   i.UnitPrice,
+			Amount:      i.Amount,
+		})
+	}
+	return &breakdown
+}
+
+// marks the quote used by the rental, fails if it was used concurrently
+func (qs *QuoteService) MarkUsed(tx *gorm.DB, quote *model.Quote, rentalID uint) error {
+	now := time.Now()
+	res := tx.Model(&model.Quote{}).
+		Where("id=? AND used_at IS NULL", quote.ID).
+		Updates(map[string]any{"used_at": now, "rental_id": rentalID})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrQuoteUsed
+	}
+	quote.UsedAt = &now
+	quote.RentalID = &rentalID
+	return nil
+}
@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func createTestQuote(t *testing.T, db *gorm.DB, qs *service.QuoteService, user *model.User, car *model.Car) *model.Quote {
+	breakdown := service.PriceBreakdown{Days: 2}
+	breakdown.AddLine("Day rate", 2, car.RatePerDay)
+	breakdown.AddLine("One way fee", 1, 100000)
+	quote := model.Quote{
+		UserID:    user.ID,
+		CarID:     car.ID,
+		StartDate: mustTime("2030-03-01T10:00:00Z"),
+		EndDate:   mustTime("2030-03-03T10:00:00Z"),
+		OneWayFee: 100000,
+		Timed:     true,
+	}
+	err := qs.Create(&quote, &breakdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &quote
+}
+
+func TestQuoteCreate(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	qs := service.NewQuoteService(db)
+	car, _ := CreateTestCar(t, db, 1, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	quote := createTestQuote(t, db, qs, user, car)
+	assert.Equal(t, 2*car.RatePerDay+100000, quote.TotalPrice)
+	assert.WithinDuration(t, time.Now().Add(service.QuoteTTL), quote.ExpiresAt, time.Minute)
+
+	usable, err := qs.GetUsable(user.ID, quote.ID)
+	assert.NoError(t, err)
+	// booked with the opening hours checked at the quoted times
+	assert.True(t, usable.Timed)
+	// the breakdown is the quoted one, not priced again
+	breakdown := qs.Breakdown(usable)
+	assert.Equal(t, quote.TotalPrice, breakdown.Total)
+	assert.Equal(t, uint(2), breakdown.Days)
+	assert.Len(t, breakdown.Lines, 2)
+	assert.Equal(t, "Day rate", breakdown.Lines[0].Description)
+	assert.Equal(t, "One way fee", breakdown.Lines[1].Description)
+
+	// quotes are private to the user
+	other := CreateTestUser(t, db, model.RoleUser)
+	_, err = qs.GetUsable(other.ID, quote.ID)
+	assert.ErrorIs(t, err, service.ErrQuoteNotFound)
+}
+
+func TestQuoteExpires(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	qs := service.NewQuoteService(db)
+	car, _ := CreateTestCar(t, db, 1, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+	quote := createTestQuote(t, db, qs, user, car)
+
+	err := db.Model(quote).Update("expires_at", time.Now().Add(-time.Second)).Error
+	assert.NoError(t, err)
+	_, err = qs.GetUsable(user.ID, quote.ID)
+	assert.ErrorIs(t, err, service.ErrQuoteExpired)
+}
+
+func TestQuoteSingleUse(t *testing.T) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	qs := service.NewQuoteService(db)
+	car, _ := CreateTestCar(t, db, 2, nil)
+	user := CreateTestUser(t, db, model.RoleUser)
+	quote := createTestQuote(t, db, qs, user, car)
+	first := CreateTestRental(t, db, user, car, quote.StartDate, quote.EndDate, "Unpaid")
+	second := CreateTestRental(t, db, user, car, quote.StartDate, quote.EndDate, "Unpaid")
+
+	usable, err := qs.GetUsable(user.ID, quote.ID)
+	assert.NoError(t, err)
+	// both bookings read the quote before either marks it used
+	stale, err := qs.GetUsable(user.ID, quote.ID)
+	assert.NoError(t, err)
+
+	err = qs.MarkUsed(db, usable, first.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, usable.UsedAt)
+	assert.Equal(t, first.ID, *usable.RentalID)
+
+	err = qs.MarkUsed(db, stale, second.ID)
+	assert.ErrorIs(t, err, service.ErrQuoteUsed)
+	_, err = qs.GetUsable(user.ID, quote.ID)
+	assert.ErrorIs(t, err, service.ErrQuoteUsed)
+
+	var saved model.Quote
+	err = db.Where("id=?", quote.ID).First(&saved).Error
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, *saved.RentalID)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PaymentStatusCancelled = "Cancelled"
+	// paid rentals cancelled by the user, refunded to their deposit
+	PaymentStatusRefunded = "Refunded"
+	// sent by xendit when the invoice was not paid in time
+	PaymentStatusExpired = "EXPIRED"
+)
+
+var (
+	ErrRentalStarted   = errors.New("rental already started")
+	ErrRentalCancelled = errors.New("rental already cancelled")
+	// the invoice was paid but xendit hasn't called back yet
+	ErrRentalPaymentPending = errors.New("rental payment not confirmed yet")
+)
+
+type RentalService struct {
+	db  *gorm.DB
+	ws  *WaitlistService
+	fs  *FavoriteService
+	sds *SecurityDepositService
+	is  *InvoiceService
+}
+
+func NewRentalService(db *gorm.DB, ws *WaitlistService, fs *FavoriteService, sds *SecurityDepositService, is *InvoiceService) *RentalService {
+	return &RentalService{
+		db:  db,
+		ws:  ws,
+		fs:  fs,
+		sds: sds,
+		is:  is,
+	}
+}
+
+// cancels the user's rental before it starts, a paid rental and its security
+// deposit are refunded to the user's wallet. the rental is kept with its
+// payment cancelled or refunded, so its unit is free again
+func (rs *RentalService) Cancel(userID uint, rentalID uint, logger echo.Logger) (*model.Rental, error) {
+	err := rs.expireInvoice(userID, rentalID)
+	if err != nil {
+		return nil, err
+	}
+	depositPaid, err := rs.sds.ExpireForRental(rentalID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rental model.Rental
+	err = rs.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Payment").
+			Where("id=? AND user_id=?", rentalID, userID).First(&rental).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRentalNotFound
+		} else if err != nil {
+			return err
+		}
+		if !IsRentalActive(rental.Payment.Status) {
+			return ErrRentalCancelled
+		}
+		if rental.PickedUpAt != nil || !rental.StartDate.After(time.Now()) {
+			return ErrRentalStarted
+		}
+
+		status := PaymentStatusCancelled
+		if rental.Payment.Status == "Completed" {
+			status = PaymentStatusRefunded
+			err = tx.Model(&model.User{}).Where("id=?", userID).
+				Update("deposit", gorm.Expr("deposit + ?", rental.Payment.TotalPayment)).Error
+			if err != nil {
+				return err
+			}
+		}
+		rental.Payment.Status = status
+		err = tx.Model(&rental.Payment).Update("status", status).Error
+		if err != nil {
+			return err
+		}
+		return rs.sds.CancelForRental(tx, rental.ID, depositPaid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs.released(rental.CarID, logger)
+	return &rental, nil
+}
+
+// expires the invoice of an unpaid rental at xendit before it is cancelled,
+// so it can't be paid after. an invoice that was paid keeps the rental, the
+// user can cancel it for a refund once xendit confirms the payment
+func (rs *RentalService) expireInvoice(userID uint, rentalID uint) error {
+	var rental model.Rental
+	err := rs.db.Preload("Payment").Where("id=? AND user_id=?", rentalID, userID).First(&rental).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRentalNotFound
+	} else if err != nil {
+		return err
+	}
+	if !IsRentalActive(rental.Payment.Status) {
+		return ErrRentalCancelled
+	}
+	if rental.PickedUpAt != nil || !rental.StartDate.After(time.Now()) {
+		return ErrRentalStarted
+	}
+	if rental.Payment.Status != "Unpaid" {
+		return nil
+	}
+	err = rs.is.ExpireInvoice(rental.Payment.ID)
+	if err != nil && errors.Is(err, ErrInvoicePaid) {
+		return ErrRentalPaymentPending
+	}
+	return err
+}
+
+// releases the rental of an unpaid invoice that expired, its payment is
+// already expired so it no longer holds a unit
+func (rs *RentalService) ReleaseExpired(rentalID uint, logger echo.Logger) error {
+	var rental model.Rental
+	err := rs.db.Where("id=?", rentalID).First(&rental).Error
+	if err != nil {
+		return err
+	}
+	if rental.PickedUpAt != nil {
+		return ErrRentalStarted
+	}
+	depositPaid, err := rs.sds.ExpireForRental(rental.ID)
+	if err != nil {
+		return err
+	}
+	err = rs.db.Transaction(func(tx *gorm.DB) error {
+		return rs.sds.CancelForRental(tx, rental.ID, depositPaid)
+	})
+	if err != nil {
+		return err
+	}
+	rs.released(rental.CarID, logger)
+	return nil
+}
+
+// a unit of the car was freed, it is held for the waitlist first and
+// watchers are told if any is still free
+func (rs *RentalService) released(carID uint, logger echo.Logger) {
+	err := rs.ws.ProcessQueue(carID, logger)
+	if err != nil {
+		logger.Errorf("failed to process waitlist of car %d: %s", carID, err.Error())
+	}
+	err = rs.fs.CheckWatches(carID, logger)
+	if err != nil {
+		logger.Errorf("failed to check watches of car %d: %s", carID, err.Error())
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/storage"
+	"io"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxReviewPhotos     = 5
+	MaxReviewTextLength = 2000
+)
+
+var (
+	ErrReviewNotFound     = errors.New("review not found")
+	ErrRentalNotFound     = errors.New("rental not found")
+	ErrReviewExists       = errors.New("rental already reviewed")
+	ErrRentalNotCompleted = errors.New("rental not completed")
+	ErrInvalidRating      = errors.New("rating must be from 1 to 5")
+	ErrReviewTooLong      = errors.New("review text too long")
+	ErrTooManyPhotos      = errors.New("too many review photos")
+)
+
+type ReviewService struct {
+	db    *gorm.DB
+	store storage.Storage
+}
+
+func NewReviewService(db *gorm.DB, store storage.Storage) *ReviewService {
+	return &ReviewService{
+		db:    db,
+		store: store,
+	}
+}
+
+type ReviewReq struct {
+	Rating int
+	Text   string
+	Photos []io.Reader
+}
+
+func ValidateReview(req *ReviewReq) error {
+	if req.Rating < 1 || req.Rating > 5 {
+		return ErrInvalidRating
+	}
+	if len([]rune(req.Text)) > MaxReviewTextLength {
+		return ErrReviewTooLong
+	}
+	if len(req.Photos) > MaxReviewPhotos {
+		return ErrTooManyPhotos
+	}
+	return nil
+}
+
+// recounts the rating of the car from its visible reviews
+func refreshCarRating(tx *gorm.DB, carID uint) error {
+	var stats struct {
+		AverageRating float64
+		NumOfReviews  uint
+	}
+	err := tx.Model(&model.Review{}).
+		Select("COALESCE(AVG(rating), 0) AS average_rating, COUNT(id) AS num_of_reviews").
+		Where("car_id=? AND hidden = ?", carID, false).
+		Scan(&stats).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.Car{}).Where("id=?", carID).Updates(map[string]any{
+		"average_rating": roundPrice(stats.AverageRating),
+		"num_of_reviews": stats.NumOfReviews,
+	}).Error
+}
+
+func (rs *ReviewService) isReviewed(tx *gorm.DB, rentalID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&model.Review{}).Where("rental_id=?", rentalID).Count(&count).Error
+	return count != 0, err
+}
+
+// reviews the rental, which must belong to the user, be paid and returned
+func (rs *ReviewService) Create(user *model.User, rentalID uint, req *ReviewReq) (*model.Review, error) {
+	err := ValidateReview(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rental model.Rental
+	err = rs.db.Preload("Payment").Where("id=? AND user_id=?", rentalID, user.ID).First(&rental).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRentalNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !IsRentalConfirmed(rental.Payment.Status) || rental.ReturnedAt == nil {
+		return nil, ErrRentalNotCompleted
+	}
+	reviewed, err := rs.isReviewed(rs.db, rental.ID)
+	if err != nil {
+		return nil, err
+	}
+	if reviewed {
+		return nil, ErrReviewExists
+	}
+
+	review := model.Review{
+		UserID:   user.ID,
+		RentalID: rental.ID,
+		CarID:    rental.CarID,
+		Rating:   req.Rating,
+		Text:     strings.TrimSpace(req.Text),
+		Photos:   []model.ReviewPhoto{},
+	}
+	keys := []string{}
+	for _, p := range req.Photos {
+		stored, err := storeImage(rs.store, fmt.Sprintf("reviews/%d", rental.ID), p)
+		if err != nil {
+			removeFiles(rs.store, keys...)
+			return nil, err
+		}
+		keys = append(keys, stored.Key, stored.ThumbnailKey)
+		review.Photos = append(review.Photos, model.ReviewPhoto{
+			URL:          stored.URL,
+			ThumbnailURL: stored.ThumbnailURL,
+			Key:          stored.Key,
+			ThumbnailKey: stored.ThumbnailKey,
+		})
+	}
+
+	err = rs.db.Transaction(func(tx *gorm.DB) error {
+		// checked again under lock in case of a review made at the same time
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", rental.ID).First(&model.Rental{}).Error
+		if err != nil {
+			return err
+		}
+		reviewed, err := rs.isReviewed(tx, rental.ID)
+		if err != nil {
+			return err
+		}
+		if reviewed {
+			return ErrReviewExists
+		}
+		err = tx.Create(&review).Error
+		if err != nil {
+			return err
+		}
+		return refreshCarRating(tx, review.CarID)
+	})
+	if err != nil {
+		removeFiles(rs.store, keys...)
+		return nil, err
+	}
+	review.User = *user
+	return &review, nil
+}
+
+// visible reviews of the car, newest first
+func (rs *ReviewService) GetCarReviews(carID uint) ([]model.Review, error) {
+	var reviews []model.Review
+	err := rs.db.Preload("User").Preload("Photos").
+		Where("car_id=? AND hidden = ?", carID, false).
+		Order("created_at DESC").Find(&reviews).Error
+	return reviews, err
+}
+
+// all reviews for moderation, only hidden ones or only visible ones when set
+func (rs *ReviewService) GetReviews(hidden *bool) ([]model.Review, error) {
+	q := rs.db.Preload("User").Preload("Photos")
+	if hidden != nil {
+		q = q.Where("hidden = ?", *hidden)
+	}
+	var reviews []model.Review
+	err := q.Order("created_at DESC").Find(&reviews).Error
+	return reviews, err
+}
+
+// hides or shows the review again, the car's rating is recounted
+func (rs *ReviewService) SetHidden(id uint, hidden bool, reason string, staff *model.User) (*model.Review, error) {
+	var review model.Review
+	err := rs.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("User").Preload("Photos").Where("id=?", id).First(&review).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReviewNotFound
+		} else if err != nil {
+			return err
+		}
+
+		review.Hidden = hidden
+		if hidden {
+			now := time.Now()
+			review.HiddenReason = strings.TrimSpace(reason)
+			review.HiddenByID = &staff.ID
+			review.HiddenAt = &now
+		} else {
+			review.HiddenReason = ""
+			review.HiddenByID = nil
+			review.HiddenAt = nil
+		}
+		err = tx.Model(&review).Select("hidden", "hidden_reason", "hidden_by_id", "hidden_at").Updates(&review).Error
+		if err != nil {
+			return err
+		}
+		return refreshCarRating(tx, review.CarID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
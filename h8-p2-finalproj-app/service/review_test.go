@@ -0,0 +1,24 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/service"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateReview(t *testing.T) {
+	assert.NoError(t, service.ValidateReview(&service.ReviewReq{Rating: 5, Text: "Clean and comfortable"}))
+	assert.NoError(t, service.ValidateReview(&service.ReviewReq{Rating: 1}))
+
+	assert.ErrorIs(t, service.ValidateReview(&service.ReviewReq{Rating: 0}), service.ErrInvalidRating)
+	assert.ErrorIs(t, service.ValidateReview(&service.ReviewReq{Rating: 6}), service.ErrInvalidRating)
+
+	text := strings.Repeat("a", service.MaxReviewTextLength+1)
+	assert.ErrorIs(t, service.ValidateReview(&service.ReviewReq{Rating: 4, Text: text}), service.ErrReviewTooLong)
+
+	photos := make([]io.Reader, service.MaxReviewPhotos+1)
+	assert.ErrorIs(t, service.ValidateReview(&service.ReviewReq{Rating: 4, Photos: photos}), service.ErrTooManyPhotos)
+}
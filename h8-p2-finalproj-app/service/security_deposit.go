@@ -0,0 +1,535 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDepositNotFound      = errors.New("security deposit not found")
+	ErrDepositRateNotFound  = errors.New("security deposit rate not found")
+	ErrInvalidDepositRate   = errors.New("car type is required and amount cannot be negative")
+	ErrInvalidDepositMethod = errors.New("invalid security deposit method")
+	ErrInsufficientWallet   = errors.New("wallet balance too low for the security deposit")
+	ErrDepositNotHeld       = errors.New("security deposit is not held")
+	ErrDepositNotPayable    = errors.New("security deposit is already paid or settled")
+	ErrInvalidCapture       = errors.New("captured amount must be above 0 and at most the deposit")
+	ErrCaptureReasonNeeded  = errors.New("capture reason cannot be empty")
+	ErrRentalNotPickedUp    = errors.New("rental not picked up yet")
+)
+
+type SecurityDepositService struct {
+	db *gorm.DB
+	is *InvoiceService
+	ns *NotificationService
+}
+
+func NewSecurityDepositService(db *gorm.DB, is *InvoiceService, ns *NotificationService) *SecurityDepositService {
+	return &SecurityDepositService{
+		db: db,
+		is: is,
+		ns: ns,
+	}
+}
+
+// how long deposits stay held after the car is returned, so staff can
+// capture them for damages. DEPOSIT_RELEASE_HOURS, 24 by default
+func DepositReleaseDelay() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("DEPOSIT_RELEASE_HOURS"))
+	if err != nil || hours < 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// the requested method, or the wallet when it covers the deposit and an
+// invoice otherwise
+func ChooseDepositMethod(requested string, balance float64, amount float64) (string, error) {
+	switch requested {
+	case "":
+		if balance >= amount {
+			return model.DepositMethodWallet, nil
+		}
+		return model.DepositMethodInvoice, nil
+	case model.DepositMethodWallet:
+		if balance < amount {
+			return "", ErrInsufficientWallet
+		}
+		return requested, nil
+	case model.DepositMethodInvoice:
+		return requested, nil
+	}
+	return "", ErrInvalidDepositMethod
+}
+
+// splits a held deposit into the captured part and the part released
+func SplitCapture(held float64, capture float64) (float64, float64, error) {
+	if capture <= 0 || capture > held {
+		return 0, 0, ErrInvalidCapture
+	}
+	return roundPrice(capture), roundPrice(held - capture), nil
+}
+
+func (sds *SecurityDepositService) GetRates() ([]model.SecurityDepositRate, error) {
+	var rates []model.SecurityDepositRate
+	err := sds.db.Order("car_type").Find(&rates).Error
+	return rates, err
+}
+
+// sets the deposit of a car type, replacing the one set before
+func (sds *SecurityDepositService) SetRate(carType string, amount float64) (*model.SecurityDepositRate, error) {
+	carType = strings.TrimSpace(carType)
+	if carType == "" || amount < 0 {
+		return nil, ErrInvalidDepositRate
+	}
+	var rate model.SecurityDepositRate
+	err := sds.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("LOWER(car_type) = LOWER(?)", carType).First(&rate).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			rate = model.SecurityDepositRate{CarType: carType, Amount: amount}
+			return tx.Create(&rate).Error
+		} else if err != nil {
+			return err
+		}
+		rate.Amount = amount
+		return tx.Model(&rate).Update("amount", amount).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (sds *SecurityDepositService) DeleteRate(id uint) error {
+	res := sds.db.Unscoped().Where("id=?", id).Delete(&model.SecurityDepositRate{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrDepositRateNotFound
+	}
+	return nil
+}
+
+// deposit required to rent a car of the type, 0 when none is set
+func (sds *SecurityDepositService) AmountFor(carType string) (float64, error) {
+	var rate model.SecurityDepositRate
+	err := sds.db.Where("LOWER(car_type) = LOWER(?)", carType).First(&rate).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return rate.Amount, nil
+}
+
+func (sds *SecurityDepositService) GetForRental(rentalID uint) (*model.SecurityDeposit, error) {
+	var deposit model.SecurityDeposit
+	err := sds.db.Preload("Payment").Where("rental_id=?", rentalID).First(&deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDepositNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &deposit, nil
+}
+
+// takes the amount from the user's wallet, unless it has become too low
+func debitWallet(tx *gorm.DB, userID uint, amount float64) error {
+	res := tx.Model(&model.User{}).Where("id=? AND deposit >= ?", userID, amount).
+		Update("deposit", gorm.Expr("deposit - ?", amount))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInsufficientWallet
+	}
+	return nil
+}
+
+func creditWallet(tx *gorm.DB, userID uint, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+	return tx.Model(&model.User{}).Where("id=?", userID).
+		Update("deposit", gorm.Expr("deposit + ?", amount)).Error
+}
+
+// reserves the deposit of a new rental, from the wallet right away or as an
+// unpaid invoice. the invoice url is made with Invoice after the booking
+func (sds *SecurityDepositService) Reserve(tx *gorm.DB, rental *model.Rental, amount float64, method string) error {
+	deposit := model.SecurityDeposit{
+		RentalID: rental.ID,
+		UserID:   rental.UserID,
+		Amount:   amount,
+		Method:   method,
+		Status:   model.DepositHeld,
+	}
+	if method == model.DepositMethodInvoice {
+		deposit.Status = model.DepositPending
+		deposit.Payment = &model.Payment{
+			Status: "Unpaid",
+		}
+	} else {
+		err := debitWallet(tx, rental.UserID, amount)
+		if err != nil {
+			return err
+		}
+	}
+	err := tx.Create(&deposit).Error
+	if err != nil {
+		return err
+	}
+	rental.SecurityDeposit = &deposit
+	return nil
+}
+
+// generates the invoice of a deposit paid by invoice
+func (sds *SecurityDepositService) Invoice(deposit *model.SecurityDeposit, desc string, email string) error {
+	url, err := sds.is.GenerateInvoice(deposit.Payment.ID, deposit.Amount, desc, email)
+	if err != nil {
+		return err
+	}
+	deposit.Payment.PaymentUrl = url
+	return sds.db.Save(deposit.Payment).Error
+}
+
+// pays a deposit that is still invoiced, or whose invoice expired, from the
+// wallet instead
+func (sds *SecurityDepositService) PayFromWallet(userID uint, rentalID uint) (*model.SecurityDeposit, error) {
+	var deposit model.SecurityDeposit
+	err := sds.loadPayable(sds.db, false, userID, rentalID, &deposit)
+	if err != nil {
+		return nil, err
+	}
+	// the invoice can't be paid anymore, expired first so the deposit is not
+	// paid twice. xendit is called before the transaction so no rows stay
+	// locked meanwhile, and the wallet is checked before so a low balance
+	// doesn't expire it
+	if deposit.Payment != nil && deposit.Payment.Status == "Unpaid" {
+		var user model.User
+		err = sds.db.Where("id=?", userID).First(&user).Error
+		if err != nil {
+			return nil, err
+		}
+		if user.Deposit < deposit.Amount {
+			return nil, ErrInsufficientWallet
+		}
+		err = sds.is.ExpireInvoice(deposit.Payment.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = sds.db.Transaction(func(tx *gorm.DB) error {
+		// checked again, the invoice may have been paid or the rental
+		// cancelled meanwhile
+		deposit = model.SecurityDeposit{}
+		err := sds.loadPayable(tx, true, userID, rentalID, &deposit)
+		if err != nil {
+			return err
+		}
+		err = debitWallet(tx, userID, deposit.Amount)
+		if err != nil {
+			return err
+		}
+		if deposit.Payment != nil && deposit.Payment.Status == "Unpaid" {
+			deposit.Payment.Status = PaymentStatusCancelled
+			err = tx.Model(deposit.Payment).Update("status", PaymentStatusCancelled).Error
+			if err != nil {
+				return err
+			}
+		}
+		deposit.Method = model.DepositMethodWallet
+		deposit.Status = model.DepositHeld
+		return tx.Model(&deposit).Updates(map[string]any{
+			"method": deposit.Method,
+			"status": deposit.Status,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &deposit, nil
+}
+
+// loads the deposit of the rental if it can still be paid from the wallet
+func (sds *SecurityDepositService) loadPayable(tx *gorm.DB, lock bool, userID uint, rentalID uint, deposit *model.SecurityDeposit) error {
+	q := tx
+	if lock {
+		q = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	err := q.Preload("Payment").Where("rental_id=? AND user_id=?", rentalID, userID).First(deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDepositNotFound
+	} else if err != nil {
+		return err
+	}
+	var rental model.Rental
+	err = tx.Preload("Payment").Where("id=?", rentalID).First(&rental).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDepositNotPayable
+	} else if err != nil {
+		return err
+	}
+	// nothing to secure for a cancelled rental
+	if !IsRentalActive(rental.Payment.Status) || rental.ReturnedAt != nil || (deposit.Status != model.DepositPending && deposit.Status != model.DepositCancelled) {
+		return ErrDepositNotPayable
+	}
+	return nil
+}
+
+// updates the deposit when its invoice is paid or expires. a deposit paid
+// after its rental was cancelled goes back to the wallet
+func (sds *SecurityDepositService) HandlePayment(payment *model.Payment) error {
+	if payment.Status != "Completed" && payment.Status != PaymentStatusExpired {
+		return nil
+	}
+	return sds.db.Transaction(func(tx *gorm.DB) error {
+		var deposit model.SecurityDeposit
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id=? AND status=?", payment.PurchaseID, model.DepositPending).First(&deposit).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if payment.Status == PaymentStatusExpired {
+			return tx.Model(&deposit).Update("status", model.DepositCancelled).Error
+		}
+		var rental model.Rental
+		err = tx.Preload("Payment").Where("id=?", deposit.RentalID).First(&rental).Error
+		if err != nil {
+			return err
+		}
+		if !IsRentalActive(rental.Payment.Status) {
+			return sds.settle(tx, &deposit, 0, "", nil)
+		}
+		return tx.Model(&deposit).Update("status", model.DepositHeld).Error
+	})
+}
+
+// checks the deposit of the rental is held before the car is handed over,
+// rentals without a deposit pass
+func (sds *SecurityDepositService) CheckHeld(tx *gorm.DB, rentalID uint) error {
+	var deposit model.SecurityDeposit
+	err := tx.Where("rental_id=?", rentalID).First(&deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if deposit.Status != model.DepositHeld {
+		return ErrDepositNotHeld
+	}
+	return nil
+}
+
+// expires the unpaid deposit invoice of the rental at xendit, before the
+// rental is cancelled with CancelForRental. called outside the transaction
+// so no rows stay locked while xendit is called
+func (sds *SecurityDepositService) ExpireForRental(rentalID uint) (bool, error) {
+	var deposit model.SecurityDeposit
+	err := sds.db.Preload("Payment").Where("rental_id=?", rentalID).First(&deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if deposit.Status != model.DepositPending || deposit.Payment == nil || deposit.Payment.Status != "Unpaid" {
+		return false, nil
+	}
+	err = sds.is.ExpireInvoice(deposit.Payment.ID)
+	if err != nil && errors.Is(err, ErrInvoicePaid) {
+		return true, nil
+	}
+	return false, err
+}
+
+// returns the deposit of a rental that was cancelled before pickup, an
+// unpaid invoice is cancelled. an invoice that was paid at xendit is left
+// pending, HandlePayment returns it once xendit confirms the payment
+func (sds *SecurityDepositService) CancelForRental(tx *gorm.DB, rentalID uint, invoicePaid bool) error {
+	var deposit model.SecurityDeposit
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Payment").
+		Where("rental_id=?", rentalID).First(&deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch deposit.Status {
+	case model.DepositHeld:
+		return sds.settle(tx, &deposit, 0, "", nil)
+	case model.DepositPending:
+		if invoicePaid {
+			return nil
+		}
+		if deposit.Payment != nil && deposit.Payment.Status == "Unpaid" {
+			err = tx.Model(deposit.Payment).Update("status", PaymentStatusCancelled).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&deposit).Update("status", model.DepositCancelled).Error
+	}
+	return nil
+}
+
+// keeps the captured amount and returns the rest to the wallet
+func (sds *SecurityDepositService) settle(tx *gorm.DB, deposit *model.SecurityDeposit, captured float64, reason string, staffID *uint) error {
+	released := roundPrice(deposit.Amount - captured)
+	err := creditWallet(tx, deposit.UserID, released)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	deposit.Status = model.DepositReleased
+	if captured > 0 {
+		deposit.Status = model.DepositCaptured
+	}
+	deposit.CapturedAmount = captured
+	deposit.CaptureReason = reason
+	deposit.CapturedByID = staffID
+	deposit.SettledAt = &now
+	return tx.Model(deposit).Updates(map[string]any{
+		"status":          deposit.Status,
+		"captured_amount": captured,
+		"capture_reason":  reason,
+		"captured_by_id":  staffID,
+		"settled_at":      now,
+	}).Error
+}
+
+// staff keep part or all of the held deposit for damages, the rest goes
+// back to the user's wallet
+func (sds *SecurityDepositService) Capture(rentalID uint, staffID uint, amount float64, reason string, logger echo.Logger) (*model.SecurityDeposit, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrCaptureReasonNeeded
+	}
+	var deposit model.SecurityDeposit
+	err := sds.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		deposit, err = sds.lockHeld(tx, rentalID)
+		if err != nil {
+			return err
+		}
+		return sds.capture(tx, &deposit, staffID, amount, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sds.notifySettled(&deposit, logger)
+	return &deposit, nil
+}
+
+// the held deposit of a rental that was picked up, locked for settling
+func (sds *SecurityDepositService) lockHeld(tx *gorm.DB, rentalID uint) (model.SecurityDeposit, error) {
+	var deposit model.SecurityDeposit
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("rental_id=?", rentalID).First(&deposit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return deposit, ErrDepositNotFound
+	} else if err != nil {
+		return deposit, err
+	}
+	if deposit.Status != model.DepositHeld {
+		return deposit, ErrDepositNotHeld
+	}
+	var rental model.Rental
+	err = tx.Where("id=?", rentalID).First(&rental).Error
+	if err != nil {
+		return deposit, err
+	}
+	if rental.PickedUpAt == nil {
+		return deposit, ErrRentalNotPickedUp
+	}
+	return deposit, nil
+}
+
+func (sds *SecurityDepositService) capture(tx *gorm.DB, deposit *model.SecurityDeposit, staffID uint, amount float64, reason string) error {
+	captured, _, err := SplitCapture(deposit.Amount, amount)
+	if err != nil {
+		return err
+	}
+	return sds.settle(tx, deposit, captured, reason, &staffID)
+}
+
+// releases the held deposits of rentals returned longer than the release
+// delay ago
+func (sds *SecurityDepositService) ReleaseDue(logger echo.Logger) error {
+	var rentalIDs []uint
+	err := sds.db.Model(&model.SecurityDeposit{}).
+		Joins("join rentals on rentals.id = security_deposits.rental_id").
+		Where("security_deposits.status=? AND rentals.returned_at <= ?", model.DepositHeld, time.Now().Add(-DepositReleaseDelay())).
+		Pluck("security_deposits.rental_id", &rentalIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, rentalID := range rentalIDs {
+		var deposit model.SecurityDeposit
+		err = sds.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			deposit, err = sds.lockHeld(tx, rentalID)
+			if err != nil {
+				return err
+			}
+			return sds.settle(tx, &deposit, 0, "", nil)
+		})
+		// captured by staff in the meantime
+		if err != nil && errors.Is(err, ErrDepositNotHeld) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		sds.notifySettled(&deposit, logger)
+	}
+	return nil
+}
+
+// releases due deposits every interval until the app stops
+func (sds *SecurityDepositService) RunRelease(interval time.Duration, logger echo.Logger) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		err := sds.ReleaseDue(logger)
+		if err != nil {
+			logger.Errorf("failed to release security deposits: %s", err.Error())
+		}
+	}
+}
+
+func (sds *SecurityDepositService) notifySettled(deposit *model.SecurityDeposit, logger echo.Logger) {
+	var user model.User
+	err := sds.db.Where("id=?", deposit.UserID).First(&user).Error
+	if err != nil {
+		logger.Errorf("failed to load user of security deposit %d: %s", deposit.ID, err.Error())
+		return
+	}
+	if deposit.Status == model.DepositReleased {
+		sds.ns.SendMail(user.ID, user.Email, "Your security deposit is released",
+			fmt.Sprintf("<h1>Hello %s,</h1><p>The security deposit of IDR %.0f for rental %d is back in your wallet.</p>",
+				user.Name, deposit.Amount, deposit.RentalID),
+			logger,
+		)
+		return
+	}
+	sds.ns.SendMail(user.ID, user.Email, "Your security deposit was charged",
+		fmt.Sprintf(`<h1>Hello %s,</h1>
+		<p>IDR %.0f of the security deposit for rental %d was kept.</p>
+		<p>Reason: %s</p>
+		<p>The remaining IDR %.0f is back in your wallet.</p>`,
+			user.Name, deposit.CapturedAmount, deposit.RentalID, deposit.CaptureReason,
+			roundPrice(deposit.Amount-deposit.CapturedAmount)),
+		logger,
+	)
+}
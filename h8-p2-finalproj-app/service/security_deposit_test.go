@@ -0,0 +1,58 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChooseDepositMethod(t *testing.T) {
+	method, err := service.ChooseDepositMethod("", 500000, 500000)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DepositMethodWallet, method)
+
+	// not enough in the wallet, invoiced instead
+	method, err = service.ChooseDepositMethod("", 499999, 500000)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DepositMethodInvoice, method)
+
+	method, err = service.ChooseDepositMethod(model.DepositMethodInvoice, 1000000, 500000)
+	assert.NoError(t, err)
+	assert.Equal(t, model.DepositMethodInvoice, method)
+
+	_, err = service.ChooseDepositMethod(model.DepositMethodWallet, 100000, 500000)
+	assert.ErrorIs(t, err, service.ErrInsufficientWallet)
+	_, err = service.ChooseDepositMethod("cash", 1000000, 500000)
+	assert.ErrorIs(t, err, service.ErrInvalidDepositMethod)
+}
+
+func TestSplitCapture(t *testing.T) {
+	captured, released, err := service.SplitCapture(500000, 150000)
+	assert.NoError(t, err)
+	assert.Equal(t, 150000.0, captured)
+	assert.Equal(t, 350000.0, released)
+
+	captured, released, err = service.SplitCapture(500000, 500000)
+	assert.NoError(t, err)
+	assert.Equal(t, 500000.0, captured)
+	assert.Equal(t, 0.0, released)
+
+	for _, amount := range []float64{0, -1, 500001} {
+		_, _, err = service.SplitCapture(500000, amount)
+		assert.ErrorIs(t, err, service.ErrInvalidCapture, amount)
+	}
+}
+
+func TestDepositReleaseDelay(t *testing.T) {
+	t.Setenv("DEPOSIT_RELEASE_HOURS", "")
+	assert.Equal(t, 24*time.Hour, service.DepositReleaseDelay())
+	t.Setenv("DEPOSIT_RELEASE_HOURS", "0")
+	assert.Equal(t, time.Duration(0), service.DepositReleaseDelay())
+	t.Setenv("DEPOSIT_RELEASE_HOURS", "72")
+	assert.Equal(t, 72*time.Hour, service.DepositReleaseDelay())
+	t.Setenv("DEPOSIT_RELEASE_HOURS", "-5")
+	assert.Equal(t, 24*time.Hour, service.DepositReleaseDelay())
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"h8-p2-finalproj-app/auth"
+	"h8-p2-finalproj-app/model"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type TokenService struct {
+	db   *gorm.DB
+	keys *auth.KeySet
+}
+
+func NewTokenService(db *gorm.DB, keys *auth.KeySet) *TokenService {
+	return &TokenService{
+		db:   db,
+		keys: keys,
+	}
+}
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// generates a random url safe string of n bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (ts *TokenService) signAccessToken(user *model.User, twoFactor bool, jti string, expiresAt time.Time) (string, error) {
+	claims := &auth.JwtAppClaims{
+		UserID:    user.ID,
+		Role:      user.Role,
+		TwoFactor: twoFactor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   user.Email,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	return ts.keys.Sign(claims)
+}
+
+// creates access and refresh token within the given family
+func (ts *TokenService) issue(tx *gorm.DB, user *model.User, twoFactor bool, familyID string) (*TokenPair, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accessExp := now.Add(AccessTokenTTL)
+	access, err := ts.signAccessToken(user, twoFactor, jti, accessExp)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := model.RefreshToken{
+		UserID:          user.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashToken(refresh),
+		AccessTokenID:   jti,
+		AccessExpiresAt: accessExp,
+		TwoFactor:       twoFactor,
+		ExpiresAt:       now.Add(RefreshTokenTTL),
+	}
+	err = tx.Create(&rt).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresAt:    accessExp,
+	}, nil
+}
+
+// issues a new token pair, starting a new family.
+// twoFactor marks tokens of a login completed with 2fa
+func (ts *TokenService) IssueTokens(user *model.User, twoFactor bool) (*TokenPair, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	return ts.issue(ts.db, user, twoFactor, familyID)
+}
+
+// exchanges a refresh token for a new pair, the old one can't be used again.
+// presenting an already used token revokes the whole family
+func (ts *TokenService) Refresh(refreshToken string) (*TokenPair, error) {
+	var pair *TokenPair
+	reusedFamily := ""
+	err := ts.db.Transaction(func(tx *gorm.DB) error {
+		var rt model.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("User").
+			Where("token_hash=?", hashToken(refreshToken)).
+			First(&rt).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+
+		if rt.UsedAt != nil || rt.RevokedAt != nil {
+			reusedFamily = rt.FamilyID
+			return nil
+		}
+		if time.Now().After(rt.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		now := time.Now()
+		rt.UsedAt = &now
+		err = tx.Save(&rt).Error
+		if err != nil {
+			return err
+		}
+
+		pair, err = ts.issue(tx, &rt.User, rt.TwoFactor, rt.FamilyID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reusedFamily != "" {
+		err = ts.RevokeFamily(reusedFamily)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	return pair, nil
+}
+
+// revokes an access token until it expires
+func (ts *TokenService) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+	return ts.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+// revokes all refresh tokens in the family and the access tokens issued with them
+func (ts *TokenService) RevokeFamily(familyID string) error {
+	return ts.db.Transaction(func(tx *gorm.DB) error {
+		var tokens []model.RefreshToken
+		err := tx.Where("family_id=?", familyID).Find(&tokens).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, t := range tokens {
+			if t.AccessExpiresAt.After(now) && t.AccessTokenID != "" {
+				err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+					Create(&model.RevokedToken{JTI: t.AccessTokenID, ExpiresAt: t.AccessExpiresAt}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Model(&model.RefreshToken{}).
+			Where("family_id=? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// revokes the family of a refresh token, if it belongs to the user
+func (ts *TokenService) RevokeRefreshToken(userID uint, refreshToken string) error {
+	var rt model.RefreshToken
+	err := ts.db.Where("token_hash=? AND user_id=?", hashToken(refreshToken), userID).First(&rt).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidRefreshToken
+	} else if err != nil {
+		return err
+	}
+	return ts.RevokeFamily(rt.FamilyID)
+}
+
+// revokes every refresh token family of the user, ending all sessions
+func (ts *TokenService) RevokeAllForUser(userID uint) error {
+	return ts.RevokeOtherSessions(userID, "")
+}
+
+// revokes every refresh token family of the user except the one the access
+// token with the jti was issued in, ending all other sessions
+func (ts *TokenService) RevokeOtherSessions(userID uint, keepJTI string) error {
+	q := ts.db.Model(&model.RefreshToken{}).
+		Where("user_id=? AND revoked_at IS NULL", userID)
+	if keepJTI != "" {
+		q = q.Where("family_id NOT IN (?)", ts.db.Model(&model.RefreshToken{}).
+			Select("family_id").Where("user_id=? AND access_token_id=?", userID, keepJTI))
+	}
+	var familyIDs []string
+	err := q.Distinct().Pluck("family_id", &familyIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, f := range familyIDs {
+		err = ts.RevokeFamily(f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *TokenService) IsRevoked(jti string) (bool, error) {
+	var count int64
+	err := ts.db.Model(&model.RevokedToken{}).
+		Where("jti=? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
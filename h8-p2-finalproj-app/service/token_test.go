@@ -0,0 +1,210 @@
+package service_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"h8-p2-finalproj-app/auth"
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func newTestTokenService(t *testing.T) (*service.TokenService, *auth.KeySet, *gorm.DB) {
+	godotenv.Load("../.env")
+	db := CreateTestDB()
+	if db == nil {
+		t.FailNow()
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	key, err := auth.NewSigningKey("test", edKey)
+	assert.NoError(t, err)
+	keys, err := auth.NewKeySet([]*auth.SigningKey{key}, "test", nil)
+	assert.NoError(t, err)
+	return service.NewTokenService(db, keys), keys, db
+}
+
+// jti of a signed access token
+func accessTokenID(t *testing.T, keys *auth.KeySet, token string) string {
+	claims := &auth.JwtAppClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, keys.Keyfunc)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, claims.ID)
+	return claims.ID
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	ts, keys, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	first, err := ts.IssueTokens(user, true)
+	assert.NoError(t, err)
+	second, err := ts.Refresh(first.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	assert.NotEqual(t, accessTokenID(t, keys, first.AccessToken), accessTokenID(t, keys, second.AccessToken))
+
+	// the new pair stays in the family and keeps the 2fa flag
+	var tokens []model.RefreshToken
+	err = db.Where("user_id=?", user.ID).Order("id").Find(&tokens).Error
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, tokens[0].FamilyID, tokens[1].FamilyID)
+	assert.NotNil(t, tokens[0].UsedAt)
+	assert.Nil(t, tokens[1].UsedAt)
+	assert.True(t, tokens[1].TwoFactor)
+
+	third, err := ts.Refresh(second.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, third.AccessToken)
+}
+
+func TestRefreshReusedTokenRevokesFamily(t *testing.T) {
+	ts, keys, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	first, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+	second, err := ts.Refresh(first.RefreshToken)
+	assert.NoError(t, err)
+	// another session of the user is not touched
+	other, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+
+	// the stolen first token is presented again
+	_, err = ts.Refresh(first.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+
+	// the latest token of the family is revoked too
+	_, err = ts.Refresh(second.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+
+	for _, pair := range []*service.TokenPair{first, second} {
+		revoked, err := ts.IsRevoked(accessTokenID(t, keys, pair.AccessToken))
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+	}
+	revoked, err := ts.IsRevoked(accessTokenID(t, keys, other.AccessToken))
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	_, err = ts.Refresh(other.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestRefreshInvalidToken(t *testing.T) {
+	ts, _, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	_, err := ts.Refresh("not-a-refresh-token")
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+
+	pair, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+	err = db.Model(&model.RefreshToken{}).Where("user_id=?", user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error
+	assert.NoError(t, err)
+	_, err = ts.Refresh(pair.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	ts, keys, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+	other := CreateTestUser(t, db, model.RoleUser)
+
+	pair, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+
+	// only the owner can log the token out
+	err = ts.RevokeRefreshToken(other.ID, pair.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrInvalidRefreshToken)
+
+	err = ts.RevokeRefreshToken(user.ID, pair.RefreshToken)
+	assert.NoError(t, err)
+	revoked, err := ts.IsRevoked(accessTokenID(t, keys, pair.AccessToken))
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = ts.Refresh(pair.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+}
+
+func TestRevokeAllForUser(t *testing.T) {
+	ts, keys, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	pairs := []*service.TokenPair{}
+	for i := 0; i < 2; i++ {
+		pair, err := ts.IssueTokens(user, false)
+		assert.NoError(t, err)
+		pairs = append(pairs, pair)
+	}
+
+	err := ts.RevokeAllForUser(user.ID)
+	assert.NoError(t, err)
+	for _, pair := range pairs {
+		revoked, err := ts.IsRevoked(accessTokenID(t, keys, pair.AccessToken))
+		assert.NoError(t, err)
+		assert.True(t, revoked)
+		_, err = ts.Refresh(pair.RefreshToken)
+		assert.Error(t, err)
+	}
+}
+
+func TestIsRevokedUntilExpiry(t *testing.T) {
+	ts, _, _ := newTestTokenService(t)
+	jti := "test-jti-" + time.Now().Format(time.RFC3339Nano)
+
+	revoked, err := ts.IsRevoked(jti)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	err = ts.RevokeAccessToken(jti, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	revoked, err = ts.IsRevoked(jti)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	// revoking twice is fine
+	assert.NoError(t, ts.RevokeAccessToken(jti, time.Now().Add(time.Minute)))
+
+	// the token has expired anyway
+	expired := jti + "-expired"
+	err = ts.RevokeAccessToken(expired, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	revoked, err = ts.IsRevoked(expired)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	ts, keys, db := newTestTokenService(t)
+	user := CreateTestUser(t, db, model.RoleUser)
+
+	other, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+	first, err := ts.IssueTokens(user, false)
+	assert.NoError(t, err)
+	// the current session was refreshed since login
+	current, err := ts.Refresh(first.RefreshToken)
+	assert.NoError(t, err)
+
+	err = ts.RevokeOtherSessions(user.ID, accessTokenID(t, keys, current.AccessToken))
+	assert.NoError(t, err)
+
+	revoked, err := ts.IsRevoked(accessTokenID(t, keys, current.AccessToken))
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	_, err = ts.Refresh(current.RefreshToken)
+	assert.NoError(t, err)
+
+	revoked, err = ts.IsRevoked(accessTokenID(t, keys, other.AccessToken))
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = ts.Refresh(other.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+}
@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"encoding/base32"
+	"h8-p2-finalproj-app/service"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shared secret from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeAtRFCVectors(t *testing.T) {
+	// last 6 digits of the SHA1 vectors
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := service.TOTPCodeAt(rfcSecret, service.TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, "time %d", unix)
+	}
+}
+
+func TestValidateTOTPWithSkew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	prev, _ := service.TOTPCodeAt(rfcSecret, service.TOTPStep(now)-1)
+	_, ok := service.ValidateTOTP(rfcSecret, prev, now)
+	assert.True(t, ok)
+
+	old, _ := service.TOTPCodeAt(rfcSecret, service.TOTPStep(now)-2)
+	_, ok = service.ValidateTOTP(rfcSecret, old, now)
+	assert.False(t, ok)
+
+	_, ok = service.ValidateTOTP(rfcSecret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := service.TOTPProvisioningURI("Car Rental", "john@example.com", rfcSecret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Car%20Rental:john@example.com?"))
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=Car+Rental")
+}
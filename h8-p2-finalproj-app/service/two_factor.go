@@ -0,0 +1,295 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TwoFactorIssuer        = "H8 Car Rental"
+	NumOfRecoveryCodes     = 10
+	LoginChallengeTTL      = 5 * time.Minute
+	MaxLoginChallengeTries = 5
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("two factor not enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two factor enrollment not started")
+	ErrTwoFactorRequired       = errors.New("two factor required for role")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two factor code")
+	ErrInvalidLoginChallenge   = errors.New("invalid or expired login challenge")
+)
+
+type TwoFactorService struct {
+	db            *gorm.DB
+	requiredRoles []string
+}
+
+// roles required to use 2fa are read from TWO_FACTOR_REQUIRED_ROLES, comma separated
+func NewTwoFactorService(db *gorm.DB) *TwoFactorService {
+	roles := []string{}
+	for _, r := range strings.Split(os.Getenv("TWO_FACTOR_REQUIRED_ROLES"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	return &TwoFactorService{
+		db:            db,
+		requiredRoles: roles,
+	}
+}
+
+func (tfs *TwoFactorService) IsRequiredForRole(role string) bool {
+	return slices.Contains(tfs.requiredRoles, role)
+}
+
+func (tfs *TwoFactorService) IsEnabled(userID uint) (bool, error) {
+	var count int64
+	err := tfs.db.Model(&model.TwoFactorAuth{}).
+		Where("user_id=? AND enabled", userID).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// creates a new pending secret, replacing any previous pending one.
+// returns the secret and the provisioning uri
+func (tfs *TwoFactorService) BeginEnrollment(user *model.User) (string, string, error) {
+	var tfa model.TwoFactorAuth
+	err := tfs.db.Where("user_id=?", user.ID).First(&tfa).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", "", err
+	}
+	if tfa.Enabled {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	tfa.UserID = user.ID
+	tfa.Secret = secret
+	tfa.LastUsedStep = 0
+	err = tfs.db.Save(&tfa).Error
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPProvisioningURI(TwoFactorIssuer, user.Email, secret), nil
+}
+
+// checks the totp code and marks its step as used
+func (tfs *TwoFactorService) verifyTOTP(tx *gorm.DB, tfa *model.TwoFactorAuth, code string) error {
+	step, ok := ValidateTOTP(tfa.Secret, code, time.Now())
+	if !ok || step <= tfa.LastUsedStep {
+		return ErrInvalidTwoFactorCode
+	}
+	tfa.LastUsedStep = step
+	return tx.Save(tfa).Error
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// consumes a recovery code of the user
+func (tfs *TwoFactorService) useRecoveryCode(tx *gorm.DB, userID uint, code string) error {
+	res := tx.Model(&model.RecoveryCode{}).
+		Where("user_id=? AND code_hash=? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaces the recovery codes of the user, returns the plain codes
+func (tfs *TwoFactorService) generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	err := tx.Unscoped().Where("user_id=?", userID).Delete(&model.RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	rows := []model.RecoveryCode{}
+	for i := 0; i < NumOfRecoveryCodes; i++ {
+		raw, err := GenerateTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(raw[:5] + "-" + raw[5:10])
+		codes = append(codes, code)
+		rows = append(rows, model.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(code)),
+		})
+	}
+	err = tx.Create(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enables 2fa once the user proves the authenticator is set up.
+// returns the recovery codes, these are only shown once
+func (tfs *TwoFactorService) ConfirmEnrollment(user *model.User, code string) ([]string, error) {
+	var codes []string
+	err := tfs.db.Transaction(func(tx *gorm.DB) error {
+		var tfa model.TwoFactorAuth
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id=?", user.ID).First(&tfa).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrTwoFactorNotEnrolled
+		} else if err != nil {
+			return err
+		}
+		if tfa.Enabled {
+			return ErrTwoFactorAlreadyEnabled
+		}
+
+		err = tfs.verifyTOTP(tx, &tfa, code)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		tfa.Enabled = true
+		tfa.EnabledAt = &now
+		err = tx.Save(&tfa).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = tfs.generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifies either a totp code or an unused recovery code
+func (tfs *TwoFactorService) verifyCode(tx *gorm.DB, userID uint, code string) error {
+	var tfa model.TwoFactorAuth
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id=? AND enabled", userID).
+		First(&tfa).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTwoFactorNotEnabled
+	} else if err != nil {
+		return err
+	}
+
+	err = tfs.verifyTOTP(tx, &tfa, code)
+	if err == nil || !errors.Is(err, ErrInvalidTwoFactorCode) {
+		return err
+	}
+	return tfs.useRecoveryCode(tx, userID, code)
+}
+
+func (tfs *TwoFactorService) Disable(user *model.User, code string) error {
+	if tfs.IsRequiredForRole(user.Role) {
+		return ErrTwoFactorRequired
+	}
+	return tfs.db.Transaction(func(tx *gorm.DB) error {
+		err := tfs.verifyCode(tx, user.ID, code)
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Where("user_id=?", user.ID).Delete(&model.RecoveryCode{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id=?", user.ID).Delete(&model.TwoFactorAuth{}).Error
+	})
+}
+
+// regenerates recovery codes, invalidating the old ones
+func (tfs *TwoFactorService) RegenerateRecoveryCodes(user *model.User, code string) ([]string, error) {
+	var codes []string
+	err := tfs.db.Transaction(func(tx *gorm.DB) error {
+		err := tfs.verifyCode(tx, user.ID, code)
+		if err != nil {
+			return err
+		}
+		codes, err = tfs.generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// starts the second login step after the password was verified
+func (tfs *TwoFactorService) CreateLoginChallenge(user *model.User) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	err = tfs.db.Create(&model.LoginChallenge{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(LoginChallengeTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// completes the login challenge with a totp or recovery code, returns the user
+func (tfs *TwoFactorService) VerifyLoginChallenge(token string, code string) (*model.User, error) {
+	var user *model.User
+	err := tfs.db.Transaction(func(tx *gorm.DB) error {
+		var challenge model.LoginChallenge
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("User").
+			Where("token_hash=?", hashToken(token)).
+			First(&challenge).Error
+		if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidLoginChallenge
+		} else if err != nil {
+			return err
+		}
+		if challenge.UsedAt != nil ||
+			challenge.Attempts >= MaxLoginChallengeTries ||
+			time.Now().After(challenge.ExpiresAt) {
+			return ErrInvalidLoginChallenge
+		}
+
+		err = tfs.verifyCode(tx, challenge.UserID, code)
+		if err != nil && errors.Is(err, ErrInvalidTwoFactorCode) {
+			challenge.Attempts++
+			return tx.Save(&challenge).Error
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		challenge.UsedAt = &now
+		user = &challenge.User
+		return tx.Save(&challenge).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	return user, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"h8-p2-finalproj-app/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNoUnitAvailable = errors.New("no unit available for rental")
+	ErrUnitNotFree     = errors.New("unit not free for rental")
+)
+
+// ids of units assigned to other active rentals overlapping the rental dates
+func (cs *CarService) busyUnitsQuery(tx *gorm.DB, rental *model.Rental) *gorm.DB {
+	q := tx.Model(&model.Rental{}).
+		Select("rentals.vehicle_unit_id").
+		Where("rentals.vehicle_unit_id IS NOT NULL AND rentals.id <> ?", rental.ID)
+	q = whereRentalActive(q, "rentals")
+	return whereRentalOverlaps(q, "rentals", rental.StartDate, rental.EndDate)
+}
+
+// ids of units scheduled for maintenance overlapping the rental dates
+func (cs *CarService) maintenanceUnitsQuery(tx *gorm.DB, rental *model.Rental) *gorm.DB {
+	q := tx.Model(&model.Maintenance{}).
+		Select("maintenance_units.vehicle_unit_id").
+		Joins("join maintenance_units on maintenance_units.maintenance_id = maintenances.id")
+	return whereMaintenanceOverlaps(q, rental.StartDate, rental.EndDate)
+}
+
+// units of the car that are in service and not assigned to an overlapping rental
+// or maintenance, at the pickup branch if the rental has one
+func (cs *CarService) FreeUnitsQuery(tx *gorm.DB, rental *model.Rental) *gorm.DB {
+	q := tx.Model(&model.VehicleUnit{}).
+		Where("car_id=? AND status=?", rental.CarID, model.UnitStatusAvailable).
+		Where("id NOT IN (?)", cs.busyUnitsQuery(tx, rental)).
+		Where("id NOT IN (?)", cs.maintenanceUnitsQuery(tx, rental))
+	if rental.PickupBranchID != nil {
+		q = q.Where("branch_id=?", *rental.PickupBranchID)
+	}
+	return q
+}
+
+// assigns a unit to the rental, the given unit or the first free one when nil.
+// does nothing if the rental already has a unit and no unit is given
+func (cs *CarService) AssignUnit(tx *gorm.DB, rental *model.Rental, unitID *uint) error {
+	if unitID == nil && rental.VehicleUnitID != nil {
+		return nil
+	}
+
+	// lock the car so concurrent assignments don't pick the same unit
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id=?", rental.CarID).First(&model.Car{}).Error
+	if err != nil {
+		return err
+	}
+
+	q := cs.FreeUnitsQuery(tx, rental)
+	if unitID != nil {
+		q = q.Where("id=?", *unitID)
+	}
+
+	var unit model.VehicleUnit
+	err = q.Order("id").First(&unit).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		if unitID != nil {
+			return ErrUnitNotFree
+		}
+		return ErrNoUnitAvailable
+	} else if err != nil {
+		return err
+	}
+
+	rental.VehicleUnitID = &unit.ID
+	rental.VehicleUnit = &unit
+	rental.UnitAssignmentError = ""
+	return tx.Model(rental).Updates(map[string]any{
+		"vehicle_unit_id":       unit.ID,
+		"unit_assignment_error": "",
+	}).Error
+}
+
+// records why no unit could be reserved for a confirmed rental, so staff
+// can sort it out before pickup
+func (cs *CarService) FlagUnassigned(rentalID uint, reason error) error {
+	return cs.db.Model(&model.Rental{}).Where("id=?", rentalID).
+		Update("unit_assignment_error", reason.Error()).Error
+}
+
+// confirmed rentals still waiting for a unit after assigning one failed,
+// earliest pickup first
+func (cs *CarService) GetUnassignedRentals() ([]model.Rental, error) {
+	var rentals []model.Rental
+	q := cs.db.Preload("Car").Preload("Payment").Preload("PickupBranch").Preload("DropoffBranch").
+		Preload("PriceItems").Preload("AddOns.AddOn").Preload("SecurityDeposit.Payment").
+		Where("rentals.unit_assignment_error <> '' AND rentals.vehicle_unit_id IS NULL AND rentals.picked_up_at IS NULL")
+	err := whereRentalActive(q, "rentals").Order("rentals.start_date").Find(&rentals).Error
+	return rentals, err
+}
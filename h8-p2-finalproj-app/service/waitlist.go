@@ -0,0 +1,305 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"h8-p2-finalproj-app/model"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrWaitlistEntryNotFound = errors.New("waitlist entry not found")
+	ErrAlreadyWaiting        = errors.New("already on the waitlist for the period")
+	ErrWaitlistEntryClosed   = errors.New("waitlist entry no longer open")
+)
+
+type WaitlistService struct {
+	db *gorm.DB
+	cs *CarService
+	ns *NotificationService
+}
+
+func NewWaitlistService(db *gorm.DB, cs *CarService, ns *NotificationService) *WaitlistService {
+	return &WaitlistService{
+		db: db,
+		cs: cs,
+		ns: ns,
+	}
+}
+
+// how long a freed unit is held for the first in line, WAITLIST_HOLD_MINUTES
+func WaitlistHoldDuration() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("WAITLIST_HOLD_MINUTES"))
+	if err != nil || minutes < 1 {
+		return 30 * time.Minute
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// units of the car held for waitlisted users other than the user, during the
+// period and at the pickup branch. holds without a branch count everywhere
+func (cs *CarService) heldUnits(tx *gorm.DB, carID uint, branchID *uint, startDate time.Time, endDate time.Time, exceptUserID uint) (uint, error) {
+	q := tx.Model(&model.WaitlistEntry{}).
+		Where("car_id=? AND user_id<>?", carID, exceptUserID).
+		Where("status=? AND hold_expires_at > ?", model.WaitlistHeld, time.Now())
+	if branchID != nil {
+		q = q.Where("pickup_branch_id=? OR pickup_branch_id IS NULL", *branchID)
+	}
+	var count int64
+	err := whereRentalOverlaps(q, "waitlist_entries", startDate, endDate).Count(&count).Error
+	return uint(count), err
+}
+
+func (cs *CarService) isCarBookable(tx *gorm.DB, carID uint, branchID *uint, startDate time.Time, endDate time.Time, userID uint) (bool, error) {
+	data, err := cs.GetCarWithRentals(carID, &GetCarsQueryParams{
+		StartDate:      &startDate,
+		EndDate:        &endDate,
+		PickupBranchID: branchID,
+	})
+	if err != nil && errors.Is(err, ErrCarNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	held, err := cs.heldUnits(tx, carID, branchID, startDate, endDate, userID)
+	if err != nil {
+		return false, err
+	}
+	return data.NumOfAvailable() > held, nil
+}
+
+// like IsCarAvailableAtBranch, but units held for other users on the
+// waitlist can't be booked by the user
+func (cs *CarService) IsCarBookable(carID uint, branchID *uint, startDate time.Time, endDate time.Time, userID uint) (bool, error) {
+	return cs.isCarBookable(cs.db, carID, branchID, startDate, endDate, userID)
+}
+
+// the status an open entry is closed with at the time: lapsed when the hold
+// ran out, expired when the period started while still waiting
+func ClosedWaitlistStatus(entry *model.WaitlistEntry, now time.Time) (string, bool) {
+	switch {
+	case entry.Status == model.WaitlistHeld && entry.HoldExpiresAt != nil && !entry.HoldExpiresAt.After(now):
+		return model.WaitlistLapsed, true
+	case entry.Status == model.WaitlistWaiting && !entry.StartDate.After(now):
+		return model.WaitlistExpired, true
+	}
+	return entry.Status, false
+}
+
+// place in line of a waiting entry, from 1
+func (ws *WaitlistService) Position(entry *model.WaitlistEntry) (uint, error) {
+	if entry.Status != model.WaitlistWaiting {
+		return 0, nil
+	}
+	var ahead int64
+	err := ws.db.Model(&model.WaitlistEntry{}).
+		Where("car_id=? AND status=? AND id<?", entry.CarID, model.WaitlistWaiting, entry.ID).
+		Count(&ahead).Error
+	return uint(ahead) + 1, err
+}
+
+func (ws *WaitlistService) GetEntries(userID uint) ([]model.WaitlistEntry, error) {
+	var entries []model.WaitlistEntry
+	err := ws.db.Preload("Car").Where("user_id=?", userID).Order("id DESC").Find(&entries).Error
+	return entries, err
+}
+
+// queues the user for a car that is fully booked for the period
+func (ws *WaitlistService) Join(userID uint, carID uint, branchID *uint, startDate time.Time, endDate time.Time) (*model.WaitlistEntry, error) {
+	err := ValidateWatchPeriod(startDate, endDate, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	car, err := ws.cs.GetCar(carID)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	q := ws.db.Model(&model.WaitlistEntry{}).
+		Where("user_id=? AND car_id=?", userID, carID).
+		Where("status IN ?", []string{model.WaitlistWaiting, model.WaitlistHeld})
+	err = whereRentalOverlaps(q, "waitlist_entries", startDate, endDate).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyWaiting
+	}
+
+	isAvail, err := ws.cs.IsCarBookable(carID, branchID, startDate, endDate, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isAvail {
+		return nil, ErrCarAvailable
+	}
+
+	entry := model.WaitlistEntry{
+		UserID:         userID,
+		CarID:          carID,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		PickupBranchID: branchID,
+		Status:         model.WaitlistWaiting,
+	}
+	err = ws.db.Create(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	entry.Car = *car
+	return &entry, nil
+}
+
+// takes the user off the waitlist, a held unit goes to the next in line
+func (ws *WaitlistService) Leave(userID uint, id uint, logger echo.Logger) error {
+	var entry model.WaitlistEntry
+	err := ws.db.Where("id=? AND user_id=?", id, userID).First(&entry).Error
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrWaitlistEntryNotFound
+	} else if err != nil {
+		return err
+	}
+
+	res := ws.db.Model(&entry).
+		Where("status IN ?", []string{model.WaitlistWaiting, model.WaitlistHeld}).
+		Update("status", model.WaitlistCancelled)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrWaitlistEntryClosed
+	}
+	return ws.ProcessQueue(entry.CarID, logger)
+}
+
+// closes the user's open entries on the car for the period once they booked it
+func (ws *WaitlistService) MarkBooked(tx *gorm.DB, rental *model.Rental) error {
+	q := tx.Model(&model.WaitlistEntry{}).
+		Where("user_id=? AND car_id=?", rental.UserID, rental.CarID).
+		Where("status IN ?", []string{model.WaitlistWaiting, model.WaitlistHeld})
+	return whereRentalOverlaps(q, "waitlist_entries", rental.StartDate, rental.EndDate).
+		Updates(map[string]any{
+			"status":    model.WaitlistBooked,
+			"rental_id": rental.ID,
+		}).Error
+}
+
+// moves the waitlist of the car along: lapsed holds and entries whose period
+// started are closed, then units that are free are held for the waiting
+// users in the order they joined
+func (ws *WaitlistService) ProcessQueue(carID uint, logger echo.Logger) error {
+	held := []model.WaitlistEntry{}
+	err := ws.db.Transaction(func(tx *gorm.DB) error {
+		var entries []model.WaitlistEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("car_id=? AND status IN ?", carID, []string{model.WaitlistWaiting, model.WaitlistHeld}).
+			Order("id").Find(&entries).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for i := range entries {
+			e := &entries[i]
+			status, closed := ClosedWaitlistStatus(e, now)
+			if !closed {
+				continue
+			}
+			e.Status = status
+			err = tx.Model(e).Update("status", e.Status).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := range entries {
+			e := &entries[i]
+			if e.Status != model.WaitlistWaiting {
+				continue
+			}
+			isAvail, err := ws.cs.isCarBookable(tx, carID, e.PickupBranchID, e.StartDate, e.EndDate, e.UserID)
+			if err != nil {
+				return err
+			}
+			if !isAvail {
+				continue
+			}
+			holdExpiresAt := now.Add(WaitlistHoldDuration())
+			err = tx.Model(e).Updates(map[string]any{
+				"status":          model.WaitlistHeld,
+				"hold_expires_at": holdExpiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+			e.Status = model.WaitlistHeld
+			e.HoldExpiresAt = &holdExpiresAt
+			held = append(held, *e)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range held {
+		ws.notifyHold(&held[i], logger)
+	}
+	return nil
+}
+
+func (ws *WaitlistService) notifyHold(entry *model.WaitlistEntry, logger echo.Logger) {
+	err := ws.db.Preload("User").Preload("Car").Where("id=?", entry.ID).First(entry).Error
+	if err != nil {
+		logger.Errorf("failed to load waitlist entry %d: %s", entry.ID, err.Error())
+		return
+	}
+	ws.ns.SendMail(entry.UserID, entry.User.Email, "A car is held for you!",
+		fmt.Sprintf(`
+		<h1>Hello %s,</h1><br>
+		<p>%s is available from %s to %s and is held for you until %s.</p>
+		<p>Book it before then or it goes to the next person in line.</p>
+		`, entry.User.Name,
+			entry.Car.GetCarName(),
+			entry.StartDate.Format(mailTimeFormat),
+			entry.EndDate.Format(mailTimeFormat),
+			entry.HoldExpiresAt.In(entry.StartDate.Location()).Format(mailTimeFormat)),
+		logger,
+	)
+}
+
+// passes lapsed holds on to the next in line
+func (ws *WaitlistService) ExpireHolds(logger echo.Logger) error {
+	var carIDs []uint
+	err := ws.db.Model(&model.WaitlistEntry{}).
+		Where("status=? AND hold_expires_at <= ?", model.WaitlistHeld, time.Now()).
+		Distinct().Pluck("car_id", &carIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, carID := range carIDs {
+		err = ws.ProcessQueue(carID, logger)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checks for lapsed holds every interval until the app stops
+func (ws *WaitlistService) RunHoldExpiry(interval time.Duration, logger echo.Logger) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		err := ws.ExpireHolds(logger)
+		if err != nil {
+			logger.Errorf("failed to expire waitlist holds: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package service_test
+
+import (
+	"h8-p2-finalproj-app/model"
+	"h8-p2-finalproj-app/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosedWaitlistStatus(t *testing.T) {
+	now := mustTime("2024-09-01T10:00:00+07:00")
+	start := mustTime("2024-09-10T09:00:00+07:00")
+	lapsedAt := mustTime("2024-09-01T09:30:00+07:00")
+	heldUntil := mustTime("2024-09-01T10:30:00+07:00")
+
+	waiting := model.WaitlistEntry{Status: model.WaitlistWaiting, StartDate: start}
+	_, closed := service.ClosedWaitlistStatus(&waiting, now)
+	assert.False(t, closed)
+
+	held := model.WaitlistEntry{Status: model.WaitlistHeld, StartDate: start, HoldExpiresAt: &heldUntil}
+	_, closed = service.ClosedWaitlistStatus(&held, now)
+	assert.False(t, closed)
+
+	// the next in line gets the unit
+	held.HoldExpiresAt = &lapsedAt
+	status, closed := service.ClosedWaitlistStatus(&held, now)
+	assert.True(t, closed)
+	assert.Equal(t, model.WaitlistLapsed, status)
+
+	started := model.WaitlistEntry{Status: model.WaitlistWaiting, StartDate: now}
+	status, closed = service.ClosedWaitlistStatus(&started, now)
+	assert.True(t, closed)
+	assert.Equal(t, model.WaitlistExpired, status)
+
+	booked := model.WaitlistEntry{Status: model.WaitlistBooked, StartDate: now}
+	_, closed = service.ClosedWaitlistStatus(&booked, now)
+	assert.False(t, closed)
+}
+
+func TestWaitlistHoldDuration(t *testing.T) {
+	t.Setenv("WAITLIST_HOLD_MINUTES", "")
+	assert.Equal(t, 30*time.Minute, service.WaitlistHoldDuration())
+
+	t.Setenv("WAITLIST_HOLD_MINUTES", "90")
+	assert.Equal(t, 90*time.Minute, service.WaitlistHoldDuration())
+
+	t.Setenv("WAITLIST_HOLD_MINUTES", "0")
+	assert.Equal(t, 30*time.Minute, service.WaitlistHoldDuration())
+}
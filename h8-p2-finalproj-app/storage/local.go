@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// files on the local disk, served by the app itself
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocalStorage(dir string, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// files are written to IMAGE_STORAGE_DIR, defaults to the temp dir,
+// and served under APP_URL/images
+func NewLocalStorageFromEnv() *LocalStorage {
+	dir := os.Getenv("IMAGE_STORAGE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "images")
+	}
+	return NewLocalStorage(dir, os.Getenv("APP_URL")+"/images")
+}
+
+// private documents like driver licenses, written to DOCUMENT_STORAGE_DIR,
+// defaults to the temp dir. they have no public url and are only read
+// through authorized endpoints
+func NewDocumentStorageFromEnv() *LocalStorage {
+	dir := os.Getenv("DOCUMENT_STORAGE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "documents")
+	}
+	return NewLocalStorage(dir, "")
+}
+
+// path of the file of the key on disk
+func (ls *LocalStorage) Path(key string) (string, error) {
+	err := ValidateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
+func (ls *LocalStorage) Put(key string, contentType string, r io.Reader) error {
+	path, err := ls.Path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	// written next to the final path first so a failed upload leaves nothing behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (ls *LocalStorage) Delete(key string) error {
+	path, err := ls.Path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (ls *LocalStorage) URL(key string) string {
+	return ls.baseURL + "/" + key
+}
@@ -0,0 +1,36 @@
+package storage_test
+
+import (
+	"h8-p2-finalproj-app/storage"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateKey(t *testing.T) {
+	assert.NoError(t, storage.ValidateKey("cars/1/abc.jpg"))
+	for _, key := range []string{"", "/etc/passwd", "cars/../../etc", "cars//a.jpg", "cars\\a.jpg"} {
+		assert.ErrorIs(t, storage.ValidateKey(key), storage.ErrInvalidKey, key)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	ls := storage.NewLocalStorage(t.TempDir(), "http://localhost:8080/images/")
+	err := ls.Put("cars/1/a.jpg", "image/jpeg", strings.NewReader("data"))
+	assert.NoError(t, err)
+
+	path, err := ls.Path("cars/1/a.jpg")
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Equal(t, "http://localhost:8080/images/cars/1/a.jpg", ls.URL("cars/1/a.jpg"))
+
+	assert.NoError(t, ls.Delete("cars/1/a.jpg"))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	// deleting again is fine
+	assert.NoError(t, ls.Delete("cars/1/a.jpg"))
+}
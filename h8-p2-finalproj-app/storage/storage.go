@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid storage key")
+)
+
+// where uploaded files are kept, keys are slash separated paths like
+// cars/1/abc.jpg and URL gives the public address of a stored file
+type Storage interface {
+	Put(key string, contentType string, r io.Reader) error
+	Delete(key string) error
+	URL(key string) string
+}
+
+// keys can't be empty, absolute or climb out of the storage
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// storage selected by IMAGE_STORAGE, only local is supported for now
+func NewStorageFromEnv() (Storage, error) {
+	switch kind := os.Getenv("IMAGE_STORAGE"); kind {
+	case "", "local":
+		return NewLocalStorageFromEnv(), nil
+	default:
+		return nil, fmt.Errorf("unsupported IMAGE_STORAGE %q", kind)
+	}
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// where the client ip is read from. X-Forwarded-For is only trusted from the
+// proxies in TRUSTED_PROXIES, comma separated CIDRs, without it the address
+// of the connection is used so clients can't pick their own ip
+func IPExtractorFromEnv() (echo.IPExtractor, error) {
+	proxies := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	if proxies == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range strings.Split(proxies, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES %q: %w", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
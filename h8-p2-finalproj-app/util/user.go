@@ -12,8 +12,8 @@ import (
 	"gorm.io/gorm"
 )
 
-// parse user claims in context and returns user
-func GetUserFromContext(c echo.Context, db *gorm.DB) (*model.User, error) {
+// parse claims of the jwt in context
+func GetClaimsFromContext(c echo.Context) (*auth.JwtAppClaims, error) {
 	d := c.Get("user")
 	token, ok := d.(*jwt.Token)
 	if !ok {
@@ -24,9 +24,18 @@ func GetUserFromContext(c echo.Context, db *gorm.DB) (*model.User, error) {
 	if !ok {
 		return nil, NewAppError(http.StatusInternalServerError, "failed to parse token", fmt.Sprintf("found type %T", token.Claims))
 	}
+	return appClaims, nil
+}
+
+// parse user claims in context and returns user
+func GetUserFromContext(c echo.Context, db *gorm.DB) (*model.User, error) {
+	appClaims, err := GetClaimsFromContext(c)
+	if err != nil {
+		return nil, err
+	}
 
 	var user model.User
-	err := db.Where("id=?", appClaims.UserID).First(&user).Error
+	err = db.Where("id=?", appClaims.UserID).First(&user).Error
 	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, NewAppError(http.StatusBadRequest, "user not found", "")
 	} else if err != nil {
@@ -11,9 +11,22 @@ CREATE TABLE cars (
     year INT NOT NULL,
     stock INT NOT NULL,
     rate_per_day DECIMAL NOT NULL,
+    -- 0 when the car is only rented by the day
+    rate_per_hour DECIMAL NOT NULL DEFAULT 0,
+    -- aggregated from reviews
+    average_rating DECIMAL NOT NULL DEFAULT 0,
+    num_of_reviews INT NOT NULL DEFAULT 0,
     UNIQUE(manufacturer, car_model)
 );
 
+-- full text search of cars, must match carSearchVector in service/car_search.go
+CREATE INDEX idx_cars_search ON cars USING GIN ((
+    setweight(to_tsvector('simple', coalesce(manufacturer, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(car_model, '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(type, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(transmission, '')), 'C')
+));
+
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
@@ -23,15 +36,489 @@ CREATE TABLE users (
     deposit DECIMAL NOT NULL DEFAULT 0
 );
 
+-- stored hashed, each rotation adds a row to the same family
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    family_id VARCHAR(64) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    access_token_id VARCHAR(64),
+    access_expires_at TIMESTAMPTZ,
+    two_factor BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+-- access tokens revoked before they expire
+CREATE TABLE revoked_tokens (
+    id SERIAL PRIMARY KEY,
+    jti VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+-- every login, failures are counted per ip and per account within a window
+CREATE TABLE login_attempts (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    success BOOLEAN NOT NULL
+);
+
+CREATE INDEX idx_login_attempts_email ON login_attempts (email);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip);
+
+-- lockout events kept for auditing, the unlock token is stored hashed
+CREATE TABLE account_lockouts (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    ip VARCHAR(45),
+    reason TEXT,
+    locked_until TIMESTAMPTZ NOT NULL,
+    unlock_token_hash VARCHAR(64),
+    unlocked_at TIMESTAMPTZ
+);
+
+-- totp secret of the user, enabled once the first code is confirmed
+CREATE TABLE two_factor_auths (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL UNIQUE,
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled_at TIMESTAMPTZ,
+    -- a code can't be used twice
+    last_used_step BIGINT
+);
+
+-- single use codes, stored hashed
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+-- second step of a login when 2fa is enabled
+CREATE TABLE login_challenges (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    used_at TIMESTAMPTZ
+);
+
+-- pending email change, applied once the new address is verified
+CREATE TABLE email_change_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    new_email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_email_change_requests_user_id ON email_change_requests (user_id);
+
+-- history of notifications sent to a user, mail bodies are not kept
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255)
+);
+
+CREATE INDEX idx_notifications_user_id ON notifications (user_id);
+
+-- archive of the personal data of a user, the download token is stored hashed
+CREATE TABLE data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    file_path VARCHAR(255),
+    download_token_hash VARCHAR(64),
+    error TEXT,
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports (user_id);
+CREATE INDEX idx_data_exports_download_token_hash ON data_exports (download_token_hash);
+
 CREATE TABLE rentals (
     id SERIAL PRIMARY KEY,
     user_id INT REFERENCES users(id) NOT NULL,
     car_id INT REFERENCES cars(id) NOT NULL,
+    -- pickup and return times
+    start_date TIMESTAMPTZ NOT NULL,
+    end_date TIMESTAMPTZ NOT NULL,
+    total_price DECIMAL NOT NULL
+);
+
+CREATE TABLE branches (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    address TEXT NOT NULL,
+    city VARCHAR(100) NOT NULL,
+    phone VARCHAR(50)
+);
+
+-- weekday follows go's time.Weekday, 0 is sunday
+CREATE TABLE branch_opening_hours (
+    id SERIAL PRIMARY KEY,
+    branch_id INT REFERENCES branches(id) NOT NULL,
+    weekday INT NOT NULL,
+    opens_at VARCHAR(5) NOT NULL,
+    closes_at VARCHAR(5) NOT NULL,
+    UNIQUE(branch_id, weekday)
+);
+
+CREATE TABLE one_way_fees (
+    id SERIAL PRIMARY KEY,
+    from_branch_id INT REFERENCES branches(id) NOT NULL,
+    to_branch_id INT REFERENCES branches(id) NOT NULL,
+    fee DECIMAL NOT NULL,
+    UNIQUE(from_branch_id, to_branch_id)
+);
+
+CREATE TABLE vehicle_units (
+    id SERIAL PRIMARY KEY,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    branch_id INT REFERENCES branches(id),
+    plate_number VARCHAR(20) NOT NULL UNIQUE,
+    vin VARCHAR(17) NOT NULL UNIQUE,
+    color VARCHAR(50),
+    odometer INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'Available'
+);
+
+-- units blocked from rentals while in the workshop
+CREATE TABLE maintenances (
+    id SERIAL PRIMARY KEY,
+    car_id INT REFERENCES cars(id) NOT NULL,
     start_date DATE NOT NULL,
     end_date DATE NOT NULL,
-    total_price DECIMAL NOT NULL
+    reason TEXT,
+    created_by_id INT REFERENCES users(id)
+);
+
+CREATE TABLE maintenance_units (
+    maintenance_id INT REFERENCES maintenances(id) NOT NULL,
+    vehicle_unit_id INT REFERENCES vehicle_units(id) NOT NULL,
+    PRIMARY KEY (maintenance_id, vehicle_unit_id)
+);
+
+-- car specific rules replace the general rules of the same type
+CREATE TABLE pricing_rules (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(20) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    car_id INT REFERENCES cars(id),
+    multiplier DECIMAL,
+    rate DECIMAL,
+    start_date DATE,
+    end_date DATE,
+    min_days INT,
+    discount_percent DECIMAL
+);
+
+-- itemized price of a rental
+CREATE TABLE rental_price_items (
+    id SERIAL PRIMARY KEY,
+    rental_id INT REFERENCES rentals(id) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    quantity INT,
+    unit_price DECIMAL,
+    amount DECIMAL NOT NULL
+);
+
+-- time limited price offered before booking
+CREATE TABLE quotes (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    start_date TIMESTAMPTZ NOT NULL,
+    end_date TIMESTAMPTZ NOT NULL,
+    -- pickup and return times were given, not only dates
+    timed BOOLEAN NOT NULL DEFAULT FALSE,
+    pickup_branch_id INT REFERENCES branches(id),
+    dropoff_branch_id INT REFERENCES branches(id),
+    one_way_fee DECIMAL,
+    total_price DECIMAL NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    rental_id INT REFERENCES rentals(id)
+);
+
+CREATE TABLE quote_items (
+    id SERIAL PRIMARY KEY,
+    quote_id INT REFERENCES quotes(id) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    quantity INT,
+    unit_price DECIMAL,
+    amount DECIMAL NOT NULL
+);
+
+-- promo codes, limits of 0 are unlimited
+CREATE TABLE promotions (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    description TEXT,
+    discount_type VARCHAR(20) NOT NULL,
+    discount_value DECIMAL NOT NULL,
+    max_discount DECIMAL,
+    min_spend DECIMAL,
+    starts_at TIMESTAMPTZ NOT NULL,
+    ends_at TIMESTAMPTZ NOT NULL,
+    usage_limit INT,
+    per_user_limit INT,
+    car_types TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE promotion_redemptions (
+    id SERIAL PRIMARY KEY,
+    promotion_id INT REFERENCES promotions(id) NOT NULL,
+    user_id INT REFERENCES users(id) NOT NULL,
+    rental_id INT REFERENCES rentals(id) NOT NULL,
+    discount DECIMAL
+);
+
+ALTER TABLE rentals ADD COLUMN promotion_id INT REFERENCES promotions(id);
+ALTER TABLE rentals ADD COLUMN discount DECIMAL NOT NULL DEFAULT 0;
+ALTER TABLE quotes ADD COLUMN promotion_id INT REFERENCES promotions(id);
+ALTER TABLE quotes ADD COLUMN discount DECIMAL NOT NULL DEFAULT 0;
+
+CREATE TABLE add_ons (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    description TEXT,
+    pricing_type VARCHAR(20) NOT NULL,
+    price DECIMAL NOT NULL,
+    inventory INT,
+    active BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE rental_add_ons (
+    id SERIAL PRIMARY KEY,
+    rental_id INT REFERENCES rentals(id) NOT NULL,
+    add_on_id INT REFERENCES add_ons(id) NOT NULL,
+    quantity INT NOT NULL,
+    amount DECIMAL NOT NULL
+);
+
+-- add ons of a quote, booked with it at the quoted amount
+CREATE TABLE quote_add_ons (
+    id SERIAL PRIMARY KEY,
+    quote_id INT REFERENCES quotes(id) NOT NULL,
+    add_on_id INT REFERENCES add_ons(id) NOT NULL,
+    quantity INT NOT NULL,
+    amount DECIMAL NOT NULL
+);
+
+CREATE TABLE car_images (
+    id SERIAL PRIMARY KEY,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    thumbnail_url VARCHAR(255),
+    -- storage keys of uploaded images
+    key VARCHAR(255),
+    thumbnail_key VARCHAR(255),
+    content_type VARCHAR(50),
+    size BIGINT
+);
+
+CREATE TABLE reviews (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    rental_id INT REFERENCES rentals(id) UNIQUE NOT NULL,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    rating INT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    text TEXT,
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    hidden_reason TEXT,
+    hidden_by_id INT REFERENCES users(id),
+    hidden_at TIMESTAMPTZ
+);
+
+CREATE TABLE review_photos (
+    id SERIAL PRIMARY KEY,
+    review_id INT REFERENCES reviews(id) NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    thumbnail_url VARCHAR(255),
+    key VARCHAR(255),
+    thumbnail_key VARCHAR(255)
+);
+
+CREATE TABLE favorites (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    UNIQUE(user_id, car_id)
+);
+
+-- notified once when the car is available again for the period
+CREATE TABLE availability_watches (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    start_date TIMESTAMPTZ NOT NULL,
+    end_date TIMESTAMPTZ NOT NULL,
+    pickup_branch_id INT REFERENCES branches(id),
+    notified_at TIMESTAMPTZ
 );
 
+-- the first waiting user gets a freed unit held until hold_expires_at
+CREATE TABLE waitlist_entries (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    car_id INT REFERENCES cars(id) NOT NULL,
+    start_date TIMESTAMPTZ NOT NULL,
+    end_date TIMESTAMPTZ NOT NULL,
+    pickup_branch_id INT REFERENCES branches(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'Waiting',
+    hold_expires_at TIMESTAMPTZ,
+    rental_id INT REFERENCES rentals(id)
+);
+
+-- reviewed by staff, images are kept in the private document storage
+CREATE TABLE driver_licenses (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) NOT NULL,
+    number VARCHAR(50) NOT NULL,
+    expires_on DATE NOT NULL,
+    image_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50),
+    status VARCHAR(20) NOT NULL DEFAULT 'Pending',
+    reviewed_by_id INT REFERENCES users(id),
+    reviewed_at TIMESTAMPTZ,
+    reject_reason TEXT
+);
+
+-- businesses renting for their employees, billed once a month
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    billing_email VARCHAR(255) NOT NULL,
+    monthly_limit DECIMAL
+);
+
+-- a user belongs to at most one organization
+CREATE TABLE organization_members (
+    id SERIAL PRIMARY KEY,
+    organization_id INT REFERENCES organizations(id) NOT NULL,
+    user_id INT REFERENCES users(id) NOT NULL UNIQUE,
+    role VARCHAR(20) NOT NULL,
+    monthly_limit DECIMAL
+);
+
+-- users invited by an admin, they become members once they accept
+CREATE TABLE organization_invitations (
+    id SERIAL PRIMARY KEY,
+    organization_id INT REFERENCES organizations(id) NOT NULL,
+    user_id INT REFERENCES users(id) NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    monthly_limit DECIMAL,
+    UNIQUE(organization_id, user_id)
+);
+
+-- one invoice per organization and month, for rentals ending in the month
+CREATE TABLE organization_invoices (
+    id SERIAL PRIMARY KEY,
+    organization_id INT REFERENCES organizations(id) NOT NULL,
+    period VARCHAR(7) NOT NULL,
+    period_start TIMESTAMPTZ NOT NULL,
+    period_end TIMESTAMPTZ NOT NULL,
+    total DECIMAL NOT NULL,
+    UNIQUE(organization_id, period)
+);
+
+-- refundable deposit required for cars of a type
+CREATE TABLE security_deposit_rates (
+    id SERIAL PRIMARY KEY,
+    car_type VARCHAR(50) NOT NULL UNIQUE,
+    amount DECIMAL NOT NULL
+);
+
+-- held from booking until after the return, paid from the wallet or by invoice
+CREATE TABLE security_deposits (
+    id SERIAL PRIMARY KEY,
+    rental_id INT REFERENCES rentals(id) NOT NULL UNIQUE,
+    user_id INT REFERENCES users(id) NOT NULL,
+    amount DECIMAL NOT NULL,
+    method VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    captured_amount DECIMAL NOT NULL DEFAULT 0,
+    capture_reason TEXT,
+    captured_by_id INT REFERENCES users(id),
+    settled_at TIMESTAMPTZ
+);
+
+-- the state of the car at pickup and at return, one of each per rental
+CREATE TABLE inspection_reports (
+    id SERIAL PRIMARY KEY,
+    rental_id INT REFERENCES rentals(id) NOT NULL,
+    kind VARCHAR(10) NOT NULL,
+    inspector_id INT REFERENCES users(id) NOT NULL,
+    odometer INT NOT NULL,
+    -- percent of a full tank
+    fuel_level INT NOT NULL,
+    notes TEXT,
+    acknowledged_at TIMESTAMPTZ,
+    customer_comment TEXT,
+    UNIQUE(rental_id, kind)
+);
+
+CREATE TABLE inspection_items (
+    id SERIAL PRIMARY KEY,
+    inspection_report_id INT REFERENCES inspection_reports(id) NOT NULL,
+    item VARCHAR(100) NOT NULL,
+    condition VARCHAR(10) NOT NULL,
+    note TEXT
+);
+
+CREATE TABLE inspection_photos (
+    id SERIAL PRIMARY KEY,
+    inspection_report_id INT REFERENCES inspection_reports(id) NOT NULL,
+    item VARCHAR(100),
+    caption TEXT,
+    image_key VARCHAR(255) NOT NULL,
+    content_type VARCHAR(50)
+);
+
+-- taken from the security deposit first, the rest is invoiced
+CREATE TABLE damage_charges (
+    id SERIAL PRIMARY KEY,
+    inspection_report_id INT REFERENCES inspection_reports(id) NOT NULL,
+    rental_id INT REFERENCES rentals(id) NOT NULL,
+    user_id INT REFERENCES users(id) NOT NULL,
+    amount DECIMAL NOT NULL,
+    reason TEXT NOT NULL,
+    from_deposit DECIMAL NOT NULL DEFAULT 0,
+    invoiced DECIMAL NOT NULL DEFAULT 0,
+    charged_by_id INT REFERENCES users(id) NOT NULL
+);
+
+-- unit assigned at confirmation or pickup
+ALTER TABLE rentals ADD COLUMN vehicle_unit_id INT REFERENCES vehicle_units(id);
+ALTER TABLE rentals ADD COLUMN picked_up_at TIMESTAMPTZ;
+ALTER TABLE rentals ADD COLUMN unit_assignment_error TEXT NOT NULL DEFAULT '';
+-- one way rentals have different pickup and drop off branches
+ALTER TABLE rentals ADD COLUMN pickup_branch_id INT REFERENCES branches(id);
+ALTER TABLE rentals ADD COLUMN dropoff_branch_id INT REFERENCES branches(id);
+ALTER TABLE rentals ADD COLUMN one_way_fee DECIMAL NOT NULL DEFAULT 0;
+ALTER TABLE rentals ADD COLUMN returned_at TIMESTAMPTZ;
+-- booked on an organization account, driven by a member
+ALTER TABLE rentals ADD COLUMN organization_id INT REFERENCES organizations(id);
+ALTER TABLE rentals ADD COLUMN organization_invoice_id INT REFERENCES organization_invoices(id);
+ALTER TABLE rentals ADD COLUMN driver_id INT REFERENCES users(id);
+
 CREATE TABLE top_ups (
     id SERIAL PRIMARY KEY,
     user_id INT REFERENCES users(id) NOT NULL,
@@ -50,12 +537,46 @@ CREATE TABLE payments (
 );
 
 -- Insert dummy data into cars table
-INSERT INTO cars (wheel_drive, type, seats, transmission, manufacturer, car_model, year, stock, rate_per_day) VALUES
-(4, 'SUV', 5, 'Automatic', 'Toyota', 'RAV4', 2022, 10, 500000),
-(2, 'Sedan', 5, 'Manual', 'Honda', 'Civic', 2021, 8, 400000),
-(4, 'Truck', 2, 'Automatic', 'Ford', 'F-150', 2023, 5, 700000),
-(4, 'SUV', 7, 'Automatic', 'Chevrolet', 'Tahoe', 2022, 6, 600000),
-(2, 'Coupe', 4, 'Manual', 'BMW', 'M4', 2021, 3, 80.00);
+INSERT INTO cars (wheel_drive, type, seats, transmission, manufacturer, car_model, year, stock, rate_per_day, rate_per_hour) VALUES
+(4, 'SUV', 5, 'Automatic', 'Toyota', 'RAV4', 2022, 10, 500000, 75000),
+(2, 'Sedan', 5, 'Manual', 'Honda', 'Civic', 2021, 8, 400000, 60000),
+(4, 'Truck', 2, 'Automatic', 'Ford', 'F-150', 2023, 5, 700000, 0),
+(4, 'SUV', 7, 'Automatic', 'Chevrolet', 'Tahoe', 2022, 6, 600000, 90000),
+(2, 'Coupe', 4, 'Manual', 'BMW', 'M4', 2021, 3, 80.00, 0);
+
+-- Insert dummy branches, open every day
+INSERT INTO branches (name, address, city, phone) VALUES
+('Jakarta Sudirman', 'Jl. Jend. Sudirman No. 1', 'Jakarta', '021-5550001'),
+('Bandung Dago', 'Jl. Ir. H. Juanda No. 10', 'Bandung', '022-5550002');
+
+INSERT INTO branch_opening_hours (branch_id, weekday, opens_at, closes_at)
+SELECT b.id, d, '08:00', '20:00'
+FROM branches b, generate_series(0, 6) AS d;
+
+INSERT INTO one_way_fees (from_branch_id, to_branch_id, fee) VALUES
+(1, 2, 300000),
+(2, 1, 300000);
+
+-- Insert dummy units, one per car in stock, all at the first branch
+INSERT INTO vehicle_units (car_id, branch_id, plate_number, vin, color, odometer, status)
+SELECT c.id, 1,
+    'B ' || (1000 + c.id * 100 + n) || ' DUM',
+    'DUMMYVIN' || LPAD((c.id * 100 + n)::TEXT, 9, '0'),
+    'White', 0, 'Available'
+FROM cars c, generate_series(1, c.stock) AS n;
+
+-- Insert dummy pricing rules
+INSERT INTO pricing_rules (type, name, multiplier, start_date, end_date, min_days, discount_percent) VALUES
+('Weekend', 'Weekend rate', 1.2, NULL, NULL, NULL, NULL),
+('Season', 'Christmas', 1.5, '2024-12-20', '2025-01-02', NULL, NULL),
+('LongStay', 'Weekly discount', NULL, NULL, NULL, 7, 10);
+
+-- Insert dummy add ons
+INSERT INTO add_ons (name, description, pricing_type, price, inventory) VALUES
+('Child seat', 'Seat for children up to 4 years old', 'PerDay', 50000, 5),
+('GPS', 'Portable navigation device', 'PerDay', 30000, 10),
+('Driver', 'Professional driver, 12 hours per day', 'PerDay', 300000, NULL),
+('Full insurance', 'Covers damage to the car without excess', 'PerRental', 150000, NULL);
 
 -- Insert dummy data into users table
 INSERT INTO users (name, email, password, deposit) VALUES
@@ -85,6 +606,6 @@ INSERT INTO top_ups (user_id, amount) VALUES
 INSERT INTO payments (purchase_id, purchase_type, payment_url, status, payment_method, total_payment) VALUES
 (1, 'rentals','http://payment.com/1', 'Completed', 'Credit Card', 200000),
 (2, 'rentals', 'http://payment.com/2', 'Completed', 'PayPal', 80000),
-(3, 'rentals', 'http://payment.com/3', 'Pending', 'Credit Card', 350000),
+(3, 'rentals', 'http://payment.com/3', 'Unpaid', 'Credit Card', 350000),
 (4, 'rentals', 'http://payment.com/4', 'Completed', 'Debit Card', 180000),
-(5, 'rentals', 'http://payment.com/5', 'Failed', 'Credit Card', 400000);
\ No newline at end of file
+(5, 'rentals', 'http://payment.com/5', 'Unpaid', 'Credit Card', 400000);
\ No newline at end of file